@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keychainService is what API keys stored by gpt auth login are filed under
+// in the OS keychain. See keychainAccount.
+const keychainService = "gpt-cli"
+
+// apiKeyEnv is the environment variable each provider's API key may be set
+// in, and so the providers gpt auth knows.
+var apiKeyEnv = map[string]string{
+	"openai":    "OPENAI_API_KEY",
+	"azure":     "AZURE_OPENAI_API_KEY",
+	"anthropic": "ANTHROPIC_API_KEY",
+	"gemini":    "GEMINI_API_KEY",
+}
+
+// apiKey returns where the provider's API key is configured, or nil if it
+// takes none.
+func (c *config) apiKey(provider string) *string {
+	switch provider {
+	case "openai":
+		return &c.APIKey
+	case "azure":
+		if c.Azure.APIKey == "" {
+			// The Azure provider falls back to api_key.
+			return &c.APIKey
+		}
+		return &c.Azure.APIKey
+	case "anthropic":
+		return &c.Anthropic.APIKey
+	case "gemini":
+		return &c.Gemini.APIKey
+	}
+	return nil
+}
+
+// withStoredKey returns c with the provider's API key from api_key_cmd or
+// else the keychain, if none is set in the config file or environment. A
+// keychain that can't be reached counts as having no key.
+func (c config) withStoredKey() (config, error) {
+	key := c.apiKey(c.Provider)
+	if key == nil || *key != "" {
+		return c, nil
+	}
+	if c.APIKeyCmd != "" {
+		var err error
+		*key, err = commandKey(c.APIKeyCmd)
+		return c, err
+	}
+	if stored, err := c.storedKey(c.Provider); err == nil {
+		*key = stored
+	}
+	return c, nil
+}
+
+// keychainAccount is what the provider's API key is filed under in the
+// keychain: the provider's name, followed by the profile's if one is in use
+// so that profiles can keep keys of their own.
+func (c config) keychainAccount(provider string) string {
+	if c.Profile == "" {
+		return provider
+	}
+	return provider + "/" + c.Profile
+}
+
+// storedKey returns the provider's API key from the keychain, looking for
+// the profile's own before the one shared by every profile.
+func (c config) storedKey(provider string) (string, error) {
+	key, err := keyring.Get(keychainService, c.keychainAccount(provider))
+	if errors.Is(err, keyring.ErrNotFound) && c.Profile != "" {
+		key, err = keyring.Get(keychainService, provider)
+	}
+	return key, err
+}
+
+var (
+	commandKeysMu sync.Mutex
+	// commandKeys keeps what api_key_cmd printed, so that a password
+	// manager is only asked once.
+	commandKeys = make(map[string]string)
+)
+
+// commandKey runs command in the user's shell and returns the first line of
+// its output as an API key.
+func commandKey(command string) (string, error) {
+	commandKeysMu.Lock()
+	defer commandKeysMu.Unlock()
+	if key, ok := commandKeys[command]; ok {
+		return key, nil
+	}
+
+	cmd := shellCommand(context.Background(), command)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	// Let a password manager ask for its passphrase.
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("api_key_cmd: %w", err)
+	}
+	key, _, _ := strings.Cut(out.String(), "\n")
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("api_key_cmd: %q printed no key", command)
+	}
+	commandKeys[command] = key
+	return key, nil
+}
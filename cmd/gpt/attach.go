@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// maxFileAttachment is the size of the largest file @path can attach.
+const maxFileAttachment = 100 * 1024
+
+var (
+	// fileReference matches @path in a message.
+	fileReference = regexp.MustCompile(`(?:^|\s)@(\S+)`)
+	// attachedFile matches a file attached to a message by attachFiles, a
+	// web page attached by fetchPage, or a tmux pane by paneAttachment.
+	attachedFile = regexp.MustCompile(`(?s)\n\n<(?:file path|page url|pane id)="([^"]*)">\n(.*?)\n</(?:file|page|pane)>`)
+)
+
+// attachFiles appends the contents of the files referenced as @path in
+// content. References to anything that isn't a file, such as @someone, are
+// left alone.
+func attachFiles(content string) (string, error) {
+	var b strings.Builder
+	seen := make(map[string]bool)
+	for _, match := range fileReference.FindAllStringSubmatch(content, -1) {
+		path := strings.TrimRight(match[1], ".,;:!?)")
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if info.Size() > maxFileAttachment {
+			return "", fmt.Errorf("%s is too large to attach (%d KB, the limit is %d KB)",
+				path, info.Size()/1024, maxFileAttachment/1024)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		if bytes.IndexByte(data, 0) >= 0 {
+			return "", fmt.Errorf("%s is not a text file", path)
+		}
+		fmt.Fprintf(&b, "\n\n<file path=\"%s\">\n%s\n</file>", path, strings.TrimRight(string(data), "\n"))
+	}
+	return content + b.String(), nil
+}
+
+// fileChips shows the files and pages attached to a message as short labels
+// rather than in full.
+func fileChips(content string, style lipgloss.Style) string {
+	return attachedFile.ReplaceAllStringFunc(content, func(s string) string {
+		match := attachedFile.FindStringSubmatch(s)
+		lines := strings.Count(match[2], "\n") + 1
+		return "\n" + style.Render(fmt.Sprintf("[%s · %d lines]", match[1], lines))
+	})
+}
+
+// completeFile completes the @path at the end of the input, as far as it is
+// unambiguous, and lists the candidates when there are several.
+func (m *model) completeFile() bool {
+	input := m.textarea.Value()
+	start := strings.LastIndexAny(input, " \t\n") + 1
+	if !strings.HasPrefix(input[start:], "@") {
+		return false
+	}
+
+	dir, base := filepath.Split(input[start+1:])
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return false
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		matches = append(matches, name)
+	}
+	if len(matches) == 0 {
+		return false
+	}
+
+	prefix := commonPrefix(matches)
+	switch {
+	case len(matches) > 1:
+		m.err = nil
+		m.notice = strings.Join(matches, "  ")
+	case !strings.HasSuffix(prefix, "/"):
+		prefix += " "
+	}
+	m.textarea.SetValue(input[:start] + "@" + dir + prefix)
+	return true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// auditConfig turns on the audit log, a permanent record of every exchange
+// kept apart from the conversations, which can be deleted or rewritten.
+type auditConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Dir is where the log is kept, one file a day, defaulting to audit in
+	// the data directory.
+	Dir string `yaml:"dir"`
+}
+
+// auditEntry is a single line of the audit log: a message and the reply to
+// it.
+type auditEntry struct {
+	Started      time.Time           `json:"started"`
+	Finished     time.Time           `json:"finished"`
+	Conversation string              `json:"conversation"`
+	Provider     string              `json:"provider"`
+	Model        string              `json:"model"`
+	Prompt       string              `json:"prompt"`
+	Reply        string              `json:"reply"`
+	FinishReason openai.FinishReason `json:"finish_reason,omitempty"`
+	Usage        oneShotUsage        `json:"usage"`
+	// Error is why the reply failed or was cut short, if it was.
+	Error string `json:"error,omitempty"`
+}
+
+// newAuditEntry describes an exchange that finished just now.
+func newAuditEntry(cfg config, conv *conversation, prompt, reply string, started time.Time, result chatResult, err error) auditEntry {
+	e := auditEntry{
+		Started:      started,
+		Finished:     time.Now(),
+		Conversation: conv.ID,
+		Provider:     cfg.Provider,
+		Model:        result.model,
+		Prompt:       prompt,
+		Reply:        reply,
+		FinishReason: result.finish,
+		Usage: oneShotUsage{
+			PromptTokens:     result.usage.PromptTokens,
+			CompletionTokens: result.usage.CompletionTokens,
+			TotalTokens:      result.usage.TotalTokens,
+		},
+	}
+	if e.Model == "" {
+		e.Model = cfg.Model
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// audit appends e to the day's audit log, if the audit log is on. Nothing is
+// ever rewritten or removed.
+func (c config) audit(e auditEntry) error {
+	if !c.AuditLog.Enabled {
+		return nil
+	}
+	dir := c.AuditLog.Dir
+	if dir == "" {
+		dataDir, err := defaultDataDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(dataDir, "audit")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, e.Started.Format("2006-01-02")+".jsonl")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(e)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const defaultBudgetWarnAt = 0.8
+
+type budgetConfig struct {
+	// Daily and Monthly are hard limits in US dollars. Zero means no limit.
+	Daily   float64 `yaml:"daily"`
+	Monthly float64 `yaml:"monthly"`
+
+	// WarnAt is the fraction of a limit at which to start warning.
+	WarnAt float64 `yaml:"warn_at"`
+}
+
+// spendEntry is a single line of the spend log.
+type spendEntry struct {
+	Time             time.Time `json:"time"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Cost             float64   `json:"cost"`
+}
+
+// budget keeps a log of what every request cost so that spending can be held
+// to the configured limits.
+type budget struct {
+	limits budgetConfig
+	path   string
+	force  bool
+}
+
+func newBudget(cfg config, force bool) (*budget, error) {
+	dataDir, err := defaultDataDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	limits := cfg.Budget
+	if limits.WarnAt == 0 {
+		limits.WarnAt = defaultBudgetWarnAt
+	}
+	return &budget{
+		limits: limits,
+		path:   filepath.Join(dataDir, "spend.jsonl"),
+		force:  force,
+	}, nil
+}
+
+// record logs the cost of a reply.
+func (b *budget) record(cfg config, model string, usage openai.Usage) error {
+	entry := spendEntry{
+		Time:             time.Now(),
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+	}
+	if price, ok := cfg.price(model); ok {
+		entry.Cost = price.cost(usage)
+	}
+
+	f, err := os.OpenFile(b.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(entry)
+}
+
+// spent returns how much has been spent today and this month.
+func (b *budget) spent(now time.Time) (today, month float64, err error) {
+	f, err := os.Open(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	year, mon, day := now.Date()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry spendEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return 0, 0, fmt.Errorf("spend log: %w", err)
+		}
+
+		y, m, d := entry.Time.Local().Date()
+		if y != year || m != mon {
+			continue
+		}
+		month += entry.Cost
+		if d == day {
+			today += entry.Cost
+		}
+	}
+	return today, month, scanner.Err()
+}
+
+// check is called before each request. It fails once a limit has been
+// reached, unless forced, and returns a warning as a limit is approached.
+func (b *budget) check() (string, error) {
+	if b.limits.Daily == 0 && b.limits.Monthly == 0 {
+		return "", nil
+	}
+
+	today, month, err := b.spent(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	var warning string
+	for _, period := range []struct {
+		name  string
+		limit float64
+		spent float64
+	}{
+		{"daily", b.limits.Daily, today},
+		{"monthly", b.limits.Monthly, month},
+	} {
+		switch {
+		case period.limit == 0:
+		case period.spent >= period.limit && !b.force:
+			return "", fmt.Errorf("%s budget of %s reached (%s spent); use -force to send anyway",
+				period.name, formatCost(period.limit), formatCost(period.spent))
+		case period.spent >= period.limit*b.limits.WarnAt && warning == "":
+			warning = fmt.Sprintf("Warning: %s of the %s %s budget spent",
+				formatCost(period.spent), formatCost(period.limit), period.name)
+		}
+	}
+	return warning, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	// defaultCandidates is how many replies /candidates asks for unless
+	// told otherwise.
+	defaultCandidates = 3
+	// maxCandidates keeps /candidates from running up a bill by accident.
+	maxCandidates = 8
+)
+
+// candidatesMsg carries the replies asked for by /candidates once they have
+// all arrived.
+type candidatesMsg struct {
+	model   string
+	replies []string
+	usage   openai.Usage
+	// summaryModel and summaryUsage are of the request for a summary made
+	// to fit the context window, if one was.
+	summaryModel string
+	summaryUsage openai.Usage
+	err          error
+}
+
+// chooser holds candidate replies while one is picked to keep.
+type chooser struct {
+	// replies is nil until the candidates arrive.
+	replies []string
+	cursor  int
+}
+
+// candidates handles /candidates, asking for several replies to the message
+// given, or else to the last message, and offering to keep one of them.
+func (m *model) candidates(args []string) tea.Cmd {
+	m.err = nil
+	m.notice = ""
+	if m.streaming || m.chooser != nil {
+		m.err = errors.New("wait for the reply to finish first")
+		return nil
+	}
+
+	n := defaultCandidates
+	if len(args) > 0 {
+		if i, err := strconv.Atoi(args[0]); err == nil {
+			if i < 2 || i > maxCandidates {
+				m.err = fmt.Errorf("ask for 2 to %d candidates, not %d", maxCandidates, i)
+				return nil
+			}
+			n, args = i, args[1:]
+		}
+	}
+	if !m.checkBudget() {
+		return nil
+	}
+
+	if len(args) > 0 {
+		content, err := m.takeInput(strings.Join(args, " "), "")
+		if err != nil {
+			m.err = err
+			return nil
+		}
+		message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: content}
+		m.messages = append(m.messages, message)
+		if err := m.conversation.append(message); err != nil {
+			m.err = err
+		}
+	} else if !m.dropLastReply() {
+		m.err = errors.New("nothing to answer")
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.cancel = cancel
+	m.chooser = &chooser{}
+	m.notice = fmt.Sprintf("Asking for %d replies...", n)
+	m.refreshViewport()
+	m.viewport.GotoBottom()
+	return m.createCandidates(ctx, m.config, m.messages, n)
+}
+
+// createCandidates asks for n replies to messages at once, with tools left
+// out. With a seed, each is asked for with a seed of its own so that they
+// differ.
+func (m model) createCandidates(ctx context.Context, cfg config, messages []openai.ChatCompletionMessage, n int) tea.Cmd {
+	return func() tea.Msg {
+		ctx := withRetryNotice(ctx, func(s string) {
+			m.emit(retryMsg(s))
+		})
+		ctx = withQueueStatus(ctx, func(position int) {
+			m.emit(queueMsg(position))
+		})
+		msg := candidatesMsg{model: cfg.Model, summaryModel: cfg.summaryModel()}
+		req, err := newChatRequest(cfg, promptData{GOOS: m.goos, Shell: m.shell}, messages)
+		if err == nil {
+			err = m.retriever.augment(ctx, &req)
+		}
+		if err == nil {
+			_, msg.summaryUsage, err = fitContext(ctx, cfg, m.provider, m.conversation, &req)
+		}
+		if err != nil {
+			msg.err = err
+			return msg
+		}
+
+		var (
+			wg      sync.WaitGroup
+			replies = make([]strings.Builder, n)
+			results = make([]chatResult, n)
+			errs    = make([]error, n)
+		)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				req := req
+				if cfg.Seed != nil {
+					seed := *cfg.Seed + i
+					req.Seed = &seed
+				}
+				results[i], errs[i] = streamChat(ctx, m.provider, req, func(delta string) {
+					replies[i].WriteString(delta)
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		for i := range replies {
+			msg.usage.PromptTokens += results[i].usage.PromptTokens
+			msg.usage.CompletionTokens += results[i].usage.CompletionTokens
+			msg.usage.TotalTokens += results[i].usage.TotalTokens
+			// A reply cut off at max_tokens is still worth a look.
+			if err := errs[i]; err != nil && !errors.Is(err, errTruncated) {
+				msg.err = err
+				continue
+			}
+			if reply := replies[i].String(); reply != "" {
+				msg.replies = append(msg.replies, reply)
+			}
+		}
+		return msg
+	}
+}
+
+// candidatesArrived opens the chooser on the replies that came back.
+func (m *model) candidatesArrived(msg candidatesMsg) {
+	m.cancel = nil
+	m.notice = ""
+	m.recordUsage(msg.summaryModel, msg.summaryUsage)
+	m.recordUsage(msg.model, msg.usage)
+
+	switch {
+	case errors.Is(msg.err, context.Canceled):
+		m.chooser = nil
+		m.notice = "Response cancelled"
+	case len(msg.replies) == 0:
+		m.chooser = nil
+		m.err = msg.err
+		if m.err == nil {
+			m.err = errors.New("the model sent no replies")
+		}
+	default:
+		m.chooser.replies = msg.replies
+		if msg.err != nil {
+			m.notice = fmt.Sprintf("Only %d replies arrived: %v", len(msg.replies), msg.err)
+		}
+	}
+	m.refreshViewport()
+	m.viewport.GotoBottom()
+}
+
+// updateChooser handles keys while a candidate is being picked. It reports
+// whether the key was used.
+func (m *model) updateChooser(msg tea.KeyMsg) bool {
+	c := m.chooser
+	if c.replies == nil {
+		return false
+	}
+	switch msg.String() {
+	case "left", "shift+tab":
+		c.cursor = (c.cursor + len(c.replies) - 1) % len(c.replies)
+	case "right", "tab":
+		c.cursor = (c.cursor + 1) % len(c.replies)
+	case "enter":
+		m.keepCandidate(c.cursor)
+	case "esc":
+		m.keepCandidate(-1)
+	default:
+		n, err := strconv.Atoi(msg.String())
+		if err != nil || n < 1 || n > len(c.replies) {
+			return false
+		}
+		c.cursor = n - 1
+	}
+	m.refreshViewport()
+	m.viewport.GotoBottom()
+	return true
+}
+
+// keepCandidate adds the ith candidate to the conversation as the reply, or
+// none of them if i is -1, and moves the rest to the scratch area.
+func (m *model) keepCandidate(i int) {
+	replies := m.chooser.replies
+	m.chooser = nil
+	for j, reply := range replies {
+		if j != i {
+			m.scratch = append(m.scratch, reply)
+		}
+	}
+
+	if i < 0 {
+		m.notice = fmt.Sprintf("Moved %d replies to the scratch area; /scratch lists them", len(replies))
+		return
+	}
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: replies[i]}
+	m.messages = append(m.messages, message)
+	m.truncated = false
+	if err := m.conversation.append(message); err != nil {
+		m.err = err
+	}
+	if m.session != "" {
+		if err := m.sessions.save(m.snapshot(m.session)); err != nil {
+			m.err = err
+		}
+	}
+	m.notice = fmt.Sprintf("Kept reply %d; the other %d are in /scratch", i+1, len(replies)-1)
+}
+
+// chooserView shows the candidate being looked at below the conversation.
+func (m model) chooserView() string {
+	c := m.chooser
+	header := m.styles.notice.Render(fmt.Sprintf(
+		"Reply %d of %d · ←/→ to compare, Enter to keep, Esc to keep none", c.cursor+1, len(c.replies)))
+	reply := c.replies[c.cursor]
+	if !m.config.Markdown {
+		return header + "\n" + m.renderer.renderPlain(reply)
+	}
+	return header + "\n" + m.renderer.renderMarkdown(reply, true)
+}
+
+// showScratch handles /scratch. Without arguments it lists the replies left
+// out by /candidates; with a number it copies that one.
+func (m *model) showScratch(args []string) tea.Cmd {
+	if len(m.scratch) == 0 {
+		m.notice = "The scratch area is empty"
+		return nil
+	}
+	if len(args) == 0 {
+		list := make([]string, len(m.scratch))
+		for i, reply := range m.scratch {
+			first, _, _ := strings.Cut(strings.TrimSpace(reply), "\n")
+			if len(first) > 40 {
+				first = strings.ToValidUTF8(first[:37], "") + "..."
+			}
+			list[i] = fmt.Sprintf("%d: %s", i+1, first)
+		}
+		m.notice = strings.Join(list, "  ") + ". /scratch <n> copies one"
+		return nil
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(m.scratch) {
+		m.err = fmt.Errorf("no reply %s in the scratch area; it has %d", args[0], len(m.scratch))
+		return nil
+	}
+	m.copyToClipboard(m.scratch[n-1])
+	m.notice = fmt.Sprintf("Copied reply %d from the scratch area", n)
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func detectPromptData() promptData {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	} else {
+		shell = path.Base(shell)
+	}
+
+	return promptData{
+		GOOS:  runtime.GOOS,
+		Shell: shell,
+	}
+}
+
+// newChatRequest builds the request for a transcript, prepending the rendered
+// system prompt.
+func newChatRequest(cfg config, data promptData, messages []openai.ChatCompletionMessage) (openai.ChatCompletionRequest, error) {
+	if cfg.SystemPrompt != "" {
+		systemPrompt, err := renderPrompt(cfg.SystemPrompt, data)
+		if err != nil {
+			return openai.ChatCompletionRequest{}, fmt.Errorf("system prompt: %w", err)
+		}
+
+		messages = append([]openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: systemPrompt,
+			},
+		}, messages...)
+	}
+
+	return openai.ChatCompletionRequest{
+		Model:            cfg.Model,
+		Temperature:      cfg.Temperature,
+		TopP:             cfg.TopP,
+		PresencePenalty:  cfg.PresencePenalty,
+		FrequencyPenalty: cfg.FrequencyPenalty,
+		MaxTokens:        cfg.maxTokens(),
+		Stop:             cfg.Stop,
+		Seed:             cfg.Seed,
+		Messages:         messages,
+	}, nil
+}
+
+// errTruncated is returned when the reply stopped because it reached
+// max_tokens; what arrived before is kept.
+var errTruncated = errors.New("the reply was cut off at the max_tokens limit")
+
+// continuePrompt asks for the rest of a reply that was cut off.
+const continuePrompt = "Your reply was cut off. Continue exactly where you left off, without repeating anything."
+
+// chatResult is what is known of a reply besides its text.
+type chatResult struct {
+	// usage is the token usage, if the provider reported any.
+	usage openai.Usage
+	// calls are the tools the reply calls.
+	calls []openai.ToolCall
+	// fingerprint identifies the backend configuration that wrote the
+	// reply, for providers that report one.
+	fingerprint string
+	// finish is why the reply ended, such as "stop" or "length".
+	finish openai.FinishReason
+	// model is the model that wrote the reply as the provider names it,
+	// which may be more exact than the one asked for.
+	model string
+}
+
+// streamChat sends req and calls onDelta with each piece of the reply as it
+// arrives. The tools the reply calls are pieced together from their deltas.
+// A reply cut off by max_tokens ends with errTruncated.
+func streamChat(ctx context.Context, p provider, req openai.ChatCompletionRequest, onDelta func(string)) (chatResult, error) {
+	var result chatResult
+
+	stream, err := p.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	defer stream.Close()
+
+	for {
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if result.finish == openai.FinishReasonLength {
+				return result, errTruncated
+			}
+			return result, nil
+		}
+
+		if err != nil {
+			return result, err
+		}
+
+		if response.Usage != nil {
+			result.usage = *response.Usage
+		}
+		if response.SystemFingerprint != "" {
+			result.fingerprint = response.SystemFingerprint
+		}
+		if response.Model != "" {
+			result.model = response.Model
+		}
+		if len(response.Choices) == 0 {
+			continue
+		}
+		if reason := response.Choices[0].FinishReason; reason != "" {
+			result.finish = reason
+		}
+		delta := response.Choices[0].Delta
+		if delta.Content != "" {
+			onDelta(delta.Content)
+		}
+		for _, call := range delta.ToolCalls {
+			result.calls = addToolCallDelta(result.calls, call)
+		}
+	}
+}
+
+// addToolCallDelta adds a piece of a streamed tool call to calls. The first
+// piece of each call has its ID and name; the arguments arrive in pieces. An
+// index past the end of calls, or below zero, starts the next call, so a bad
+// index from the server can't panic or grow calls without bound.
+func addToolCallDelta(calls []openai.ToolCall, delta openai.ToolCall) []openai.ToolCall {
+	i := len(calls) - 1
+	if delta.Index != nil {
+		i = *delta.Index
+		if i < 0 || i > len(calls) {
+			i = len(calls)
+		}
+	} else if delta.ID != "" || i < 0 {
+		i = len(calls)
+	}
+	if i == len(calls) {
+		calls = append(calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+	}
+	if delta.ID != "" {
+		calls[i].ID = delta.ID
+	}
+	calls[i].Function.Name += delta.Function.Name
+	calls[i].Function.Arguments += delta.Function.Arguments
+	return calls
+}
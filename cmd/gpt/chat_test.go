@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestAddToolCallDelta(t *testing.T) {
+	index := func(i int) *int { return &i }
+	call := func(id, name, args string) openai.ToolCall {
+		return openai.ToolCall{
+			ID:       id,
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: name, Arguments: args},
+		}
+	}
+	delta := func(i *int, id, name, args string) openai.ToolCall {
+		return openai.ToolCall{Index: i, ID: id, Function: openai.FunctionCall{Name: name, Arguments: args}}
+	}
+	tests := []struct {
+		name   string
+		deltas []openai.ToolCall
+		want   []openai.ToolCall
+	}{
+		{
+			name: "one call in pieces",
+			deltas: []openai.ToolCall{
+				delta(index(0), "call_1", "add", ""),
+				delta(index(0), "", "", `{"a":`),
+				delta(index(0), "", "", ` 1}`),
+			},
+			want: []openai.ToolCall{call("call_1", "add", `{"a": 1}`)},
+		},
+		{
+			name: "calls by index",
+			deltas: []openai.ToolCall{
+				delta(index(0), "call_1", "add", `{}`),
+				delta(index(1), "call_2", "sub", `{"b"`),
+				delta(index(1), "", "", `:2}`),
+			},
+			want: []openai.ToolCall{call("call_1", "add", `{}`), call("call_2", "sub", `{"b":2}`)},
+		},
+		{
+			name: "calls without an index start with an ID",
+			deltas: []openai.ToolCall{
+				delta(nil, "call_1", "add", `{"a"`),
+				delta(nil, "", "", `:1}`),
+				delta(nil, "call_2", "add", `{}`),
+			},
+			want: []openai.ToolCall{call("call_1", "add", `{"a":1}`), call("call_2", "add", `{}`)},
+		},
+		{
+			name:   "first piece without an ID or index",
+			deltas: []openai.ToolCall{delta(nil, "", "now", `{}`)},
+			want:   []openai.ToolCall{call("", "now", `{}`)},
+		},
+		{
+			name:   "index skipped ahead",
+			deltas: []openai.ToolCall{delta(index(1), "call_2", "add", `{}`)},
+			want:   []openai.ToolCall{call("call_2", "add", `{}`)},
+		},
+		{
+			name: "index out of range",
+			deltas: []openai.ToolCall{
+				delta(index(0), "call_1", "add", `{}`),
+				delta(index(-1), "call_2", "add", `{}`),
+				delta(index(1<<30), "call_3", "add", `{}`),
+			},
+			want: []openai.ToolCall{call("call_1", "add", `{}`), call("call_2", "add", `{}`), call("call_3", "add", `{}`)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []openai.ToolCall
+			for _, d := range tt.deltas {
+				got = addToolCallDelta(got, d)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("calls = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testMockProvider returns the mock provider with replies, sending them as
+// fast as it can.
+func testMockProvider(t *testing.T, replies ...mockReply) mockProvider {
+	t.Helper()
+	var delay time.Duration
+	p, err := newMockProvider(config{Mock: mockConfig{Replies: replies, Delay: &delay}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestStreamChat(t *testing.T) {
+	p := testMockProvider(t, mockReply{Reply: "Hello there friend"})
+	tests := []struct {
+		name      string
+		maxTokens int
+		cancel    bool
+		want      string
+		// completion is the tokens the mock counts, a word at a time.
+		completion int
+		finish     openai.FinishReason
+		err        error
+	}{
+		{
+			name:       "whole reply",
+			want:       "Hello there friend",
+			completion: 6,
+			finish:     openai.FinishReasonStop,
+		},
+		{
+			name:       "cut off at max_tokens",
+			maxTokens:  4,
+			want:       "Hello there ",
+			completion: 4,
+			finish:     openai.FinishReasonLength,
+			err:        errTruncated,
+		},
+		{
+			name:   "cancelled",
+			cancel: true,
+			err:    context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			req := openai.ChatCompletionRequest{
+				Model:     "mock-model",
+				MaxTokens: tt.maxTokens,
+				Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hi"}},
+			}
+
+			var reply strings.Builder
+			result, err := streamChat(ctx, p, req, func(delta string) {
+				reply.WriteString(delta)
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("streamChat() error = %v, want %v", err, tt.err)
+			}
+			if reply.String() != tt.want {
+				t.Errorf("reply = %q, want %q", reply.String(), tt.want)
+			}
+			if tt.cancel {
+				return
+			}
+			if result.finish != tt.finish {
+				t.Errorf("finish = %q, want %q", result.finish, tt.finish)
+			}
+			if result.model != "mock-model" {
+				t.Errorf("model = %q, want mock-model", result.model)
+			}
+			prompt := tokensPerReply + tokensPerMessage + estimateTokens("Hi")
+			if u := result.usage; u.PromptTokens != prompt || u.CompletionTokens != tt.completion ||
+				u.TotalTokens != u.PromptTokens+u.CompletionTokens {
+				t.Errorf("usage = %+v, want %d prompt and %d completion tokens", u, prompt, tt.completion)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// subcommand is one of gpt's modes, run when named as the first argument.
+// Without one, gpt chats.
+type subcommand struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// subcommands are listed by gpt help in this order.
+var subcommands []subcommand
+
+func init() {
+	subcommands = []subcommand{
+		{"chat", "chat in the terminal, or answer a prompt given as arguments; the default", nil},
+		{"ask", "answer a question about the code indexed by gpt index", runAsk},
+		{"agent", "work on a task with the tools until it is done", runAgent},
+		{"sh", "write a shell command that does what the arguments describe", runSh},
+		{"explain", "break a command line down flag by flag", runExplain},
+		{"fix", "explain why a command failed and suggest one that works", runFix},
+		{"commit", "write a commit message for the staged changes and commit", runCommit},
+		{"pr", "describe the current branch for a pull request and open one", runPR},
+		{"review", "review a diff, or else the uncommitted changes", runReview},
+		{"tests", "write table-driven tests for a Go file", runTests},
+		{"index", "embed the code in a directory for gpt ask and gpt -index", runIndex},
+		{"embed", "print the embeddings of text", runEmbed},
+		{"history", "list, show, rename or delete stored conversations", runHistory},
+		{"export", "export a session or conversation", runExport},
+		{"replay", "play a session or conversation back as it streamed", runReplay},
+		{"config", "show the settings in effect, where files are kept, or edit the config", runConfig},
+		{"auth", "keep API keys in the OS keychain", runAuth},
+		{"shell-init", "print the Ctrl+G key binding for a shell", runShellInit},
+		{"completion", "print the tab completion script for a shell", runCompletion},
+		{"serve", "serve chat and history over a local HTTP API", runServe},
+		{"mcp-serve", "serve chat and history over the Model Context Protocol", runMCPServe},
+		{"doctor", "check the config, API key, provider and terminal for problems", runDoctor},
+		{"update", "replace gpt with the latest release", runUpdate},
+		{"version", "print the version and build of gpt", runVersion},
+		{"help", "show this, or the usage of a command", runHelp},
+		// Hidden, for the completion scripts.
+		{"__complete", "", runComplete},
+	}
+}
+
+func findSubcommand(name string) (subcommand, bool) {
+	for _, cmd := range subcommands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return subcommand{}, false
+}
+
+// globalFlags apply to every subcommand as well as the chat, given before
+// the subcommand's name.
+type globalFlags struct {
+	profile  string
+	provider string
+	model    string
+	logLevel string
+	logFile  string
+}
+
+var globals globalFlags
+
+func (g *globalFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.profile, "profile", "", "use one of the profiles in the config file, overriding GPT_PROFILE")
+	fs.StringVar(&g.provider, "provider", "", "backend to use: openai, azure, anthropic, gemini, ollama or mock")
+	fs.StringVar(&g.model, "model", "", "model to use, overriding the config file")
+	fs.StringVar(&g.logLevel, "log-level", "", "log requests to the provider: off, error, info, or debug for every chunk")
+	fs.StringVar(&g.logFile, "log-file", "", "file to log to, instead of gpt.log in the data directory")
+}
+
+func (g globalFlags) isGlobal(name string) bool {
+	switch name {
+	case "profile", "provider", "model", "log-level", "log-file":
+		return true
+	}
+	return false
+}
+
+// apply returns cfg with the provider, model and logging given.
+func (g globalFlags) apply(cfg config) config {
+	if g.logLevel != "" {
+		cfg.LogLevel = g.logLevel
+	}
+	if g.logFile != "" {
+		cfg.LogFile = g.logFile
+	}
+	if g.provider != "" && g.provider != cfg.Provider {
+		cfg.Provider = g.provider
+		if g.model == "" {
+			// The configured model most likely belongs to the other provider.
+			cfg.Model = ""
+		}
+	}
+	if g.model != "" {
+		cfg.Model = g.model
+	}
+	return cfg
+}
+
+// usage prints the usage of gpt as a whole.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprint(out, `usage: gpt [flags] [prompt]
+       gpt [-profile name] [-provider name] [-model name] <command> [args]
+
+Commands:
+`)
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, cmd := range subcommands {
+		if !strings.HasPrefix(cmd.name, "_") {
+			fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+		}
+	}
+	w.Flush()
+	fmt.Fprint(out, "\nRun gpt help <command> for a command's flags.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// runHelp implements the help subcommand, which lists the commands or shows
+// the usage of one.
+func runHelp(args []string) error {
+	if len(args) == 0 || args[0] == "chat" {
+		flag.CommandLine.SetOutput(os.Stdout)
+		usage()
+		return nil
+	}
+	cmd, ok := findSubcommand(args[0])
+	if !ok {
+		return fmt.Errorf("no command %q; there are %s", args[0], strings.Join(completions(nil, ""), ", "))
+	}
+	if cmd.name == "help" {
+		return runHelp(nil)
+	}
+	return cmd.run([]string{"-h"})
+}
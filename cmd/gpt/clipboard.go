@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/aymanbagabas/go-osc52/v2"
+)
+
+// copyToClipboard puts text on the clipboard. An OSC 52 escape sequence asks
+// the terminal to do it, which works over SSH; as not every terminal supports
+// it, the system clipboard is set as well when there is one. Its errors are
+// ignored, since the terminal may well have done the copying.
+func copyToClipboard(text string) {
+	seq := osc52.New(text)
+	switch {
+	case os.Getenv("TMUX") != "":
+		seq = seq.Tmux()
+	case strings.HasPrefix(os.Getenv("TERM"), "screen"):
+		seq = seq.Screen()
+	}
+	_, _ = seq.WriteTo(os.Stdout)
+
+	if !clipboard.Unsupported {
+		_ = clipboard.WriteAll(text)
+	}
+}
+
+// copyToClipboard puts text on the clipboard of the terminal the chat is
+// shown on, which is a remote one for a chat served over SSH.
+func (m *model) copyToClipboard(text string) {
+	if m.terminal == nil {
+		copyToClipboard(text)
+		return
+	}
+	_, _ = osc52.New(text).WriteTo(m.terminal)
+}
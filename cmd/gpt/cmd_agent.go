@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// defaultAgentSteps is how many rounds of tool calls an agent gets unless
+// told otherwise.
+const defaultAgentSteps = 20
+
+// agentOutputLines is how much of each step's output the panel shows.
+const agentOutputLines = 4
+
+const agentPrompt = `You are an agent carrying out a task for the user on {{.GOOS}} with the {{.Shell}} shell, working in the current directory.
+
+Start with a short plan. Then carry it out a step at a time with the tools, looking at what each returns before deciding on the next. The user approves each command and each file written; if they decline one, find another way or stop and say why.
+
+When the task is done, or can't be done, finish with a short summary of what you did and anything left for the user to do.`
+
+// runAgent implements the agent subcommand, which works on a task with the
+// tools until it is done, showing each step and asking before anything that
+// changes the system.
+func runAgent(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	steps := fs.Int("max-steps", defaultAgentSteps, "most rounds of tool calls before the agent has to finish")
+	modelName := fs.String("model", "", "model to use, overriding the config file")
+	force := fs.Bool("force", false, "send requests even once the spending budget is used up")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt agent [-max-steps n] [-model name] <task>\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	task := strings.Join(fs.Args(), " ")
+	if task == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *modelName != "" {
+		cfg.Model = *modelName
+	}
+	cfg.fillDefaults()
+	cfg.SystemPrompt = agentPrompt
+
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	spending, err := newBudget(cfg, *force)
+	if err != nil {
+		return err
+	}
+	if _, err := spending.check(); err != nil {
+		return err
+	}
+	store, err := newHistoryStore()
+	if err != nil {
+		return err
+	}
+	tools, err := newToolRegistry(cfg)
+	if err != nil {
+		return err
+	}
+	defer tools.close()
+	if err := tools.addAgentTools(); err != nil {
+		return err
+	}
+	tools.rounds = *steps
+
+	keys, err := newKeyMap(cfg.Keys)
+	if err != nil {
+		return err
+	}
+	t, err := resolveTheme(cfg)
+	if err != nil {
+		return err
+	}
+	conv, err := store.create()
+	if err != nil {
+		return err
+	}
+	defer conv.discardIfEmpty()
+
+	m := &agentModel{
+		task:     task,
+		maxSteps: *steps,
+		config:   cfg,
+		provider: prov,
+		tools:    tools,
+		budget:   spending,
+		conv:     conv,
+		events:   make(chan tea.Msg),
+		keys:     keys,
+		styles:   newStyles(t),
+	}
+	if _, err := tea.NewProgram(m).Run(); err != nil {
+		return err
+	}
+	if errors.Is(m.err, context.Canceled) {
+		return errors.New("stopped")
+	}
+	return m.err
+}
+
+// agentEntry is a line of the agent's log: what the model said, or a tool
+// call it made.
+type agentEntry struct {
+	text string
+	call *openai.ToolCall
+	// output is what the call printed, or its result once it has one.
+	output string
+	state  agentStepState
+}
+
+type agentStepState int
+
+const (
+	stepPending agentStepState = iota
+	stepRunning
+	stepDone
+	stepFailed
+)
+
+// agentModel shows an agent at work.
+type agentModel struct {
+	task     string
+	maxSteps int
+
+	config   config
+	provider provider
+	tools    *toolRegistry
+	budget   *budget
+	conv     *conversation
+	events   chan tea.Msg
+	cancel   context.CancelFunc
+
+	keys   keyMap
+	styles styles
+	width  int
+
+	log []agentEntry
+	// reply is the text of the round in progress.
+	reply   string
+	rounds  int
+	confirm *confirmMsg
+	done    bool
+	err     error
+}
+
+func (m *agentModel) Init() tea.Cmd {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	task := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: m.task}
+	if err := m.conv.append(task); err != nil {
+		m.err = err
+		return tea.Quit
+	}
+	return tea.Batch(m.run(ctx, task), waitForDelta(m.events))
+}
+
+// run works on the task in the background, reporting on m.events.
+func (m *agentModel) run(ctx context.Context, task openai.ChatCompletionMessage) tea.Cmd {
+	cfg, p, tools, events := m.config, m.provider, m.tools, m.events
+	return func() tea.Msg {
+		var result chatResult
+		req, err := newChatRequest(cfg, detectPromptData(), []openai.ChatCompletionMessage{task})
+		if err == nil {
+			result, err = chatWithTools(ctx, p, tools, req, chatEvents{
+				delta: func(delta string) {
+					events <- deltaMsg(delta)
+				},
+				calling: func(calls []openai.ToolCall) {
+					events <- toolCallsMsg(calls)
+				},
+				called: func(reply openai.ChatCompletionMessage, results []openai.ChatCompletionMessage) {
+					events <- toolResultsMsg{reply: reply, results: results}
+				},
+				toolIO: toolIO{
+					confirm: confirmVia(ctx, events),
+					output: func(s string) {
+						events <- toolOutputMsg(s)
+					},
+					running: func(call openai.ToolCall) {
+						events <- agentStepMsg(call.ID)
+					},
+				},
+			})
+		}
+		events <- streamDoneMsg{model: cfg.Model, usage: result.usage, err: err}
+		return nil
+	}
+}
+
+// agentStepMsg reports that the call with this ID has started.
+type agentStepMsg string
+
+func (m *agentModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			m.cancel()
+			return m, tea.Quit
+		case m.confirm != nil:
+			if answerConfirm(m.confirm, msg) {
+				m.confirm = nil
+			}
+		case key.Matches(msg, m.keys.Cancel):
+			m.cancel()
+		}
+		return m, nil
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		return m, nil
+	case deltaMsg:
+		m.reply += string(msg)
+	case toolCallsMsg:
+		m.rounds++
+		if text := strings.TrimSpace(m.reply); text != "" {
+			m.log = append(m.log, agentEntry{text: text})
+		}
+		m.reply = ""
+		for i := range msg {
+			m.log = append(m.log, agentEntry{call: &msg[i]})
+		}
+	case agentStepMsg:
+		if step := m.step(string(msg)); step != nil {
+			step.state = stepRunning
+		}
+	case toolOutputMsg:
+		if step := m.running(); step != nil {
+			step.output += string(msg)
+		}
+	case confirmMsg:
+		m.confirm = &msg
+	case toolResultsMsg:
+		for _, result := range msg.results {
+			step := m.step(result.ToolCallID)
+			if step == nil {
+				continue
+			}
+			step.state = stepDone
+			if strings.HasPrefix(result.Content, "Error: ") {
+				step.state = stepFailed
+			}
+			if step.output == "" || step.state == stepFailed {
+				step.output = result.Content
+			}
+		}
+		for _, message := range append([]openai.ChatCompletionMessage{msg.reply}, msg.results...) {
+			if err := m.conv.append(message); err != nil {
+				m.err = err
+			}
+		}
+	case streamDoneMsg:
+		m.done = true
+		m.confirm = nil
+		if msg.err != nil {
+			m.err = msg.err
+		}
+		if msg.usage.TotalTokens > 0 {
+			if err := m.budget.record(m.config, msg.model, msg.usage); err != nil && m.err == nil {
+				m.err = err
+			}
+		}
+		if m.reply != "" {
+			err := m.conv.append(openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: m.reply,
+			})
+			if err != nil && m.err == nil {
+				m.err = err
+			}
+		}
+		return m, tea.Quit
+	}
+	return m, waitForDelta(m.events)
+}
+
+// step returns the log entry of the call with the given ID.
+func (m *agentModel) step(id string) *agentEntry {
+	for i := range m.log {
+		if m.log[i].call != nil && m.log[i].call.ID == id {
+			return &m.log[i]
+		}
+	}
+	return nil
+}
+
+// running returns the log entry of the call that is running.
+func (m *agentModel) running() *agentEntry {
+	for i := len(m.log) - 1; i >= 0; i-- {
+		if m.log[i].state == stepRunning {
+			return &m.log[i]
+		}
+	}
+	return nil
+}
+
+func (m *agentModel) View() string {
+	wrap := lipgloss.NewStyle()
+	if m.width > 0 {
+		wrap = wrap.Width(m.width)
+	}
+
+	var blocks []string
+	blocks = append(blocks, wrap.Render(m.styles.user.Render("Task: ")+m.task))
+	for _, entry := range m.log {
+		if entry.call == nil {
+			blocks = append(blocks, wrap.Render(entry.text))
+			continue
+		}
+
+		marks := map[agentStepState]string{stepPending: "·", stepRunning: "●", stepDone: "✓", stepFailed: "✗"}
+		style := m.styles.notice
+		if entry.state == stepFailed {
+			style = m.styles.err
+		}
+		block := style.Render(marks[entry.state] + " " + formatToolCall(*entry.call))
+		if output := lastLines(entry.output, agentOutputLines); output != "" {
+			block += "\n" + m.styles.footer.Render(indent(output, "  │ "))
+		}
+		blocks = append(blocks, wrap.Render(block))
+	}
+	if m.reply != "" {
+		blocks = append(blocks, wrap.Render(m.styles.assistant.Render(m.reply)))
+	}
+
+	var status string
+	switch {
+	case m.confirm != nil:
+		status = m.styles.notice.Render(m.confirm.prompt + " [y/N]")
+	case m.done && m.err != nil:
+		status = m.styles.err.Render(m.err.Error())
+	case m.done:
+		status = m.styles.footer.Render(fmt.Sprintf("Done in %d steps", m.rounds))
+	default:
+		status = m.styles.footer.Render(fmt.Sprintf("Step %d of at most %d · %s stops the agent",
+			m.rounds, m.maxSteps, m.keys.Cancel.Help().Key))
+	}
+	blocks = append(blocks, wrap.Render(status))
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/zalando/go-keyring"
+)
+
+const authUsage = `usage: gpt auth login [provider]
+       gpt auth status
+       gpt auth logout [provider]`
+
+// runAuth implements the auth subcommand, which keeps API keys in the OS
+// keychain so that they needn't be set in the environment or config file.
+// The provider defaults to the configured one.
+func runAuth(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(authUsage)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	provider := cfg.Provider
+	if len(args) == 2 {
+		provider = args[1]
+	}
+	if _, ok := apiKeyEnv[provider]; !ok && args[0] != "status" {
+		return fmt.Errorf("the %s provider takes no API key", provider)
+	}
+
+	switch args[0] {
+	case "login":
+		return authLogin(cfg, provider)
+	case "status":
+		if len(args) != 1 {
+			return errors.New(authUsage)
+		}
+		return authStatus(cfg)
+	case "logout":
+		account := cfg.keychainAccount(provider)
+		err := keyring.Delete(keychainService, account)
+		if errors.Is(err, keyring.ErrNotFound) {
+			return fmt.Errorf("no %s API key in the keychain", account)
+		}
+		if err != nil {
+			return fmt.Errorf("keychain: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Removed the %s API key from the keychain\n", account)
+		return nil
+	}
+	return errors.New(authUsage)
+}
+
+// authLogin asks for an API key without echoing it, or reads it from stdin
+// if that isn't a terminal, and stores it in the keychain, for the profile
+// alone if one is in use.
+func authLogin(cfg config, provider string) error {
+	var key string
+	if term.IsTerminal(os.Stdin.Fd()) {
+		fmt.Fprintf(os.Stderr, "%s API key: ", provider)
+		data, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		key = string(data)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return errors.New("no API key on stdin")
+		}
+		key = line
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return errors.New("no API key given")
+	}
+
+	account := cfg.keychainAccount(provider)
+	if err := keyring.Set(keychainService, account, key); err != nil {
+		return fmt.Errorf("keychain: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Stored the %s API key in the keychain\n", account)
+	if env := apiKeyEnv[provider]; os.Getenv(env) != "" {
+		fmt.Fprintf(os.Stderr, "%s is set and will be used instead\n", env)
+	}
+	return nil
+}
+
+// authStatus lists where each provider's API key comes from, in the order
+// they are looked for.
+func authStatus(cfg config) error {
+	providers := make([]string, 0, len(apiKeyEnv))
+	for provider := range apiKeyEnv {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tKEY\tFROM")
+	for _, provider := range providers {
+		key, from := *cfg.apiKey(provider), "config file"
+		if env := apiKeyEnv[provider]; key != "" && os.Getenv(env) == key {
+			from = env
+		} else if key == "" && cfg.APIKeyCmd != "" && provider == cfg.Provider {
+			var err error
+			if key, err = commandKey(cfg.APIKeyCmd); err == nil {
+				from = "api_key_cmd"
+			} else {
+				from = err.Error()
+			}
+		} else if key == "" {
+			stored, err := cfg.storedKey(provider)
+			switch {
+			case err == nil:
+				key, from = stored, "keychain"
+			case errors.Is(err, keyring.ErrNotFound):
+				from = "not set"
+			default:
+				from = fmt.Sprintf("keychain unavailable: %v", err)
+			}
+		}
+		if provider == cfg.Provider {
+			provider += " (current)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", provider, maskKey(key), from)
+	}
+	return w.Flush()
+}
+
+// maskKey shows no more of an API key than needed to tell it apart.
+func maskKey(key string) string {
+	if key == "" {
+		return "-"
+	}
+	if len(key) < 12 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
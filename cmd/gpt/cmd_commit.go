@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/term"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// maxDiff is the most of a diff sent to the model; the rest is cut off.
+const maxDiff = 100 * 1024
+
+const commitPrompt = `You write git commit messages in the Conventional Commits format for the staged changes the user sends.
+
+The first line is "type(scope): summary", where type is one of feat, fix, docs, style, refactor, perf, test, build, ci or chore, the scope is optional and names the part of the code changed, and the summary is in the imperative mood, lower case, without a full stop, and under 72 characters in all. Add "!" after the type or scope if the change breaks compatibility.
+
+If the change needs explaining, follow with a blank line and a body wrapped at 72 columns saying what changed and why, not how. Leave the body out for small, obvious changes.
+
+Reply with the message only, not in a code fence.`
+
+// runCommit implements the commit subcommand, which writes a commit message
+// for the staged changes and, once the user has looked it over, commits them.
+func runCommit(args []string) error {
+	fs := flag.NewFlagSet("commit", flag.ExitOnError)
+	modelName := fs.String("model", "", "model to use, overriding the config file")
+	printOnly := fs.Bool("print", false, "print the message instead of offering to commit")
+	force := fs.Bool("force", false, "send the request even once the spending budget is used up")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt commit [-model name] [-print]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	diff, err := git("diff", "--cached", "--no-color")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(diff) == "" {
+		return errors.New("nothing is staged; git add the changes to commit first")
+	}
+	stat, err := git("diff", "--cached", "--stat", "--no-color")
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *modelName != "" {
+		cfg.Model = *modelName
+	}
+	cfg.fillDefaults()
+	cfg.SystemPrompt = commitPrompt
+
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	spending, err := newBudget(cfg, *force)
+	if err != nil {
+		return err
+	}
+	warning, err := spending.check()
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+
+	fmt.Fprintln(os.Stderr, "Writing the commit message...")
+	message, err := completeOnce(cfg, prov, spending, stat+"\n"+truncateDiff(diff))
+	if err != nil {
+		return err
+	}
+	message = strings.TrimSpace(unfence(message))
+
+	if _, _, err := term.GetSize(os.Stdout.Fd()); *printOnly || err != nil {
+		fmt.Println(message)
+		return nil
+	}
+
+	keys, err := newKeyMap(cfg.Keys)
+	if err != nil {
+		return err
+	}
+	t, err := resolveTheme(cfg)
+	if err != nil {
+		return err
+	}
+	m := newDraftModel(message, stat, "commit", keys, newStyles(t))
+	if _, err := tea.NewProgram(m).Run(); err != nil {
+		return err
+	}
+	if !m.accepted {
+		fmt.Fprintln(os.Stderr, "Not committed.")
+		return nil
+	}
+
+	cmd := exec.Command("git", "commit", "-F", "-")
+	cmd.Stdin = strings.NewReader(m.textarea.Value() + "\n")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// git runs git with args and returns what it printed, or an error with what
+// it complained about.
+func git(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// truncateDiff cuts diff down to maxDiff, saying so.
+func truncateDiff(diff string) string {
+	if len(diff) <= maxDiff {
+		return diff
+	}
+	return strings.ToValidUTF8(diff[:maxDiff], "") + "\n[diff truncated]"
+}
+
+// completeOnce sends a single message with the configured system prompt and
+// returns the whole reply.
+func completeOnce(cfg config, p provider, b *budget, message string) (string, error) {
+	req, err := newChatRequest(cfg, detectPromptData(), []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: message},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var reply strings.Builder
+	result, err := streamChat(context.Background(), p, req, func(delta string) {
+		reply.WriteString(delta)
+	})
+	if err != nil {
+		return "", err
+	}
+	if result.usage.TotalTokens > 0 {
+		if err := b.record(cfg, cfg.Model, result.usage); err != nil {
+			return "", err
+		}
+	}
+	if strings.TrimSpace(reply.String()) == "" {
+		return "", errors.New("the model sent an empty reply")
+	}
+	return reply.String(), nil
+}
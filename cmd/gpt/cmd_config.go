@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const configUsage = `usage: gpt config show
+       gpt config path
+       gpt config edit`
+
+// runConfig implements the config subcommand, which shows the settings in
+// effect, where gpt keeps its files, or opens the config file in the editor.
+func runConfig(args []string) error {
+	if len(args) != 1 {
+		return errors.New(configUsage)
+	}
+	switch args[0] {
+	case "show":
+		return showConfig()
+	case "path", "paths":
+		return showPaths()
+	case "edit":
+		return editConfig()
+	}
+	return errors.New(configUsage)
+}
+
+// showConfig prints the settings in effect, once the project's config file,
+// the environment, the profile and the global flags have been applied, with
+// API keys and the values of headers and env masked.
+func showConfig() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.fillDefaults()
+
+	var node yaml.Node
+	if err := node.Encode(cfg); err != nil {
+		return err
+	}
+	maskSecrets(&node)
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// maskSecrets replaces what may be a secret under node.
+func maskSecrets(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		for _, child := range node.Content {
+			maskSecrets(child)
+		}
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		switch {
+		case key == "api_key" && value.Kind == yaml.ScalarNode && value.Value != "":
+			value.Value = maskKey(value.Value)
+		case (key == "headers" || key == "env") && value.Kind == yaml.MappingNode:
+			for j := 1; j < len(value.Content); j += 2 {
+				value.Content[j].Value = maskKey(value.Content[j].Value)
+			}
+		default:
+			maskSecrets(value)
+		}
+	}
+}
+
+// showPaths prints where gpt looks for and keeps its files.
+func showPaths() error {
+	configDir, err := defaultConfigDir()
+	if err != nil {
+		return err
+	}
+	dataDir, err := defaultDataDir()
+	if err != nil {
+		return err
+	}
+	cacheDir, err := defaultCacheDir()
+	if err != nil {
+		return err
+	}
+	project, err := findProjectConfig()
+	if err != nil {
+		return err
+	}
+	if project == "" {
+		project = "none"
+	}
+
+	fmt.Printf("config file:    %s\n", filepath.Join(configDir, "config.yaml"))
+	fmt.Printf("project config: %s\n", project)
+	fmt.Printf("templates:      %s\n", filepath.Join(configDir, "prompts"))
+	fmt.Printf("data:           %s\n", dataDir)
+	fmt.Printf("cache:          %s\n", cacheDir)
+	return nil
+}
+
+// editConfig opens the config file in the user's editor, creating its
+// directory if need be.
+func editConfig() error {
+	dir, err := defaultConfigDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	args := append(editorCommand(), filepath.Join(dir, "config.yaml"))
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return errors.New("editor: " + err.Error())
+	}
+	// Catch mistakes while the file is still fresh in mind.
+	_, err = loadConfig()
+	return err
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
+	"github.com/muesli/termenv"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// doctorTimeout bounds the test request gpt doctor sends.
+const doctorTimeout = 30 * time.Second
+
+// checkResult is the outcome of one of gpt doctor's checks.
+type checkResult struct {
+	level  checkLevel
+	name   string
+	detail string
+	// fix says what to do about a warning or failure.
+	fix string
+}
+
+type checkLevel int
+
+const (
+	checkOK checkLevel = iota
+	checkWarn
+	checkFail
+)
+
+// doctor runs the checks in turn, printing each result as it comes.
+type doctor struct {
+	out      io.Writer
+	failures int
+	warnings int
+}
+
+func (d *doctor) report(r checkResult) {
+	mark := "ok  "
+	switch r.level {
+	case checkWarn:
+		mark = "warn"
+		d.warnings++
+	case checkFail:
+		mark = "FAIL"
+		d.failures++
+	}
+	fmt.Fprintf(d.out, "[%s] %s: %s\n", mark, r.name, r.detail)
+	if r.fix != "" && r.level != checkOK {
+		fmt.Fprintf(d.out, "       %s\n", r.fix)
+	}
+}
+
+// runDoctor implements the doctor subcommand, which checks the config, the
+// API key, the provider and the terminal, and says what to do about anything
+// wrong.
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	offline := fs.Bool("offline", false, "skip the test request to the provider")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt doctor [-offline]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	d := &doctor{out: os.Stdout}
+	d.report(checkResult{name: "gpt", detail: currentBuild().String()})
+
+	cfg, ok := d.checkConfig()
+	if ok {
+		switch {
+		case !d.checkKey(cfg):
+			d.report(checkResult{name: "test request", detail: "skipped without an API key"})
+		case *offline:
+			d.report(checkResult{name: "test request", detail: "skipped with -offline"})
+		default:
+			d.report(checkProvider(cfg))
+		}
+	}
+	d.report(checkDataDir())
+	for _, r := range checkTerminal() {
+		d.report(r)
+	}
+
+	switch {
+	case d.failures == 1:
+		return errors.New("1 problem found")
+	case d.failures > 1:
+		return fmt.Errorf("%d problems found", d.failures)
+	case d.warnings > 0:
+		fmt.Fprintf(d.out, "\nNo problems, %d warnings.\n", d.warnings)
+	default:
+		fmt.Fprintln(d.out, "\nNo problems found.")
+	}
+	return nil
+}
+
+// checkConfig loads the config, reporting on the files it comes from.
+func (d *doctor) checkConfig() (config, bool) {
+	dir, err := defaultConfigDir()
+	if err != nil {
+		d.report(checkResult{level: checkFail, name: "config", detail: err.Error()})
+		return config{}, false
+	}
+	path := filepath.Join(dir, "config.yaml")
+	info, statErr := os.Stat(path)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		d.report(checkResult{level: checkFail, name: "config", detail: err.Error(), fix: "fix the file with gpt config edit"})
+		return cfg, false
+	}
+	cfg.fillDefaults()
+	switch {
+	case errors.Is(statErr, os.ErrNotExist):
+		d.report(checkResult{name: "config", detail: "no config file; using the defaults"})
+	case statErr != nil:
+		d.report(checkResult{level: checkFail, name: "config", detail: statErr.Error()})
+	default:
+		d.report(checkResult{name: "config", detail: path})
+		d.report(checkConfigMode(path, info.Mode()))
+	}
+	if project, err := findProjectConfig(); err == nil && project != "" {
+		d.report(checkResult{name: "project config", detail: project})
+	}
+	d.report(checkResult{name: "provider", detail: fmt.Sprintf("%s, model %s", cfg.Provider, cfg.Model)})
+	return cfg, true
+}
+
+// checkConfigMode warns if a config file that may hold API keys can be read
+// by other users.
+func checkConfigMode(path string, mode os.FileMode) checkResult {
+	r := checkResult{name: "config permissions", detail: mode.Perm().String()}
+	if runtime.GOOS == "windows" {
+		r.detail = "not checked on Windows"
+		return r
+	}
+	if mode.Perm()&0o077 == 0 {
+		return r
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		r.level, r.detail = checkFail, err.Error()
+		return r
+	}
+	if strings.Contains(string(data), "api_key:") || strings.Contains(string(data), "headers:") {
+		r.level = checkWarn
+		r.detail += ", readable by other users and holding secrets"
+		r.fix = "run chmod 600 " + path + ", or keep the key in the keychain with gpt auth login"
+	}
+	return r
+}
+
+// checkKey reports where the provider's API key comes from, if it needs one,
+// and whether there is one.
+func (d *doctor) checkKey(cfg config) bool {
+	if cfg.apiKey(cfg.Provider) == nil {
+		d.report(checkResult{name: "API key", detail: fmt.Sprintf("the %s provider needs none", cfg.Provider)})
+		return true
+	}
+	withKey, err := cfg.withStoredKey()
+	if err != nil {
+		d.report(checkResult{level: checkFail, name: "API key", detail: err.Error(), fix: "check api_key_cmd in the config file"})
+		return false
+	}
+	key := *withKey.apiKey(cfg.Provider)
+	if key == "" {
+		d.report(checkResult{
+			level:  checkFail,
+			name:   "API key",
+			detail: "not set",
+			fix:    fmt.Sprintf("run gpt auth login, or set %s", apiKeyEnv[cfg.Provider]),
+		})
+		return false
+	}
+	from := "config file"
+	switch {
+	case os.Getenv(apiKeyEnv[cfg.Provider]) == key:
+		from = apiKeyEnv[cfg.Provider]
+	case *cfg.apiKey(cfg.Provider) == "" && cfg.APIKeyCmd != "":
+		from = "api_key_cmd"
+	case *cfg.apiKey(cfg.Provider) == "":
+		from = "keychain"
+	}
+	d.report(checkResult{name: "API key", detail: fmt.Sprintf("%s from %s", maskKey(key), from)})
+	return true
+}
+
+// checkProvider sends the cheapest request there is, asking for a single
+// token, and reports how long the reply took to start.
+func checkProvider(cfg config) checkResult {
+	r := checkResult{name: "test request"}
+	prov, err := newProvider(cfg)
+	if err != nil {
+		r.level, r.detail = checkFail, err.Error()
+		return r
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	start := time.Now()
+	stream, err := prov.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model:     cfg.Model,
+		MaxTokens: 1,
+		Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Say OK."}},
+		Stream:    true,
+	})
+	if err == nil {
+		defer stream.Close()
+		_, err = stream.Recv()
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		r.level, r.detail = checkFail, err.Error()
+		var apiErr *openai.APIError
+		var reqErr *openai.RequestError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.HTTPStatusCode == 401,
+			errors.As(err, &reqErr) && reqErr.HTTPStatusCode == 401:
+			r.fix = "the API key was refused; check it with gpt auth status"
+		case errors.As(err, &apiErr) && apiErr.HTTPStatusCode == 404,
+			errors.As(err, &reqErr) && reqErr.HTTPStatusCode == 404:
+			r.fix = fmt.Sprintf("check that the model %s exists and base_url is right", cfg.Model)
+		case errors.Is(err, context.DeadlineExceeded):
+			r.fix = "no reply in time; check the network, proxy and base_url"
+		default:
+			r.fix = "check the network, proxy and base_url"
+		}
+		return r
+	}
+	r.detail = fmt.Sprintf("%s replied in %s", cfg.Model, time.Since(start).Round(time.Millisecond))
+	return r
+}
+
+// checkDataDir reports whether conversations and sessions can be saved.
+func checkDataDir() checkResult {
+	r := checkResult{name: "data directory"}
+	dir, err := defaultDataDir()
+	if err == nil {
+		err = os.MkdirAll(dir, 0o700)
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.CreateTemp(dir, ".doctor-*")
+	}
+	if err != nil {
+		r.level, r.detail = checkFail, err.Error()
+		r.fix = "conversations can't be saved; check the directory's permissions or set XDG_DATA_HOME"
+		return r
+	}
+	f.Close()
+	os.Remove(f.Name())
+	r.detail = dir + " is writable"
+	return r
+}
+
+// checkTerminal reports what the terminal can show and whether copying to
+// the clipboard is likely to work.
+func checkTerminal() []checkResult {
+	if !term.IsTerminal(os.Stdout.Fd()) {
+		return []checkResult{{name: "terminal", detail: "output isn't a terminal; skipping the terminal checks"}}
+	}
+	var results []checkResult
+
+	colors := checkResult{name: "colors"}
+	switch lipgloss.ColorProfile() {
+	case termenv.TrueColor:
+		colors.detail = "true color"
+	case termenv.ANSI256:
+		colors.detail = "256 colors"
+		colors.level = checkWarn
+		colors.fix = "hex colors in themes are shown approximately; set COLORTERM=truecolor if the terminal supports more"
+	case termenv.ANSI:
+		colors.detail = "16 colors"
+		colors.level = checkWarn
+		colors.fix = "themes and code highlighting will look plain; check TERM"
+	default:
+		colors.detail = "none"
+		colors.level = checkWarn
+		colors.fix = "NO_COLOR is set or TERM is dumb"
+	}
+	results = append(results, colors)
+
+	results = append(results, checkOSC52())
+
+	system := checkResult{name: "system clipboard", detail: "available"}
+	if clipboard.Unsupported {
+		system.detail = "unavailable"
+		system.level = checkWarn
+		system.fix = "install xclip, xsel or wl-clipboard, or rely on OSC 52"
+	}
+	results = append(results, system)
+	return results
+}
+
+// osc52Terminals are the TERM_PROGRAMs known to accept OSC 52 copies.
+var osc52Terminals = map[string]bool{
+	"iTerm.app": true,
+	"WezTerm":   true,
+	"ghostty":   true,
+	"kitty":     true,
+	"alacritty": true,
+	"vscode":    true,
+}
+
+// checkOSC52 guesses whether the terminal accepts OSC 52 copies, which is
+// how /copy and Ctrl+Y reach the clipboard over SSH. There is no asking a
+// terminal, so this goes by what is known of it.
+func checkOSC52() checkResult {
+	r := checkResult{name: "OSC 52 clipboard"}
+	if os.Getenv("TMUX") != "" {
+		out, err := runTmux("show-options", "-gv", "set-clipboard")
+		setting := strings.TrimSpace(out)
+		if err != nil || setting == "off" {
+			r.level = checkWarn
+			r.detail = "tmux won't pass copies on"
+			r.fix = "add set -g set-clipboard on to ~/.tmux.conf"
+			return r
+		}
+		r.detail = "tmux set-clipboard is " + setting
+		return r
+	}
+	program := os.Getenv("TERM_PROGRAM")
+	switch {
+	case osc52Terminals[program], os.Getenv("KITTY_WINDOW_ID") != "", os.Getenv("WT_SESSION") != "":
+		r.detail = "supported"
+	case program == "Apple_Terminal":
+		r.level = checkWarn
+		r.detail = "not supported by Terminal.app"
+		r.fix = "copying works locally through the system clipboard, but not over SSH"
+	default:
+		r.level = checkWarn
+		r.detail = "unknown for this terminal"
+		r.fix = "if /copy doesn't work over SSH, enable clipboard access in the terminal's settings"
+	}
+	return r
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runEmbed implements the embed subcommand, which prints the embeddings of
+// text for use in scripts.
+func runEmbed(args []string) error {
+	fs := flag.NewFlagSet("embed", flag.ExitOnError)
+	format := fs.String("format", "json", "json, or binary for little-endian float32s one vector after another")
+	model := fs.String("model", "", "embedding model, overriding the config file")
+	files := fs.Bool("f", false, "arguments are files to embed, one embedding for each")
+	lines := fs.Bool("lines", false, "embed each line of stdin separately")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt embed [-format json|binary] [-model name] [-f] [-lines] [text or file...]\n" +
+			"Without arguments, stdin is embedded.\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *format != "json" && *format != "binary" {
+		return fmt.Errorf("unknown format %q; use json or binary", *format)
+	}
+
+	input, err := embedInput(fs.Args(), *files, *lines)
+	if err != nil {
+		return err
+	}
+	if len(input) == 0 {
+		return errors.New("nothing to embed")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *model != "" {
+		cfg.EmbeddingModel = *model
+	}
+	cfg.fillDefaults()
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	e, err := newEmbedder(cfg, prov)
+	if err != nil {
+		return err
+	}
+
+	var vectors [][]float32
+	for start := 0; start < len(input); start += embedBatch {
+		end := start + embedBatch
+		if end > len(input) {
+			end = len(input)
+		}
+		batch, err := e.CreateEmbeddings(context.Background(), cfg.EmbeddingModel, input[start:end])
+		if err != nil {
+			return err
+		}
+		vectors = append(vectors, batch...)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if *format == "binary" {
+		for _, vector := range vectors {
+			if err := binary.Write(w, binary.LittleEndian, vector); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}
+
+	err = json.NewEncoder(w).Encode(struct {
+		Model      string      `json:"model"`
+		Embeddings [][]float32 `json:"embeddings"`
+	}{cfg.EmbeddingModel, vectors})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// embedInput collects what to embed: the arguments as text or as files, or
+// else stdin, whole or line by line.
+func embedInput(args []string, files, lines bool) ([]string, error) {
+	if len(args) > 0 {
+		if !files {
+			return args, nil
+		}
+		input := make([]string, len(args))
+		for i, path := range args {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			input[i] = string(data)
+		}
+		return input, nil
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	if !lines {
+		if strings.TrimSpace(string(data)) == "" {
+			return nil, nil
+		}
+		return []string{string(data)}, nil
+	}
+
+	var input []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			input = append(input, line)
+		}
+	}
+	return input, nil
+}
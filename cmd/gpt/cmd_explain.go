@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const explainPrompt = `You explain command lines for the {{.Shell}} shell on {{.GOOS}}.
+
+Answer in Markdown, in this shape and nothing else:
+
+A sentence or two on what the whole command does.
+
+Then, for each stage of a pipeline or each command joined by &&, ||, or ;, a "### ` + "`stage`" + `" heading with the stage as written, followed by a bullet per part of it: "- ` + "`part`" + ` — what it does", covering the program, each flag and argument, and each redirection. Explain combined short flags such as -xvf one by one.
+
+End with a "### Watch out" section only if the command can delete or overwrite data, needs privileges, or behaves differently elsewhere than on {{.GOOS}}.
+
+If it isn't a command line, say so in one sentence.`
+
+// runExplain implements the explain subcommand, which breaks a command line
+// down flag by flag. The command comes after -- or on stdin.
+func runExplain(args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	modelName := fs.String("model", "", "model to use, overriding the config file")
+	force := fs.Bool("force", false, "send the request even once the spending budget is used up")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt explain [-model name] -- <command>\n       echo <command> | gpt explain\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	command := shellJoin(fs.Args())
+	if command == "" {
+		stdin, err := readStdin()
+		if err != nil {
+			return err
+		}
+		command = strings.TrimSpace(stdin)
+	}
+	if command == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *modelName != "" {
+		cfg.Model = *modelName
+	}
+	cfg.fillDefaults()
+	cfg.SystemPrompt = explainPrompt
+
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	spending, err := newBudget(cfg, *force)
+	if err != nil {
+		return err
+	}
+	warning, err := spending.check()
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+
+	_, err = printReply(cfg, prov, spending, command)
+	return err
+}
+
+// printReply sends message with the configured system prompt and prints the
+// reply, rendered as Markdown on a terminal and streamed as it is otherwise.
+func printReply(cfg config, p provider, b *budget, message string) (string, error) {
+	req, err := newChatRequest(cfg, detectPromptData(), []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: message},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// On a terminal the reply is rendered once it is complete; otherwise the
+	// Markdown is streamed as it comes.
+	width, _, sizeErr := term.GetSize(os.Stdout.Fd())
+	rendered := sizeErr == nil
+	if rendered {
+		fmt.Fprintln(os.Stderr, "Thinking...")
+	}
+	var reply strings.Builder
+	result, err := streamChat(context.Background(), p, req, func(delta string) {
+		if !rendered {
+			fmt.Print(delta)
+		}
+		reply.WriteString(delta)
+	})
+	truncated := errors.Is(err, errTruncated)
+	if err != nil && !truncated {
+		return "", err
+	}
+	if result.usage.TotalTokens > 0 {
+		if err := b.record(cfg, cfg.Model, result.usage); err != nil {
+			return "", err
+		}
+	}
+	if truncated {
+		defer fmt.Fprintln(os.Stderr, "The reply was cut off at the max_tokens limit; raise max_tokens in the config for more.")
+	}
+	if reply.Len() == 0 {
+		return "", errors.New("the model sent an empty reply")
+	}
+
+	if !rendered {
+		fmt.Println()
+		return reply.String(), nil
+	}
+	t, err := resolveTheme(cfg)
+	if err != nil {
+		return "", err
+	}
+	r := newMessageRenderer(width, t)
+	fmt.Println(r.renderMarkdown(reply.String(), false))
+	return reply.String(), nil
+}
+
+// shellJoin puts arguments back together into a command line, quoting those
+// the shell would have split or expanded. A single argument is taken to be a
+// whole command line, quoted so that pipes and the like reach gpt.
+func shellJoin(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`*?[]{}()<>|&;#~!") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
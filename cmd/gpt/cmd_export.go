@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+)
+
+// runExport implements the export subcommand. It accepts the name of a saved
+// session or a conversation ID.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "", "markdown or json (default: from the output file name, else markdown)")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt export [-format markdown|json] [-o file] <session or conversation ID>\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	t, err := loadTranscript(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *format == "" {
+		*format = exportFormat(*output)
+	}
+	if *output == "" {
+		return writeTranscript(os.Stdout, t, *format)
+	}
+	return exportToFile(*output, t, *format)
+}
+
+// loadTranscript looks name up as a saved session first and then as a
+// conversation ID.
+func loadTranscript(name string) (transcript, error) {
+	sessions, err := newSessionStore()
+	if err != nil {
+		return transcript{}, err
+	}
+	sess, err := sessions.load(name)
+	if err == nil {
+		return transcript{
+			Title:    sess.Name,
+			Model:    sess.Model,
+			Messages: sess.Messages,
+		}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return transcript{}, err
+	}
+
+	store, err := newHistoryStore()
+	if err != nil {
+		return transcript{}, err
+	}
+	info, err := store.info(name)
+	if err != nil {
+		return transcript{}, err
+	}
+	conv, err := store.open(info.ID)
+	if err != nil {
+		return transcript{}, err
+	}
+	return transcript{
+		Title:    info.Title,
+		Messages: conv.Messages,
+	}, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const fixPrompt = `You help with commands that failed in the {{.Shell}} shell on {{.GOOS}}.
+
+The user sends what they know of the failure: the command, its exit status, and its output. Answer in Markdown: say in a sentence or two what went wrong and why, then give the corrected command in a single fenced code block, after everything else. If the fix isn't a command (installing something, editing a file), say what to do instead and leave the code block out. Don't guess at a fix the output doesn't support; say what to check instead.`
+
+// runFix implements the fix subcommand, which explains why a command failed
+// and suggests one that works. The output comes from running the command
+// given after --, or else from stdin.
+func runFix(args []string) error {
+	fs := flag.NewFlagSet("fix", flag.ExitOnError)
+	command := fs.String("command", "", "the command that failed, when its output is piped in")
+	status := fs.Int("status", -1, "the exit status of the command that failed")
+	modelName := fs.String("model", "", "model to use, overriding the config file")
+	force := fs.Bool("force", false, "send the request even once the spending budget is used up")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: <command> 2>&1 | gpt fix [-command cmd] [-status n]\n       gpt fix -- <command>\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var (
+		output string
+		err    error
+	)
+	if fs.NArg() > 0 {
+		*command = shellJoin(fs.Args())
+		fmt.Fprintf(os.Stderr, "$ %s\n", *command)
+		if output, *status, err = rerunCommand(*command); err != nil {
+			return err
+		}
+		if *status == 0 {
+			fmt.Fprintln(os.Stderr, "The command succeeded; there is nothing to fix.")
+			return nil
+		}
+	} else if output, err = readStdin(); err != nil {
+		return err
+	}
+	if output == "" && *command == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *modelName != "" {
+		cfg.Model = *modelName
+	}
+	cfg.fillDefaults()
+	cfg.SystemPrompt = fixPrompt
+
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	spending, err := newBudget(cfg, *force)
+	if err != nil {
+		return err
+	}
+	warning, err := spending.check()
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+
+	var message strings.Builder
+	if *command != "" {
+		fmt.Fprintf(&message, "Command: %s\n", *command)
+	}
+	if *status >= 0 {
+		fmt.Fprintf(&message, "Exit status: %d\n", *status)
+	}
+	if output != "" {
+		fmt.Fprintf(&message, "Output:\n\n```\n%s\n```\n", strings.TrimRight(lastLines(output, maxFixLines), "\n"))
+	}
+
+	reply, err := printReply(cfg, prov, spending, message.String())
+	if err != nil {
+		return err
+	}
+	blocks := parseCodeBlocks(reply)
+	if len(blocks) == 0 {
+		return nil
+	}
+	fixed := strings.TrimSpace(blocks[len(blocks)-1].Code)
+	if fixed == "" || strings.Contains(fixed, "\n") {
+		return nil
+	}
+	return offerCommand(fixed)
+}
+
+// maxFixLines is how much of the end of a failed command's output is sent;
+// the error is nearly always at the end.
+const maxFixLines = 200
+
+// rerunCommand runs command in the user's shell, passing its output through
+// to stderr as well as returning it, along with its exit status.
+func rerunCommand(command string) (string, int, error) {
+	cmd := shellCommand(context.Background(), command)
+	var out strings.Builder
+	// Being the same writer, stdout and stderr are written one at a time.
+	w := io.MultiWriter(&out, os.Stderr)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return out.String(), exitErr.ExitCode(), nil
+	case err != nil:
+		return "", 0, err
+	}
+	return out.String(), 0, nil
+}
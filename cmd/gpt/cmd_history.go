@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const historyUsage = `usage: gpt history list
+       gpt history show <id>
+       gpt history delete <id>
+       gpt history rename <id> <title>`
+
+// runHistory implements the history subcommand, which manages stored
+// conversations.
+func runHistory(args []string) error {
+	if len(args) == 0 {
+		return errors.New(historyUsage)
+	}
+
+	store, err := newHistoryStore()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		return listHistory(store)
+	case "show":
+		if len(args) != 2 {
+			return errors.New(historyUsage)
+		}
+		return showHistory(store, args[1])
+	case "delete", "rm":
+		if len(args) != 2 {
+			return errors.New(historyUsage)
+		}
+		return store.delete(args[1])
+	case "rename":
+		if len(args) < 3 {
+			return errors.New(historyUsage)
+		}
+		return store.rename(args[1], strings.Join(args[2:], " "))
+	}
+	return errors.New(historyUsage)
+}
+
+func listHistory(store *historyStore) error {
+	infos, err := store.list()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tCREATED\tUPDATED\tMESSAGES\tTOKENS")
+	for _, info := range infos {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t~%d\n",
+			info.ID,
+			info.Title,
+			formatTime(info.Created),
+			formatTime(info.Updated),
+			info.Messages,
+			info.Tokens,
+		)
+	}
+	return w.Flush()
+}
+
+func showHistory(store *historyStore, id string) error {
+	info, err := store.info(id)
+	if err != nil {
+		return err
+	}
+	conv, err := store.open(info.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (%s)\n", info.Title, info.ID)
+	for _, msg := range conv.Messages {
+		switch msg.Role {
+		case openai.ChatMessageRoleUser:
+			fmt.Printf("\nYou: %s\n", msg.Content)
+		case openai.ChatMessageRoleAssistant:
+			if msg.Content != "" {
+				fmt.Printf("\nAssistant: %s\n", msg.Content)
+			}
+			for _, call := range msg.ToolCalls {
+				fmt.Printf("\nAssistant called %s\n", formatToolCall(call))
+			}
+		}
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runIndex implements the index subcommand, which embeds the code in a
+// directory so that gpt ask and gpt -index can find what is relevant.
+func runIndex(args []string) error {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt index [dir]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	root := "."
+	if fs.NArg() == 1 {
+		root = fs.Arg(0)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.fillDefaults()
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	e, err := newEmbedder(cfg, prov)
+	if err != nil {
+		return err
+	}
+
+	stats, err := buildIndex(context.Background(), cfg, e, root)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d files in %d chunks (%d embedded, %d removed)\n",
+		stats.files, stats.chunks, stats.embedded, stats.deleted)
+	return nil
+}
+
+// runAsk implements the ask subcommand, which answers a question about the
+// code indexed by gpt index.
+func runAsk(args []string) error {
+	fs := flag.NewFlagSet("ask", flag.ExitOnError)
+	dir := fs.String("dir", ".", "the indexed directory to ask about")
+	chunks := fs.Int("k", defaultRetrievedChunks, "number of pieces of code to send with the question")
+	force := fs.Bool("force", false, "send the request even once the spending budget is used up")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt ask [-dir dir] [-k n] <question>\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	question := strings.Join(fs.Args(), " ")
+	if question == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.fillDefaults()
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	r, err := newRetriever(cfg, prov, *dir, *chunks)
+	if err != nil {
+		return err
+	}
+	spending, err := newBudget(cfg, *force)
+	if err != nil {
+		return err
+	}
+	store, err := newHistoryStore()
+	if err != nil {
+		return err
+	}
+
+	conv, err := store.create()
+	if err != nil {
+		return err
+	}
+	defer conv.discardIfEmpty()
+	return runOneShot(cfg, prov, spending, conv, question, r, nil, outputOptions{})
+}
+
+// newRetriever loads the index of dir for adding code to requests.
+func newRetriever(cfg config, p provider, dir string, chunks int) (*retriever, error) {
+	ix, err := loadIndex(cfg, dir)
+	if err != nil {
+		return nil, err
+	}
+	e, err := newEmbedder(cfg, p)
+	if err != nil {
+		return nil, err
+	}
+	return &retriever{index: ix, embedder: e, chunks: chunks}, nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC error codes.
+const (
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+const (
+	conversationURIPrefix = "gpt://conversations/"
+	sessionURIPrefix      = "gpt://sessions/"
+)
+
+// runMCPServe implements the mcp-serve subcommand, which speaks the Model
+// Context Protocol on stdin and stdout so that other agents and editors can
+// chat through gpt and read its stored conversations and sessions.
+func runMCPServe(args []string) error {
+	fs := flag.NewFlagSet("mcp-serve", flag.ExitOnError)
+	modelName := fs.String("model", "", "model to chat with unless a call names one")
+	metricsAddr := fs.String("metrics", "", "serve Prometheus metrics at `addr`/metrics, e.g. :9090")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt mcp-serve [-model name] [-metrics addr]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *modelName != "" {
+		cfg.Model = *modelName
+	}
+	cfg.fillDefaults()
+	if *metricsAddr != "" {
+		if err := serveMetrics(*metricsAddr); err != nil {
+			return err
+		}
+	}
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	spending, err := newBudget(cfg, false)
+	if err != nil {
+		return err
+	}
+	store, err := newHistoryStore()
+	if err != nil {
+		return err
+	}
+	sessions, err := newSessionStore()
+	if err != nil {
+		return err
+	}
+
+	s := &mcpServer{
+		config:   cfg,
+		provider: prov,
+		budget:   spending,
+		store:    store,
+		sessions: sessions,
+		out:      os.Stdout,
+	}
+	return s.serve(os.Stdin)
+}
+
+// mcpServer answers MCP requests with the chat and the local stores.
+type mcpServer struct {
+	config   config
+	provider provider
+	budget   *budget
+	store    *historyStore
+	sessions *sessionStore
+
+	mu  sync.Mutex
+	out io.Writer
+
+	busy busySet
+}
+
+type mcpServerRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// serve handles requests until r ends. Each is handled on its own, so that
+// a long chat doesn't hold up pings and the like.
+func (s *mcpServer) serve(r io.Reader) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var req mcpServerRequest
+			if jsonErr := json.Unmarshal(line, &req); jsonErr != nil {
+				s.reply(nil, nil, &mcpError{Code: rpcInvalidRequest, Message: jsonErr.Error()})
+			} else if len(req.ID) > 0 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					result, err := s.handle(context.Background(), req)
+					s.reply(req.ID, result, err)
+				}()
+			}
+			// Notifications need no answer.
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// reply writes a response, reporting err if it isn't nil.
+func (s *mcpServer) reply(id json.RawMessage, result any, err error) {
+	resp := map[string]any{"jsonrpc": "2.0", "id": id}
+	if err != nil {
+		var rpcErr *mcpError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &mcpError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.Write(append(data, '\n'))
+}
+
+func (s *mcpServer) handle(ctx context.Context, req mcpServerRequest) (any, error) {
+	switch req.Method {
+	case "initialize":
+		return map[string]any{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities": map[string]any{
+				"tools":     map[string]any{},
+				"resources": map[string]any{},
+			},
+			"serverInfo": map[string]string{"name": "gpt-cli", "version": "1.0"},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		return map[string]any{"tools": mcpServerTools}, nil
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		text, err := s.callTool(ctx, params.Name, params.Arguments)
+		if err != nil {
+			// Failures of the tool itself go to the caller's model.
+			return map[string]any{
+				"content": []mcpContent{{Type: "text", Text: err.Error()}},
+				"isError": true,
+			}, nil
+		}
+		return map[string]any{"content": []mcpContent{{Type: "text", Text: text}}}, nil
+	case "resources/list":
+		resources, err := s.resources()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"resources": resources}, nil
+	case "resources/read":
+		var params struct {
+			URI string `json:"uri"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		text, err := s.readResource(params.URI)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"contents": []map[string]string{
+			{"uri": params.URI, "mimeType": "text/markdown", "text": text},
+		}}, nil
+	}
+	return nil, &mcpError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+// mcpServerTools are the tools gpt mcp-serve offers.
+var mcpServerTools = []mcpTool{
+	{
+		Name:        "chat",
+		Description: "Send a message to the configured model and get its reply. Pass the conversation_id of an earlier reply to continue that conversation; otherwise a new one is started.",
+		InputSchema: mustMarshal(jsonSchema{
+			Type: "object",
+			Properties: map[string]jsonSchema{
+				"message":         {Type: "string", Description: "The message to send"},
+				"conversation_id": {Type: "string", Description: "The conversation to continue"},
+				"model":           {Type: "string", Description: "The model to use instead of the configured one"},
+				"system_prompt":   {Type: "string", Description: "The system prompt to use instead of the configured one"},
+			},
+			Required: []string{"message"},
+		}),
+	},
+	{
+		Name:        "list_conversations",
+		Description: "List the stored conversations, newest last, with their IDs and titles.",
+		InputSchema: mustMarshal(jsonSchema{Type: "object"}),
+	},
+}
+
+func mustMarshal(v any) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func (s *mcpServer) callTool(ctx context.Context, name string, args json.RawMessage) (string, error) {
+	switch name {
+	case "chat":
+		var params struct {
+			Message        string  `json:"message"`
+			ConversationID string  `json:"conversation_id"`
+			Model          string  `json:"model"`
+			SystemPrompt   *string `json:"system_prompt"`
+		}
+		if err := json.Unmarshal(args, &params); err != nil {
+			return "", err
+		}
+		if params.Message == "" {
+			return "", errors.New("no message given")
+		}
+		cfg := s.config
+		if params.Model != "" {
+			cfg.Model = params.Model
+		}
+		if params.SystemPrompt != nil {
+			cfg.SystemPrompt = *params.SystemPrompt
+		}
+		return s.chat(ctx, cfg, params.ConversationID, params.Message)
+	case "list_conversations":
+		infos, err := s.store.list()
+		if err != nil {
+			return "", err
+		}
+		if len(infos) == 0 {
+			return "No conversations yet.", nil
+		}
+		var b strings.Builder
+		for _, info := range infos {
+			fmt.Fprintf(&b, "%s\t%s\t%d messages\n", info.ID, info.Title, info.Messages)
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("there is no tool called %q", name)
+}
+
+// chat sends message in the conversation with the given ID, or a new one,
+// and returns the reply followed by the conversation's ID.
+func (s *mcpServer) chat(ctx context.Context, cfg config, id, message string) (string, error) {
+	var conv *conversation
+	if id == "" {
+		var err error
+		if conv, err = s.store.create(); err != nil {
+			return "", err
+		}
+		id = conv.ID
+	} else {
+		// The ID may be "last".
+		info, err := s.store.info(id)
+		if err != nil {
+			return "", err
+		}
+		id = info.ID
+	}
+	// A new conversation is claimed too, as another call may be asking for
+	// the last one already, and one asked for is only read once claimed.
+	if !s.busy.claim(id) {
+		return "", fmt.Errorf("conversation %q is waiting for a reply", id)
+	}
+	defer s.busy.release(id)
+	if conv == nil {
+		var err error
+		if conv, err = s.store.open(id); err != nil {
+			return "", err
+		}
+	}
+
+	reply, _, err := converse(ctx, cfg, s.provider, s.budget, conv, message, func(string) {})
+	if err != nil {
+		// A new conversation the message never reached isn't kept.
+		conv.discardIfEmpty()
+		return "", err
+	}
+	return fmt.Sprintf("%s\n\nconversation_id: %s", reply, conv.ID), nil
+}
+
+// resources lists the stored conversations and the saved sessions.
+func (s *mcpServer) resources() ([]mcpResource, error) {
+	var resources []mcpResource
+	infos, err := s.store.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		resources = append(resources, mcpResource{
+			URI:         conversationURIPrefix + info.ID,
+			Name:        info.Title,
+			Description: fmt.Sprintf("Conversation of %d messages, last updated %s", info.Messages, formatTime(info.Updated)),
+			MimeType:    "text/markdown",
+		})
+	}
+
+	names, err := s.sessions.names()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		resources = append(resources, mcpResource{
+			URI:         sessionURIPrefix + name,
+			Name:        name,
+			Description: "Saved session",
+			MimeType:    "text/markdown",
+		})
+	}
+	return resources, nil
+}
+
+// readResource returns a conversation or session as Markdown.
+func (s *mcpServer) readResource(uri string) (string, error) {
+	var t transcript
+	switch {
+	case strings.HasPrefix(uri, conversationURIPrefix):
+		info, err := s.store.info(strings.TrimPrefix(uri, conversationURIPrefix))
+		if err != nil {
+			return "", err
+		}
+		conv, err := s.store.open(info.ID)
+		if err != nil {
+			return "", err
+		}
+		t = transcript{Title: info.Title, Messages: conv.Messages}
+	case strings.HasPrefix(uri, sessionURIPrefix):
+		sess, err := s.sessions.load(strings.TrimPrefix(uri, sessionURIPrefix))
+		if err != nil {
+			return "", err
+		}
+		t = transcript{Title: sess.Name, Model: sess.Model, Messages: sess.Messages}
+	default:
+		return "", fmt.Errorf("unknown resource %q", uri)
+	}
+
+	var b strings.Builder
+	if err := writeMarkdown(&b, t); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/term"
+)
+
+// defaultPRTemplate is the body the model fills in when neither the config
+// file nor the repository has a template.
+const defaultPRTemplate = `## Summary
+
+## Changes
+
+## Testing`
+
+const prPrompt = `You write pull request descriptions from the commits and diff of a branch.
+
+Reply with the title on the first line, in plain text and under 72 characters, then a blank line, then the body in Markdown. Fill in the template below for the body, keeping its headings; leave out sections that don't apply rather than writing "N/A", and don't invent testing that the commits don't show.
+
+Template:
+
+`
+
+// runPR implements the pr subcommand, which describes the changes of the
+// current branch for a pull request and can open one with gh.
+func runPR(args []string) error {
+	fs := flag.NewFlagSet("pr", flag.ExitOnError)
+	base := fs.String("base", "", "branch the pull request goes into (default: the remote's default branch)")
+	modelName := fs.String("model", "", "model to use, overriding the config file")
+	draft := fs.Bool("draft", false, "open the pull request as a draft")
+	printOnly := fs.Bool("print", false, "print the title and body instead of offering to open the pull request")
+	force := fs.Bool("force", false, "send the request even once the spending budget is used up")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt pr [-base branch] [-draft] [-print]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *base == "" {
+		*base = defaultBranch()
+	}
+	// Compare with the remote's copy of the base if there isn't a local one.
+	ref := *base
+	if _, err := git("rev-parse", "--verify", "--quiet", ref); err != nil {
+		ref = "origin/" + ref
+	}
+	log, err := git("log", "--reverse", "--format=%s%n%n%b", ref+"..HEAD")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(log) == "" {
+		return fmt.Errorf("the branch has no commits that aren't on %s", *base)
+	}
+	stat, err := git("diff", "--stat", "--no-color", ref+"...HEAD")
+	if err != nil {
+		return err
+	}
+	diff, err := git("diff", "--no-color", ref+"...HEAD")
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *modelName != "" {
+		cfg.Model = *modelName
+	}
+	cfg.fillDefaults()
+	template, err := prTemplate(cfg)
+	if err != nil {
+		return err
+	}
+	// The template goes in as is; it isn't a prompt template.
+	cfg.SystemPrompt = prPrompt + strings.ReplaceAll(template, "{{", "{{`{{`}}")
+
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	spending, err := newBudget(cfg, *force)
+	if err != nil {
+		return err
+	}
+	warning, err := spending.check()
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+
+	fmt.Fprintln(os.Stderr, "Describing the changes...")
+	message := fmt.Sprintf("Commits:\n\n%s\nFiles changed:\n\n%s\nDiff:\n\n%s", log, stat, truncateDiff(diff))
+	description, err := completeOnce(cfg, prov, spending, message)
+	if err != nil {
+		return err
+	}
+	description = strings.TrimSpace(description)
+
+	if _, _, err := term.GetSize(os.Stdout.Fd()); *printOnly || err != nil {
+		fmt.Println(description)
+		return nil
+	}
+
+	keys, err := newKeyMap(cfg.Keys)
+	if err != nil {
+		return err
+	}
+	t, err := resolveTheme(cfg)
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("Into %s. The first line is the title.\n\n%s", *base, stat)
+	m := newDraftModel(description, header, "open it with gh", keys, newStyles(t))
+	if _, err := tea.NewProgram(m).Run(); err != nil {
+		return err
+	}
+	if !m.accepted {
+		fmt.Println(m.textarea.Value())
+		return nil
+	}
+	return openPR(*base, m.textarea.Value(), *draft)
+}
+
+// defaultBranch returns the branch origin's HEAD points to, or "main".
+func defaultBranch() string {
+	ref, err := git("symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+	if err != nil {
+		return "main"
+	}
+	return strings.TrimPrefix(strings.TrimSpace(ref), "origin/")
+}
+
+// prTemplate returns pr_template from the config file, or else the
+// repository's own pull request template, or else defaultPRTemplate.
+func prTemplate(cfg config) (string, error) {
+	if cfg.PRTemplate != "" {
+		return cfg.PRTemplate, nil
+	}
+
+	root, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	root = strings.TrimSpace(root)
+	for _, name := range []string{
+		".github/pull_request_template.md",
+		".github/PULL_REQUEST_TEMPLATE.md",
+		"PULL_REQUEST_TEMPLATE.md",
+		"pull_request_template.md",
+		"docs/pull_request_template.md",
+		"docs/PULL_REQUEST_TEMPLATE.md",
+	} {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err == nil {
+			return string(data), nil
+		}
+	}
+	return defaultPRTemplate, nil
+}
+
+// openPR pushes the branch if it has no upstream yet and opens a pull request
+// with gh, taking the title from the first line of description.
+func openPR(base, description string, draft bool) error {
+	if _, err := exec.LookPath("gh"); err != nil {
+		return errors.New("opening pull requests needs the GitHub CLI, gh")
+	}
+	title, body, _ := strings.Cut(strings.TrimSpace(description), "\n")
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return errors.New("the pull request has no title")
+	}
+
+	if _, err := git("rev-parse", "--abbrev-ref", "@{upstream}"); err != nil {
+		push := exec.Command("git", "push", "--set-upstream", "origin", "HEAD")
+		push.Stdout = os.Stderr
+		push.Stderr = os.Stderr
+		if err := push.Run(); err != nil {
+			return err
+		}
+	}
+
+	args := []string{"pr", "create", "--base", base, "--title", title, "--body-file", "-"}
+	if draft {
+		args = append(args, "--draft")
+	}
+	cmd := exec.Command("gh", args...)
+	cmd.Stdin = strings.NewReader(strings.TrimSpace(body) + "\n")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
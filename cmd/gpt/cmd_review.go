@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const reviewPrompt = `You review code changes like a careful senior engineer.
+
+The user sends a unified diff in which each line of the new version starts with its line number and a tab. Comment only on lines the diff adds or changes, and only where it matters: bugs, security problems, unhandled errors, races, unclear code, missing tests. Don't praise, and don't comment on style a formatter would fix.
+
+Reply with JSON only, no code fence, in this shape:
+
+{"summary": "one or two sentences on the change as a whole", "comments": [{"file": "path/as/in/the/diff", "line": 42, "severity": "issue", "body": "what is wrong and how to fix it"}]}
+
+severity is "issue" for something that should be fixed before merging, "suggestion" for an improvement, "question" where the intent is unclear, and "nit" for something minor. Give an empty list if there is nothing worth saying.`
+
+// review is what gpt review finds, and what -format json prints.
+type review struct {
+	Summary  string          `json:"summary"`
+	Comments []reviewComment `json:"comments"`
+}
+
+type reviewComment struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Body     string `json:"body"`
+}
+
+// runReview implements the review subcommand, which reviews a diff from a
+// file, a git range or stdin, or else the uncommitted changes.
+func runReview(args []string) error {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	failOn := fs.String("fail-on", "", "exit with status 1 if there is a comment of this severity or worse: issue, suggestion, question or nit")
+	modelName := fs.String("model", "", "model to use, overriding the config file")
+	force := fs.Bool("force", false, "send the request even once the spending budget is used up")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt review [-format text|json] [-fail-on severity] [file.diff | git range]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if _, ok := reviewSeverities[*failOn]; *failOn != "" && !ok {
+		return fmt.Errorf("unknown severity %q", *failOn)
+	}
+
+	diff, err := reviewInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(diff) == "" {
+		return errors.New("there are no changes to review")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *modelName != "" {
+		cfg.Model = *modelName
+	}
+	cfg.fillDefaults()
+	cfg.SystemPrompt = reviewPrompt
+
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	spending, err := newBudget(cfg, *force)
+	if err != nil {
+		return err
+	}
+	warning, err := spending.check()
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+
+	numbered, lines := numberDiff(truncateDiff(diff))
+	fmt.Fprintln(os.Stderr, "Reviewing...")
+	reply, err := completeOnce(cfg, prov, spending, numbered)
+	if err != nil {
+		return err
+	}
+	var r review
+	if err := json.Unmarshal([]byte(unfence(reply)), &r); err != nil {
+		return fmt.Errorf("the review isn't valid JSON: %w", err)
+	}
+	if r.Comments == nil {
+		r.Comments = []reviewComment{}
+	}
+	sort.SliceStable(r.Comments, func(i, j int) bool {
+		a, b := r.Comments[i], r.Comments[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	} else {
+		t, err := resolveTheme(cfg)
+		if err != nil {
+			return err
+		}
+		printReview(r, lines, newStyles(t))
+	}
+
+	if *failOn != "" {
+		for _, c := range r.Comments {
+			if reviewSeverities[c.Severity] >= reviewSeverities[*failOn] {
+				os.Exit(1)
+			}
+		}
+	}
+	return nil
+}
+
+// reviewSeverities ranks the severities, the worst highest.
+var reviewSeverities = map[string]int{"nit": 1, "question": 2, "suggestion": 3, "issue": 4}
+
+// reviewInput returns the diff to review: the file named by arg, or else the
+// git diff of arg as a range, or else stdin, or else the changes since HEAD.
+func reviewInput(arg string) (string, error) {
+	if arg != "" {
+		if data, err := os.ReadFile(arg); err == nil {
+			return string(data), nil
+		}
+		return git("diff", "--no-color", arg)
+	}
+
+	stdin, err := readStdin()
+	if err != nil || stdin != "" {
+		return stdin, err
+	}
+	return git("diff", "--no-color", "HEAD")
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// numberDiff prefixes the lines of diff that are in the new version with
+// their line numbers, and returns them by file and line as well.
+func numberDiff(diff string) (string, map[string]map[int]string) {
+	lines := make(map[string]map[int]string)
+	var (
+		b    strings.Builder
+		file string
+		// next is the number of the next new line; oldLeft and newLeft
+		// count down the lines of the hunk.
+		next, oldLeft, newLeft int
+	)
+	for _, line := range strings.Split(diff, "\n") {
+		inHunk := oldLeft > 0 || newLeft > 0
+		switch {
+		case inHunk && strings.HasPrefix(line, "-"):
+			oldLeft--
+		case inHunk && (strings.HasPrefix(line, "+") || strings.HasPrefix(line, " ") || line == ""):
+			if !strings.HasPrefix(line, "+") {
+				oldLeft--
+			}
+			newLeft--
+			lines[file][next] = line
+			fmt.Fprintf(&b, "%d\t%s\n", next, line)
+			next++
+			continue
+		case strings.HasPrefix(line, "+++ "):
+			file, _, _ = strings.Cut(strings.TrimPrefix(line, "+++ "), "\t")
+			file = strings.TrimPrefix(file, "b/")
+			lines[file] = make(map[int]string)
+		case hunkHeader.MatchString(line):
+			m := hunkHeader.FindStringSubmatch(line)
+			oldLeft, newLeft = hunkCount(m[1]), hunkCount(m[3])
+			next, _ = strconv.Atoi(m[2])
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String(), lines
+}
+
+// hunkCount returns the line count of a hunk header, which is 1 if left out.
+func hunkCount(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// printReview shows the comments under the lines they are about.
+func printReview(r review, lines map[string]map[int]string, s styles) {
+	if r.Summary != "" {
+		fmt.Println(r.Summary)
+	}
+	if len(r.Comments) == 0 {
+		fmt.Println(s.footer.Render("No comments."))
+		return
+	}
+
+	file := ""
+	for _, c := range r.Comments {
+		if c.File != file {
+			file = c.File
+			fmt.Println()
+			fmt.Println(s.user.Render(file))
+		}
+		style := s.notice
+		if c.Severity == "issue" {
+			style = s.err
+		}
+		fmt.Printf("%s %s\n", s.footer.Render(fmt.Sprintf("%s:%d", c.File, c.Line)), style.Render(c.Severity))
+		if code, ok := lines[c.File][c.Line]; ok {
+			fmt.Println(s.footer.Render("  │ " + code))
+		}
+		fmt.Println(indent(c.Body, "  "))
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// runServe implements the serve subcommand, an HTTP API on the chat and the
+// stored conversations for editors and scripts on the same machine, and the
+// chat itself over SSH if asked for.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "`address` to listen on, or empty for no HTTP API")
+	modelName := fs.String("model", "", "model to chat with unless a request names one")
+	token := fs.String("token", os.Getenv("GPT_SERVE_TOKEN"), "require `token` as a bearer token on every request, overriding GPT_SERVE_TOKEN")
+	web := fs.Bool("web", false, "serve the web UI at /")
+	sshAddr := fs.String("ssh", "", "serve the chat over SSH at `address` too, e.g. :2222")
+	authorizedKeys := fs.String("authorized-keys", "", "let in the SSH keys in `file` instead of ~/.ssh/authorized_keys")
+	shareRoom := fs.Bool("room", false, "share one conversation among everyone connected over SSH, taking turns")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt serve [-addr address] [-model name] [-token token] [-web] [-ssh address [-room]]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 || (*addr == "" && *sshAddr == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *shareRoom && *sshAddr == "" {
+		return errors.New("-room needs -ssh")
+	}
+	if *addr != "" && *token == "" && !isLoopback(*addr) {
+		return fmt.Errorf("-token is needed to serve the API on %s, beyond this machine", *addr)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *modelName != "" {
+		cfg.Model = *modelName
+	}
+	cfg.fillDefaults()
+	s := &apiServer{token: *token, web: *web}
+	if host, _, err := net.SplitHostPort(*addr); err == nil {
+		s.host = host
+	}
+	s.metrics = enableMetrics()
+	if s.provider, err = newProvider(cfg); err != nil {
+		return err
+	}
+	if s.budget, err = newBudget(cfg, false); err != nil {
+		return err
+	}
+	if s.store, err = newHistoryStore(); err != nil {
+		return err
+	}
+	if s.sessions, err = newSessionStore(); err != nil {
+		return err
+	}
+	s.config = cfg
+
+	// Whichever server stops first stops gpt.
+	done := make(chan error, 2)
+	if *addr != "" {
+		ln, err := net.Listen("tcp", *addr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Serving the API at http://%s/v1\n", ln.Addr())
+		if s.web {
+			fmt.Fprintf(os.Stderr, "Serving the web UI at http://%s/\n", ln.Addr())
+		}
+		go func() { done <- http.Serve(ln, s.handler()) }()
+	}
+	if *sshAddr != "" {
+		server, err := s.newSSHServer(*sshAddr, *authorizedKeys)
+		if err != nil {
+			return err
+		}
+		ln, err := net.Listen("tcp", *sshAddr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Serving the chat over SSH at %s\n", ln.Addr())
+		if *shareRoom {
+			conv, err := s.store.createWith(nil)
+			if err != nil {
+				return err
+			}
+			s.room = newRoom(conv)
+			fmt.Fprintf(os.Stderr, "Sharing conversation %s in the chat room\n", conv.ID)
+		}
+		go func() { done <- server.Serve(ln) }()
+	}
+	return <-done
+}
+
+// apiServer answers the HTTP API.
+type apiServer struct {
+	config   config
+	provider provider
+	budget   *budget
+	store    *historyStore
+	sessions *sessionStore
+	metrics  *metrics
+	// token, if set, must be given with every request.
+	token string
+	// host is the host the API listens on, by which it can be reached as
+	// well as by the names of the loopback address.
+	host string
+	// web is whether the web UI is served too.
+	web bool
+	// room is shared by everyone connected over SSH, with -room.
+	room *room
+
+	busy busySet
+}
+
+// apiConversation describes a stored conversation.
+type apiConversation struct {
+	ID       string       `json:"id"`
+	Title    string       `json:"title"`
+	Created  *time.Time   `json:"created,omitempty"`
+	Updated  *time.Time   `json:"updated,omitempty"`
+	Count    int          `json:"message_count"`
+	Messages []apiMessage `json:"messages,omitempty"`
+}
+
+type apiMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// apiSend is the body of a request to send a message.
+type apiSend struct {
+	Content      string  `json:"content"`
+	Model        string  `json:"model"`
+	SystemPrompt *string `json:"system_prompt"`
+	// Stream asks for the reply as server-sent events, a delta event for
+	// each piece and then a done event with the reply as a whole.
+	Stream bool `json:"stream"`
+}
+
+// apiReply is the reply to a message.
+type apiReply struct {
+	Message      string              `json:"message"`
+	Model        string              `json:"model"`
+	FinishReason openai.FinishReason `json:"finish_reason"`
+	Usage        oneShotUsage        `json:"usage"`
+	Conversation string              `json:"conversation"`
+	// Error is why a reply that was kept in part was cut short.
+	Error string `json:"error,omitempty"`
+}
+
+// apiError carries the status an error is answered with.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string { return e.err.Error() }
+
+func (e *apiError) Unwrap() error { return e.err }
+
+func badRequest(format string, args ...any) error {
+	return &apiError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+func (s *apiServer) handler() http.Handler {
+	api := http.NewServeMux()
+	api.Handle("/metrics", s.metrics)
+	api.HandleFunc("/v1/conversations", s.conversations)
+	api.HandleFunc("/v1/conversations/", s.conversation)
+	api.Handle("/v1/ws", s.wsHandler())
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.guard(api))
+	mux.Handle("/v1/", s.guard(api))
+	if s.web {
+		// The page holds nothing until it has the token.
+		mux.Handle("/", webUI())
+	}
+	return mux
+}
+
+// guard turns away requests without the token, if one is set, and those
+// from web pages of other origins, which a browser would otherwise send on
+// behalf of any site. So that a site can't pass for this one by a name of
+// its own that resolves to 127.0.0.1, requests must name the server by the
+// address it listens on.
+func (s *apiServer) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			writeAPIError(w, &apiError{http.StatusForbidden, fmt.Errorf("the server isn't known as %q", r.Host)})
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				writeAPIError(w, &apiError{http.StatusForbidden, errors.New("requests from other origins aren't allowed")})
+				return
+			}
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			given = ""
+		}
+		if r.URL.Path == "/v1/ws" && r.URL.Query().Has("token") {
+			// Browsers can't set headers on a WebSocket.
+			given = r.URL.Query().Get("token")
+		}
+		// Compared in constant time, so that how long it takes doesn't
+		// give the token away.
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, &apiError{http.StatusUnauthorized, errors.New("a valid bearer token is required")})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost reports whether host, from a request, names the server: by a
+// loopback name, or by the host it listens on. Listening on every interface,
+// which needs the token, it can be reached by any.
+func (s *apiServer) allowedHost(host string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.Trim(host, "[]")
+	switch {
+	case s.host == "" || s.host == "0.0.0.0" || s.host == "::":
+		return true
+	case strings.EqualFold(host, "localhost"), host == "127.0.0.1", host == "::1":
+		return true
+	}
+	return strings.EqualFold(host, strings.Trim(s.host, "[]"))
+}
+
+// isLoopback reports whether addr, a host and port to listen on, is only
+// reachable from this machine.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// conversations handles /v1/conversations: GET lists them, oldest first, and
+// POST starts one, optionally with messages already in it.
+func (s *apiServer) conversations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		infos, err := s.store.list()
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		list := make([]apiConversation, 0, len(infos))
+		for _, info := range infos {
+			list = append(list, newAPIConversation(info))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"conversations": list})
+	case http.MethodPost:
+		var body struct {
+			Title    string       `json:"title"`
+			Messages []apiMessage `json:"messages"`
+		}
+		if err := readJSON(r, &body); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		messages := make([]openai.ChatCompletionMessage, 0, len(body.Messages))
+		for _, msg := range body.Messages {
+			switch msg.Role {
+			case openai.ChatMessageRoleSystem, openai.ChatMessageRoleUser, openai.ChatMessageRoleAssistant:
+			default:
+				writeAPIError(w, badRequest("unknown role %q", msg.Role))
+				return
+			}
+			messages = append(messages, openai.ChatCompletionMessage{Role: msg.Role, Content: msg.Content})
+		}
+		conv, err := s.store.createWith(messages)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		if body.Title != "" {
+			if err := s.store.rename(conv.ID, body.Title); err != nil {
+				writeAPIError(w, err)
+				return
+			}
+		}
+		s.writeConversation(w, http.StatusCreated, conv.ID)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// conversation handles /v1/conversations/{id}, which GET returns with its
+// messages and DELETE deletes, and /v1/conversations/{id}/messages, which
+// POST sends a message to.
+func (s *apiServer) conversation(w http.ResponseWriter, r *http.Request) {
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/conversations/"), "/")
+	info, err := s.store.info(id)
+	if err != nil {
+		writeAPIError(w, &apiError{http.StatusNotFound, err})
+		return
+	}
+	// The ID may have been "last".
+	id = info.ID
+
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		s.writeConversation(w, http.StatusOK, id)
+	case rest == "" && r.Method == http.MethodDelete:
+		if err := s.store.delete(id); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case rest == "":
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	case rest == "messages" && r.Method == http.MethodPost:
+		s.send(w, r, id)
+	case rest == "messages":
+		methodNotAllowed(w, http.MethodPost)
+	default:
+		writeAPIError(w, &apiError{http.StatusNotFound, fmt.Errorf("no such endpoint %s", r.URL.Path)})
+	}
+}
+
+func (s *apiServer) writeConversation(w http.ResponseWriter, status int, id string) {
+	info, err := s.store.info(id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	conv, err := s.store.open(id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	c := newAPIConversation(info)
+	c.Messages = []apiMessage{}
+	for _, msg := range conv.Messages {
+		c.Messages = append(c.Messages, apiMessage{Role: msg.Role, Content: msg.Content})
+	}
+	writeJSON(w, status, c)
+}
+
+func newAPIConversation(info conversationInfo) apiConversation {
+	c := apiConversation{ID: info.ID, Title: info.Title, Count: info.Messages}
+	if !info.Created.IsZero() {
+		c.Created, c.Updated = &info.Created, &info.Updated
+	}
+	return c
+}
+
+// send sends a message in the conversation and answers with the reply, or
+// streams it as server-sent events if asked to.
+func (s *apiServer) send(w http.ResponseWriter, r *http.Request, id string) {
+	var body apiSend
+	if err := readJSON(r, &body); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if body.Content == "" {
+		writeAPIError(w, badRequest("no content given"))
+		return
+	}
+	if !s.busy.claim(id) {
+		writeAPIError(w, &apiError{http.StatusConflict, fmt.Errorf("conversation %q is waiting for a reply", id)})
+		return
+	}
+	defer s.busy.release(id)
+
+	conv, err := s.store.open(id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	cfg := s.config
+	if body.Model != "" {
+		cfg.Model = body.Model
+	}
+	if body.SystemPrompt != nil {
+		cfg.SystemPrompt = *body.SystemPrompt
+	}
+
+	if !body.Stream {
+		reply, result, err := converse(r.Context(), cfg, s.provider, s.budget, conv, body.Content, func(string) {})
+		if err != nil && reply == "" {
+			writeAPIError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPIReply(cfg, conv, reply, result, err))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, errors.New("streaming isn't supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	event := func(name string, data any) {
+		payload, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, payload)
+		flusher.Flush()
+	}
+	reply, result, err := converse(r.Context(), cfg, s.provider, s.budget, conv, body.Content, func(delta string) {
+		event("delta", map[string]string{"content": delta})
+	})
+	if err != nil && reply == "" {
+		event("error", map[string]string{"error": err.Error()})
+		return
+	}
+	event("done", newAPIReply(cfg, conv, reply, result, err))
+}
+
+func newAPIReply(cfg config, conv *conversation, reply string, result chatResult, err error) apiReply {
+	a := apiReply{
+		Message:      reply,
+		Model:        result.model,
+		FinishReason: result.finish,
+		Usage: oneShotUsage{
+			PromptTokens:     result.usage.PromptTokens,
+			CompletionTokens: result.usage.CompletionTokens,
+			TotalTokens:      result.usage.TotalTokens,
+		},
+		Conversation: conv.ID,
+	}
+	if a.Model == "" {
+		a.Model = cfg.Model
+	}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return a
+}
+
+// busySet holds the conversations waiting for a reply, which can't be sent
+// another message until it arrives. The zero value is empty.
+type busySet struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+// claim marks the conversation as waiting for a reply, unless it already is.
+func (b *busySet) claim(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ids[id] {
+		return false
+	}
+	if b.ids == nil {
+		b.ids = make(map[string]bool)
+	}
+	b.ids[id] = true
+	return true
+}
+
+func (b *busySet) release(id string) {
+	b.mu.Lock()
+	delete(b.ids, id)
+	b.mu.Unlock()
+}
+
+// converse sends message in conv and saves it along with the reply, which
+// is passed to onDelta as it streams in. A reply cut short after some of it
+// arrived is saved and returned along with the error; the budget and the
+// audit log are kept as for any other reply.
+func converse(ctx context.Context, cfg config, p provider, b *budget, conv *conversation, message string, onDelta func(string)) (string, chatResult, error) {
+	if _, err := b.check(); err != nil {
+		return "", chatResult{}, err
+	}
+
+	msg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: message}
+	n := len(conv.Messages)
+	req, err := newChatRequest(cfg, detectPromptData(), append(conv.Messages[:n:n], msg))
+	if err != nil {
+		return "", chatResult{}, err
+	}
+	_, summary, err := fitContext(ctx, cfg, p, conv, &req)
+	if summary.TotalTokens > 0 {
+		if recordErr := b.record(cfg, cfg.summaryModel(), summary); recordErr != nil && err == nil {
+			err = recordErr
+		}
+	}
+	if err != nil {
+		return "", chatResult{}, err
+	}
+	if err := conv.append(msg); err != nil {
+		return "", chatResult{}, err
+	}
+
+	started := time.Now()
+	var reply strings.Builder
+	result, err := streamChat(ctx, p, req, func(delta string) {
+		reply.WriteString(delta)
+		onDelta(delta)
+	})
+	if auditErr := cfg.audit(newAuditEntry(cfg, conv, message, reply.String(), started, result, err)); auditErr != nil && err == nil {
+		err = auditErr
+	}
+	if err != nil && reply.Len() == 0 {
+		return "", result, err
+	}
+	if result.usage.TotalTokens > 0 {
+		if recordErr := b.record(cfg, cfg.Model, result.usage); recordErr != nil && err == nil {
+			err = recordErr
+		}
+	}
+	if appendErr := conv.append(openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleAssistant,
+		Content: reply.String(),
+	}); appendErr != nil {
+		return "", result, appendErr
+	}
+	return reply.String(), result, err
+}
+
+func readJSON(r *http.Request, v any) error {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return &apiError{http.StatusUnsupportedMediaType, errors.New("the body must be application/json")}
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 8<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("invalid body: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError answers with err as {"error": "..."}, with the status it
+// carries, or 500.
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		status = apiErr.status
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIError(w, &apiError{http.StatusMethodNotAllowed, errors.New("method not allowed")})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const shPrompt = `You turn requests into commands for the {{.Shell}} shell on {{.GOOS}}.
+
+If the request is already a command, whole or partial, complete or correct it. Reply with exactly one command line that does what the user asks, and nothing else: no explanation, no Markdown, no code fence. Chain steps with pipes or && rather than writing several lines. Prefer tools that come with {{.GOOS}} over ones that may need installing.`
+
+// runSh implements the sh subcommand, which asks the model for a command that
+// does what the arguments describe and offers to run it.
+func runSh(args []string) error {
+	fs := flag.NewFlagSet("sh", flag.ExitOnError)
+	modelName := fs.String("model", "", "model to use, overriding the config file")
+	printOnly := fs.Bool("print", false, "print the command instead of offering to run it")
+	force := fs.Bool("force", false, "send the request even once the spending budget is used up")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt sh [-model name] [-print] <what the command should do>\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	request := strings.Join(fs.Args(), " ")
+	if request == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *modelName != "" {
+		cfg.Model = *modelName
+	}
+	cfg.fillDefaults()
+	cfg.SystemPrompt = shPrompt
+
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	spending, err := newBudget(cfg, *force)
+	if err != nil {
+		return err
+	}
+	warning, err := spending.check()
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+
+	command, err := suggestCommand(cfg, prov, spending, request)
+	if err != nil {
+		return err
+	}
+	if *printOnly {
+		fmt.Println(command)
+		return nil
+	}
+	return offerCommand(command)
+}
+
+// suggestCommand asks the model for a command that does what request says.
+func suggestCommand(cfg config, p provider, b *budget, request string) (string, error) {
+	reply, err := completeOnce(cfg, p, b, request)
+	if err != nil {
+		return "", err
+	}
+	command := unfence(reply)
+	if command == "" {
+		return "", errors.New("the model didn't suggest a command")
+	}
+	return command, nil
+}
+
+// unfence takes a reply out of a code fence, and a command out of a "$ "
+// prompt, in case the model added them when told not to.
+func unfence(reply string) string {
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "```") {
+		lines := strings.Split(reply, "\n")
+		lines = lines[1:]
+		if n := len(lines); n > 0 && strings.HasPrefix(lines[n-1], "```") {
+			lines = lines[:n-1]
+		}
+		reply = strings.TrimSpace(strings.Join(lines, "\n"))
+	}
+	reply = strings.TrimPrefix(reply, "$ ")
+	return reply
+}
+
+// offerCommand shows command and does with it what the user picks. Without a
+// terminal to ask on, the command is just printed.
+func offerCommand(command string) error {
+	for {
+		fmt.Fprintf(os.Stderr, "$ %s\n", displayCommand(command))
+		answer, ok := askOnTerminal("[r]un / [e]dit / [c]opy / [a]bort? ")
+		if !ok {
+			fmt.Println(command)
+			return nil
+		}
+
+		switch strings.ToLower(answer) {
+		case "r", "run":
+			return runCommand(command)
+		case "e", "edit":
+			edited, err := editText(command)
+			if err != nil {
+				return err
+			}
+			if edited != "" {
+				command = edited
+			}
+		case "c", "copy":
+			copyToClipboard(command)
+			fmt.Fprintln(os.Stderr, "Copied to the clipboard.")
+			return nil
+		case "a", "abort", "":
+			return nil
+		}
+	}
+}
+
+// runCommand runs command in the user's shell on the terminal, exiting with
+// its status if it fails.
+func runCommand(command string) error {
+	cmd := shellCommand(context.Background(), command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// shellWidgets bind Ctrl+G to replace the command line being typed with what
+// gpt sh makes of it.
+var shellWidgets = map[string]string{
+	"zsh": `_gpt_widget() {
+  [[ -z $BUFFER ]] && return
+  local result
+  zle -R "Asking gpt..."
+  result=$(gpt sh -print -- "$BUFFER" 2>/dev/null </dev/null)
+  if [[ -n $result ]]; then
+    BUFFER=$result
+    CURSOR=${#BUFFER}
+  fi
+  zle reset-prompt
+}
+zle -N _gpt_widget
+bindkey '^G' _gpt_widget
+`,
+	"bash": `_gpt_widget() {
+  [[ -z $READLINE_LINE ]] && return
+  local result
+  result=$(gpt sh -print -- "$READLINE_LINE" 2>/dev/null </dev/null)
+  if [[ -n $result ]]; then
+    READLINE_LINE=$result
+    READLINE_POINT=${#READLINE_LINE}
+  fi
+}
+bind -x '"\C-g": _gpt_widget'
+`,
+	"fish": `function _gpt_widget
+    set -l buffer (commandline)
+    test -z "$buffer"; and return
+    set -l result (gpt sh -print -- "$buffer" 2>/dev/null </dev/null | string collect)
+    if test -n "$result"
+        commandline -r -- $result
+        commandline -C (string length -- $result)
+    end
+    commandline -f repaint
+end
+bind \cg _gpt_widget
+`,
+}
+
+// runShellInit implements the shell-init subcommand, which prints the key
+// binding for a shell's startup file.
+func runShellInit(args []string) error {
+	fs := flag.NewFlagSet("shell-init", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt shell-init zsh|bash|fish\n"))
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	widget, ok := shellWidgets[fs.Arg(0)]
+	if !ok {
+		shells := make([]string, 0, len(shellWidgets))
+		for name := range shellWidgets {
+			shells = append(shells, name)
+		}
+		sort.Strings(shells)
+		return fmt.Errorf("unknown shell %q; choose from %s", fs.Arg(0), strings.Join(shells, ", "))
+	}
+	fmt.Print(widget)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const testsPrompt = `You write Go tests.
+
+The user sends a Go source file, and the test file that goes with it if there is one. Reply with the complete new test file: table-driven tests using only the standard library's testing package, one test function per exported or otherwise non-trivial function, each with a []struct of named cases run with t.Run. Cover ordinary cases, edge cases and errors. Use the package clause of the source file. Keep every test already in the test file as it is, adding new ones after them.
+
+Reply with the Go code only, not in a code fence.`
+
+// runTests implements the tests subcommand, which writes table-driven tests
+// for a Go file into its _test.go file once the user has seen the changes.
+func runTests(args []string) error {
+	fs := flag.NewFlagSet("tests", flag.ExitOnError)
+	modelName := fs.String("model", "", "model to use, overriding the config file")
+	yes := fs.Bool("y", false, "write the tests without asking")
+	force := fs.Bool("force", false, "send the request even once the spending budget is used up")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt tests [-model name] [-y] <file.go>\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	path := fs.Arg(0)
+	if fs.NArg() != 1 || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	testPath := strings.TrimSuffix(path, ".go") + "_test.go"
+	existing, err := os.ReadFile(testPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *modelName != "" {
+		cfg.Model = *modelName
+	}
+	cfg.fillDefaults()
+	cfg.SystemPrompt = testsPrompt
+
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	spending, err := newBudget(cfg, *force)
+	if err != nil {
+		return err
+	}
+	warning, err := spending.check()
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+
+	message := fmt.Sprintf("%s:\n\n%s", filepath.Base(path), source)
+	if existing != nil {
+		message += fmt.Sprintf("\n\n%s:\n\n%s", filepath.Base(testPath), existing)
+	}
+	fmt.Fprintln(os.Stderr, "Writing tests...")
+	reply, err := completeOnce(cfg, prov, spending, message)
+	if err != nil {
+		return err
+	}
+	tests := []byte(unfence(reply) + "\n")
+	if formatted, err := format.Source(tests); err == nil {
+		tests = formatted
+	} else {
+		fmt.Fprintf(os.Stderr, "The tests don't parse, so they are shown as written: %v\n", err)
+	}
+
+	diff := unifiedDiff(testPath, testPath, string(existing), string(tests))
+	if diff == "" {
+		fmt.Fprintln(os.Stderr, "No changes to "+testPath+".")
+		return nil
+	}
+	fmt.Print(diff)
+	if !*yes && !confirmOnTerminal("Write "+testPath+"?") {
+		fmt.Fprintln(os.Stderr, "Not written.")
+		return nil
+	}
+	return os.WriteFile(testPath, tests, 0o644)
+}
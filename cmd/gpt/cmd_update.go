@@ -0,0 +1,401 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// updateRepo is the GitHub repository gpt update looks for releases in.
+const updateRepo = "jianyuan/gpt-cli"
+
+// updatePublicKey is the base64 Ed25519 key the checksums of a release are
+// signed with, set at build time with -X main.updatePublicKey=.... When set,
+// gpt update refuses a release whose checksums.txt.sig doesn't verify.
+var updatePublicKey = ""
+
+// maxDownload caps what gpt update downloads, archives included.
+const maxDownload = 200 << 20
+
+// release is the part of GitHub's release object gpt update needs.
+type release struct {
+	TagName string         `json:"tag_name"`
+	HTMLURL string         `json:"html_url"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// runUpdate implements the update subcommand, which replaces the running
+// binary with the latest release once its checksum, and signature if gpt was
+// built with a key, have been verified.
+func runUpdate(args []string) error {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	check := fs.Bool("check", false, "only report whether a newer release is out")
+	force := fs.Bool("force", false, "install the latest release even if it isn't newer")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt update [-check] [-force]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if !cfg.UpdateCheck {
+		return errors.New("update checks are turned off by update_check: false in the config file")
+	}
+	// Headers in the config are for the provider and mustn't go to GitHub.
+	cfg.Headers = nil
+	client, err := cfg.httpClient()
+	if err != nil {
+		return err
+	}
+
+	latest, err := latestRelease(client)
+	if err != nil {
+		return err
+	}
+	current := currentBuild().Version
+	newer := compareVersions(latest.TagName, current) > 0
+	if *check {
+		if newer {
+			fmt.Printf("gpt %s is out; you have %s. Run gpt update to install it.\n", latest.TagName, current)
+		} else {
+			fmt.Printf("gpt %s is the latest release.\n", current)
+		}
+		return nil
+	}
+	if !newer && !*force {
+		fmt.Printf("gpt %s is the latest release.\n", current)
+		return nil
+	}
+
+	asset, ok := latest.asset(runtime.GOOS, runtime.GOARCH)
+	if !ok {
+		return fmt.Errorf("release %s has no build for %s/%s; see %s", latest.TagName, runtime.GOOS, runtime.GOARCH, latest.HTMLURL)
+	}
+	sums, ok := latest.named("checksums.txt")
+	if !ok {
+		return fmt.Errorf("release %s has no checksums.txt to verify the download with", latest.TagName)
+	}
+
+	checksums, err := download(client, sums.URL)
+	if err != nil {
+		return err
+	}
+	if updatePublicKey != "" {
+		sig, ok := latest.named("checksums.txt.sig")
+		if !ok {
+			return fmt.Errorf("release %s has no checksums.txt.sig to verify the checksums with", latest.TagName)
+		}
+		signature, err := download(client, sig.URL)
+		if err != nil {
+			return err
+		}
+		if err := verifySignature(checksums, signature); err != nil {
+			return err
+		}
+	}
+	want, err := findChecksum(checksums, asset.Name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Downloading %s...\n", asset.Name)
+	data, err := download(client, asset.URL)
+	if err != nil {
+		return err
+	}
+	if got := sha256.Sum256(data); hex.EncodeToString(got[:]) != want {
+		return fmt.Errorf("%s: checksum mismatch; nothing was changed", asset.Name)
+	}
+	if updatePublicKey == "" {
+		// The checksums come from the same place as the download, so they
+		// catch one that is corrupt but not one that was swapped.
+		fmt.Fprintln(os.Stderr, "Only the checksum was verified: this build has no key to check the release's signature with.")
+	}
+	binary, err := extractBinary(asset.Name, data)
+	if err != nil {
+		return err
+	}
+	if err := replaceExecutable(binary); err != nil {
+		return err
+	}
+	fmt.Printf("Updated gpt from %s to %s.\n", current, latest.TagName)
+	return nil
+}
+
+func latestRelease(client *http.Client) (release, error) {
+	var r release
+	req, err := http.NewRequest(http.MethodGet, "https://api.github.com/repos/"+updateRepo+"/releases/latest", nil)
+	if err != nil {
+		return r, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "gpt-cli")
+	resp, err := client.Do(req)
+	if err != nil {
+		return r, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return r, fmt.Errorf("checking for releases: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return r, fmt.Errorf("checking for releases: %w", err)
+	}
+	return r, nil
+}
+
+func (r release) named(name string) (releaseAsset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return releaseAsset{}, false
+}
+
+// asset returns the build for goos and goarch, named as GoReleaser names
+// them: gpt-cli_1.2.3_linux_amd64.tar.gz, or x86_64 in place of amd64.
+func (r release) asset(goos, goarch string) (releaseAsset, bool) {
+	arches := []string{goarch}
+	switch goarch {
+	case "amd64":
+		arches = append(arches, "x86_64")
+	case "386":
+		arches = append(arches, "i386")
+	}
+	for _, a := range r.Assets {
+		name := strings.ToLower(a.Name)
+		if strings.HasSuffix(name, ".sig") || strings.HasSuffix(name, ".txt") {
+			continue
+		}
+		for _, arch := range arches {
+			if strings.Contains(name, "_"+goos+"_"+arch) {
+				return a, true
+			}
+		}
+	}
+	return releaseAsset{}, false
+}
+
+func download(client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "gpt-cli")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", path.Base(url), resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDownload {
+		return nil, fmt.Errorf("%s: larger than %d MB", path.Base(url), maxDownload>>20)
+	}
+	return data, nil
+}
+
+// verifySignature checks that signature, raw or base64, is updatePublicKey's
+// signature of checksums.
+func verifySignature(checksums, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("this build of gpt has a malformed update key")
+	}
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return errors.New("checksums.txt.sig: not an Ed25519 signature")
+		}
+		signature = decoded
+	}
+	if !ed25519.Verify(key, checksums, signature) {
+		return errors.New("checksums.txt.sig: signature doesn't verify; nothing was changed")
+	}
+	return nil
+}
+
+// findChecksum returns the SHA-256 of name in a sha256sum-style file.
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt has no checksum for %s", name)
+}
+
+// extractBinary returns the gpt executable from a release asset: a .tar.gz,
+// a .zip or the bare binary.
+func extractBinary(name string, data []byte) ([]byte, error) {
+	exe := "gpt"
+	if runtime.GOOS == "windows" {
+		exe = "gpt.exe"
+	}
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if h.Typeflag == tar.TypeReg && path.Base(h.Name) == exe {
+				return io.ReadAll(io.LimitReader(tr, maxDownload))
+			}
+		}
+	case strings.HasSuffix(name, ".zip"):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if path.Base(f.Name) != exe {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(io.LimitReader(rc, maxDownload))
+		}
+	default:
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s has no %s in it", name, exe)
+}
+
+// replaceExecutable puts binary in place of the running executable. The new
+// file is written next to it and renamed over it, so that a failure leaves
+// the old one as it was. Windows won't let a running executable be replaced,
+// only renamed, so there it is moved aside first.
+func replaceExecutable(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".gpt-update-*")
+	if err != nil {
+		return fmt.Errorf("can't write next to %s: %w", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0o111); err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			os.Rename(old, exe)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), exe)
+}
+
+// compareVersions compares two versions such as v1.2.3 by their numbers,
+// ignoring anything after a - or +. A version that isn't one, such as dev,
+// is older than any that is.
+func compareVersions(a, b string) int {
+	pa, okA := parseVersion(a)
+	pb, okB := parseVersion(b)
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	fields := strings.Split(v, ".")
+	if len(fields) == 0 || len(fields) > 3 {
+		return parts, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
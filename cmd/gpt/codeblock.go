@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/quick"
+)
+
+// codeBlock is a fenced code block within a message. Start and End are the
+// line indexes of the opening and closing fences; End is the number of lines
+// if the block hasn't been closed yet, e.g. while it is being streamed.
+type codeBlock struct {
+	Lang  string
+	Code  string
+	Start int
+	End   int
+}
+
+func parseCodeBlocks(content string) []codeBlock {
+	var (
+		blocks []codeBlock
+		open   *codeBlock
+		code   []string
+	)
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		fence := strings.HasPrefix(strings.TrimSpace(line), "```")
+		switch {
+		case open == nil && fence:
+			open = &codeBlock{
+				Lang:  strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "```")),
+				Start: i,
+			}
+			code = nil
+		case open != nil && fence:
+			open.Code = strings.Join(code, "\n")
+			open.End = i
+			blocks = append(blocks, *open)
+			open = nil
+		case open != nil:
+			code = append(code, line)
+		}
+	}
+
+	if open != nil {
+		open.Code = strings.Join(code, "\n")
+		open.End = len(lines)
+		blocks = append(blocks, *open)
+	}
+	return blocks
+}
+
+// describeCodeBlock names a block by its language and length, e.g.
+// "go, 12 lines".
+func describeCodeBlock(block codeBlock) string {
+	lang := block.Lang
+	if lang == "" {
+		lang = guessLanguage(block.Code)
+	}
+	if lang == "" {
+		lang = "text"
+	}
+
+	lines := strings.Count(block.Code, "\n") + 1
+	if lines == 1 {
+		return lang + ", 1 line"
+	}
+	return fmt.Sprintf("%s, %d lines", lang, lines)
+}
+
+// guessLanguage picks a lexer name for an untagged code block.
+func guessLanguage(code string) string {
+	lexer := lexers.Analyse(code)
+	if lexer == nil {
+		return ""
+	}
+	return strings.ToLower(lexer.Config().Name)
+}
+
+// tagCodeBlocks adds a guessed language to untagged fences so that the
+// Markdown renderer highlights them too.
+func tagCodeBlocks(content string) string {
+	blocks := parseCodeBlocks(content)
+	if len(blocks) == 0 {
+		return content
+	}
+
+	lines := strings.Split(content, "\n")
+	for _, block := range blocks {
+		if block.Lang != "" {
+			continue
+		}
+		if lang := guessLanguage(block.Code); lang != "" {
+			lines[block.Start] = strings.Replace(lines[block.Start], "```", "```"+lang, 1)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// highlightCodeBlocks colors the code inside fenced blocks for display without
+// Markdown rendering. Everything else is left untouched.
+func highlightCodeBlocks(content, theme string) string {
+	blocks := parseCodeBlocks(content)
+	if len(blocks) == 0 {
+		return content
+	}
+
+	lines := strings.Split(content, "\n")
+	var out []string
+	next := 0
+	for _, block := range blocks {
+		out = append(out, lines[next:block.Start+1]...)
+
+		lang := block.Lang
+		if lang == "" {
+			lang = guessLanguage(block.Code)
+		}
+
+		var b strings.Builder
+		if err := quick.Highlight(&b, block.Code, lang, "terminal256", theme); err != nil {
+			out = append(out, lines[block.Start+1:block.End]...)
+		} else {
+			out = append(out, strings.TrimSuffix(b.String(), "\n"))
+		}
+		next = block.End
+	}
+	out = append(out, lines[next:]...)
+	return strings.Join(out, "\n")
+}
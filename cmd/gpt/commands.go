@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// slashCommand is a command typed into the chat, such as /model.
+type slashCommand struct {
+	name  string
+	usage string
+	help  string
+	run   func(m *model, args []string) tea.Cmd
+}
+
+// slashCommands is filled in by init because /help refers back to it.
+var slashCommands map[string]slashCommand
+
+func init() {
+	slashCommands = make(map[string]slashCommand)
+	for _, c := range []slashCommand{
+		{"/help", "", "list the keys and commands", func(m *model, args []string) tea.Cmd {
+			m.showHelp = true
+			return nil
+		}},
+		{"/model", "[name]", "switch models, or list the known ones", func(m *model, args []string) tea.Cmd {
+			m.switchModel(args)
+			return nil
+		}},
+		{"/persona", "[name]", "switch personas, or list them", (*model).switchPersona},
+		{"/profile", "[name]", "switch profiles, or list them", (*model).switchProfile},
+		{"/system", "[prompt]", "replace the system prompt, or show it", func(m *model, args []string) tea.Cmd {
+			m.setSystemPrompt(args)
+			return nil
+		}},
+		{"/clear", "", "start a new conversation", func(m *model, args []string) tea.Cmd {
+			m.clear()
+			return nil
+		}},
+		{"/retry", "[model=NAME] [temperature=T ...]", "ask for a new answer to the last message", (*model).retry},
+		{"/continue", "", "ask for the rest of a reply that was cut off", func(m *model, args []string) tea.Cmd {
+			return m.continueReply()
+		}},
+		{"/candidates", "[n] [message]", "ask for several replies to the message, or the last one, and keep the best", (*model).candidates},
+		{"/scratch", "[n]", "list the replies /candidates left out, or copy one", (*model).showScratch},
+		{"/set", "[name [value]]", "change temperature, top_p, presence_penalty, frequency_penalty, max_tokens or seed, or show them", (*model).set},
+		{"/cost", "", "show token usage and cost by model", func(m *model, args []string) tea.Cmd {
+			m.notice = m.costs.breakdown(m.config)
+			return nil
+		}},
+		{"/template", "[name] [VAR=VALUE...]", "send a prompt template, or list them", (*model).useTemplate},
+		{"/save", "<name>", "save the conversation as a session", func(m *model, args []string) tea.Cmd {
+			m.saveSession(args)
+			return nil
+		}},
+		{"/load", "<name>", "load a saved session", func(m *model, args []string) tea.Cmd {
+			m.loadSession(args)
+			return nil
+		}},
+		{"/copy", "", "copy the last reply to the clipboard", func(m *model, args []string) tea.Cmd {
+			m.copyLastReply()
+			return nil
+		}},
+		{"/save-response", "[-a] <file>", "write the last reply to a file, or the one streaming as it arrives; -a appends", (*model).saveResponse},
+		{"/code", "[n] [file]", "list the code blocks of the last reply, or copy or save one", func(m *model, args []string) tea.Cmd {
+			m.codeBlock(args)
+			return nil
+		}},
+		{"/tools", "", "list the tools the model can call", func(m *model, args []string) tea.Cmd {
+			m.listTools()
+			return nil
+		}},
+		{"/fetch", "<url>...", "attach web pages to the next message", (*model).fetch},
+		{"/capture-pane", "[lines] [pane]", "attach the scrollback of a tmux pane to the next message", (*model).capture},
+		{"/export", "[file]", "write the conversation to Markdown or JSON", func(m *model, args []string) tea.Cmd {
+			m.export(args)
+			return nil
+		}},
+	} {
+		slashCommands[c.name] = c
+	}
+}
+
+// commandNames returns the names of the slash commands in order.
+func commandNames() []string {
+	names := make([]string, 0, len(slashCommands))
+	for name := range slashCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runCommand handles slash commands, reporting whether input was one. Input
+// starting with // is a message that begins with a slash.
+func (m *model) runCommand(input string) (tea.Cmd, bool) {
+	if !strings.HasPrefix(input, "/") || strings.HasPrefix(input, "//") {
+		return nil, false
+	}
+
+	m.err = nil
+	m.notice = ""
+	fields := strings.Fields(input)
+	command, ok := slashCommands[fields[0]]
+	if !ok {
+		m.err = fmt.Errorf("unknown command %s; type /help for a list", fields[0])
+		return nil, true
+	}
+	if m.roomless(command.name) {
+		return nil, true
+	}
+	return command.run(m, fields[1:]), true
+}
+
+// completeCommand completes the slash command being typed, as far as it is
+// unambiguous, and lists the candidates when there are several.
+func (m *model) completeCommand() bool {
+	input := m.textarea.Value()
+	if !strings.HasPrefix(input, "/") || strings.ContainsAny(input, " \n") {
+		return false
+	}
+
+	var matches []string
+	for _, name := range commandNames() {
+		if strings.HasPrefix(name, input) {
+			matches = append(matches, name)
+		}
+	}
+	if len(matches) == 0 {
+		return false
+	}
+
+	prefix := commonPrefix(matches)
+	if len(matches) == 1 {
+		prefix += " "
+	} else {
+		m.err = nil
+		m.notice = strings.Join(matches, "  ")
+	}
+	m.textarea.SetValue(prefix)
+	return true
+}
+
+// commonPrefix returns the longest prefix the strings share.
+func commonPrefix(s []string) string {
+	prefix := s[0]
+	for _, other := range s[1:] {
+		for !strings.HasPrefix(other, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// setSystemPrompt handles /system. The prompt applies from the next message
+// on.
+func (m *model) setSystemPrompt(args []string) {
+	if len(args) == 0 {
+		if m.config.SystemPrompt == "" {
+			m.notice = "No system prompt"
+			return
+		}
+		m.notice = "System prompt: " + m.config.SystemPrompt
+		return
+	}
+
+	m.config.SystemPrompt = strings.Join(args, " ")
+	m.notice = "System prompt updated"
+}
+
+// clear handles /clear. The old conversation stays in the history.
+func (m *model) clear() {
+	if m.streaming {
+		m.err = errors.New("wait for the reply to finish before clearing")
+		return
+	}
+
+	conv, err := m.store.create()
+	if err != nil {
+		m.err = err
+		return
+	}
+	if err := m.conversation.discardIfEmpty(); err != nil {
+		m.err = err
+	}
+	m.conversation = conv
+	m.messages = nil
+	m.truncated = false
+	m.details = make(map[int]replyDetails)
+	m.notice = "Started a new conversation"
+	m.refreshViewport()
+}
+
+// codeBlock handles /code. Without arguments it lists the code blocks of the
+// last reply; with a number it copies that block, or writes it to a file if
+// one is given.
+func (m *model) codeBlock(args []string) {
+	reply, _ := m.lastReply()
+	blocks := parseCodeBlocks(reply)
+	if len(blocks) == 0 {
+		m.notice = "The last reply has no code blocks"
+		return
+	}
+
+	if len(args) == 0 {
+		list := make([]string, len(blocks))
+		for i, block := range blocks {
+			list[i] = fmt.Sprintf("%d: %s", i+1, describeCodeBlock(block))
+		}
+		m.notice = strings.Join(list, "  ") + ". /code <n> copies one, /code <n> <file> saves it"
+		return
+	}
+	if len(args) > 2 {
+		m.err = errors.New("usage: /code [n] [file]")
+		return
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(blocks) {
+		m.err = fmt.Errorf("no code block %s; the last reply has %d", args[0], len(blocks))
+		return
+	}
+	block := blocks[n-1]
+
+	if len(args) == 1 {
+		m.copyToClipboard(block.Code)
+		m.notice = fmt.Sprintf("Copied code block %d", n)
+		return
+	}
+	if err := os.WriteFile(args[1], []byte(block.Code+"\n"), 0o644); err != nil {
+		m.err = err
+		return
+	}
+	m.notice = fmt.Sprintf("Saved code block %d to %s", n, args[1])
+}
+
+// useTemplate handles /template. Variables not given as arguments are asked
+// for one at a time, and piped input fills {{input}}.
+func (m *model) useTemplate(args []string) tea.Cmd {
+	if len(args) == 0 {
+		names, err := listTemplates()
+		if err != nil {
+			m.err = err
+			return nil
+		}
+		if len(names) == 0 {
+			dir, _ := templateDir()
+			m.notice = "No templates in " + dir
+			return nil
+		}
+		m.notice = "Templates: " + strings.Join(names, ", ")
+		return nil
+	}
+
+	t, err := loadTemplate(args[0])
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	values, rest := parseTemplateArgs(args[1:])
+	if len(rest) > 0 {
+		m.err = fmt.Errorf("expected VAR=VALUE, got %q", rest[0])
+		return nil
+	}
+	if m.attachment != "" {
+		values["input"] = m.attachment
+		m.attachment = ""
+	}
+
+	m.template = &templateFill{
+		template: t,
+		values:   values,
+		missing:  t.missing(values),
+	}
+	return m.fillTemplate("")
+}
+
+// fillTemplate takes the value of the variable being asked for, if any, and
+// sends the template once every variable has a value.
+func (m *model) fillTemplate(value string) tea.Cmd {
+	f := m.template
+	if value != "" {
+		f.values[f.missing[0]] = value
+		f.missing = f.missing[1:]
+	}
+	if len(f.missing) > 0 {
+		m.notice = f.question()
+		return nil
+	}
+
+	m.template = nil
+	cfg := m.config
+	if len(f.template.Stop) > 0 {
+		cfg.Stop = f.template.Stop
+	}
+	return m.sendWith(cfg, f.template.render(f.values))
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// completionScripts have the shell ask gpt __complete for the candidates,
+// passing the words before the one being completed and then that one after
+// a colon, so that an empty word isn't lost on the way. With no candidates,
+// bash and zsh complete file names instead.
+var completionScripts = map[string]string{
+	"bash": `_gpt() {
+  local IFS=$'\n'
+  COMPREPLY=($(gpt __complete "${COMP_WORDS[@]:1:COMP_CWORD-1}" ":${COMP_WORDS[COMP_CWORD]}" 2>/dev/null))
+}
+complete -o default -F _gpt gpt
+`,
+	"zsh": `#compdef gpt
+_gpt() {
+  local -a candidates
+  candidates=("${(@f)$(gpt __complete "${(@)words[2,CURRENT-1]}" ":${words[CURRENT]}" 2>/dev/null)}")
+  if [[ -n ${candidates[1]} ]]; then
+    compadd -a candidates
+  else
+    _files
+  fi
+}
+# Run when autoloaded from fpath, else register for when sourced.
+if [[ ${funcstack[1]} == _gpt ]]; then
+  _gpt "$@"
+else
+  compdef _gpt gpt
+fi
+`,
+	"fish": `complete -c gpt -a '(gpt __complete (commandline -opc)[2..-1] (string join "" ":" (commandline -ct)) 2>/dev/null)'
+`,
+	"powershell": `Register-ArgumentCompleter -Native -CommandName gpt -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+    if ($wordToComplete -ne '') {
+        $words = @($words | Select-Object -SkipLast 1)
+    }
+    gpt __complete @words ":$wordToComplete" 2>$null | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`,
+}
+
+// runCompletion implements the completion subcommand, which prints the
+// completion script for a shell.
+func runCompletion(args []string) error {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt completion bash|zsh|fish|powershell\n"))
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	script, ok := completionScripts[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("no completion for %s; there is %s", fs.Arg(0), strings.Join(sortedKeys(completionScripts), ", "))
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// runComplete implements __complete, which the completion scripts call to
+// print the candidates for the last word, one to a line.
+func runComplete(args []string) error {
+	if len(args) == 0 || !strings.HasPrefix(args[len(args)-1], ":") {
+		return errors.New("usage: gpt __complete [word...] :current")
+	}
+	current := strings.TrimPrefix(args[len(args)-1], ":")
+	for _, candidate := range completions(args[:len(args)-1], current) {
+		if strings.HasPrefix(candidate, current) {
+			fmt.Println(candidate)
+		}
+	}
+	return nil
+}
+
+// verbUsages are the usages of the subcommands that take a verb rather than
+// flags, from which the verbs are read.
+var verbUsages = map[string]string{
+	"history": historyUsage,
+	"auth":    authUsage,
+	"config":  configUsage,
+}
+
+// completions returns what may follow words, the candidates for the next
+// word before those not starting with it are left out.
+func completions(words []string, current string) []string {
+	flags := flagsTakingValues(flag.CommandLine)
+	command := ""
+	var positional []string
+	var pending string
+	for _, word := range words {
+		switch {
+		case pending != "":
+			pending = ""
+		case word == "--":
+		case strings.HasPrefix(word, "-") && word != "-":
+			if name := strings.TrimLeft(word, "-"); flags[name] {
+				pending = name
+			}
+		case command == "" && len(positional) == 0 && isCommand(word):
+			command = word
+			if command != "chat" {
+				flags = subcommandFlags(command)
+			}
+		default:
+			positional = append(positional, word)
+		}
+	}
+
+	switch {
+	case pending != "":
+		return flagValues(pending)
+	case strings.HasPrefix(current, "-"):
+		names := make([]string, 0, len(flags))
+		for name := range flags {
+			names = append(names, "-"+name)
+		}
+		sort.Strings(names)
+		return names
+	case command == "" && len(positional) == 0:
+		var names []string
+		for _, cmd := range subcommands {
+			if !strings.HasPrefix(cmd.name, "_") {
+				names = append(names, cmd.name)
+			}
+		}
+		return names
+	}
+	return argumentValues(command, positional)
+}
+
+func isCommand(word string) bool {
+	_, ok := findSubcommand(word)
+	return ok && !strings.HasPrefix(word, "_")
+}
+
+// flagsTakingValues returns the flags of fs, reporting for each whether it
+// takes a value.
+func flagsTakingValues(fs *flag.FlagSet) map[string]bool {
+	flags := make(map[string]bool)
+	fs.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags[f.Name] = !ok || !b.IsBoolFlag()
+	})
+	return flags
+}
+
+// flagLine matches a flag in the usage printed by a flag.FlagSet, with the
+// name of its value if it takes one.
+var flagLine = regexp.MustCompile(`^  -(\S+)(?: (\S+))?$`)
+
+// subcommandFlags returns the flags of a subcommand, reporting for each
+// whether it takes a value. They are read from the usage it prints, as only
+// the subcommand itself knows them.
+func subcommandFlags(name string) map[string]bool {
+	flags := make(map[string]bool)
+	if _, ok := verbUsages[name]; ok || name == "help" || name == "completion" {
+		return flags
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return flags
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	out, _ := exec.CommandContext(ctx, exe, name, "-h").CombinedOutput()
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if match := flagLine.FindStringSubmatch(scanner.Text()); match != nil {
+			flags[match[1]] = match[2] != ""
+		}
+	}
+	return flags
+}
+
+// flagValues returns the values worth offering for the flag name.
+func flagValues(name string) []string {
+	cfg, _ := loadConfig()
+	switch name {
+	case "model":
+		models := append([]string(nil), knownModels...)
+		for _, known := range knownModels {
+			if known == cfg.Model {
+				return models
+			}
+		}
+		if cfg.Model != "" {
+			models = append(models, cfg.Model)
+		}
+		return models
+	case "provider":
+		return sortedKeys(defaultModels)
+	case "log-level":
+		return sortedKeys(logLevels)
+	case "profile":
+		return cfg.profileNames()
+	case "persona":
+		return cfg.personaNames()
+	case "session":
+		return sessionNames()
+	case "resume":
+		return append([]string{"last"}, conversationIDs()...)
+	case "t":
+		names, _ := listTemplates()
+		return names
+	}
+	return nil
+}
+
+// argumentValues returns the values worth offering for the next argument of
+// a subcommand, given those before it.
+func argumentValues(command string, args []string) []string {
+	if usage, ok := verbUsages[command]; ok {
+		if len(args) == 0 {
+			return usageVerbs(command, usage)
+		}
+		if len(args) > 1 {
+			return nil
+		}
+		switch command + " " + args[0] {
+		case "history show", "history delete", "history rm", "history rename":
+			return conversationIDs()
+		case "auth login", "auth logout":
+			return sortedKeys(apiKeyEnv)
+		}
+		return nil
+	}
+	if len(args) > 0 {
+		return nil
+	}
+
+	switch command {
+	case "help":
+		return completions(nil, "")
+	case "completion":
+		return sortedKeys(completionScripts)
+	case "shell-init":
+		return sortedKeys(shellWidgets)
+	case "export", "replay":
+		return append(append([]string{"last"}, sessionNames()...), conversationIDs()...)
+	}
+	return nil
+}
+
+// usageVerbs returns the words following "gpt command" in usage.
+func usageVerbs(command, usage string) []string {
+	var verbs []string
+	for _, line := range strings.Split(usage, "\n") {
+		_, rest, ok := strings.Cut(line, "gpt "+command+" ")
+		if !ok {
+			continue
+		}
+		if verb, _, _ := strings.Cut(rest, " "); !strings.HasPrefix(verb, "[") && !strings.HasPrefix(verb, "<") {
+			verbs = append(verbs, verb)
+		}
+	}
+	return verbs
+}
+
+func sessionNames() []string {
+	sessions, err := newSessionStore()
+	if err != nil {
+		return nil
+	}
+	names, _ := sessions.names()
+	return names
+}
+
+func conversationIDs() []string {
+	store, err := newHistoryStore()
+	if err != nil {
+		return nil
+	}
+	infos, err := store.list()
+	if err != nil {
+		return nil
+	}
+	ids := make([]string, len(infos))
+	for i, info := range infos {
+		ids[i] = info.ID
+	}
+	return ids
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	Provider     string  `yaml:"provider"`
+	Model        string  `yaml:"model"`
+	Temperature  float32 `yaml:"temperature"`
+	SystemPrompt string  `yaml:"system_prompt"`
+	BaseURL      string  `yaml:"base_url"`
+	APIKey       string  `yaml:"api_key"`
+	// APIKeyCmd prints the API key, e.g. from a password manager, when
+	// none is set otherwise.
+	APIKeyCmd string `yaml:"api_key_cmd"`
+	// Organization and Project pick what OpenAI API usage is billed to,
+	// for keys that belong to more than one.
+	Organization string `yaml:"organization"`
+	Project      string `yaml:"project"`
+
+	// The sampling parameters; zero leaves each to the provider. See
+	// samplingParams.
+	TopP             float32 `yaml:"top_p"`
+	PresencePenalty  float32 `yaml:"presence_penalty"`
+	FrequencyPenalty float32 `yaml:"frequency_penalty"`
+	MaxTokens        int     `yaml:"max_tokens"`
+	// ModelMaxTokens sets max_tokens for models by name prefix, for when
+	// max_tokens itself isn't set.
+	ModelMaxTokens map[string]int `yaml:"model_max_tokens"`
+	// Seed makes replies repeatable, as far as the provider allows.
+	Seed *int `yaml:"seed"`
+	// Stop ends a reply where the model would write any of these.
+	Stop []string `yaml:"stop"`
+
+	// ContextWindow overrides the model's context window size in tokens.
+	ContextWindow int `yaml:"context_window"`
+	// ContextStrategy is what to do when a conversation no longer fits the
+	// context window: "sliding", "summarize" or "error".
+	ContextStrategy string `yaml:"context_strategy"`
+	// SummaryModel writes the summaries, defaulting to Model.
+	SummaryModel string `yaml:"summary_model"`
+	// EmbeddingModel embeds code for gpt index, defaulting to the
+	// provider's.
+	EmbeddingModel string `yaml:"embedding_model"`
+	// VectorStore is where gpt index keeps its embeddings: "sqlite", in
+	// the indexed directory, or "json", in the data directory.
+	VectorStore string `yaml:"vector_store"`
+
+	// AuditLog keeps a permanent record of every exchange.
+	AuditLog auditConfig `yaml:"audit_log"`
+
+	// Prices override the built-in price table, keyed by model.
+	Prices map[string]modelPrice `yaml:"prices"`
+	Budget budgetConfig          `yaml:"budget"`
+
+	// WebSearch lets the model search the web once a backend is set.
+	WebSearch webSearchConfig `yaml:"web_search"`
+	// ShellTool lets the model run shell commands, each once it has been
+	// allowed.
+	ShellTool bool `yaml:"shell_tool"`
+	// MCPServers offer their tools and resources to the model, keyed by a
+	// name of their own.
+	MCPServers map[string]mcpServerConfig `yaml:"mcp_servers"`
+
+	// UpdateCheck lets gpt update look for new releases. Packagers and
+	// those who update gpt another way can turn it off.
+	UpdateCheck bool `yaml:"update_check"`
+
+	// PRTemplate is the body gpt pr fills in, instead of the repository's
+	// pull request template.
+	PRTemplate string `yaml:"pr_template"`
+
+	// Headers are added to every API request.
+	Headers map[string]string `yaml:"headers"`
+	// Proxy is the proxy for API requests, http, https or socks5, in place
+	// of HTTPS_PROXY, HTTP_PROXY and ALL_PROXY.
+	Proxy string `yaml:"proxy"`
+	// CABundle is a PEM file of certificate authorities to trust along with
+	// the system's, for proxies that inspect TLS.
+	CABundle string `yaml:"ca_bundle"`
+	// Timeouts bound how long talking to the provider may take.
+	Timeouts timeoutConfig `yaml:"timeouts"`
+	// RateLimit holds requests back to keep within the provider's limits.
+	RateLimit rateLimitConfig `yaml:"rate_limit"`
+	// LogLevel is how much to log about requests to the provider: off,
+	// error, info or debug. LogFile is where, defaulting to gpt.log in the
+	// data directory.
+	LogLevel string `yaml:"log_level"`
+	LogFile  string `yaml:"log_file"`
+	// Tracing exports spans of the requests to an OpenTelemetry collector.
+	Tracing tracingConfig `yaml:"tracing"`
+	// Retries is how many times a request that failed for a passing
+	// reason, such as a rate limit, is tried again. Zero turns retrying
+	// off.
+	Retries int `yaml:"retries"`
+
+	// Keys rebinds the chat's actions, e.g. send: [ctrl+s].
+	Keys map[string][]string `yaml:"keys"`
+	// Vim turns on modal editing of the input.
+	Vim bool `yaml:"vim"`
+	// InputLimit caps the length of the input in characters. Zero means no
+	// limit.
+	InputLimit int `yaml:"input_limit"`
+	// FetchURLs fetches the pages at the URLs in a message to send along
+	// with it, as /fetch does when asked.
+	FetchURLs bool `yaml:"fetch_urls"`
+
+	Markdown  bool   `yaml:"markdown"`
+	CodeTheme string `yaml:"code_theme"`
+	// ReplyDetails shows the model, tokens, time taken and finish reason
+	// under each reply.
+	ReplyDetails bool `yaml:"reply_details"`
+
+	// Theme names a built-in theme or one defined under Themes.
+	Theme  string           `yaml:"theme"`
+	Themes map[string]theme `yaml:"themes"`
+
+	Personas map[string]persona `yaml:"personas"`
+
+	// Profile names the profile in use, from the -profile flag, GPT_PROFILE
+	// or the config file.
+	Profile  string             `yaml:"profile"`
+	Profiles map[string]profile `yaml:"profiles"`
+
+	Azure     azureConfig     `yaml:"azure"`
+	Anthropic anthropicConfig `yaml:"anthropic"`
+	Gemini    geminiConfig    `yaml:"gemini"`
+	Mock      mockConfig      `yaml:"mock"`
+}
+
+func defaultConfig() config {
+	return config{
+		Provider:     "openai",
+		SystemPrompt: defaultSystemPrompt,
+		Markdown:     true,
+		Retries:      defaultRetries,
+		UpdateCheck:  true,
+	}
+}
+
+// loadConfig reads the config file, if there is one, and applies the
+// project's config file, environment variable overrides, the profile, if one
+// is chosen, and then the global flags on top of it.
+func loadConfig() (config, error) {
+	cfg, err := loadConfigFor(globals.profile)
+	if err != nil {
+		return cfg, err
+	}
+	return globals.apply(cfg), nil
+}
+
+// loadConfigFor is loadConfig without the global flags, and with the named
+// profile in place of the one chosen otherwise, unless name is empty.
+func loadConfigFor(name string) (config, error) {
+	cfg := defaultConfig()
+
+	dir, err := defaultConfigDir()
+	if err != nil {
+		return cfg, err
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return cfg, err
+	default:
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := applyProjectConfig(&cfg, path); err != nil {
+		return cfg, err
+	}
+	if cfg.Retries < 0 || cfg.Retries > maxRetries {
+		return cfg, fmt.Errorf("%s: retries must be from 0 to %d", path, maxRetries)
+	}
+
+	if v := os.Getenv("OPENAI_API_KEY"); v != "" {
+		cfg.APIKey = v
+	}
+	if v := os.Getenv("OPENAI_BASE_URL"); v != "" {
+		cfg.BaseURL = v
+	}
+	if v := os.Getenv("OPENAI_ORG_ID"); v != "" {
+		cfg.Organization = v
+	}
+	if v := os.Getenv("OPENAI_PROJECT"); v != "" {
+		cfg.Project = v
+	}
+	if v := os.Getenv("AZURE_OPENAI_ENDPOINT"); v != "" {
+		cfg.Azure.Endpoint = v
+	}
+	if v := os.Getenv("AZURE_OPENAI_API_KEY"); v != "" {
+		cfg.Azure.APIKey = v
+	}
+	if v := os.Getenv("AZURE_OPENAI_API_VERSION"); v != "" {
+		cfg.Azure.APIVersion = v
+	}
+	if v := os.Getenv("AZURE_OPENAI_DEPLOYMENT"); v != "" {
+		cfg.Azure.Deployment = v
+	}
+	if v := os.Getenv("ANTHROPIC_API_KEY"); v != "" {
+		cfg.Anthropic.APIKey = v
+	}
+	if v := os.Getenv("GEMINI_API_KEY"); v != "" {
+		cfg.Gemini.APIKey = v
+	}
+	if v := os.Getenv("GPT_PROVIDER"); v != "" {
+		cfg.Provider = v
+	}
+	if v := os.Getenv("GPT_MODEL"); v != "" {
+		cfg.Model = v
+	}
+	if v := os.Getenv("GPT_SYSTEM_PROMPT"); v != "" {
+		cfg.SystemPrompt = v
+	}
+	if v := os.Getenv("GPT_TEMPERATURE"); v != "" {
+		temperature, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return cfg, fmt.Errorf("GPT_TEMPERATURE: %w", err)
+		}
+		cfg.Temperature = float32(temperature)
+	}
+	if v := os.Getenv("GPT_SEED"); v != "" {
+		seed, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("GPT_SEED: %w", err)
+		}
+		cfg.Seed = &seed
+	}
+	if v := os.Getenv("GPT_PROFILE"); v != "" {
+		cfg.Profile = v
+	}
+
+	if name != "" {
+		cfg.Profile = name
+	}
+	if cfg.Profile != "" {
+		return applyProfile(cfg, cfg.Profile)
+	}
+	return cfg, nil
+}
+
+// projectConfigNames are the files looked for in the working directory and
+// each one above it for the settings of the project there.
+var projectConfigNames = []string{".gpt.yaml", filepath.Join(".gpt", "config.yaml")}
+
+// projectSettings are those a project's config file may change. The rest
+// could run commands or send the API key elsewhere, which a repository just
+// cloned shouldn't be able to do.
+var projectSettings = map[string]bool{
+	"provider":          true,
+	"model":             true,
+	"profile":           true,
+	"temperature":       true,
+	"top_p":             true,
+	"presence_penalty":  true,
+	"frequency_penalty": true,
+	"max_tokens":        true,
+	"model_max_tokens":  true,
+	"seed":              true,
+	"stop":              true,
+	"system_prompt":     true,
+	"context_window":    true,
+	"context_strategy":  true,
+	"summary_model":     true,
+	"embedding_model":   true,
+	"vector_store":      true,
+	"pr_template":       true,
+	"personas":          true,
+	"markdown":          true,
+	"code_theme":        true,
+	"reply_details":     true,
+	"input_limit":       true,
+}
+
+// findProjectConfig returns the nearest project config file, or "" if there
+// is none.
+func findProjectConfig() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range projectConfigNames {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// applyProjectConfig merges the nearest project config file, if there is
+// one, over cfg. A file that sets anything beyond projectSettings is refused
+// as a whole; those settings belong in global, the global config file.
+func applyProjectConfig(cfg *config, global string) error {
+	path, err := findProjectConfig()
+	if err != nil || path == "" {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	var refused []string
+	for name := range settings {
+		if !projectSettings[name] {
+			refused = append(refused, name)
+		}
+	}
+	if len(refused) > 0 {
+		sort.Strings(refused)
+		return fmt.Errorf("%s: %s can only be set in %s", path, strings.Join(refused, ", "), global)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// fillDefaults sets whatever is still unset once the config file, environment
+// and flags have been applied.
+func (c *config) fillDefaults() {
+	if c.Model == "" {
+		c.Model = defaultModels[c.Provider]
+	}
+	if c.EmbeddingModel == "" {
+		c.EmbeddingModel = defaultEmbeddingModels[c.Provider]
+	}
+}
+
+func (c config) newClient() (*openai.Client, error) {
+	clientConfig := openai.DefaultConfig(c.APIKey)
+	if c.BaseURL != "" {
+		clientConfig.BaseURL = c.BaseURL
+	}
+	clientConfig.OrgID = c.Organization
+	if c.Project != "" {
+		// Headers set in the config win, as they do over the client's own.
+		c.Headers = mergeHeaders(map[string]string{"OpenAI-Project": c.Project}, c.Headers)
+	}
+	client, err := c.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	clientConfig.HTTPClient = client
+	return openai.NewClientWithConfig(clientConfig), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// confirmMsg asks the user to allow what a tool is about to do. The answer is
+// sent back on answer, which has room for it.
+type confirmMsg struct {
+	prompt string
+	answer chan bool
+}
+
+// toolOutputMsg is output from a running tool.
+type toolOutputMsg string
+
+// confirmVia returns a toolIO.confirm that asks the user by sending a
+// confirmMsg on events, giving up if ctx is done first.
+func confirmVia(ctx context.Context, events chan tea.Msg) func(string) bool {
+	return func(prompt string) bool {
+		answer := make(chan bool, 1)
+		select {
+		case events <- confirmMsg{prompt: prompt, answer: answer}:
+		case <-ctx.Done():
+			return false
+		}
+		select {
+		case ok := <-answer:
+			return ok
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// updateConfirm answers the open question with y or n. Anything else but
+// quitting is ignored, so that a stray key doesn't allow anything.
+func (m *model) updateConfirm(msg tea.KeyMsg) (bool, tea.Cmd) {
+	if key.Matches(msg, m.keys.Quit) {
+		return false, nil
+	}
+	if answerConfirm(m.confirm, msg) {
+		m.confirm = nil
+	}
+	return true, nil
+}
+
+// answerConfirm answers c if msg is y or n, or Enter or Esc for no, and
+// reports whether it did.
+func answerConfirm(c *confirmMsg, msg tea.KeyMsg) bool {
+	switch msg.String() {
+	case "y", "Y":
+		c.answer <- true
+	case "n", "N", "enter", "esc":
+		c.answer <- false
+	default:
+		return false
+	}
+	return true
+}
+
+func (m model) confirmView() string {
+	return m.styles.notice.Width(m.width).Render(m.confirm.prompt + " [y/N]")
+}
+
+// confirmOnTerminal asks on the terminal, for when there is no chat to ask
+// in. Nothing is allowed if there is no terminal.
+func confirmOnTerminal(prompt string) bool {
+	answer, _ := askOnTerminal(prompt + " [y/N] ")
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes"
+}
+
+// askOnTerminal prints prompt to stderr and reads a line from the terminal,
+// even when stdin is piped. It reports false if there is no terminal.
+func askOnTerminal(prompt string) (string, bool) {
+	name := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		name = "CONIN$"
+	}
+	tty, err := os.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer tty.Close()
+
+	fmt.Fprint(os.Stderr, prompt)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	return strings.TrimSpace(answer), true
+}
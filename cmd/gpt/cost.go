@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// modelPrice is the price of a model in US dollars per million tokens.
+type modelPrice struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// defaultPrices are list prices by model name prefix. The longest matching
+// prefix wins, and the prices config setting takes precedence.
+var defaultPrices = map[string]modelPrice{
+	"gpt-5":             {1.25, 10},
+	"gpt-5-mini":        {0.25, 2},
+	"gpt-5-nano":        {0.05, 0.40},
+	"gpt-4.1":           {2, 8},
+	"gpt-4.1-mini":      {0.40, 1.60},
+	"gpt-4.1-nano":      {0.10, 0.40},
+	"gpt-4o":            {2.50, 10},
+	"gpt-4o-mini":       {0.15, 0.60},
+	"gpt-4-turbo":       {10, 30},
+	"gpt-4":             {30, 60},
+	"gpt-3.5-turbo":     {0.50, 1.50},
+	"o1":                {15, 60},
+	"o1-mini":           {1.10, 4.40},
+	"o3":                {2, 8},
+	"o3-mini":           {1.10, 4.40},
+	"o4-mini":           {1.10, 4.40},
+	"claude-opus-4":     {15, 75},
+	"claude-sonnet-4":   {3, 15},
+	"claude-haiku-4":    {1, 5},
+	"claude-3-5-haiku":  {0.80, 4},
+	"claude-3-7-sonnet": {3, 15},
+	"gemini-2.5-pro":    {1.25, 10},
+	"gemini-2.5-flash":  {0.30, 2.50},
+	"gemini-2.0-flash":  {0.10, 0.40},
+}
+
+// price looks up the price of a model, reporting whether it is known.
+func (c config) price(model string) (modelPrice, bool) {
+	if price, ok := c.Prices[model]; ok {
+		return price, true
+	}
+
+	var match string
+	for prefix := range defaultPrices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return modelPrice{}, false
+	}
+	return defaultPrices[match], true
+}
+
+func (p modelPrice) cost(usage openai.Usage) float64 {
+	return (float64(usage.PromptTokens)*p.Input + float64(usage.CompletionTokens)*p.Output) / 1_000_000
+}
+
+// modelUsage is the usage of one model over a session.
+type modelUsage struct {
+	Requests int
+	openai.Usage
+}
+
+// costTracker adds up the token usage reported with each reply.
+type costTracker struct {
+	models map[string]*modelUsage
+}
+
+func newCostTracker() *costTracker {
+	return &costTracker{models: make(map[string]*modelUsage)}
+}
+
+func (t *costTracker) add(model string, usage openai.Usage) {
+	u, ok := t.models[model]
+	if !ok {
+		u = &modelUsage{}
+		t.models[model] = u
+	}
+	u.Requests++
+	u.PromptTokens += usage.PromptTokens
+	u.CompletionTokens += usage.CompletionTokens
+	u.TotalTokens += usage.TotalTokens
+}
+
+// total returns the cost of the models with a known price.
+func (t *costTracker) total(cfg config) float64 {
+	var total float64
+	for model, u := range t.models {
+		if price, ok := cfg.price(model); ok {
+			total += price.cost(u.Usage)
+		}
+	}
+	return total
+}
+
+// breakdown describes the usage and cost of each model, one per line.
+func (t *costTracker) breakdown(cfg config) string {
+	if len(t.models) == 0 {
+		return "No usage yet"
+	}
+
+	models := make([]string, 0, len(t.models))
+	for model := range t.models {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+
+	var b strings.Builder
+	for _, model := range models {
+		u := t.models[model]
+		cost := "no price"
+		if price, ok := cfg.price(model); ok {
+			cost = formatCost(price.cost(u.Usage))
+		}
+		fmt.Fprintf(&b, "%s: %d requests, %s in / %s out tokens, %s\n", model, u.Requests,
+			formatCount(u.PromptTokens), formatCount(u.CompletionTokens), cost)
+	}
+	fmt.Fprintf(&b, "Total: %s", formatCost(t.total(cfg)))
+	return b.String()
+}
+
+// formatCost formats an amount in US dollars, with more precision for the
+// small amounts a single session usually costs.
+func formatCost(dollars float64) string {
+	if dollars < 1 {
+		return fmt.Sprintf("$%.4f", dollars)
+	}
+	return fmt.Sprintf("$%.2f", dollars)
+}
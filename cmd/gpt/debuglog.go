@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// logLevel is how much gpt logs about talking to providers. Each level logs
+// what those below it do.
+type logLevel int
+
+const (
+	logOff logLevel = iota
+	// logError logs failed requests.
+	logError
+	// logInfo logs each request's model and size, and when and how its
+	// reply ended.
+	logInfo
+	// logDebug logs the requests themselves, every chunk of the replies
+	// and the HTTP exchanges beneath them, with keys redacted.
+	logDebug
+)
+
+var logLevels = map[string]logLevel{
+	"off":   logOff,
+	"error": logError,
+	"info":  logInfo,
+	"debug": logDebug,
+}
+
+func parseLogLevel(s string) (logLevel, error) {
+	if s == "" {
+		return logOff, nil
+	}
+	level, ok := logLevels[strings.ToLower(s)]
+	if !ok {
+		return logOff, fmt.Errorf("unknown log level %q; use %s", s, strings.Join(sortedKeys(logLevels), ", "))
+	}
+	return level, nil
+}
+
+// requestLog writes what happens to requests to a file.
+type requestLog struct {
+	// level is a logLevel, raised when another config asks for more of the
+	// same file while requests are being logged.
+	level  atomic.Int32
+	logger *log.Logger
+	// next numbers the requests, so that the lines of one can be told
+	// apart from another's.
+	next atomic.Int64
+}
+
+var (
+	requestLogsMu sync.Mutex
+	// requestLogs are opened once for each file, however many providers
+	// log to it.
+	requestLogs = make(map[string]*requestLog)
+)
+
+// defaultLogFile is where the log is written unless log_file says otherwise.
+func defaultLogFile() (string, error) {
+	dir, err := defaultDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gpt.log"), nil
+}
+
+// requestLogFor returns the log the config asks for, or nil if it asks for
+// none.
+func requestLogFor(cfg config) (*requestLog, error) {
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil || level == logOff {
+		return nil, err
+	}
+	path := cfg.LogFile
+	if path == "" {
+		if path, err = defaultLogFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	requestLogsMu.Lock()
+	defer requestLogsMu.Unlock()
+	if l, ok := requestLogs[path]; ok {
+		if int32(level) > l.level.Load() {
+			l.level.Store(int32(level))
+		}
+		return l, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("log_file: %w", err)
+	}
+	l := &requestLog{logger: log.New(f, "", log.LstdFlags|log.Lmicroseconds)}
+	l.level.Store(int32(level))
+	requestLogs[path] = l
+	return l, nil
+}
+
+// enabled reports whether the log takes what is logged at level.
+func (l *requestLog) enabled(level logLevel) bool {
+	return l != nil && int32(level) <= l.level.Load()
+}
+
+func (l *requestLog) logf(level logLevel, format string, args ...any) {
+	if l.enabled(level) {
+		l.logger.Printf(format, args...)
+	}
+}
+
+// loggingProvider logs the requests sent through it and their replies.
+type loggingProvider struct {
+	provider
+	name string
+	log  *requestLog
+}
+
+func (p loggingProvider) unwrap() provider { return p.provider }
+
+func (p loggingProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	id := p.log.next.Add(1)
+	p.log.logf(logInfo, "#%d request: provider=%s model=%s messages=%d tools=%d max_tokens=%d",
+		id, p.name, req.Model, len(req.Messages), len(req.Tools), req.MaxTokens)
+	if p.log.enabled(logDebug) {
+		if data, err := json.Marshal(req); err == nil {
+			p.log.logf(logDebug, "#%d request body: %s", id, data)
+		}
+	}
+
+	start := time.Now()
+	stream, err := p.provider.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		p.log.logf(logError, "#%d failed after %s: %v", id, time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+	return &loggingStream{stream: stream, log: p.log, id: id, start: start}, nil
+}
+
+// loggingStream logs the chunks of a reply, how long the first took to
+// arrive, and how the reply ended.
+type loggingStream struct {
+	stream chatStream
+	log    *requestLog
+	id     int64
+	start  time.Time
+	chunks int
+	finish openai.FinishReason
+	usage  *openai.Usage
+	done   bool
+}
+
+func (s *loggingStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	response, err := s.stream.Recv()
+	elapsed := time.Since(s.start).Round(time.Millisecond)
+	switch {
+	case errors.Is(err, io.EOF):
+		if !s.done {
+			s.done = true
+			usage := "none reported"
+			if s.usage != nil {
+				usage = fmt.Sprintf("prompt=%d completion=%d", s.usage.PromptTokens, s.usage.CompletionTokens)
+			}
+			s.log.logf(logInfo, "#%d done in %s: chunks=%d finish=%s usage: %s", s.id, elapsed, s.chunks, s.finish, usage)
+		}
+	case err != nil:
+		s.done = true
+		s.log.logf(logError, "#%d failed after %s and %d chunks: %v", s.id, elapsed, s.chunks, err)
+	default:
+		s.chunks++
+		if s.chunks == 1 {
+			s.log.logf(logInfo, "#%d first chunk after %s", s.id, elapsed)
+		}
+		if len(response.Choices) > 0 && response.Choices[0].FinishReason != "" {
+			s.finish = response.Choices[0].FinishReason
+		}
+		if response.Usage != nil {
+			s.usage = response.Usage
+		}
+		if s.log.enabled(logDebug) {
+			if data, err := json.Marshal(response); err == nil {
+				s.log.logf(logDebug, "#%d chunk %d at %s: %s", s.id, s.chunks, elapsed, data)
+			}
+		}
+	}
+	return response, err
+}
+
+func (s *loggingStream) Close() error {
+	if !s.done {
+		s.done = true
+		s.log.logf(logInfo, "#%d closed after %s and %d chunks", s.id, time.Since(s.start).Round(time.Millisecond), s.chunks)
+	}
+	return s.stream.Close()
+}
+
+// loggingTransport logs the HTTP requests beneath the provider's, such as
+// retries, with their status and timing.
+type loggingTransport struct {
+	log  *requestLog
+	base http.RoundTripper
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	t.log.logf(logDebug, "http %s %s headers: %s", req.Method, redactURL(req.URL), redactHeaders(req.Header))
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		t.log.logf(logDebug, "http %s %s failed after %s: %v", req.Method, redactURL(req.URL), elapsed, err)
+		return resp, err
+	}
+	t.log.logf(logDebug, "http %s %s: %s after %s headers: %s", req.Method, redactURL(req.URL), resp.Status, elapsed, redactHeaders(resp.Header))
+	return resp, err
+}
+
+// secretHeader reports whether a header's value may be a secret, going by
+// its name.
+func secretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"auth", "key", "token", "secret", "cookie"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		value := strings.Join(h[name], ", ")
+		if secretHeader(name) {
+			value = maskKey(value)
+		}
+		parts[i] = name + "=" + value
+	}
+	return strings.Join(parts, " ")
+}
+
+// redactURL masks query parameters that may hold keys, as some APIs take
+// them there.
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	q := u.Query()
+	for name, values := range q {
+		if secretHeader(name) || name == "sig" {
+			for i := range values {
+				values[i] = maskKey(values[i])
+			}
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = q.Encode()
+	return redacted.String()
+}
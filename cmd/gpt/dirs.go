@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// defaultConfigDir is where the config file and prompt templates live:
+// $XDG_CONFIG_HOME/gpt or ~/.config/gpt, ~/Library/Application Support/gpt
+// on macOS and %AppData%\gpt on Windows.
+func defaultConfigDir() (string, error) {
+	return appDir("XDG_CONFIG_HOME", ".config", func() (string, error) {
+		dir, err := os.UserConfigDir()
+		return filepath.Join(dir, "gpt"), err
+	})
+}
+
+// defaultDataDir is where conversations, sessions, spending and indexes are
+// kept: $XDG_DATA_HOME/gpt or ~/.local/share/gpt, ~/Library/Application
+// Support/gpt on macOS and %LocalAppData%\gpt on Windows.
+func defaultDataDir() (string, error) {
+	return appDir("XDG_DATA_HOME", filepath.Join(".local", "share"), func() (string, error) {
+		dir, err := os.UserConfigDir()
+		if runtime.GOOS == "windows" {
+			// Conversations and indexes are too big to roam.
+			dir, err = os.UserCacheDir()
+		}
+		return filepath.Join(dir, "gpt"), err
+	})
+}
+
+// defaultCacheDir is for what can be fetched again: $XDG_CACHE_HOME/gpt or
+// ~/.cache/gpt, ~/Library/Caches/gpt on macOS and %LocalAppData%\gpt\cache
+// on Windows.
+func defaultCacheDir() (string, error) {
+	return appDir("XDG_CACHE_HOME", ".cache", func() (string, error) {
+		dir, err := os.UserCacheDir()
+		if runtime.GOOS == "windows" {
+			// %LocalAppData%\gpt holds the data.
+			return filepath.Join(dir, "gpt", "cache"), err
+		}
+		return filepath.Join(dir, "gpt"), err
+	})
+}
+
+// appDir returns $xdg/gpt if the variable is set, else ~/unix/gpt, except on
+// macOS and Windows, which have places of their own returned by platform.
+// There ~/unix/gpt is still used if it exists, as it was before.
+func appDir(xdg, unix string, platform func() (string, error)) (string, error) {
+	if dir := os.Getenv(xdg); dir != "" {
+		return filepath.Join(dir, "gpt"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, unix, "gpt")
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" {
+		return dir, nil
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+	return platform()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	acceptKey = key.NewBinding(key.WithKeys("ctrl+s"), key.WithHelp("ctrl+s", "accept"))
+	abortKey  = key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "abort"))
+)
+
+// draftModel lets the user edit text the model wrote, such as a commit
+// message, before it is used.
+type draftModel struct {
+	textarea textarea.Model
+	// header is shown above the text, e.g. the files changed.
+	header string
+	// action is what accepting the text does, for the help line.
+	action string
+	keys   keyMap
+	styles styles
+	err    error
+	// accepted is whether the user chose to go ahead with the text.
+	accepted bool
+}
+
+func newDraftModel(text, header, action string, keys keyMap, s styles) *draftModel {
+	ta := textarea.New()
+	ta.ShowLineNumbers = false
+	ta.Prompt = "┃ "
+	ta.CharLimit = 0
+	ta.FocusedStyle.CursorLine = lipgloss.NewStyle()
+	ta.SetValue(text)
+	ta.Focus()
+	return &draftModel{
+		textarea: ta,
+		header:   strings.TrimRight(header, "\n"),
+		action:   action,
+		keys:     keys,
+		styles:   s,
+	}
+}
+
+func (m *draftModel) Init() tea.Cmd {
+	return textarea.Blink
+}
+
+func (m *draftModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, acceptKey):
+			if strings.TrimSpace(m.textarea.Value()) == "" {
+				m.err = errors.New("there is nothing to " + m.action)
+				return m, nil
+			}
+			m.accepted = true
+			return m, tea.Quit
+		case key.Matches(msg, abortKey), key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Editor):
+			return m, editInEditor(m.textarea.Value())
+		}
+		m.err = nil
+	case tea.WindowSizeMsg:
+		m.textarea.SetWidth(msg.Width)
+		height := msg.Height - lipgloss.Height(m.header) - 4
+		if height < minInputHeight {
+			height = minInputHeight
+		}
+		m.textarea.SetHeight(height)
+	case editorDoneMsg:
+		if msg.err != nil {
+			m.err = msg.err
+		} else {
+			m.textarea.SetValue(msg.content)
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.textarea, cmd = m.textarea.Update(msg)
+	return m, cmd
+}
+
+func (m *draftModel) View() string {
+	status := m.styles.footer.Render(fmt.Sprintf("%s %s · %s %s · %s %s",
+		acceptKey.Help().Key, m.action,
+		m.keys.Editor.Help().Key, "edit in $EDITOR",
+		abortKey.Help().Key, abortKey.Help().Desc))
+	if m.err != nil {
+		status = m.styles.err.Render(m.err.Error())
+	}
+	return m.styles.footer.Render(m.header) + "\n\n" + m.textarea.View() + "\n" + status
+}
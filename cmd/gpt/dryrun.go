@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// runDryRun prints the request prompt would be sent as, once the system
+// prompt, the conversation so far, code from the index, the tools and the
+// fitting to the context window are in place, without sending it or saving
+// anything. structured asks for JSON, matching schema if it isn't nil.
+func runDryRun(cfg config, p provider, conv *conversation, prompt string, r *retriever, tools *toolRegistry, structured bool, schema json.RawMessage) error {
+	content := prompt
+	var format *openai.ChatCompletionResponseFormat
+	if structured {
+		var instruction string
+		instruction, format = jsonFormat(schema)
+		content += instruction
+	}
+	messages := append(conv.Messages[:len(conv.Messages):len(conv.Messages)], openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: content,
+	})
+
+	req, err := newChatRequest(cfg, detectPromptData(), messages)
+	if err != nil {
+		return err
+	}
+	req.ResponseFormat = format
+	ctx := context.Background()
+	if err := r.augment(ctx, &req); err != nil {
+		return err
+	}
+
+	// Summarizing would take a request of its own, so the messages it would
+	// cover are shown left out.
+	fit := cfg
+	if fit.ContextStrategy == contextSummarize {
+		fit.ContextStrategy = contextSliding
+	}
+	dropped, _, err := fitContext(ctx, fit, p, conv, &req)
+	if err != nil {
+		return err
+	}
+	if dropped > 0 {
+		verb := "left out"
+		if cfg.ContextStrategy == contextSummarize {
+			verb = "summarized"
+		}
+		fmt.Fprintf(os.Stderr, "%d earlier messages would be %s to fit the context window\n", dropped, verb)
+	}
+	if !structured {
+		req.Tools = tools.definitions()
+	}
+	req.Stream = true
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(req)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// editorDoneMsg carries the input back from the external editor.
+type editorDoneMsg struct {
+	content string
+	err     error
+}
+
+// editorCommand returns the user's editor: $VISUAL, then $EDITOR, then a
+// default for the platform. The variables may include arguments, as in
+// "code --wait".
+func editorCommand() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// openEditor hands the input to the external editor in a temporary file,
+// suspending the chat until the editor exits.
+func (m *model) openEditor() tea.Cmd {
+	return editInEditor(m.textarea.Value())
+}
+
+// editInEditor returns a command that suspends the program while the user
+// edits text in the external editor, and then sends an editorDoneMsg.
+func editInEditor(text string) tea.Cmd {
+	f, err := os.CreateTemp("", "gpt-*.md")
+	if err != nil {
+		return func() tea.Msg { return editorDoneMsg{err: err} }
+	}
+	path := f.Name()
+	_, err = f.WriteString(text)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return func() tea.Msg { return editorDoneMsg{err: err} }
+	}
+
+	args := append(editorCommand(), path)
+	c := exec.Command(args[0], args[1:]...)
+	return tea.ExecProcess(c, func(err error) tea.Msg {
+		defer os.Remove(path)
+		if err != nil {
+			return editorDoneMsg{err: errors.New("editor: " + err.Error())}
+		}
+		content, err := os.ReadFile(path)
+		return editorDoneMsg{content: strings.TrimRight(string(content), "\n"), err: err}
+	})
+}
+
+// editText lets the user change text in the external editor, outside the
+// chat, and returns the result.
+func editText(text string) (string, error) {
+	f, err := os.CreateTemp("", "gpt-*.txt")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	defer os.Remove(path)
+	_, err = f.WriteString(text + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	args := append(editorCommand(), path)
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return "", errors.New("editor: " + err.Error())
+	}
+	content, err := os.ReadFile(path)
+	return strings.TrimSpace(string(content)), err
+}
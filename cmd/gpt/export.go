@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// transcript is the exported form of a conversation.
+type transcript struct {
+	Title    string                         `json:"title,omitempty"`
+	Model    string                         `json:"model,omitempty"`
+	Messages []openai.ChatCompletionMessage `json:"messages"`
+}
+
+// exportFormat picks the export format from a file name, defaulting to
+// Markdown.
+func exportFormat(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return "json"
+	}
+	return "markdown"
+}
+
+func writeTranscript(w io.Writer, t transcript, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t)
+	case "markdown", "md":
+		return writeMarkdown(w, t)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+func writeMarkdown(w io.Writer, t transcript) error {
+	var b strings.Builder
+	if t.Title != "" {
+		fmt.Fprintf(&b, "# %s\n\n", t.Title)
+	}
+	if t.Model != "" {
+		fmt.Fprintf(&b, "_Model: %s_\n\n", t.Model)
+	}
+
+	for _, msg := range t.Messages {
+		switch msg.Role {
+		case openai.ChatMessageRoleUser:
+			b.WriteString("## You\n\n")
+		case openai.ChatMessageRoleAssistant:
+			b.WriteString("## Assistant\n\n")
+		default:
+			continue
+		}
+		if content := strings.TrimSpace(msg.Content); content != "" {
+			b.WriteString(closeCodeFence(content))
+			b.WriteString("\n\n")
+		}
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(&b, "_Called `%s`_\n\n", formatToolCall(call))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// closeCodeFence terminates a code block left open, e.g. by a reply that was
+// cut off, so it doesn't swallow the rest of the document.
+func closeCodeFence(content string) string {
+	lines := strings.Split(content, "\n")
+	blocks := parseCodeBlocks(content)
+	if len(blocks) > 0 && blocks[len(blocks)-1].End == len(lines) {
+		return content + "\n```"
+	}
+	return content
+}
+
+func exportToFile(path string, t transcript, format string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := writeTranscript(f, t, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/net/html"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	// maxFetchBody is the most that is downloaded of a page.
+	maxFetchBody = 2 * 1024 * 1024
+	// maxPageText is the most text of a page that is attached.
+	maxPageText = 50 * 1024
+	// fetchCacheTTL is how long fetched pages are reused for.
+	fetchCacheTTL = 24 * time.Hour
+)
+
+// webURL matches the URLs in a message that are fetched for context.
+var webURL = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+// skippedElements hold no text worth reading.
+var skippedElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"svg":      true,
+	"iframe":   true,
+	"form":     true,
+	"nav":      true,
+	"header":   true,
+	"footer":   true,
+	"aside":    true,
+	"template": true,
+}
+
+// blockElements start a new line of text.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "pre": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"section": true, "article": true, "blockquote": true, "table": true,
+	"ul": true, "ol": true, "dt": true, "dd": true, "hr": true,
+}
+
+// webPage is the readable text of a page.
+type webPage struct {
+	URL     string    `json:"url"`
+	Title   string    `json:"title"`
+	Text    string    `json:"text"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// attachment formats the page for sending along with a message.
+func (p webPage) attachment() string {
+	return fmt.Sprintf("\n\n<page url=\"%s\">\n%s\n</page>", p.URL, p.Text)
+}
+
+// findURLs returns the distinct URLs in s, without trailing punctuation.
+func findURLs(s string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, url := range webURL.FindAllString(s, -1) {
+		url = strings.TrimRight(url, ".,;:!?)]}")
+		if !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// fetchPage downloads url and strips it to readable text, reusing a copy
+// fetched within fetchCacheTTL.
+func fetchPage(ctx context.Context, url string) (webPage, error) {
+	cachePath, cacheErr := fetchCachePath(url)
+	if cacheErr == nil {
+		if page, err := readCachedPage(cachePath); err == nil && time.Since(page.Fetched) < fetchCacheTTL {
+			return page, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return webPage{}, err
+	}
+	req.Header.Set("User-Agent", "gpt-cli")
+	req.Header.Set("Accept", "text/html, text/plain;q=0.9, */*;q=0.5")
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return webPage{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return webPage{}, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	body := io.LimitReader(resp.Body, maxFetchBody)
+	page := webPage{URL: url, Fetched: time.Now()}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml" || mediaType == "":
+		page.Title, page.Text, err = readableText(body)
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json":
+		var data []byte
+		data, err = io.ReadAll(body)
+		page.Text = string(data)
+	default:
+		err = fmt.Errorf("can't read %s: it is %s", url, mediaType)
+	}
+	if err != nil {
+		return webPage{}, err
+	}
+
+	page.Text = strings.TrimSpace(page.Text)
+	if len(page.Text) > maxPageText {
+		page.Text = strings.ToValidUTF8(page.Text[:maxPageText], "") + "\n[truncated]"
+	}
+	if cacheErr == nil {
+		// The page is still good to use if it can't be cached.
+		_ = writeCachedPage(cachePath, page)
+	}
+	return page, nil
+}
+
+// fetchClient fetches pages, only from addresses beyond this machine and its
+// network: public ones. A URL in a message, or one the model asks for, could
+// otherwise reach the services listening on localhost, a router or intranet
+// server, or a cloud's metadata server. Redirects are dialed the same way.
+var fetchClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: newFetchTransport(),
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// Alibaba Cloud's metadata server is in.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func newFetchTransport() *http.Transport {
+	// The proxies set in the environment are the user's own choice and
+	// are dialed whatever their address.
+	proxies := make(map[string]bool)
+	env := httpproxy.FromEnvironment()
+	for _, proxy := range []string{env.HTTPProxy, env.HTTPSProxy} {
+		if u, err := url.Parse(proxy); err == nil && u.Hostname() != "" {
+			proxies[u.Hostname()] = true
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || proxies[host] {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		// The address checked is the one dialed, so that a name can't
+		// resolve to another in between.
+		ips, err := fetchableAddrs(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+	}
+	// Through a proxy only the proxy is dialed, so the page's host is
+	// checked before it is handed over.
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		proxy, err := http.ProxyFromEnvironment(req)
+		if err != nil || proxy == nil {
+			return proxy, err
+		}
+		if _, err := fetchableAddrs(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+		return proxy, nil
+	}
+	return t
+}
+
+// fetchableAddrs resolves host, failing if any of its addresses isn't one
+// pages may be fetched from.
+func fetchableAddrs(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !fetchableIP(ip.IP) {
+			return nil, fmt.Errorf("won't fetch from %s, an address of this machine or its network", host)
+		}
+	}
+	return ips, nil
+}
+
+// fetchableIP reports whether pages may be fetched from ip. Private addresses
+// take in the unique local IPv6 range of AWS's metadata server.
+func fetchableIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// readableText extracts the title and text of an HTML page, leaving out
+// scripts, navigation and the like.
+func readableText(r io.Reader) (title, text string, err error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.ElementNode:
+			if skippedElements[n.Data] {
+				return
+			}
+			if n.Data == "title" {
+				if n.FirstChild != nil {
+					title = strings.TrimSpace(n.FirstChild.Data)
+				}
+				return
+			}
+			if blockElements[n.Data] {
+				b.WriteString("\n")
+			}
+		case html.TextNode:
+			b.WriteString(strings.Join(strings.Fields(n.Data), " "))
+			if strings.TrimSpace(n.Data) != "" && strings.HasSuffix(n.Data, " ") {
+				b.WriteString(" ")
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			b.WriteString("\n")
+		}
+	}
+	walk(doc)
+
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return title, strings.Join(lines, "\n"), nil
+}
+
+func fetchCachePath(url string) (string, error) {
+	dir, err := defaultCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fetch", hashString(url)[:16]+".json"), nil
+}
+
+func readCachedPage(path string) (webPage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return webPage{}, err
+	}
+	var page webPage
+	err = json.Unmarshal(data, &page)
+	return page, err
+}
+
+func writeCachedPage(path string, page webPage) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// pagesFetchedMsg carries the pages fetched for a message, which is sent once
+// they have all arrived.
+type pagesFetchedMsg struct {
+	// input is the message to send, or empty for /fetch.
+	input string
+	pages []webPage
+	err   error
+}
+
+// fetchPages fetches urls in the background.
+func (m *model) fetchPages(input string, urls []string) tea.Cmd {
+	m.fetching = true
+	m.notice = "Fetching " + strings.Join(urls, ", ")
+	return func() tea.Msg {
+		msg := pagesFetchedMsg{input: input}
+		for _, url := range urls {
+			page, err := fetchPage(context.Background(), url)
+			if err != nil {
+				msg.err = errors.Join(msg.err, err)
+				continue
+			}
+			msg.pages = append(msg.pages, page)
+		}
+		return msg
+	}
+}
+
+// fetch handles /fetch, attaching the pages to the next message.
+func (m *model) fetch(args []string) tea.Cmd {
+	if len(args) == 0 {
+		m.err = errors.New("usage: /fetch <url>...")
+		return nil
+	}
+	return m.fetchPages("", args)
+}
+
+// pagesFetched attaches the fetched pages, sending the message they were
+// fetched for if there is one. The message is sent with the pages that
+// could be fetched, if not all of them could.
+func (m *model) pagesFetched(msg pagesFetchedMsg) tea.Cmd {
+	m.fetching = false
+	m.notice = ""
+
+	var pages string
+	titles := make([]string, len(msg.pages))
+	for i, page := range msg.pages {
+		pages += page.attachment()
+		titles[i] = page.URL
+		if page.Title != "" {
+			titles[i] = page.Title
+		}
+	}
+
+	if msg.input == "" {
+		if msg.err != nil {
+			m.err = msg.err
+			return nil
+		}
+		m.pages += pages
+		m.notice = "Attached " + strings.Join(titles, ", ") + " to your next message"
+		return nil
+	}
+
+	cmd, err := m.sendInput(msg.input, pages)
+	if err != nil {
+		m.err = err
+		// Give the message back so that it isn't lost.
+		if m.textarea.Value() == "" {
+			m.textarea.SetValue(msg.input)
+		}
+		return nil
+	}
+	if msg.err != nil {
+		m.notice = "Sent without the pages that couldn't be fetched: " + strings.ReplaceAll(msg.err.Error(), "\n", "; ")
+	}
+	return cmd
+}
+
+// newFetchTool lets the model read web pages.
+func newFetchTool() tool {
+	return tool{
+		name:        "fetch_url",
+		description: "Download a web page and return its readable text.",
+		parameters: jsonSchema{
+			Type: "object",
+			Properties: map[string]jsonSchema{
+				"url": {Type: "string", Description: "The http or https URL of the page"},
+			},
+			Required: []string{"url"},
+		},
+		status: func(args string) string {
+			return "Fetching " + fetchToolURL(args)
+		},
+		run: func(ctx context.Context, args string, tio toolIO) (string, error) {
+			url := fetchToolURL(args)
+			if !webURL.MatchString(url) {
+				return "", fmt.Errorf("%q is not an http or https URL", url)
+			}
+			if !tio.ask(fmt.Sprintf("Fetch %s?", url)) {
+				return "", errors.New("the user declined to fetch the page")
+			}
+			page, err := fetchPage(ctx, url)
+			if err != nil {
+				return "", err
+			}
+			if page.Title != "" {
+				return page.Title + "\n\n" + page.Text, nil
+			}
+			return page.Text, nil
+		},
+	}
+}
+
+func fetchToolURL(args string) string {
+	var params struct {
+		URL string `json:"url"`
+	}
+	_ = json.Unmarshal([]byte(args), &params)
+	return strings.TrimSpace(params.URL)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// newReadFileTool lets the model read text files, up to maxFileAttachment.
+func newReadFileTool() tool {
+	return tool{
+		name:        "read_file",
+		description: "Read a text file. Lines are numbered; give start_line and end_line to read part of a long file.",
+		parameters: jsonSchema{
+			Type: "object",
+			Properties: map[string]jsonSchema{
+				"path":       {Type: "string", Description: "The file to read"},
+				"start_line": {Type: "integer", Description: "The first line to read, from 1"},
+				"end_line":   {Type: "integer", Description: "The last line to read"},
+			},
+			Required: []string{"path"},
+		},
+		status: func(args string) string {
+			return "Reading " + toolPath(args)
+		},
+		run: func(ctx context.Context, args string, tio toolIO) (string, error) {
+			var params struct {
+				Path      string `json:"path"`
+				StartLine int    `json:"start_line"`
+				EndLine   int    `json:"end_line"`
+			}
+			if err := json.Unmarshal([]byte(args), &params); err != nil {
+				return "", err
+			}
+			if !inWorkingDir(params.Path) && !tio.ask(fmt.Sprintf("Read %s, outside the working directory?", params.Path)) {
+				return "", errors.New("the user declined to read the file")
+			}
+			return readFileLines(params.Path, params.StartLine, params.EndLine)
+		},
+	}
+}
+
+// inWorkingDir reports whether path is in the working directory, once any
+// symlinks are followed.
+func inWorkingDir(path string) bool {
+	dir, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readFileLines returns lines start to end of a text file, numbered.
+func readFileLines(path string, start, end int) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return "", err
+		}
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			names[i] = entry.Name()
+			if entry.IsDir() {
+				names[i] += "/"
+			}
+		}
+		return fmt.Sprintf("%s is a directory of:\n%s", path, strings.Join(names, "\n")), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("%s is not a text file", path)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if start < 1 {
+		start = 1
+	}
+	if end < 1 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return "", fmt.Errorf("%s has %d lines", path, len(lines))
+	}
+
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		if b.Len() > maxFileAttachment {
+			fmt.Fprintf(&b, "[stopped at line %d of %d; read the rest with start_line]", i, len(lines))
+			break
+		}
+		fmt.Fprintf(&b, "%d\t%s\n", i, lines[i-1])
+	}
+	return b.String(), nil
+}
+
+// newWriteFileTool lets the model write files, each once the user has
+// allowed it.
+func newWriteFileTool() tool {
+	return tool{
+		name:        "write_file",
+		description: "Create or replace a file with the given content. The user is asked first.",
+		parameters: jsonSchema{
+			Type: "object",
+			Properties: map[string]jsonSchema{
+				"path":    {Type: "string", Description: "The file to write"},
+				"content": {Type: "string", Description: "The whole new content of the file"},
+			},
+			Required: []string{"path", "content"},
+		},
+		status: func(args string) string {
+			return "Writing " + toolPath(args)
+		},
+		run: func(ctx context.Context, args string, tio toolIO) (string, error) {
+			var params struct {
+				Path    string `json:"path"`
+				Content string `json:"content"`
+			}
+			if err := json.Unmarshal([]byte(args), &params); err != nil {
+				return "", err
+			}
+			if params.Path == "" {
+				return "", errors.New("no path given")
+			}
+
+			lines := strings.Count(params.Content, "\n")
+			if !strings.HasSuffix(params.Content, "\n") && params.Content != "" {
+				lines++
+			}
+			verb := "Create"
+			if _, err := os.Stat(params.Path); err == nil {
+				verb = "Overwrite"
+			}
+			if !tio.ask(fmt.Sprintf("%s %s with %d lines?", verb, params.Path, lines)) {
+				return "", errors.New("the user declined to write the file")
+			}
+
+			if err := os.MkdirAll(filepath.Dir(params.Path), 0o755); err != nil {
+				return "", err
+			}
+			if err := os.WriteFile(params.Path, []byte(params.Content), 0o644); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Wrote %d lines to %s", lines, params.Path), nil
+		},
+	}
+}
+
+// toolPath returns the path in the arguments of a file tool call.
+func toolPath(args string) string {
+	var params struct {
+		Path string `json:"path"`
+	}
+	_ = json.Unmarshal([]byte(args), &params)
+	return params.Path
+}
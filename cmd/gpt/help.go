@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// helpView lists the key bindings and slash commands. It is generated from
+// the key map and the command registry so that it can't go out of date.
+func (m model) helpView() string {
+	var b strings.Builder
+	b.WriteString(m.styles.user.Render("Keys") + "\n")
+	for _, nb := range m.keys.bindings() {
+		if !nb.binding.Enabled() {
+			continue
+		}
+		fmt.Fprintf(&b, "  %-16s %s\n", strings.Join(nb.binding.Keys(), "/"), nb.binding.Help().Desc)
+	}
+	if m.vim.enabled {
+		fmt.Fprintf(&b, "  %-16s %s\n", "esc, i/a/I/A", "switch between normal and insert mode")
+	}
+
+	b.WriteString("\n" + m.styles.user.Render("Commands") + "\n")
+	for _, name := range commandNames() {
+		command := slashCommands[name]
+		fmt.Fprintf(&b, "  %-40s %s\n", strings.TrimSpace(name+" "+command.usage), command.help)
+	}
+	b.WriteString("\nPress any key to close.")
+
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(m.styles.footer.GetForeground()).
+		Padding(0, 1).
+		Render(b.String())
+}
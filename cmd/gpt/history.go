@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// historyEntry is a single line of a conversation file.
+type historyEntry struct {
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+
+	// ToolCalls are the tools an assistant message called, and ToolCallID
+	// the call a tool message answers.
+	ToolCalls  []openai.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+	// Name is who sent a user message in a chat room.
+	Name string `json:"name,omitempty"`
+}
+
+func newHistoryEntry(msg openai.ChatCompletionMessage) historyEntry {
+	return historyEntry{
+		Role:       msg.Role,
+		Content:    msg.Content,
+		Time:       time.Now(),
+		ToolCalls:  msg.ToolCalls,
+		ToolCallID: msg.ToolCallID,
+		Name:       msg.Name,
+	}
+}
+
+func (e historyEntry) message() openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{
+		Role:       e.Role,
+		Content:    e.Content,
+		ToolCalls:  e.ToolCalls,
+		ToolCallID: e.ToolCallID,
+		Name:       e.Name,
+	}
+}
+
+type historyStore struct {
+	dir string
+}
+
+type conversation struct {
+	ID       string
+	path     string
+	Messages []openai.ChatCompletionMessage
+
+	summary conversationSummary
+}
+
+// conversationSummary stands in for the first Covers messages of a
+// conversation once it no longer fits the context window.
+type conversationSummary struct {
+	Covers int    `json:"covers"`
+	Text   string `json:"text"`
+}
+
+func newHistoryStore() (*historyStore, error) {
+	dataDir, err := defaultDataDir()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(dataDir, "conversations")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &historyStore{dir: dir}, nil
+}
+
+// create starts a new conversation, reserving its ID by creating its file.
+func (s *historyStore) create() (*conversation, error) {
+	stamp := time.Now().Format("20060102-150405")
+	id := stamp
+	// Another conversation may be started within the same second, by gpt
+	// serve for one, so the ID goes to whoever creates the file first.
+	for n := 2; ; n++ {
+		f, err := os.OpenFile(s.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			if err := f.Close(); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		id = fmt.Sprintf("%s-%d", stamp, n)
+	}
+	return &conversation{
+		ID:   id,
+		path: s.path(id),
+	}, nil
+}
+
+// createWith starts a new conversation that already contains messages, e.g.
+// when continuing a saved session.
+func (s *historyStore) createWith(messages []openai.ChatCompletionMessage) (*conversation, error) {
+	c, err := s.create()
+	if err != nil {
+		return nil, err
+	}
+	c.Messages = messages
+
+	entries := make([]historyEntry, 0, len(messages))
+	for _, msg := range messages {
+		entries = append(entries, newHistoryEntry(msg))
+	}
+	if err := writeHistory(c.path, entries); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ids returns the stored conversation IDs, oldest first.
+func (s *historyStore) ids() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, strings.TrimSuffix(filepath.Base(match), ".jsonl"))
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		si, ni := splitID(ids[i])
+		sj, nj := splitID(ids[j])
+		if si != sj {
+			return si < sj
+		}
+		return ni < nj
+	})
+	return ids, nil
+}
+
+// splitID splits a conversation ID into the time it was started and the
+// number create gave it to tell it from others started in the same second,
+// which is 1 for the first.
+func splitID(id string) (string, int) {
+	const stampLen = len("20060102-150405")
+	if len(id) > stampLen+1 && id[stampLen] == '-' {
+		if n, err := strconv.Atoi(id[stampLen+1:]); err == nil {
+			return id[:stampLen], n
+		}
+	}
+	return id, 1
+}
+
+// resolve validates a conversation ID. The special ID "last" refers to the
+// most recent conversation with anything in it, passing over those only
+// reserved by create.
+func (s *historyStore) resolve(id string) (string, error) {
+	if id != "last" {
+		if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+			return "", fmt.Errorf("invalid conversation ID %q", id)
+		}
+		return id, nil
+	}
+
+	ids, err := s.ids()
+	if err != nil {
+		return "", err
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		info, err := os.Stat(s.path(ids[i]))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if err == nil && info.Size() > 0 {
+			return ids[i], nil
+		}
+	}
+	return "", errors.New("no previous conversations")
+}
+
+func (s *historyStore) path(id string) string {
+	return filepath.Join(s.dir, id+".jsonl")
+}
+
+func (s *historyStore) read(id string) (string, []historyEntry, error) {
+	id, err := s.resolve(id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	entries, err := readHistory(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, fmt.Errorf("conversation %q not found", id)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("conversation %q: %w", id, err)
+	}
+	return id, entries, nil
+}
+
+// open loads a stored conversation.
+func (s *historyStore) open(id string) (*conversation, error) {
+	id, entries, err := s.read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conversation{
+		ID:   id,
+		path: s.path(id),
+	}
+	for _, entry := range entries {
+		c.Messages = append(c.Messages, entry.message())
+	}
+
+	data, err := os.ReadFile(summaryPath(c.path))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &c.summary); err != nil {
+			return nil, fmt.Errorf("conversation %q summary: %w", id, err)
+		}
+	}
+	return c, nil
+}
+
+// conversationInfo summarizes a stored conversation.
+type conversationInfo struct {
+	ID       string
+	Title    string
+	Created  time.Time
+	Updated  time.Time
+	Messages int
+	Tokens   int
+}
+
+func (s *historyStore) info(id string) (conversationInfo, error) {
+	id, entries, err := s.read(id)
+	if err != nil {
+		return conversationInfo{}, err
+	}
+	titles, err := s.titles()
+	if err != nil {
+		return conversationInfo{}, err
+	}
+
+	info := conversationInfo{
+		ID:       id,
+		Title:    titles[id],
+		Messages: len(entries),
+	}
+	for i, entry := range entries {
+		if i == 0 {
+			info.Created = entry.Time
+		}
+		info.Updated = entry.Time
+		info.Tokens += countTokens("", entry.Content)
+
+		if info.Title == "" && entry.Role == openai.ChatMessageRoleUser {
+			info.Title = summarizeTitle(entry.Content)
+		}
+	}
+	return info, nil
+}
+
+// list returns a summary of every stored conversation, oldest first.
+func (s *historyStore) list() ([]conversationInfo, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]conversationInfo, 0, len(ids))
+	for _, id := range ids {
+		info, err := s.info(id)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *historyStore) delete(id string) error {
+	id, err := s.resolve(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.path(id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("conversation %q not found", id)
+		}
+		return err
+	}
+	if err := os.Remove(summaryPath(s.path(id))); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	titles, err := s.titles()
+	if err != nil {
+		return err
+	}
+	if _, ok := titles[id]; !ok {
+		return nil
+	}
+	delete(titles, id)
+	return s.saveTitles(titles)
+}
+
+// rename sets the title shown for a conversation in place of its first
+// message.
+func (s *historyStore) rename(id, title string) error {
+	id, err := s.resolve(id)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(s.path(id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("conversation %q not found", id)
+		}
+		return err
+	}
+
+	titles, err := s.titles()
+	if err != nil {
+		return err
+	}
+	titles[id] = title
+	return s.saveTitles(titles)
+}
+
+// titles returns the custom conversation titles, keyed by ID.
+func (s *historyStore) titles() (map[string]string, error) {
+	titles := make(map[string]string)
+
+	data, err := os.ReadFile(filepath.Join(s.dir, "titles.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return titles, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &titles); err != nil {
+		return nil, fmt.Errorf("titles.json: %w", err)
+	}
+	return titles, nil
+}
+
+func (s *historyStore) saveTitles(titles map[string]string) error {
+	data, err := json.MarshalIndent(titles, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, "titles.json"), data, 0o600)
+}
+
+// summarizeTitle derives a title from the first line of a message.
+func summarizeTitle(content string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if runes := []rune(title); len(runes) > 50 {
+		title = string(runes[:49]) + "…"
+	}
+	return title
+}
+
+// append writes a message to the end of the conversation file.
+func (c *conversation) append(msg openai.ChatCompletionMessage) error {
+	f, err := os.OpenFile(c.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(newHistoryEntry(msg))
+}
+
+// discardIfEmpty removes the conversation's file if nothing was ever written
+// to it, as when the chat is left before a message is sent.
+func (c *conversation) discardIfEmpty() error {
+	info, err := os.Stat(c.path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.Size() > 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(c.path)
+}
+
+// truncate drops everything but the first n messages from the conversation
+// file.
+func (c *conversation) truncate(n int) error {
+	entries, err := readHistory(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n >= len(entries) {
+		return nil
+	}
+
+	if c.summary.Covers > n {
+		if err := c.setSummary(conversationSummary{}); err != nil {
+			return err
+		}
+	}
+	return writeHistory(c.path, entries[:n])
+}
+
+func summaryPath(path string) string {
+	return strings.TrimSuffix(path, ".jsonl") + ".summary.json"
+}
+
+// setSummary replaces the conversation's summary. An empty summary removes it.
+func (c *conversation) setSummary(summary conversationSummary) error {
+	c.summary = summary
+
+	path := summaryPath(c.path)
+	if summary.Covers == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func readHistory(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// writeHistory replaces the file at path with entries.
+func writeHistory(path string, entries []historyEntry) error {
+	// A temporary file of its own, so that writers at the same time don't
+	// write over each other's.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	enc := json.NewEncoder(f)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
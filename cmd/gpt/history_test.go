@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHistoryStoreIDs(t *testing.T) {
+	s := &historyStore{dir: t.TempDir()}
+	files := map[string]string{
+		"20240102-150405-10": "{}\n",
+		"20240102-150405-9":  "{}\n",
+		"20240102-150405":    "{}\n",
+		"20240101-090000":    "{}\n",
+		"20240102-150405-11": "",
+	}
+	for id, content := range files {
+		if err := os.WriteFile(filepath.Join(s.dir, id+".jsonl"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := s.ids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"20240101-090000", "20240102-150405", "20240102-150405-9", "20240102-150405-10", "20240102-150405-11"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("ids() = %q, want %q", ids, want)
+	}
+
+	// The newest is only reserved, with nothing written yet.
+	last, err := s.resolve("last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != "20240102-150405-10" {
+		t.Errorf(`resolve("last") = %q, want 20240102-150405-10`, last)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// headerTransport adds fixed headers to every request, e.g. for proxies and
+// gateways in front of the API.
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// mergeHeaders returns headers with extra added, leaving both unchanged.
+func mergeHeaders(headers, extra map[string]string) map[string]string {
+	merged := make(map[string]string, len(headers)+len(extra))
+	for key, value := range headers {
+		merged[key] = value
+	}
+	for key, value := range extra {
+		merged[key] = value
+	}
+	return merged
+}
+
+// httpClient returns the client used to talk to providers.
+func (c config) httpClient() (*http.Client, error) {
+	t, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	var transport http.RoundTripper = t
+	l, err := requestLogFor(c)
+	if err != nil {
+		return nil, err
+	}
+	if l.enabled(logDebug) {
+		// Beneath the retries, so that each attempt is logged.
+		transport = loggingTransport{log: l, base: transport}
+	}
+	if tr := tracerFor(c); tr != nil {
+		transport = tracingTransport{tracer: tr, base: transport}
+	}
+	if len(c.Headers) > 0 {
+		transport = headerTransport{
+			headers: c.Headers,
+			base:    transport,
+		}
+	}
+	if c.Retries > 0 {
+		transport = retryTransport{
+			retries: c.Retries,
+			base:    transport,
+		}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// transport returns the transport beneath the client for providers, with
+// the proxy, connect timeout and CA bundle but nothing added for a provider,
+// for other servers that are reached the same way.
+func (c config) transport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	proxy, err := c.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	t.Proxy = proxy
+	if c.Timeouts.Connect > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   c.Timeouts.Connect,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		t.TLSHandshakeTimeout = c.Timeouts.Connect
+	}
+	if c.CABundle != "" {
+		roots, err := loadCABundle(c.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return t, nil
+}
+
+// proxyFunc returns how requests to providers find their proxy: the proxy
+// setting if there is one, else HTTPS_PROXY, HTTP_PROXY or, as curl has it,
+// ALL_PROXY. Either way hosts in NO_PROXY are reached directly.
+func (c config) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	env := httpproxy.FromEnvironment()
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("proxy: %q is not a URL such as http://host:port or socks5://host:port", c.Proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("proxy: unsupported scheme %q; use http, https or socks5", u.Scheme)
+		}
+		env.HTTPProxy, env.HTTPSProxy = c.Proxy, c.Proxy
+	} else if all := getenvAny("ALL_PROXY", "all_proxy"); all != "" {
+		if env.HTTPProxy == "" {
+			env.HTTPProxy = all
+		}
+		if env.HTTPSProxy == "" {
+			env.HTTPSProxy = all
+		}
+	}
+
+	proxy := env.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// loadCABundle returns the system's certificate authorities along with those
+// in the PEM file at path, for proxies that sign their own certificates.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ca_bundle: %w", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("ca_bundle: no PEM certificates in %s", path)
+	}
+	return roots, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	// chunkLines and chunkChars bound the pieces files are split into.
+	chunkLines = 50
+	chunkChars = 4000
+	// maxIndexedFile is the size of the largest file that is indexed.
+	maxIndexedFile = 256 * 1024
+	// embedBatch is the number of chunks embedded per request.
+	embedBatch = 64
+)
+
+// skippedDirs are directories that hold dependencies or build output rather
+// than code of the project's own.
+var skippedDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+	"dist":         true,
+	"build":        true,
+	"target":       true,
+}
+
+// codeIndex is the embedded code of a directory, built by gpt index for
+// finding the code that is relevant to a question.
+type codeIndex struct {
+	root  string
+	model string
+	store vectorStore
+}
+
+// indexChunk is a run of lines of a file and its embedding.
+type indexChunk struct {
+	// Path is relative to the root of the index.
+	Path   string    `json:"path"`
+	Start  int       `json:"start"`
+	End    int       `json:"end"`
+	Text   string    `json:"text"`
+	Hash   string    `json:"hash"`
+	Vector []float32 `json:"vector"`
+}
+
+// loadIndex opens the index of the directory root. The error wraps
+// os.ErrNotExist if it hasn't been indexed.
+func loadIndex(cfg config, root string) (*codeIndex, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	store, err := openVectorStore(cfg.VectorStore, root, false)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s hasn't been indexed; run gpt index first: %w", root, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := store.model()
+	if err != nil {
+		store.close()
+		return nil, err
+	}
+	return &codeIndex{root: root, model: model, store: store}, nil
+}
+
+// indexStats counts what buildIndex did.
+type indexStats struct {
+	files, chunks, embedded, deleted int
+}
+
+// buildIndex splits the text files under root into chunks and brings the
+// index up to date with them. Chunks that haven't changed keep their
+// embeddings, so re-indexing only pays for what changed, unless the embedding
+// model has changed too.
+func buildIndex(ctx context.Context, cfg config, e embedder, root string) (indexStats, error) {
+	var stats indexStats
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return stats, err
+	}
+
+	var chunks []indexChunk
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(d.Name(), ".") || skippedDirs[d.Name()]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxIndexedFile {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.IndexByte(data, 0) >= 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		stats.files++
+		chunks = append(chunks, chunkFile(filepath.ToSlash(rel), string(data))...)
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	stats.chunks = len(chunks)
+
+	store, err := openVectorStore(cfg.VectorStore, root, true)
+	if err != nil {
+		return stats, err
+	}
+	defer store.close()
+
+	stored, err := store.hashes()
+	if err != nil {
+		return stats, err
+	}
+	if model, err := store.model(); err != nil {
+		return stats, err
+	} else if model != cfg.EmbeddingModel {
+		// Embeddings of different models can't be compared.
+		all := make([]string, 0, len(stored))
+		for hash := range stored {
+			all = append(all, hash)
+		}
+		if err := store.delete(all); err != nil {
+			return stats, err
+		}
+		stored = make(map[string]bool)
+		if err := store.setModel(cfg.EmbeddingModel); err != nil {
+			return stats, err
+		}
+	}
+
+	var pending []indexChunk
+	current := make(map[string]bool, len(chunks))
+	for _, chunk := range chunks {
+		if !stored[chunk.Hash] && !current[chunk.Hash] {
+			pending = append(pending, chunk)
+		}
+		current[chunk.Hash] = true
+	}
+	var gone []string
+	for hash := range stored {
+		if !current[hash] {
+			gone = append(gone, hash)
+		}
+	}
+	if err := store.delete(gone); err != nil {
+		return stats, err
+	}
+	stats.deleted = len(gone)
+
+	for start := 0; start < len(pending); start += embedBatch {
+		batch := pending[start:]
+		if len(batch) > embedBatch {
+			batch = batch[:embedBatch]
+		}
+
+		input := make([]string, len(batch))
+		for i, chunk := range batch {
+			input[i] = chunk.embedText()
+		}
+		vectors, err := e.CreateEmbeddings(ctx, cfg.EmbeddingModel, input)
+		if err != nil {
+			return stats, err
+		}
+		for i := range batch {
+			batch[i].Vector = vectors[i]
+		}
+		// Store each batch as it comes, so that an interrupted run
+		// doesn't have to start over.
+		if err := store.add(batch); err != nil {
+			return stats, err
+		}
+		stats.embedded += len(batch)
+	}
+	return stats, nil
+}
+
+// hashString returns the hex SHA-256 hash of s.
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// chunkFile splits a file into runs of lines of at most chunkLines lines and
+// about chunkChars characters.
+func chunkFile(path, content string) []indexChunk {
+	var (
+		chunks []indexChunk
+		lines  []string
+		size   int
+		start  = 1
+	)
+	flush := func(end int) {
+		text := strings.Join(lines, "\n")
+		if strings.TrimSpace(text) != "" {
+			chunks = append(chunks, indexChunk{
+				Path:  path,
+				Start: start,
+				End:   end,
+				Text:  text,
+				Hash:  hashString(fmt.Sprintf("%s\x00%d\x00%s", path, start, text)),
+			})
+		}
+		lines, size, start = nil, 0, end+1
+	}
+
+	all := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	for i, line := range all {
+		if len(line) > chunkChars {
+			line = line[:chunkChars]
+		}
+		if len(lines) > 0 && (len(lines) == chunkLines || size+len(line) > chunkChars) {
+			flush(i)
+		}
+		lines = append(lines, line)
+		size += len(line) + 1
+	}
+	if len(lines) > 0 {
+		flush(len(all))
+	}
+	return chunks
+}
+
+// embedText is what is embedded for a chunk. The path helps to place it.
+func (c indexChunk) embedText() string {
+	return c.Path + "\n\n" + c.Text
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// retriever adds the code most relevant to the last message to requests.
+type retriever struct {
+	index    *codeIndex
+	embedder embedder
+	// chunks is the number of chunks added to each request.
+	chunks int
+}
+
+// defaultRetrievedChunks is the number of chunks added to each request unless
+// asked otherwise.
+const defaultRetrievedChunks = 8
+
+// augment adds the chunks closest to the last user message to req, as a
+// system message following the system prompt. A nil retriever adds nothing.
+func (r *retriever) augment(ctx context.Context, req *openai.ChatCompletionRequest) error {
+	if r == nil {
+		return nil
+	}
+
+	var query string
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == openai.ChatMessageRoleUser {
+			query = req.Messages[i].Content
+			break
+		}
+	}
+	if query == "" {
+		return nil
+	}
+
+	vectors, err := r.embedder.CreateEmbeddings(ctx, r.index.model, []string{query})
+	if err != nil {
+		return fmt.Errorf("retrieving code: %w", err)
+	}
+	chunks, err := r.index.store.query(vectors[0], r.chunks)
+	if err != nil {
+		return fmt.Errorf("retrieving code: %w", err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Code from %s that may be relevant to the question:", r.index.root)
+	for _, chunk := range chunks {
+		fmt.Fprintf(&b, "\n\n<file path=\"%s\" lines=\"%d-%d\">\n%s\n</file>", chunk.Path, chunk.Start, chunk.End, chunk.Text)
+	}
+	message := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: b.String(),
+	}
+
+	at := 0
+	if len(req.Messages) > 0 && req.Messages[0].Role == openai.ChatMessageRoleSystem {
+		at = 1
+	}
+	messages := append([]openai.ChatCompletionMessage{}, req.Messages[:at]...)
+	messages = append(messages, message)
+	req.Messages = append(messages, req.Messages[at:]...)
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChunkFile(t *testing.T) {
+	numbered := func(n int) string {
+		var b strings.Builder
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+		return b.String()
+	}
+	long := strings.Repeat("x", 1500)
+
+	type span struct{ start, end int }
+	tests := []struct {
+		name    string
+		content string
+		want    []span
+	}{
+		{
+			name:    "empty",
+			content: "",
+		},
+		{
+			name:    "blank",
+			content: "\n  \n\t\n",
+		},
+		{
+			name:    "short",
+			content: "package main\n\nfunc main() {}\n",
+			want:    []span{{1, 3}},
+		},
+		{
+			name:    "exactly chunkLines",
+			content: numbered(chunkLines),
+			want:    []span{{1, chunkLines}},
+		},
+		{
+			name:    "more than chunkLines",
+			content: numbered(2*chunkLines + 1),
+			want:    []span{{1, chunkLines}, {chunkLines + 1, 2 * chunkLines}, {2*chunkLines + 1, 2*chunkLines + 1}},
+		},
+		{
+			name:    "more than chunkChars",
+			content: strings.Repeat(long+"\n", 4),
+			want:    []span{{1, 2}, {3, 4}},
+		},
+		{
+			name:    "line longer than chunkChars",
+			content: "a\n" + strings.Repeat("y", 2*chunkChars) + "\nb\n",
+			want:    []span{{1, 1}, {2, 2}, {3, 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkFile("f.go", tt.content)
+			var got []span
+			for _, c := range chunks {
+				got = append(got, span{c.Start, c.End})
+				if c.Path != "f.go" {
+					t.Errorf("chunk path = %q, want f.go", c.Path)
+				}
+				if len(c.Text) > chunkChars {
+					t.Errorf("chunk of lines %d to %d is %d characters, more than %d", c.Start, c.End, len(c.Text), chunkChars)
+				}
+				if lines := strings.Count(c.Text, "\n") + 1; lines != c.End-c.Start+1 {
+					t.Errorf("chunk of lines %d to %d has %d lines", c.Start, c.End, lines)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("chunks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkFileHash(t *testing.T) {
+	a := chunkFile("a.go", "x\n")
+	if b := chunkFile("a.go", "x\n"); a[0].Hash != b[0].Hash {
+		t.Error("the same chunk hashed differently")
+	}
+	if b := chunkFile("b.go", "x\n"); a[0].Hash == b[0].Hash {
+		t.Error("chunks of different files hashed the same")
+	}
+	if b := chunkFile("a.go", "y\n"); a[0].Hash == b[0].Hash {
+		t.Error("chunks of different text hashed the same")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// inputHistorySize is the number of prompts kept in the input history.
+const inputHistorySize = 1000
+
+// inputHistory is what has been typed into the chat, oldest first. It is kept
+// in a file, one JSON string per line, so that prompts can be recalled in
+// later chats too.
+type inputHistory struct {
+	path    string
+	entries []string
+	// pos is the entry being recalled, or len(entries) if none is.
+	pos int
+}
+
+func loadInputHistory() (*inputHistory, error) {
+	dataDir, err := defaultDataDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	h := &inputHistory{path: filepath.Join(dataDir, "input_history.jsonl")}
+	data, err := os.ReadFile(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var entry string
+		if json.Unmarshal([]byte(line), &entry) == nil && entry != "" {
+			h.entries = append(h.entries, entry)
+		}
+	}
+
+	if len(h.entries) > inputHistorySize {
+		h.entries = h.entries[len(h.entries)-inputHistorySize:]
+		if err := h.rewrite(); err != nil {
+			return nil, err
+		}
+	}
+	h.pos = len(h.entries)
+	return h, nil
+}
+
+// add records input, unless it repeats the last entry, and stops recalling.
+func (h *inputHistory) add(input string) error {
+	h.pos = len(h.entries)
+	if input == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == input) {
+		return nil
+	}
+	h.entries = append(h.entries, input)
+	h.pos = len(h.entries)
+	if h.path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(input)
+}
+
+// rewrite replaces the file with the entries in memory.
+func (h *inputHistory) rewrite() error {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	for _, entry := range h.entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// recalling reports whether input is the entry being recalled, so that the
+// arrows keep moving through the history until it is edited.
+func (h *inputHistory) recalling(input string) bool {
+	return h.pos < len(h.entries) && h.entries[h.pos] == input
+}
+
+// prev steps back to the previous entry, reporting false at the oldest.
+func (h *inputHistory) prev() (string, bool) {
+	if h.pos == 0 {
+		return "", false
+	}
+	h.pos--
+	return h.entries[h.pos], true
+}
+
+// next steps forward to the next entry. Past the newest, the input is empty.
+func (h *inputHistory) next() (string, bool) {
+	if h.pos >= len(h.entries) {
+		return "", false
+	}
+	h.pos++
+	if h.pos == len(h.entries) {
+		return "", true
+	}
+	return h.entries[h.pos], true
+}
+
+// find returns the newest entry before index before that contains query.
+func (h *inputHistory) find(query string, before int) (int, bool) {
+	for i := before - 1; i >= 0; i-- {
+		if strings.Contains(h.entries[i], query) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// recallKey handles the up and down arrows while the input is empty or shows
+// a recalled entry, reporting whether it used the key.
+func (m *model) recallKey(older bool) bool {
+	if m.textarea.Value() != "" && !m.inputs.recalling(m.textarea.Value()) {
+		return false
+	}
+
+	step := m.inputs.next
+	if older {
+		step = m.inputs.prev
+	}
+	if entry, ok := step(); ok {
+		m.textarea.SetValue(entry)
+	}
+	return true
+}
+
+// historySearch is a reverse search through the input history, as with
+// Ctrl+R in a shell.
+type historySearch struct {
+	input textinput.Model
+	// match is the index of the entry found, or -1 if none is.
+	match int
+}
+
+func (m *model) openHistorySearch() {
+	input := textinput.New()
+	input.Prompt = "(reverse-i-search) "
+	input.Focus()
+	m.historySearch = &historySearch{input: input, match: -1}
+}
+
+// updateHistorySearch handles keys during a reverse search. Enter puts the
+// entry found in the input for editing or sending; Esc leaves the input as
+// it was.
+func (m *model) updateHistorySearch(msg tea.KeyMsg) tea.Cmd {
+	s := m.historySearch
+	switch {
+	case msg.String() == "esc" || msg.String() == "ctrl+c":
+		m.historySearch = nil
+		return nil
+	case msg.String() == "enter":
+		m.historySearch = nil
+		if s.match >= 0 {
+			m.textarea.SetValue(m.inputs.entries[s.match])
+			m.inputs.pos = s.match
+		}
+		return nil
+	case key.Matches(msg, m.keys.HistorySearch):
+		// Look further back for the same query.
+		before := len(m.inputs.entries)
+		if s.match >= 0 {
+			before = s.match
+		}
+		if i, ok := m.inputs.find(s.input.Value(), before); ok {
+			s.match = i
+		}
+		return nil
+	}
+
+	query := s.input.Value()
+	var cmd tea.Cmd
+	s.input, cmd = s.input.Update(msg)
+	if s.input.Value() != query {
+		s.match = -1
+		if i, ok := m.inputs.find(s.input.Value(), len(m.inputs.entries)); ok && s.input.Value() != "" {
+			s.match = i
+		}
+	}
+	return cmd
+}
+
+func (m model) historySearchView() string {
+	s := m.historySearch
+	switch {
+	case s.match >= 0:
+		// Show the first line of a multi-line entry.
+		entry, _, _ := strings.Cut(m.inputs.entries[s.match], "\n")
+		return s.input.View() + "  " + entry
+	case s.input.Value() != "":
+		return s.input.View() + "  No match"
+	}
+	return s.input.View()
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// validateJSON checks value, as decoded by encoding/json, against a JSON
+// Schema and returns what is wrong with it. It knows the keywords structured
+// outputs use: type, enum, const, properties, required,
+// additionalProperties, items, the length, size and range limits, pattern,
+// anyOf, oneOf, allOf and local $refs.
+func validateJSON(schema, value any) []string {
+	v := schemaValidator{root: schema, following: make(map[schemaRef]bool)}
+	v.validate(schema, value, "$")
+	return v.problems
+}
+
+type schemaValidator struct {
+	root     any
+	problems []string
+	// following holds the references being followed, and where, so that a
+	// schema referring back to itself without going deeper into the value
+	// is caught rather than followed forever.
+	following map[schemaRef]bool
+}
+
+type schemaRef struct {
+	ref, path string
+}
+
+func (v *schemaValidator) fail(path, format string, args ...any) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) validate(schema, value any, path string) {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		if schema == false {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "%v", err)
+			return
+		}
+		at := schemaRef{ref, path}
+		if v.following[at] {
+			v.fail(path, "%s refers back to itself", ref)
+			return
+		}
+		v.following[at] = true
+		v.validate(target, value, path)
+		delete(v.following, at)
+	}
+
+	if t, ok := s["type"]; ok && !schemaTypeMatches(t, value) {
+		v.fail(path, "expected %s, got %s", describeSchemaType(t), jsonTypeOf(value))
+		return
+	}
+	if enum, ok := s["enum"].([]any); ok && !containsJSON(enum, value) {
+		v.fail(path, "%s is not one of %s", compactJSON(value), compactJSON(enum))
+	}
+	if c, ok := s["const"]; ok && !equalJSON(c, value) {
+		v.fail(path, "expected %s, got %s", compactJSON(c), compactJSON(value))
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		v.validateObject(s, value, path)
+	case []any:
+		if items, ok := s["items"]; ok {
+			for i, item := range value {
+				v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+		if n, ok := s["minItems"].(float64); ok && float64(len(value)) < n {
+			v.fail(path, "has %d items, fewer than %v", len(value), n)
+		}
+		if n, ok := s["maxItems"].(float64); ok && float64(len(value)) > n {
+			v.fail(path, "has %d items, more than %v", len(value), n)
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(value))
+		if n, ok := s["minLength"].(float64); ok && length < n {
+			v.fail(path, "is shorter than %v characters", n)
+		}
+		if n, ok := s["maxLength"].(float64); ok && length > n {
+			v.fail(path, "is longer than %v characters", n)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(value) {
+				v.fail(path, "doesn't match %s", pattern)
+			}
+		}
+	case float64:
+		if n, ok := s["minimum"].(float64); ok && value < n {
+			v.fail(path, "is less than %v", n)
+		}
+		if n, ok := s["maximum"].(float64); ok && value > n {
+			v.fail(path, "is more than %v", n)
+		}
+		if n, ok := s["exclusiveMinimum"].(float64); ok && value <= n {
+			v.fail(path, "isn't more than %v", n)
+		}
+		if n, ok := s["exclusiveMaximum"].(float64); ok && value >= n {
+			v.fail(path, "isn't less than %v", n)
+		}
+	}
+
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			v.validate(sub, value, path)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok && v.matching(anyOf, value, path) == 0 {
+		v.fail(path, "matches none of the allowed schemas")
+	}
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		if n := v.matching(oneOf, value, path); n != 1 {
+			v.fail(path, "matches %d of the schemas instead of exactly one", n)
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(s map[string]any, value map[string]any, path string) {
+	if required, ok := s["required"].([]any); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, ok := value[name]; !ok {
+					v.fail(path, "is missing %q", name)
+				}
+			}
+		}
+	}
+
+	properties, _ := s["properties"].(map[string]any)
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if sub, ok := properties[name]; ok {
+			v.validate(sub, value[name], path+"."+name)
+			continue
+		}
+		switch extra := s["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				v.fail(path, "has %q, which isn't allowed", name)
+			}
+		case map[string]any:
+			v.validate(extra, value[name], path+"."+name)
+		}
+	}
+}
+
+// matching counts the schemas value is valid against.
+func (v *schemaValidator) matching(schemas []any, value any, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		check := schemaValidator{root: v.root, following: v.following}
+		check.validate(sub, value, path)
+		if len(check.problems) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// resolve finds the schema a local reference such as "#/$defs/item" points
+// to.
+func (v *schemaValidator) resolve(ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("only references within the schema are supported, not %s", ref)
+	}
+	node := v.root
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("can't resolve %s", ref)
+		}
+		if node, ok = m[part]; !ok {
+			return nil, fmt.Errorf("can't resolve %s", ref)
+		}
+	}
+	return node, nil
+}
+
+// schemaTypeMatches reports whether value has the type t names, which may be
+// a list of types.
+func schemaTypeMatches(t, value any) bool {
+	switch t := t.(type) {
+	case string:
+		actual := jsonTypeOf(value)
+		return actual == t || (t == "number" && actual == "integer")
+	case []any:
+		for _, one := range t {
+			if schemaTypeMatches(one, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func describeSchemaType(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, len(list))
+		for i, name := range list {
+			names[i] = fmt.Sprint(name)
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+// jsonTypeOf names the JSON type of a decoded value.
+func jsonTypeOf(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func containsJSON(list []any, value any) bool {
+	for _, item := range list {
+		if equalJSON(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalJSON(a, b any) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+func compactJSON(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
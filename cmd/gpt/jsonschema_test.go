@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		want   []string
+	}{
+		{
+			name:   "type",
+			schema: `{"type": "string"}`,
+			value:  `1`,
+			want:   []string{`$: expected string, got integer`},
+		},
+		{
+			name:   "integer is a number",
+			schema: `{"type": "number"}`,
+			value:  `1`,
+		},
+		{
+			name:   "list of types",
+			schema: `{"type": ["string", "null"]}`,
+			value:  `null`,
+		},
+		{
+			name:   "required",
+			schema: `{"type": "object", "required": ["name", "age"], "properties": {"name": {"type": "string"}}}`,
+			value:  `{"name": "Ada"}`,
+			want:   []string{`$: is missing "age"`},
+		},
+		{
+			name:   "property of the wrong type",
+			schema: `{"type": "object", "properties": {"tags": {"type": "array", "items": {"type": "string"}}}}`,
+			value:  `{"tags": ["a", 2]}`,
+			want:   []string{`$.tags[1]: expected string, got integer`},
+		},
+		{
+			name:   "additional properties",
+			schema: `{"type": "object", "properties": {"a": {}}, "additionalProperties": false}`,
+			value:  `{"a": 1, "b": 2}`,
+			want:   []string{`$: has "b", which isn't allowed`},
+		},
+		{
+			name:   "enum",
+			schema: `{"enum": ["red", "green"]}`,
+			value:  `"blue"`,
+			want:   []string{`$: "blue" is not one of ["red","green"]`},
+		},
+		{
+			name:   "enum match",
+			schema: `{"enum": ["red", "green"]}`,
+			value:  `"green"`,
+		},
+		{
+			name:   "anyOf",
+			schema: `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`,
+			value:  `true`,
+			want:   []string{`$: matches none of the allowed schemas`},
+		},
+		{
+			name:   "anyOf match",
+			schema: `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`,
+			value:  `3`,
+		},
+		{
+			name:   "oneOf matching two",
+			schema: `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`,
+			value:  `3`,
+			want:   []string{`$: matches 2 of the schemas instead of exactly one`},
+		},
+		{
+			name:   "oneOf matching one",
+			schema: `{"oneOf": [{"type": "number"}, {"type": "string"}]}`,
+			value:  `3.5`,
+		},
+		{
+			name:   "allOf",
+			schema: `{"allOf": [{"minimum": 1}, {"maximum": 5}]}`,
+			value:  `7`,
+			want:   []string{`$: is more than 5`},
+		},
+		{
+			name:   "ref",
+			schema: `{"$defs": {"id": {"type": "integer"}}, "type": "object", "properties": {"id": {"$ref": "#/$defs/id"}}}`,
+			value:  `{"id": "x"}`,
+			want:   []string{`$.id: expected integer, got string`},
+		},
+		{
+			name:   "unresolved ref",
+			schema: `{"$ref": "#/$defs/missing"}`,
+			value:  `1`,
+			want:   []string{`$: can't resolve #/$defs/missing`},
+		},
+		{
+			name:   "recursive ref",
+			schema: `{"type": "object", "properties": {"name": {"type": "string"}, "children": {"type": "array", "items": {"$ref": "#"}}}}`,
+			value:  `{"name": "a", "children": [{"name": "b", "children": [{"name": 3}]}]}`,
+			want:   []string{`$.children[0].children[0].name: expected string, got integer`},
+		},
+		{
+			name:   "ref to itself",
+			schema: `{"$ref": "#"}`,
+			value:  `1`,
+			want:   []string{`$: # refers back to itself`},
+		},
+		{
+			name:   "refs to each other",
+			schema: `{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`,
+			value:  `1`,
+			want:   []string{`$: #/$defs/a refers back to itself`},
+		},
+		{
+			name:   "ref to itself through anyOf",
+			schema: `{"anyOf": [{"$ref": "#"}]}`,
+			value:  `1`,
+			want:   []string{`$: matches none of the allowed schemas`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateJSON(decodeJSON(t, tt.schema), decodeJSON(t, tt.value))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateJSON() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func decodeJSON(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
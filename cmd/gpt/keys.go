@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+)
+
+// keyMap holds the bindings of the chat's actions. Each can be rebound under
+// keys in the config file.
+type keyMap struct {
+	Send          key.Binding
+	Newline       key.Binding
+	Cancel        key.Binding
+	Retry         key.Binding
+	Complete      key.Binding
+	Copy          key.Binding
+	Editor        key.Binding
+	HistoryPrev   key.Binding
+	HistoryNext   key.Binding
+	HistorySearch key.Binding
+	ScrollUp      key.Binding
+	ScrollDown    key.Binding
+	HalfUp        key.Binding
+	HalfDown      key.Binding
+	Top           key.Binding
+	Bottom        key.Binding
+	Palette       key.Binding
+	Search        key.Binding
+	Help          key.Binding
+	Quit          key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		Send:          key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "send")),
+		Newline:       key.NewBinding(key.WithKeys("alt+enter", "ctrl+j"), key.WithHelp("alt+enter", "start a new line")),
+		Cancel:        key.NewBinding(key.WithKeys("esc", "ctrl+x"), key.WithHelp("esc", "stop the reply")),
+		Retry:         key.NewBinding(key.WithKeys("ctrl+g"), key.WithHelp("ctrl+g", "retry")),
+		Complete:      key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "complete a command")),
+		Copy:          key.NewBinding(key.WithKeys("ctrl+y"), key.WithHelp("ctrl+y", "copy the last reply")),
+		Editor:        key.NewBinding(key.WithKeys("ctrl+e"), key.WithHelp("ctrl+e", "write the message in $EDITOR")),
+		HistoryPrev:   key.NewBinding(key.WithKeys("up"), key.WithHelp("up", "recall the previous input")),
+		HistoryNext:   key.NewBinding(key.WithKeys("down"), key.WithHelp("down", "recall the next input")),
+		HistorySearch: key.NewBinding(key.WithKeys("ctrl+r"), key.WithHelp("ctrl+r", "search earlier input")),
+		ScrollUp:      key.NewBinding(key.WithKeys("pgup"), key.WithHelp("pgup", "scroll up a page")),
+		ScrollDown:    key.NewBinding(key.WithKeys("pgdown"), key.WithHelp("pgdown", "scroll down a page")),
+		HalfUp:        key.NewBinding(key.WithKeys("ctrl+u"), key.WithHelp("ctrl+u", "scroll up half a page")),
+		HalfDown:      key.NewBinding(key.WithKeys("ctrl+d"), key.WithHelp("ctrl+d", "scroll down half a page")),
+		Top:           key.NewBinding(key.WithKeys("home"), key.WithHelp("home", "scroll to the top")),
+		Bottom:        key.NewBinding(key.WithKeys("end"), key.WithHelp("end", "scroll to the bottom and follow the reply")),
+		Palette:       key.NewBinding(key.WithKeys("ctrl+k"), key.WithHelp("ctrl+k", "open the command palette")),
+		Search:        key.NewBinding(key.WithKeys("ctrl+f"), key.WithHelp("ctrl+f", "search the conversation")),
+		Help:          key.NewBinding(key.WithKeys("?", "ctrl+_"), key.WithHelp("?", "show this help")),
+		Quit:          key.NewBinding(key.WithKeys("ctrl+c"), key.WithHelp("ctrl+c", "quit")),
+	}
+}
+
+// namedBinding is a binding with the name used for it in the config file.
+type namedBinding struct {
+	name    string
+	binding *key.Binding
+}
+
+// bindings returns every binding, in the order they are listed in the help.
+func (k *keyMap) bindings() []namedBinding {
+	return []namedBinding{
+		{"send", &k.Send},
+		{"newline", &k.Newline},
+		{"cancel", &k.Cancel},
+		{"retry", &k.Retry},
+		{"complete", &k.Complete},
+		{"copy", &k.Copy},
+		{"editor", &k.Editor},
+		{"history_prev", &k.HistoryPrev},
+		{"history_next", &k.HistoryNext},
+		{"history_search", &k.HistorySearch},
+		{"scroll_up", &k.ScrollUp},
+		{"scroll_down", &k.ScrollDown},
+		{"half_page_up", &k.HalfUp},
+		{"half_page_down", &k.HalfDown},
+		{"top", &k.Top},
+		{"bottom", &k.Bottom},
+		{"palette", &k.Palette},
+		{"search", &k.Search},
+		{"help", &k.Help},
+		{"quit", &k.Quit},
+	}
+}
+
+// newKeyMap applies the keys config setting, which maps action names to
+// lists of keys, to the default bindings.
+func newKeyMap(keys map[string][]string) (keyMap, error) {
+	k := defaultKeyMap()
+	bindings := make(map[string]*key.Binding)
+	var names []string
+	for _, b := range k.bindings() {
+		bindings[b.name] = b.binding
+		names = append(names, b.name)
+	}
+	for action, keys := range keys {
+		binding, ok := bindings[action]
+		if !ok {
+			return k, fmt.Errorf("keys: unknown action %q; expected one of %s", action, strings.Join(names, ", "))
+		}
+		if len(keys) == 0 {
+			binding.SetEnabled(false)
+			continue
+		}
+		binding.SetKeys(keys...)
+		binding.SetHelp(strings.Join(keys, "/"), binding.Help().Desc)
+	}
+	return k, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/charmbracelet/lipgloss"
+)
+
+// minInputHeight is the height of the input when it is empty.
+const minInputHeight = 3
+
+// layout sizes the viewport and textarea to the terminal. The input grows
+// with its lines up to a third of the terminal, the viewport takes whatever
+// height the input, the message line and the status bar leave, and the
+// conversation is re-wrapped when the width changes.
+func (m *model) layout() {
+	if m.width == 0 {
+		return
+	}
+
+	inputHeight := m.textarea.LineCount()
+	if limit := m.height / 3; inputHeight > limit {
+		inputHeight = limit
+	}
+	if inputHeight < minInputHeight {
+		inputHeight = minInputHeight
+	}
+	if inputHeight != m.textarea.Height() {
+		m.textarea.SetHeight(inputHeight)
+	}
+
+	if m.viewport.Width != m.width {
+		m.textarea.SetWidth(m.width)
+		m.viewport.Width = m.width
+		m.renderer.setWidth(m.width)
+		m.refreshViewport()
+	}
+
+	// One blank line separates each part, and the view ends with a newline.
+	height := m.height - m.textarea.Height() - lipgloss.Height(m.statusBar()) - 3
+	if message := m.messageLine(); message != "" {
+		height -= lipgloss.Height(message) + 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	if height != m.viewport.Height {
+		atBottom := m.viewport.AtBottom()
+		m.viewport.Height = height
+		if atBottom {
+			m.viewport.GotoBottom()
+		}
+	}
+}
+
+// messageLine returns the question, search, error or notice to show under
+// the input, if any.
+func (m model) messageLine() string {
+	if m.confirm != nil {
+		return m.confirmView()
+	}
+	if m.historySearch != nil {
+		return m.historySearchView()
+	}
+	if m.search != nil {
+		return m.search.view()
+	}
+	if m.err != nil {
+		return m.styles.err.Width(m.width).Render(m.err.Error())
+	}
+	if m.notice != "" {
+		return m.styles.notice.Width(m.width).Render(m.notice)
+	}
+	return ""
+}
@@ -2,15 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"path"
-	"runtime"
 	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
@@ -19,18 +21,321 @@ import (
 )
 
 func main() {
-	p := tea.NewProgram(initialModel())
+	globals.register(flag.CommandLine)
+	chat := chatFlags{
+		resume:       flag.String("resume", "", "reopen a past conversation by ID, or \"last\" for the most recent one"),
+		sessionName:  flag.String("session", "", "load the named session if it exists and keep it saved as the chat goes on"),
+		force:        flag.Bool("force", false, "send requests even once the spending budget is used up"),
+		personaName:  flag.String("persona", "", "chat as one of the personas in the config file"),
+		templateName: flag.String("t", "", "send the named prompt template; arguments are files or VAR=VALUE"),
+		indexDir:     flag.String("index", "", "send the code most relevant to each message from this directory, indexed by gpt index"),
+		capture:      flag.Bool("capture-pane", false, "send the scrollback of the tmux pane gpt runs in with the first message"),
+		jsonOutput:   flag.Bool("json", false, "ask for a JSON reply and print only that; needs a prompt"),
+		schemaPath:   flag.String("schema", "", "ask for a JSON reply matching the JSON Schema in this file, and print only that; needs a prompt"),
+		quiet:        new(bool),
+		outputFile:   flag.String("output-file", "", "write each reply to this file as it arrives, as well as showing it"),
+		appendOutput: flag.Bool("append", false, "add to the -output-file instead of replacing it"),
+		output:       flag.String("output", "text", "how to print the reply to a prompt: text, or json for the reply with its model, usage and timings"),
+		dryRun:       flag.Bool("dry-run", false, "print the request a prompt would be sent as, as JSON, without sending it"),
+		sampling:     samplingFlags(flag.CommandLine),
+	}
+	showVersion := flag.Bool("version", false, "print the version of gpt and exit")
+	flag.BoolVar(chat.quiet, "quiet", false, "print only the text of the reply to a prompt, without notices or a final newline")
+	flag.BoolVar(chat.quiet, "raw", false, "the same as -quiet")
+	flag.Usage = usage
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(currentBuild())
+		return
+	}
 
-	if _, err := p.Run(); err != nil {
+	args := flag.Args()
+	if flag.Arg(0) == "chat" {
+		flag.CommandLine.Parse(args[1:])
+		args = flag.Args()
+	} else if cmd, ok := findSubcommand(flag.Arg(0)); ok {
+		flag.Visit(func(f *flag.Flag) {
+			if !globals.isGlobal(f.Name) {
+				log.Fatalf("-%s is for the chat; give gpt %s's own flags after its name", f.Name, cmd.name)
+			}
+		})
+		err := cmd.run(args[1:])
+		flushTraces()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	err := runChat(chat, args)
+	flushTraces()
+	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+// chatFlags are the flags of the chat, besides the global ones.
+type chatFlags struct {
+	resume       *string
+	sessionName  *string
+	force        *bool
+	personaName  *string
+	templateName *string
+	indexDir     *string
+	capture      *bool
+	jsonOutput   *bool
+	schemaPath   *string
+	quiet        *bool
+	outputFile   *string
+	appendOutput *bool
+	output       *string
+	dryRun       *bool
+	sampling     map[string]string
+}
+
+// runChat chats in the terminal, or answers the prompt given by args.
+func runChat(f chatFlags, args []string) error {
+	if *f.resume != "" && *f.sessionName != "" {
+		return errors.New("-resume and -session can't be used together")
+	}
+	out, err := parseOutputFormat(*f.output)
+	if err != nil {
+		return err
+	}
+	out.quiet = *f.quiet
+	if *f.outputFile != "" {
+		if out.file, err = openResponseFile(*f.outputFile, *f.appendOutput); err != nil {
+			return err
+		}
+		defer out.file.close()
+	}
+	if out.json && (*f.jsonOutput || *f.schemaPath != "") {
+		return errors.New("-output json can't be used with -json or -schema, which print JSON of their own")
+	}
+	var schema json.RawMessage
+	if *f.schemaPath != "" {
+		var err error
+		if schema, err = loadSchema(*f.schemaPath); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := loadConfigFor(globals.profile)
+	if err != nil {
+		return err
+	}
+
+	store, err := newHistoryStore()
+	if err != nil {
+		return err
+	}
+	sessions, err := newSessionStore()
+	if err != nil {
+		return err
+	}
+	inputs, err := loadInputHistory()
+	if err != nil {
+		return err
+	}
+
+	var conv *conversation
+	if *f.resume != "" {
+		conv, err = store.open(*f.resume)
+		if err != nil {
+			return err
+		}
+	}
+	if *f.sessionName != "" {
+		sess, err := sessions.load(*f.sessionName)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return err
+		default:
+			cfg = sess.apply(cfg)
+			conv, err = store.createWith(sess.Messages)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if conv == nil {
+		if conv, err = store.create(); err != nil {
+			return err
+		}
+		// A new conversation in which nothing was said, as with -dry-run
+		// or after an error, isn't kept.
+		defer conv.discardIfEmpty()
+	}
+
+	if *f.personaName != "" {
+		cfg, err = applyPersona(cfg, *f.personaName)
+		if err != nil {
+			return err
+		}
+	}
+	cfg = globals.apply(cfg)
+	if err := applySampling(&cfg, f.sampling); err != nil {
+		return err
+	}
+	cfg.fillDefaults()
+
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+
+	spending, err := newBudget(cfg, *f.force)
+	if err != nil {
+		return err
+	}
+
+	var code *retriever
+	if *f.indexDir != "" {
+		code, err = newRetriever(cfg, prov, *f.indexDir, defaultRetrievedChunks)
+		if err != nil {
+			return err
+		}
+	}
+
+	tools, err := newToolRegistry(cfg)
+	if err != nil {
+		return err
+	}
+	defer tools.close()
+
+	stdin, err := readStdin()
+	if err != nil {
+		return err
+	}
+
+	var pane string
+	if *f.capture {
+		text, err := capturePane("", defaultCaptureLines, false)
+		if err != nil {
+			return err
+		}
+		pane = paneAttachment("", text)
+	}
+
+	var prompt string
+	if *f.templateName != "" {
+		var stop []string
+		prompt, stop, err = templatePrompt(*f.templateName, args, stdin)
+		if err != nil {
+			return err
+		}
+		if len(stop) > 0 {
+			cfg.Stop = stop
+		}
+	} else if text := strings.Join(args, " "); text != "" {
+		prompt = withContext(text, stdin)
+	}
+	if prompt != "" {
+		structured := *f.jsonOutput || *f.schemaPath != ""
+		if *f.dryRun {
+			return runDryRun(cfg, prov, conv, prompt+pane, code, tools, structured, schema)
+		}
+		if structured {
+			err = runStructured(cfg, prov, spending, conv, prompt+pane, schema, out)
+		} else {
+			err = runOneShot(cfg, prov, spending, conv, prompt+pane, code, tools, out)
+		}
+		return err
+	}
+	if *f.jsonOutput || *f.schemaPath != "" {
+		return errors.New("-json and -schema need a prompt")
+	}
+	if *f.dryRun {
+		return errors.New("-dry-run needs a prompt")
+	}
+	if out.json || out.quiet {
+		return errors.New("-output json and -quiet need a prompt")
+	}
+
+	var opts []tea.ProgramOption
+	if stdin != "" {
+		// Stdin has been consumed, so read keys from the terminal instead.
+		opts = append(opts, tea.WithInputTTY())
+	}
+
+	m, err := newChatModel(cfg, prov, conv, stdin)
+	if err != nil {
+		return err
+	}
+	m.retriever = code
+	m.tools = tools
+	m.budget = spending
+	m.store = store
+	m.sessions = sessions
+	m.inputs = inputs
+	m.session = *f.sessionName
+	m.outputFile = out.file
+	m.persona = *f.personaName
+	m.sampling = f.sampling
+	if pane != "" {
+		m.pages = pane
+		m.notice = "The scrollback of this pane will be sent with your message"
+	}
+
+	p := tea.NewProgram(m, opts...)
+	final, err := p.Run()
+	if m, ok := final.(model); ok {
+		// The chat may have moved on to another conversation with /clear.
+		m.conversation.discardIfEmpty()
+	}
+	return err
+}
+
 type deltaMsg string
 
-func waitForDelta(msg chan string) tea.Cmd {
+// retryMsg reports that a failed request will be tried again.
+type retryMsg string
+
+// queueMsg is a request's place in the rate limit queue, or 0 once it has
+// been sent.
+type queueMsg int
+
+// toolCallsMsg reports that the reply is calling tools.
+type toolCallsMsg []openai.ToolCall
+
+// toolResultsMsg carries the results of the tools the reply called, after
+// which it goes on.
+type toolResultsMsg struct {
+	reply   openai.ChatCompletionMessage
+	results []openai.ChatCompletionMessage
+}
+
+type streamDoneMsg struct {
+	// dropped is the number of old messages left out or summarized to fit
+	// the context window.
+	dropped int
+	model   string
+	usage   openai.Usage
+	// summaryModel and summaryUsage are of the request for a summary made
+	// to fit the context window, if one was.
+	summaryModel string
+	summaryUsage openai.Usage
+	// fingerprint is the reply's system_fingerprint, if the provider
+	// reported one.
+	fingerprint string
+	finish      openai.FinishReason
+	elapsed     time.Duration
+	err         error
+}
+
+func waitForDelta(sub chan tea.Msg) tea.Cmd {
 	return func() tea.Msg {
-		return deltaMsg(<-msg)
+		return <-sub
+	}
+}
+
+// emit sends msg to the chat from a request, unless the chat has ended and
+// nothing is left to take it.
+func (m model) emit(msg tea.Msg) {
+	select {
+	case m.deltaMessage <- msg:
+	case <-m.ctx.Done():
 	}
 }
 
@@ -38,75 +343,192 @@ type (
 	errMsg error
 )
 
+// knownModels are offered by /model. Any other name is passed through as is.
+var knownModels = []string{
+	"gpt-4o",
+	"gpt-4o-mini",
+	"gpt-4-turbo",
+	"o3-mini",
+	openai.GPT3Dot5Turbo,
+}
+
 type model struct {
 	goos  string
 	shell string
 
-	client *openai.Client
+	config       config
+	provider     provider
+	retriever    *retriever
+	tools        *toolRegistry
+	budget       *budget
+	store        *historyStore
+	conversation *conversation
+	sessions     *sessionStore
+	inputs       *inputHistory
+
+	// session is the name the conversation is saved under, if any.
+	session string
+	// persona is the name of the persona in use, if any.
+	persona string
+	// sampling holds the sampling parameters given on the command line,
+	// which outlast a change of profile.
+	sampling map[string]string
 
 	width  int
 	height int
 
 	viewport viewport.Model
 	textarea textarea.Model
+	keys     keyMap
+	vim      vimState
+	styles   styles
+	renderer *messageRenderer
+	tokens   *tokenCounter
+	costs    *costTracker
 	err      error
+	notice   string
+
+	deltaMessage chan tea.Msg
+	messages     []openai.ChatCompletionMessage
+	streaming    bool
+	cancel       context.CancelFunc
+	// ctx is the life of the chat. Requests are made within it, and are
+	// given up when it ends, as when an SSH connection drops.
+	ctx context.Context
 
-	inputMessage chan string
-	deltaMessage chan string
-	messages     []string
+	// attachment is piped input waiting to be sent with the next message.
+	attachment string
+	// pages are web pages fetched by /fetch, and panes captured by
+	// /capture-pane, for the next message.
+	pages string
+	// fetching is whether pages are being fetched.
+	fetching bool
+	// template is being filled in, if any.
+	template *templateFill
+	// showHelp shows the key bindings in place of the conversation.
+	showHelp bool
+	// palette is the open command palette, if any.
+	palette *palette
+	// search is the search of the conversation in progress, if any.
+	search *search
+	// historySearch is the reverse search of the input history in
+	// progress, if any.
+	historySearch *historySearch
+	// confirm is the question a tool is waiting on, if any.
+	confirm *confirmMsg
+	// toolOutput is the output of the tool that is running.
+	toolOutput string
+	// chooser holds the replies asked for by /candidates, if any.
+	chooser *chooser
+	// scratch holds the replies left out of the conversation.
+	scratch []string
+	// truncated is whether the last reply was cut off at max_tokens.
+	truncated bool
+	// retrying is whether the notice is of a retry, to be cleared once the
+	// reply arrives.
+	retrying bool
+	// queued is the place of the request in the rate limit queue, if it is
+	// being held back.
+	queued int
+	// details are shown under the replies they describe, keyed by their
+	// index in messages.
+	details map[int]replyDetails
+	// outputFile receives every reply as it streams, from -output-file.
+	outputFile *responseFile
+	// saveTo receives the rest of the reply streaming when /save-response
+	// was given, and is closed once it is done.
+	saveTo *responseFile
+	// terminal is where the chat is shown when it isn't this process's
+	// own terminal, as when it is served over SSH.
+	terminal io.Writer
+	// room is the chat room the conversation is shared in, if any, and
+	// member is who is chatting in it.
+	room   *room
+	member *roomMember
+	// roomTurn is the member of the room whose message is being answered,
+	// and roomMembers everyone in it, as last heard from the room.
+	roomTurn    string
+	roomMembers []string
 }
 
-func initialModel() model {
-	shell := os.Getenv("SHELL")
-	if shell == "" {
-		shell = "sh"
-	} else {
-		shell = path.Base(shell)
+// newChatModel returns the chat with the configured keys and theme.
+func newChatModel(cfg config, prov provider, conv *conversation, attachment string) (model, error) {
+	keys, err := newKeyMap(cfg.Keys)
+	if err != nil {
+		return model{}, err
 	}
+	t, err := resolveTheme(cfg)
+	if err != nil {
+		return model{}, err
+	}
+
+	m := initialModel(cfg, t, conv, attachment)
+	m.keys = keys
+	m.textarea.KeyMap.InsertNewline = keys.Newline
+	m.provider = prov
+	return m, nil
+}
 
-	client := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+func initialModel(cfg config, t theme, conv *conversation, attachment string) model {
+	env := detectPromptData()
 
 	ta := textarea.New()
 	ta.Placeholder = "Type here"
 	ta.Focus()
 
 	ta.Prompt = "┃ "
-	ta.CharLimit = 280
+	ta.CharLimit = cfg.InputLimit
 
-	ta.SetWidth(300)
-	ta.SetHeight(3)
+	ta.SetHeight(minInputHeight)
 
 	// Remove cursor line styling
 	ta.FocusedStyle.CursorLine = lipgloss.NewStyle()
 
 	ta.ShowLineNumbers = false
 
-	ta.KeyMap.InsertNewline.SetEnabled(false)
-
-	vp := viewport.New(100, 5)
-	vp.SetContent(`Welcome to the chat room!
-Type a message and press Enter to send.`)
+	// Enter sends, so new lines need a key of their own.
+	keys := defaultKeyMap()
+	ta.KeyMap.InsertNewline = keys.Newline
+	// The real size is set by layout once the terminal size is known.
+	vp := viewport.New(80, 5)
+	// Scrolling is done by Update so that the keys don't reach the input too.
+	vp.KeyMap = viewport.KeyMap{}
 
-	return model{
-		goos:  runtime.GOOS,
-		shell: shell,
+	m := model{
+		goos:  env.GOOS,
+		shell: env.Shell,
 
-		client: client,
+		config:       cfg,
+		conversation: conv,
+		inputs:       &inputHistory{},
 
 		textarea: ta,
 		viewport: vp,
+		keys:     keys,
+		vim:      vimState{enabled: cfg.Vim},
+		styles:   newStyles(t),
+		renderer: newMessageRenderer(vp.Width, t),
+		tokens:   newTokenCounter(cfg.Model),
+		costs:    newCostTracker(),
 		err:      nil,
 
-		inputMessage: make(chan string),
-		deltaMessage: make(chan string),
-		messages:     []string{},
+		deltaMessage: make(chan tea.Msg),
+		messages:     conv.Messages,
+		ctx:          context.Background(),
+		details:      make(map[int]replyDetails),
+
+		attachment: attachment,
+	}
+	if attachment != "" {
+		m.notice = fmt.Sprintf("%d bytes from stdin will be sent with your message", len(attachment))
 	}
+	m.refreshViewport()
+	return m
 }
 
 func (m model) Init() tea.Cmd {
 	return tea.Batch(
 		textarea.Blink,
-		m.createChatCompletion(),
 		waitForDelta(m.deltaMessage),
 	)
 }
@@ -121,32 +543,286 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
-			fmt.Println(m.textarea.Value())
+		if m.palette != nil {
+			cmd := m.updatePalette(msg)
+			m.layout()
+			return m, cmd
+		}
+		if m.historySearch != nil {
+			cmd := m.updateHistorySearch(msg)
+			m.layout()
+			return m, cmd
+		}
+		if m.search != nil {
+			if ok, cmd := m.updateSearch(msg); ok {
+				m.layout()
+				return m, cmd
+			}
+		}
+		if m.chooser != nil && m.updateChooser(msg) {
+			m.layout()
+			return m, nil
+		}
+		if m.confirm != nil {
+			if ok, cmd := m.updateConfirm(msg); ok {
+				m.layout()
+				return m, cmd
+			}
+		}
+		if m.showHelp {
+			m.showHelp = false
+			if !key.Matches(msg, m.keys.Quit) {
+				return m, nil
+			}
+		}
+		if msg.Paste {
+			m.paste(string(msg.Runes))
+			m.layout()
+			return m, nil
+		}
+		if m.vim.enabled && m.vim.mode == vimNormal {
+			if ok, cmd := m.vimNormalKey(msg); ok {
+				m.layout()
+				return m, cmd
+			}
+		}
+
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			if m.terminal == nil {
+				fmt.Println(m.textarea.Value())
+			}
 			return m, tea.Quit
-		case tea.KeyEnter:
-			message := m.textarea.Value()
-			m.messages = append(m.messages, lipgloss.NewStyle().Foreground(lipgloss.Color("5")).Render("You: ")+message)
-			m.messages = append(m.messages, lipgloss.NewStyle().Foreground(lipgloss.Color("5")).Render("System: "))
-			m.viewport.SetContent(strings.Join(m.messages, "\n"))
+		case key.Matches(msg, m.keys.Cancel):
+			if m.cancel != nil {
+				m.cancel()
+			}
+			if m.template != nil {
+				m.template = nil
+				m.notice = "Template cancelled"
+			}
+			if m.vim.enabled {
+				m.vim.mode = vimNormal
+			}
+		case key.Matches(msg, m.keys.Send):
+			if m.streaming || m.fetching || m.chooser != nil || strings.TrimSpace(m.textarea.Value()) == "" {
+				break
+			}
+			if m.template != nil {
+				cmds = append(cmds, m.fillTemplate(m.textarea.Value()))
+				m.textarea.Reset()
+				break
+			}
+
+			input := m.textarea.Value()
+			if holder := m.room.holder(m.member); holder != "" && !strings.HasPrefix(input, "/") {
+				// Kept in the input to send once the reply has arrived.
+				m.err = fmt.Errorf("%s is waiting for a reply; send yours once it has arrived", holder)
+				break
+			}
+			if cmd, ok := m.runCommand(input); ok {
+				cmds = append(cmds, cmd)
+			} else if urls := findURLs(input); m.config.FetchURLs && len(urls) > 0 {
+				cmds = append(cmds, m.fetchPages(input, urls))
+			} else {
+				cmd, err := m.sendInput(input, "")
+				if err != nil {
+					m.err = err
+					break
+				}
+				cmds = append(cmds, cmd)
+			}
 			m.textarea.Reset()
+			if err := m.inputs.add(input); err != nil {
+				m.err = err
+			}
+		case key.Matches(msg, m.keys.ScrollUp):
+			m.viewport.ViewUp()
+			return m, nil
+		case key.Matches(msg, m.keys.ScrollDown):
+			m.viewport.ViewDown()
+			return m, nil
+		case key.Matches(msg, m.keys.HalfUp):
+			m.viewport.HalfViewUp()
+			return m, nil
+		case key.Matches(msg, m.keys.HalfDown):
+			m.viewport.HalfViewDown()
+			return m, nil
+		case key.Matches(msg, m.keys.Top):
+			m.viewport.GotoTop()
+			return m, nil
+		case key.Matches(msg, m.keys.Bottom):
 			m.viewport.GotoBottom()
-
-			m.inputMessage <- message
+			return m, nil
+		case key.Matches(msg, m.keys.HistoryPrev) && m.recallKey(true):
+			return m, nil
+		case key.Matches(msg, m.keys.HistoryNext) && m.recallKey(false):
+			return m, nil
+		case key.Matches(msg, m.keys.HistorySearch):
+			m.openHistorySearch()
+			m.layout()
+			return m, nil
+		case key.Matches(msg, m.keys.Palette):
+			m.palette = newPalette(m.paletteActions())
+			return m, nil
+		case key.Matches(msg, m.keys.Search):
+			m.openSearch()
+			m.layout()
+			return m, nil
+		case key.Matches(msg, m.keys.Help) && (msg.String() != "?" || m.textarea.Value() == ""):
+			// A question mark is only taken for help when it isn't typed as
+			// part of a message.
+			m.showHelp = true
+			return m, nil
+		case key.Matches(msg, m.keys.Retry):
+			if !m.streaming && !m.roomless("/retry") {
+				cmds = append(cmds, m.retry(nil))
+			}
+		case key.Matches(msg, m.keys.Copy):
+			m.copyLastReply()
+		case key.Matches(msg, m.keys.Editor):
+			m.err = nil
+			return m, m.openEditor()
+		case key.Matches(msg, m.keys.Complete):
+			if m.completeCommand() || m.completeFile() {
+				return m, nil
+			}
 		}
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
-
-		m.textarea.SetWidth(m.width)
-
-		// TODO: Sync viewport width
 	case deltaMsg:
-		m.messages[len(m.messages)-1] += string(msg)
-		m.viewport.SetContent(strings.Join(m.messages, "\n"))
-		m.viewport.GotoBottom()
+		if m.retrying {
+			m.notice = ""
+			m.retrying = false
+		}
+		m.messages[len(m.messages)-1].Content += string(msg)
+		for _, r := range []*responseFile{m.outputFile, m.saveTo} {
+			if err := r.write(string(msg)); err != nil {
+				m.err = err
+			}
+		}
+		m.shareRoom(false)
+		m.refreshViewport()
+		cmds = append(cmds, waitForDelta(m.deltaMessage))
+	case toolCallsMsg:
+		m.notice = m.tools.status(msg)
+		cmds = append(cmds, waitForDelta(m.deltaMessage))
+	case queueMsg:
+		m.queued = int(msg)
+		cmds = append(cmds, waitForDelta(m.deltaMessage))
+	case retryMsg:
+		m.notice = string(msg)
+		m.retrying = true
+		cmds = append(cmds, waitForDelta(m.deltaMessage))
+	case confirmMsg:
+		m.confirm = &msg
+		cmds = append(cmds, waitForDelta(m.deltaMessage))
+	case toolOutputMsg:
+		m.toolOutput += string(msg)
+		m.refreshViewport()
+		cmds = append(cmds, waitForDelta(m.deltaMessage))
+	case toolResultsMsg:
+		m.notice = ""
+		m.toolOutput = ""
+		last := len(m.messages) - 1
+		m.messages[last] = msg.reply
+		m.messages = append(m.messages, msg.results...)
+		if err := m.saveMessages(m.messages[last:]...); err != nil {
+			m.err = err
+		}
+		// The reply goes on in a new message.
+		m.messages = append(m.messages, openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleAssistant,
+		})
+		m.shareRoom(false)
+		m.refreshViewport()
+		cmds = append(cmds, waitForDelta(m.deltaMessage))
+	case streamDoneMsg:
+		m.streaming = false
+		m.confirm = nil
+		m.toolOutput = ""
+		m.cancel = nil
+		if err := m.outputFile.end(); err != nil {
+			m.err = err
+		}
+		if m.saveTo != nil {
+			if err := m.saveTo.end(); err != nil {
+				m.err = err
+			}
+			if err := m.saveTo.close(); err != nil {
+				m.err = err
+			}
+			m.notice = "Saved the reply to " + m.saveTo.path
+			m.saveTo = nil
+		}
+		m.recordUsage(msg.summaryModel, msg.summaryUsage)
+		m.recordUsage(msg.model, msg.usage)
+		switch {
+		case errors.Is(msg.err, context.Canceled):
+			m.notice = "Response cancelled"
+		case errors.Is(msg.err, errTruncated):
+			m.truncated = true
+			m.notice = "The reply was cut off at the max_tokens limit; /continue for the rest, or /set max_tokens to raise it"
+		case errors.As(msg.err, new(timeoutError)) && m.messages[len(m.messages)-1].Content != "":
+			m.err = fmt.Errorf("%w; kept what arrived, /retry to ask again", msg.err)
+		case msg.err != nil:
+			m.err = msg.err
+		case msg.dropped > 0 && m.notice == "":
+			verb := "Left out"
+			if m.config.ContextStrategy == contextSummarize {
+				verb = "Summarized"
+			}
+			m.notice = fmt.Sprintf("%s the %d oldest messages to fit the context window", verb, msg.dropped)
+		case msg.fingerprint != "" && m.config.Seed != nil && m.notice == "":
+			// With a seed, a change of fingerprint explains a change of reply.
+			m.notice = fmt.Sprintf("seed %d · system_fingerprint %s", *m.config.Seed, msg.fingerprint)
+		}
+
+		// Keep whatever arrived before an error or cancellation, but don't
+		// leave an empty assistant turn in the transcript that would be sent
+		// along with the next request.
+		last := len(m.messages) - 1
+		if reply := m.messages[last].Content; reply != "" || (msg.err != nil && !errors.Is(msg.err, context.Canceled)) {
+			result := chatResult{usage: msg.usage, finish: msg.finish, model: msg.model}
+			entry := newAuditEntry(m.config, m.conversation, m.lastPrompt(), reply, time.Now().Add(-msg.elapsed), result, msg.err)
+			if err := m.config.audit(entry); err != nil {
+				m.err = err
+			}
+		}
+		if m.messages[last].Content == "" {
+			m.messages = m.messages[:last]
+		} else {
+			if err := m.saveMessages(m.messages[last]); err != nil {
+				m.err = err
+			}
+			m.details[last] = replyDetails{
+				model:   msg.model,
+				usage:   msg.usage,
+				elapsed: msg.elapsed,
+				finish:  msg.finish,
+			}
+		}
+		if m.session != "" {
+			if err := m.sessions.save(m.snapshot(m.session)); err != nil {
+				m.err = err
+			}
+		}
+		m.shareRoom(true)
+		m.refreshViewport()
 		cmds = append(cmds, waitForDelta(m.deltaMessage))
+	case roomSyncMsg:
+		m.syncRoom(msg)
+	case candidatesMsg:
+		m.candidatesArrived(msg)
+	case pagesFetchedMsg:
+		cmds = append(cmds, m.pagesFetched(msg))
+	case editorDoneMsg:
+		if msg.err != nil {
+			m.err = msg.err
+			break
+		}
+		m.textarea.SetValue(msg.content)
 	case errMsg:
 		m.err = msg
 		return m, nil
@@ -156,48 +832,520 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	m.viewport, vpCmd = m.viewport.Update(msg)
 	cmds = append(cmds, tiCmd, vpCmd)
 
+	m.layout()
 	return m, tea.Batch(cmds...)
 }
 
 func (m model) View() string {
-	return fmt.Sprintf(
+	if m.palette != nil {
+		return m.paletteView() + "\n\n" + m.statusBar() + "\n"
+	}
+	if m.showHelp {
+		return m.helpView() + "\n\n" + m.statusBar() + "\n"
+	}
+
+	view := fmt.Sprintf(
 		"%s\n\n%s",
 		m.viewport.View(),
 		m.textarea.View(),
-	) + "\n\n"
+	)
+	if message := m.messageLine(); message != "" {
+		view += "\n\n" + message
+	}
+	view += "\n\n" + m.statusBar()
+	return view + "\n"
 }
 
-func (m model) createChatCompletion() tea.Cmd {
-	return func() tea.Msg {
-		for {
-			ctx := context.Background()
-			req := openai.ChatCompletionRequest{
-				Model: openai.GPT3Dot5Turbo,
-				Messages: []openai.ChatCompletionMessage{
-					{
-						Role:    openai.ChatMessageRoleUser,
-						Content: <-m.inputMessage,
-					},
-				},
-			}
-			stream, err := m.client.CreateChatCompletionStream(ctx, req)
-			if err != nil {
-				return errMsg(err)
-			}
-			defer stream.Close()
+// tokenStatus reports how much of the context window the next request would
+// use, including the system prompt and whatever is still being typed.
+func (m model) tokenStatus() string {
+	messages := m.messages
+	data := promptData{GOOS: m.goos, Shell: m.shell}
+	if req, err := newChatRequest(m.config, data, m.messages); err == nil {
+		messages = req.Messages
+	}
 
-			for {
-				response, err := stream.Recv()
-				if errors.Is(err, io.EOF) {
-					break
-				}
+	n := m.tokens.countMessages(messages)
+	if input := withContext(m.textarea.Value(), m.attachment); input != "" {
+		n += tokensPerMessage + m.tokens.count(input)
+	}
 
-				if err != nil {
-					return errMsg(err)
-				}
+	if window := m.config.contextWindow(); window > 0 {
+		return fmt.Sprintf("%s / %s tokens", formatCount(n), formatWindow(window))
+	}
+	return formatCount(n) + " tokens"
+}
+
+func (m model) snapshot(name string) session {
+	return session{
+		Name:         name,
+		Model:        m.config.Model,
+		Temperature:  m.config.Temperature,
+		SystemPrompt: m.config.SystemPrompt,
+		Messages:     m.messages,
+	}
+}
+
+// saveSession handles /save. Later replies are saved to the same session
+// automatically.
+func (m *model) saveSession(args []string) {
+	m.err = nil
+	m.notice = ""
+	if len(args) != 1 {
+		m.err = errors.New("usage: /save <name>")
+		return
+	}
+
+	if err := m.sessions.save(m.snapshot(args[0])); err != nil {
+		m.err = err
+		return
+	}
+	m.session = args[0]
+	m.notice = "Saved session " + args[0]
+}
+
+// loadSession handles /load, replacing the current conversation.
+func (m *model) loadSession(args []string) {
+	m.err = nil
+	m.notice = ""
+	if len(args) != 1 {
+		m.err = errors.New("usage: /load <name>")
+		return
+	}
+	if m.streaming {
+		m.err = errors.New("wait for the reply to finish before loading a session")
+		return
+	}
+
+	sess, err := m.sessions.load(args[0])
+	if err != nil {
+		m.err = err
+		return
+	}
+	conv, err := m.store.createWith(sess.Messages)
+	if err != nil {
+		m.err = err
+		return
+	}
+
+	if err := m.conversation.discardIfEmpty(); err != nil {
+		m.err = err
+	}
+	m.config = sess.apply(m.config)
+	m.conversation = conv
+	m.messages = conv.Messages
+	m.truncated = false
+	m.details = make(map[int]replyDetails)
+	m.session = sess.Name
+	m.notice = "Loaded session " + sess.Name
+	m.viewport.GotoBottom()
+	m.refreshViewport()
+}
+
+// export handles /export, writing the conversation to a Markdown or JSON
+// file depending on its extension.
+func (m *model) export(args []string) {
+	m.err = nil
+	m.notice = ""
+	if len(args) > 1 {
+		m.err = errors.New("usage: /export [file.md|file.json]")
+		return
+	}
+
+	path := m.conversation.ID + ".md"
+	if len(args) == 1 {
+		path = args[0]
+	}
+
+	title := m.session
+	if title == "" && len(m.messages) > 0 {
+		title = summarizeTitle(m.messages[0].Content)
+	}
+	err := exportToFile(path, transcript{
+		Title:    title,
+		Model:    m.config.Model,
+		Messages: m.messages,
+	}, exportFormat(path))
+	if err != nil {
+		m.err = err
+		return
+	}
+	m.notice = "Exported to " + path
+}
+
+// switchModel handles the /model command. Without arguments it lists the
+// known models.
+func (m *model) switchModel(args []string) {
+	m.err = nil
+	if len(args) == 0 {
+		m.notice = fmt.Sprintf("Current model: %s. Available: %s",
+			m.config.Model, strings.Join(knownModels, ", "))
+		return
+	}
+
+	m.config.Model = args[0]
+	m.tokens.setModel(args[0])
+	m.notice = "Switched to " + args[0]
+}
+
+// lastReply returns the content of the last reply, if there is one.
+func (m model) lastReply() (string, bool) {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].Role == openai.ChatMessageRoleAssistant && m.messages[i].Content != "" {
+			return m.messages[i].Content, true
+		}
+	}
+	return "", false
+}
+
+// lastPrompt returns the last message the user sent.
+func (m model) lastPrompt() string {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].Role == openai.ChatMessageRoleUser {
+			return m.messages[i].Content
+		}
+	}
+	return ""
+}
+
+// copyLastReply puts the last reply on the clipboard.
+func (m *model) copyLastReply() {
+	m.err = nil
+	reply, ok := m.lastReply()
+	if !ok {
+		m.notice = "Nothing to copy yet"
+		return
+	}
+	m.copyToClipboard(reply)
+	m.notice = "Copied the last reply"
+}
+
+// sendInput sends what was typed along with the attachment, the files it
+// mentions and the pages fetched for it.
+func (m *model) sendInput(input, pages string) (tea.Cmd, error) {
+	content, err := m.takeInput(input, pages)
+	if err != nil {
+		return nil, err
+	}
+	return m.send(content), nil
+}
+
+// takeInput makes a message of input, taking the attachment and the pages
+// waiting for it.
+func (m *model) takeInput(input, pages string) (string, error) {
+	// Anything starting with a slash here was escaped as //.
+	content, err := attachFiles(withContext(strings.TrimPrefix(input, "/"), m.attachment))
+	if err != nil {
+		return "", err
+	}
+	content += m.pages + pages
+	m.attachment = ""
+	m.pages = ""
+	return content, nil
+}
+
+// send adds a user message to the conversation and asks for a reply.
+func (m *model) send(content string) tea.Cmd {
+	return m.sendWith(m.config, content)
+}
+
+// sendWith is send with settings for this reply only.
+func (m *model) sendWith(cfg config, content string) tea.Cmd {
+	m.err = nil
+	m.notice = ""
+	if !m.checkBudget() {
+		return nil
+	}
 
-				m.deltaMessage <- response.Choices[0].Delta.Content
+	message := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: content,
+	}
+	if m.room != nil {
+		holder, ok := m.room.take(m.member)
+		if !ok {
+			m.err = fmt.Errorf("%s is waiting for a reply; send yours once it has arrived", holder)
+			return nil
+		}
+		message.Name = m.member.name
+	}
+	m.messages = append(m.messages, message)
+	if err := m.saveMessages(message); err != nil {
+		m.err = err
+	}
+	m.viewport.GotoBottom()
+	cmd := m.startCompletion(cfg)
+	m.shareRoom(false)
+	return cmd
+}
+
+// startCompletion asks for a reply to the transcript so far using cfg.
+func (m *model) startCompletion(cfg config) tea.Cmd {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.cancel = cancel
+	cmd := m.createChatCompletion(ctx, cfg, m.messages)
+
+	m.messages = append(m.messages, openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleAssistant,
+	})
+	delete(m.details, len(m.messages)-1)
+	m.streaming = true
+	m.truncated = false
+	m.refreshViewport()
+	return cmd
+}
+
+// continueReply handles /continue, asking for the rest of a reply that was
+// cut off and adding it to the end of the same reply.
+func (m *model) continueReply() tea.Cmd {
+	m.err = nil
+	m.notice = ""
+	last := len(m.messages) - 1
+	if !m.truncated || last < 0 || m.messages[last].Role != openai.ChatMessageRoleAssistant {
+		m.err = errors.New("the last reply wasn't cut off")
+		return nil
+	}
+	if !m.checkBudget() {
+		return nil
+	}
+
+	// The reply is saved again once it is whole.
+	if err := m.conversation.truncate(last); err != nil {
+		m.err = err
+		return nil
+	}
+	// The request alone asks to continue; the transcript reads as one reply.
+	messages := append(m.messages[:last+1:last+1], openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: continuePrompt,
+	})
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.cancel = cancel
+	m.streaming = true
+	m.truncated = false
+	m.refreshViewport()
+	return m.createChatCompletion(ctx, m.config, messages)
+}
+
+// retry handles /retry, replacing the last reply with a new one. Arguments of
+// the form model=NAME, and temperature=T and the other sampling parameters,
+// apply to this attempt only.
+func (m *model) retry(args []string) tea.Cmd {
+	m.err = nil
+	m.notice = ""
+
+	cfg := m.config
+	for _, arg := range args {
+		key, value, _ := strings.Cut(arg, "=")
+		if key == "model" {
+			cfg.Model = value
+			continue
+		}
+		p, ok := findSamplingParam(key)
+		if !ok {
+			m.err = fmt.Errorf("unknown /retry option %q", arg)
+			return nil
+		}
+		if err := p.set(&cfg, value); err != nil {
+			m.err = err
+			return nil
+		}
+	}
+
+	if !m.checkBudget() {
+		return nil
+	}
+
+	if !m.dropLastReply() {
+		m.err = errors.New("nothing to retry")
+		return nil
+	}
+	return m.startCompletion(cfg)
+}
+
+// dropLastReply removes the last reply, along with any tools it called, so
+// that the last message can be answered again. It reports false if the
+// conversation doesn't end with a message to answer.
+func (m *model) dropLastReply() bool {
+	m.truncated = false
+	last := len(m.messages) - 1
+	for last >= 0 && (m.messages[last].Role == openai.ChatMessageRoleAssistant || m.messages[last].Role == openai.ChatMessageRoleTool) {
+		m.messages = m.messages[:last]
+		last--
+	}
+	for i := range m.details {
+		if i > last {
+			delete(m.details, i)
+		}
+	}
+	if last < 0 || m.messages[last].Role != openai.ChatMessageRoleUser {
+		return false
+	}
+	if err := m.conversation.truncate(len(m.messages)); err != nil {
+		m.err = err
+	}
+	return true
+}
+
+// checkBudget reports whether another request may be sent, warning as the
+// budget runs out.
+func (m *model) checkBudget() bool {
+	warning, err := m.budget.check()
+	if err != nil {
+		m.err = err
+		return false
+	}
+	m.notice = warning
+	return true
+}
+
+// recordUsage adds the tokens a request used to the cost of the session and
+// the spending against the budget.
+func (m *model) recordUsage(model string, usage openai.Usage) {
+	if usage.TotalTokens == 0 {
+		return
+	}
+	m.costs.add(model, usage)
+	if err := m.budget.record(m.config, model, usage); err != nil {
+		m.err = err
+	}
+}
+
+func (m *model) refreshViewport() {
+	if len(m.messages) == 0 && m.room != nil {
+		m.viewport.SetContent(m.roomWelcome())
+		m.viewport.GotoTop()
+		return
+	}
+	if len(m.messages) == 0 {
+		m.viewport.SetContent(`Welcome to the chat room!
+Type a message and press Enter to send.`)
+		m.viewport.GotoTop()
+		return
+	}
+
+	// Markdown is wrapped by the renderer; everything else is wrapped here.
+	wrap := lipgloss.NewStyle().Width(m.viewport.Width)
+	blocks := make([]string, 0, len(m.messages))
+	// calls maps the IDs of tool calls to the tools called.
+	calls := make(map[string]string)
+	for i, message := range m.messages {
+		switch message.Role {
+		case openai.ChatMessageRoleUser:
+			label := m.styles.user.Render("You: ")
+			if message.Name != "" {
+				label = nameStyle(message.Name).Render(message.Name + ": ")
+			}
+			blocks = append(blocks, wrap.Render(label+fileChips(message.Content, m.styles.notice)))
+		case openai.ChatMessageRoleTool:
+			if summary := m.tools.summary(calls[message.ToolCallID], message.Content); summary != "" {
+				blocks = append(blocks, wrap.Render(m.styles.notice.Render(summary)))
+			}
+		case openai.ChatMessageRoleAssistant:
+			var block string
+			switch {
+			case message.Content == "":
+			case !m.config.Markdown:
+				block = wrap.Render(m.styles.assistant.Render("System: ") + m.renderer.renderPlain(message.Content))
+			default:
+				final := !m.streaming || i < len(m.messages)-1
+				block = m.styles.assistant.Render("System:") + "\n" + m.renderer.renderMarkdown(message.Content, final)
+			}
+			if m.truncated && i == len(m.messages)-1 && block != "" {
+				block += "\n" + wrap.Render(m.styles.notice.Render("[truncated]"))
+			}
+			if d, ok := m.details[i]; ok && m.config.ReplyDetails && block != "" {
+				block += "\n" + wrap.Render(m.styles.footer.Render(d.String()))
+			}
+			for _, call := range message.ToolCalls {
+				calls[call.ID] = call.Function.Name
+				if block != "" {
+					block += "\n"
+				}
+				block += wrap.Render(m.styles.notice.Render("→ " + formatToolCall(call)))
+			}
+			if block != "" || len(message.ToolCalls) == 0 {
+				blocks = append(blocks, block)
 			}
 		}
 	}
+	if m.chooser != nil && m.chooser.replies != nil {
+		blocks = append(blocks, m.chooserView())
+	}
+	if m.toolOutput != "" {
+		lines := strings.Split(strings.TrimRight(m.toolOutput, "\n"), "\n")
+		if len(lines) > shellSummaryLines {
+			lines = lines[len(lines)-shellSummaryLines:]
+		}
+		blocks = append(blocks, wrap.Render(m.styles.notice.Render(strings.Join(lines, "\n"))))
+	}
+	content := strings.Join(blocks, "\n")
+	if m.search != nil {
+		content = m.search.highlight(blocks, m.styles)
+	}
+	// Follow the conversation unless it has been scrolled back.
+	atBottom := m.viewport.AtBottom()
+	m.viewport.SetContent(content)
+	if atBottom {
+		m.viewport.GotoBottom()
+	}
+}
+
+// createChatCompletion streams the assistant's reply to the given transcript
+// into m.deltaMessage, finishing with a streamDoneMsg.
+func (m model) createChatCompletion(ctx context.Context, cfg config, messages []openai.ChatCompletionMessage) tea.Cmd {
+	return func() tea.Msg {
+		var (
+			result  chatResult
+			dropped int
+			summary openai.Usage
+			start   = time.Now()
+		)
+		ctx = withRetryNotice(ctx, func(s string) {
+			m.emit(retryMsg(s))
+		})
+		ctx = withQueueStatus(ctx, func(position int) {
+			m.emit(queueMsg(position))
+		})
+		req, err := newChatRequest(cfg, promptData{GOOS: m.goos, Shell: m.shell}, messages)
+		if err == nil {
+			err = m.retriever.augment(ctx, &req)
+		}
+		if err == nil {
+			dropped, summary, err = fitContext(ctx, cfg, m.provider, m.conversation, &req)
+		}
+		if err == nil {
+			result, err = chatWithTools(ctx, m.provider, m.tools, req, chatEvents{
+				delta: func(delta string) {
+					m.emit(deltaMsg(delta))
+				},
+				calling: func(calls []openai.ToolCall) {
+					m.emit(toolCallsMsg(calls))
+				},
+				called: func(reply openai.ChatCompletionMessage, results []openai.ChatCompletionMessage) {
+					m.emit(toolResultsMsg{reply: reply, results: results})
+				},
+				toolIO: toolIO{
+					confirm: confirmVia(ctx, m.deltaMessage),
+					output: func(s string) {
+						m.emit(toolOutputMsg(s))
+					},
+				},
+			})
+		}
+
+		m.emit(streamDoneMsg{
+			dropped:      dropped,
+			model:        cfg.Model,
+			usage:        result.usage,
+			summaryModel: cfg.summaryModel(),
+			summaryUsage: summary,
+			fingerprint:  result.fingerprint,
+			finish:       result.finish,
+			elapsed:      time.Since(start),
+			err:          err,
+		})
+		return nil
+	}
 }
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mcpProtocolVersion is the version of the Model Context Protocol
+	// spoken to servers.
+	mcpProtocolVersion = "2024-11-05"
+	// mcpConnectTimeout bounds starting a server and listing what it has.
+	mcpConnectTimeout = 30 * time.Second
+	// maxListedResources is how many of a server's resources are named in
+	// the description of its read_resource tool.
+	maxListedResources = 50
+)
+
+// mcpServerConfig says how to reach an MCP server: a command to run, which
+// speaks the protocol on stdin and stdout, or the URL of its SSE endpoint.
+type mcpServerConfig struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// mcpToolName matches what a tool name may contain.
+var mcpToolName = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// addMCPServers connects to the servers and registers their tools, along
+// with a tool for reading the resources of those that have any. Tools are
+// named after the server, e.g. files__read_file, so that servers can't clash.
+// Servers reached over HTTP are reached with client.
+func (r *toolRegistry) addMCPServers(servers map[string]mcpServerConfig, client *http.Client) error {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(context.Background(), mcpConnectTimeout)
+		err := r.addMCPServer(ctx, name, servers[name], client)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("MCP server %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (r *toolRegistry) addMCPServer(ctx context.Context, name string, cfg mcpServerConfig, client *http.Client) error {
+	c, err := connectMCP(ctx, cfg, client)
+	if err != nil {
+		return err
+	}
+	r.servers = append(r.servers, c)
+
+	tools, err := c.listTools(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range tools {
+		if err := r.register(c.tool(name, t)); err != nil {
+			return err
+		}
+	}
+
+	if c.capabilities.Resources == nil {
+		return nil
+	}
+	resources, err := c.listResources(ctx)
+	if err != nil {
+		return err
+	}
+	if len(resources) > 0 {
+		return r.register(c.resourceTool(name, resources))
+	}
+	return nil
+}
+
+// mcpTransport carries JSON-RPC messages to and from a server.
+type mcpTransport interface {
+	send(ctx context.Context, msg []byte) error
+	// receive blocks until the next message arrives.
+	receive() ([]byte, error)
+	close() error
+}
+
+// mcpClient is a connection to an MCP server.
+type mcpClient struct {
+	transport    mcpTransport
+	capabilities mcpCapabilities
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan mcpResponse
+	// done is closed once the connection is lost, with err saying why.
+	done chan struct{}
+	err  error
+}
+
+type mcpCapabilities struct {
+	Tools     *struct{} `json:"tools"`
+	Resources *struct{} `json:"resources"`
+}
+
+type mcpRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      *int64 `json:"id,omitempty"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// mcpIncoming is anything a server sends: a response, a request or a
+// notification.
+type mcpIncoming struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *mcpError       `json:"error"`
+}
+
+type mcpResponse struct {
+	Result json.RawMessage
+	Error  *mcpError
+}
+
+type mcpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *mcpError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// connectMCP starts or connects to a server and goes through the protocol's
+// handshake.
+func connectMCP(ctx context.Context, cfg mcpServerConfig, client *http.Client) (*mcpClient, error) {
+	var (
+		transport mcpTransport
+		err       error
+	)
+	switch {
+	case cfg.Command != "":
+		transport, err = startMCPStdio(cfg)
+	case cfg.URL != "":
+		transport, err = connectMCPSSE(ctx, cfg, client)
+	default:
+		err = errors.New("needs a command or a url")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mcpClient{
+		transport: transport,
+		pending:   make(map[int64]chan mcpResponse),
+		done:      make(chan struct{}),
+	}
+	go c.readLoop()
+
+	var result struct {
+		Capabilities mcpCapabilities `json:"capabilities"`
+	}
+	err = c.call(ctx, "initialize", map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]string{"name": "gpt-cli", "version": "1.0"},
+	}, &result)
+	if err == nil {
+		err = c.notify(ctx, "notifications/initialized")
+	}
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	c.capabilities = result.Capabilities
+	return c, nil
+}
+
+func (c *mcpClient) close() error {
+	return c.transport.close()
+}
+
+// readLoop hands responses to the calls waiting on them until the
+// connection is lost.
+func (c *mcpClient) readLoop() {
+	for {
+		data, err := c.transport.receive()
+		if err != nil {
+			c.mu.Lock()
+			c.err = fmt.Errorf("connection lost: %w", err)
+			c.mu.Unlock()
+			close(c.done)
+			return
+		}
+
+		var msg mcpIncoming
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		if msg.Method != "" {
+			if len(msg.ID) > 0 {
+				c.answer(msg)
+			}
+			// Notifications, such as of progress, are of no use here.
+			continue
+		}
+
+		var id int64
+		if err := json.Unmarshal(msg.ID, &id); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- mcpResponse{Result: msg.Result, Error: msg.Error}
+		}
+	}
+}
+
+// answer replies to a request from the server. Only pings are supported.
+func (c *mcpClient) answer(msg mcpIncoming) {
+	reply := map[string]any{"jsonrpc": "2.0", "id": msg.ID}
+	if msg.Method == "ping" {
+		reply["result"] = map[string]any{}
+	} else {
+		reply["error"] = mcpError{Code: rpcMethodNotFound, Message: "method not found"}
+	}
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mcpConnectTimeout)
+	defer cancel()
+	_ = c.transport.send(ctx, data)
+}
+
+// call sends a request and decodes the result into result.
+func (c *mcpClient) call(ctx context.Context, method string, params, result any) error {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	ch := make(chan mcpResponse, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	forget := func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}
+
+	data, err := json.Marshal(mcpRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	if err != nil {
+		forget()
+		return err
+	}
+	if err := c.transport.send(ctx, data); err != nil {
+		forget()
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return fmt.Errorf("%s: %w", method, resp.Error)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-ctx.Done():
+		forget()
+		return ctx.Err()
+	case <-c.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.err
+	}
+}
+
+func (c *mcpClient) notify(ctx context.Context, method string) error {
+	data, err := json.Marshal(mcpRequest{JSONRPC: "2.0", Method: method})
+	if err != nil {
+		return err
+	}
+	return c.transport.send(ctx, data)
+}
+
+type mcpTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+
+type mcpResource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// mcpContent is a piece of the result of a tool call or resource read.
+type mcpContent struct {
+	Type     string       `json:"type"`
+	Text     string       `json:"text,omitempty"`
+	MimeType string       `json:"mimeType,omitempty"`
+	Blob     string       `json:"blob,omitempty"`
+	URI      string       `json:"uri,omitempty"`
+	Resource *mcpContent  `json:"resource,omitempty"`
+	Contents []mcpContent `json:"contents,omitempty"`
+}
+
+func (c *mcpClient) listTools(ctx context.Context) ([]mcpTool, error) {
+	var tools []mcpTool
+	err := c.paginate(ctx, "tools/list", func(page json.RawMessage) error {
+		var result struct {
+			Tools []mcpTool `json:"tools"`
+		}
+		err := json.Unmarshal(page, &result)
+		tools = append(tools, result.Tools...)
+		return err
+	})
+	return tools, err
+}
+
+func (c *mcpClient) listResources(ctx context.Context) ([]mcpResource, error) {
+	var resources []mcpResource
+	err := c.paginate(ctx, "resources/list", func(page json.RawMessage) error {
+		var result struct {
+			Resources []mcpResource `json:"resources"`
+		}
+		err := json.Unmarshal(page, &result)
+		resources = append(resources, result.Resources...)
+		return err
+	})
+	return resources, err
+}
+
+// paginate calls a list method, passing each page of results to add.
+func (c *mcpClient) paginate(ctx context.Context, method string, add func(json.RawMessage) error) error {
+	var cursor string
+	for {
+		var params map[string]any
+		if cursor != "" {
+			params = map[string]any{"cursor": cursor}
+		}
+		var page json.RawMessage
+		if err := c.call(ctx, method, params, &page); err != nil {
+			return err
+		}
+		if err := add(page); err != nil {
+			return err
+		}
+		var next struct {
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(page, &next); err != nil {
+			return err
+		}
+		if next.NextCursor == "" {
+			return nil
+		}
+		cursor = next.NextCursor
+	}
+}
+
+// tool offers one of the server's tools to the model.
+func (c *mcpClient) tool(server string, t mcpTool) tool {
+	schema := t.InputSchema
+	if len(schema) == 0 || string(schema) == "null" {
+		schema = json.RawMessage(`{"type":"object"}`)
+	}
+	return tool{
+		name:        mcpName(server, t.Name),
+		description: t.Description,
+		parameters:  schema,
+		run: func(ctx context.Context, args string, tio toolIO) (string, error) {
+			var result struct {
+				Content []mcpContent `json:"content"`
+				IsError bool         `json:"isError"`
+			}
+			params := map[string]any{"name": t.Name, "arguments": json.RawMessage(args)}
+			if err := c.call(ctx, "tools/call", params, &result); err != nil {
+				return "", err
+			}
+			text := formatMCPContent(result.Content)
+			if result.IsError {
+				return "", errors.New(text)
+			}
+			return text, nil
+		},
+	}
+}
+
+// resourceTool offers the server's resources to the model, naming them in
+// its description.
+func (c *mcpClient) resourceTool(server string, resources []mcpResource) tool {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Read a resource of the %s MCP server. It has:", server)
+	for i, res := range resources {
+		if i == maxListedResources {
+			fmt.Fprintf(&b, "\n- and %d more", len(resources)-i)
+			break
+		}
+		fmt.Fprintf(&b, "\n- %s", res.URI)
+		if res.Name != "" {
+			fmt.Fprintf(&b, " (%s)", res.Name)
+		}
+		if res.Description != "" {
+			fmt.Fprintf(&b, ": %s", res.Description)
+		}
+	}
+
+	return tool{
+		name:        mcpName(server, "read_resource"),
+		description: b.String(),
+		parameters: jsonSchema{
+			Type: "object",
+			Properties: map[string]jsonSchema{
+				"uri": {Type: "string", Description: "The URI of the resource"},
+			},
+			Required: []string{"uri"},
+		},
+		run: func(ctx context.Context, args string, tio toolIO) (string, error) {
+			var params struct {
+				URI string `json:"uri"`
+			}
+			if err := json.Unmarshal([]byte(args), &params); err != nil {
+				return "", err
+			}
+			var result struct {
+				Contents []mcpContent `json:"contents"`
+			}
+			if err := c.call(ctx, "resources/read", map[string]string{"uri": params.URI}, &result); err != nil {
+				return "", err
+			}
+			return formatMCPContent(result.Contents), nil
+		},
+	}
+}
+
+// mcpName names a tool of a server, keeping to the characters and length
+// tool names are allowed.
+func mcpName(server, name string) string {
+	full := mcpToolName.ReplaceAllString(server+"__"+name, "_")
+	if len(full) > 64 {
+		full = full[:64]
+	}
+	return full
+}
+
+// formatMCPContent turns content into text for the model. Anything that
+// isn't text is only described.
+func formatMCPContent(contents []mcpContent) string {
+	var parts []string
+	for _, content := range contents {
+		switch {
+		case content.Resource != nil:
+			parts = append(parts, formatMCPContent([]mcpContent{*content.Resource}))
+		case content.Text != "" || content.Type == "text":
+			parts = append(parts, content.Text)
+		default:
+			kind := content.Type
+			if content.MimeType != "" {
+				kind = content.MimeType
+			}
+			if kind == "" {
+				kind = "binary"
+			}
+			parts = append(parts, fmt.Sprintf("[%s content of %s not shown]", kind, content.URI))
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// mcpStdio talks to a server it runs, a message a line.
+type mcpStdio struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	mu     sync.Mutex
+}
+
+func startMCPStdio(cfg mcpServerConfig) (*mcpStdio, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Env = os.Environ()
+	for key, value := range cfg.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &mcpStdio{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+func (t *mcpStdio) send(ctx context.Context, msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.stdin.Write(append(msg, '\n'))
+	return err
+}
+
+func (t *mcpStdio) receive() ([]byte, error) {
+	for {
+		line, err := t.stdout.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *mcpStdio) close() error {
+	// Closing stdin asks the server to exit.
+	t.stdin.Close()
+	done := make(chan error, 1)
+	go func() { done <- t.cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Second):
+		return t.cmd.Process.Kill()
+	}
+}
+
+// mcpSSE talks to a server over HTTP: messages from the server arrive as
+// server-sent events, and those to it are posted to the endpoint it names in
+// its first event.
+type mcpSSE struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+	body     io.ReadCloser
+	events   *sseReader
+}
+
+func connectMCPSSE(ctx context.Context, cfg mcpServerConfig, client *http.Client) (*mcpSSE, error) {
+	base, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	// The stream stays open for as long as the chat does, so it can't be
+	// tied to ctx.
+	req, err := http.NewRequest(http.MethodGet, cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
+
+	type connected struct {
+		resp *http.Response
+		err  error
+	}
+	ch := make(chan connected, 1)
+	go func() {
+		resp, err := client.Do(req)
+		ch <- connected{resp, err}
+	}()
+	var resp *http.Response
+	select {
+	case c := <-ch:
+		if c.err != nil {
+			return nil, c.err
+		}
+		resp = c.resp
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("connecting: %s", resp.Status)
+	}
+
+	t := &mcpSSE{headers: cfg.Headers, client: client, body: resp.Body, events: newSSEReader(resp.Body)}
+	for t.endpoint == "" {
+		event, err := t.events.next()
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("waiting for the endpoint: %w", err)
+		}
+		if event.Event == "endpoint" {
+			endpoint, err := base.Parse(strings.TrimSpace(event.Data))
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			// Messages go with the headers, which may hold a token,
+			// so only to the server that was asked for.
+			if endpoint.Scheme != base.Scheme || !strings.EqualFold(endpoint.Host, base.Host) {
+				resp.Body.Close()
+				return nil, fmt.Errorf("the endpoint %s isn't on %s", endpoint.Redacted(), base.Host)
+			}
+			t.endpoint = endpoint.String()
+		}
+	}
+	return t, nil
+}
+
+func (t *mcpSSE) send(ctx context.Context, msg []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sending: %s", resp.Status)
+	}
+	return nil
+}
+
+func (t *mcpSSE) receive() ([]byte, error) {
+	for {
+		event, err := t.events.next()
+		if err != nil {
+			return nil, err
+		}
+		if event.Event == "" || event.Event == "message" {
+			return []byte(event.Data), nil
+		}
+	}
+}
+
+func (t *mcpSSE) close() error {
+	return t.body.Close()
+}
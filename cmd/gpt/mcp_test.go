@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConnectMCPSSEEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		err      string
+	}{
+		{name: "path", endpoint: "/messages?session=1"},
+		{name: "same origin", endpoint: "{{origin}}/messages"},
+		{name: "other host", endpoint: "http://attacker.example/collect", err: "isn't on"},
+		{name: "other scheme", endpoint: "https://{{host}}/messages", err: "isn't on"},
+		{name: "scheme-relative", endpoint: "//attacker.example/collect", err: "isn't on"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				host := r.Host
+				endpoint := strings.NewReplacer("{{origin}}", "http://"+host, "{{host}}", host).Replace(tt.endpoint)
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprintf(w, "event: endpoint\ndata: %s\n\n", endpoint)
+			}))
+			defer srv.Close()
+
+			sse, err := connectMCPSSE(context.Background(), mcpServerConfig{URL: srv.URL + "/sse"}, srv.Client())
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				sse.close()
+				if !strings.HasPrefix(sse.endpoint, srv.URL+"/messages") {
+					t.Errorf("endpoint = %q, want it on %s", sse.endpoint, srv.URL)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("connectMCPSSE() error = %v, want one about %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms.
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// metricLabels tell apart the series of a metric.
+type metricLabels struct {
+	provider string
+	model    string
+	// extra is the third label, if the metric has one, such as the
+	// outcome of a request or the kind of token.
+	extra string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// metrics counts what long-running servers send to providers, for Prometheus
+// to scrape.
+type metrics struct {
+	mu         sync.Mutex
+	requests   map[metricLabels]uint64
+	tokens     map[metricLabels]uint64
+	inFlight   map[metricLabels]int
+	duration   map[metricLabels]*histogram
+	firstToken map[metricLabels]*histogram
+}
+
+// providerMetrics is set by the servers, which newProvider then counts the
+// requests of. The chat and one-shot commands aren't counted.
+var providerMetrics *metrics
+
+// enableMetrics starts counting requests to providers made from now on.
+func enableMetrics() *metrics {
+	if providerMetrics == nil {
+		providerMetrics = &metrics{
+			requests:   make(map[metricLabels]uint64),
+			tokens:     make(map[metricLabels]uint64),
+			inFlight:   make(map[metricLabels]int),
+			duration:   make(map[metricLabels]*histogram),
+			firstToken: make(map[metricLabels]*histogram),
+		}
+	}
+	return providerMetrics
+}
+
+// serveMetrics enables the metrics and serves them at addr/metrics in the
+// background, for as long as the program runs.
+func serveMetrics(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", enableMetrics())
+	go http.Serve(ln, mux)
+	return nil
+}
+
+func (m *metrics) started(l metricLabels) {
+	m.mu.Lock()
+	m.inFlight[l]++
+	m.mu.Unlock()
+}
+
+// finished counts a request that took elapsed, ending with err.
+func (m *metrics) finished(l metricLabels, elapsed time.Duration, err error) {
+	outcome := "ok"
+	switch {
+	case errors.Is(err, context.Canceled):
+		outcome = "canceled"
+	case errors.Is(err, errTruncated):
+		outcome = "truncated"
+	case err != nil:
+		outcome = "error"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight[l]--
+	m.requests[metricLabels{l.provider, l.model, outcome}]++
+	m.observe(m.duration, l, elapsed)
+}
+
+func (m *metrics) firstTokenAfter(l metricLabels, elapsed time.Duration) {
+	m.mu.Lock()
+	m.observe(m.firstToken, l, elapsed)
+	m.mu.Unlock()
+}
+
+func (m *metrics) addTokens(l metricLabels, usage openai.Usage) {
+	m.mu.Lock()
+	m.tokens[metricLabels{l.provider, l.model, "prompt"}] += uint64(usage.PromptTokens)
+	m.tokens[metricLabels{l.provider, l.model, "completion"}] += uint64(usage.CompletionTokens)
+	m.mu.Unlock()
+}
+
+func (m *metrics) observe(histograms map[metricLabels]*histogram, l metricLabels, elapsed time.Duration) {
+	h, ok := histograms[l]
+	if !ok {
+		h = &histogram{}
+		histograms[l] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// ServeHTTP writes the metrics in Prometheus's text format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeCounter(w, "gpt_requests_total", "Requests to providers by outcome: ok, truncated, canceled or error.", "outcome", m.requests)
+	writeCounter(w, "gpt_tokens_total", "Tokens used by type: prompt or completion.", "type", m.tokens)
+
+	fmt.Fprintln(w, "# HELP gpt_requests_in_flight Requests to providers whose replies haven't finished.")
+	fmt.Fprintln(w, "# TYPE gpt_requests_in_flight gauge")
+	for _, l := range sortedLabels(m.inFlight) {
+		fmt.Fprintf(w, "gpt_requests_in_flight%s %d\n", l.format("", ""), m.inFlight[l])
+	}
+
+	writeHistogram(w, "gpt_request_duration_seconds", "Time from sending a request until its reply finished.", m.duration)
+	writeHistogram(w, "gpt_time_to_first_token_seconds", "Time from sending a request until the first chunk of its reply.", m.firstToken)
+}
+
+func writeCounter(w io.Writer, name, help, extra string, values map[metricLabels]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, l := range sortedLabels(values) {
+		fmt.Fprintf(w, "%s%s %d\n", name, l.format(extra, ""), values[l])
+	}
+}
+
+func writeHistogram(w io.Writer, name, help string, histograms map[metricLabels]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, l := range sortedLabels(histograms) {
+		h := histograms[l]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, l.format("", strconv.FormatFloat(bound, 'g', -1, 64)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, l.format("", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n", name, l.format("", ""), h.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", name, l.format("", ""), h.count)
+	}
+}
+
+// format writes the labels as Prometheus does, with the extra label named
+// extra if it is set, and le if it is given.
+func (l metricLabels) format(extra, le string) string {
+	labels := []string{
+		fmt.Sprintf("provider=%q", l.provider),
+		fmt.Sprintf("model=%q", l.model),
+	}
+	if extra != "" {
+		labels = append(labels, fmt.Sprintf("%s=%q", extra, l.extra))
+	}
+	if le != "" {
+		labels = append(labels, fmt.Sprintf("le=%q", le))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func sortedLabels[V any](m map[metricLabels]V) []metricLabels {
+	labels := make([]metricLabels, 0, len(m))
+	for l := range m {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.provider != b.provider {
+			return a.provider < b.provider
+		}
+		if a.model != b.model {
+			return a.model < b.model
+		}
+		return a.extra < b.extra
+	})
+	return labels
+}
+
+// metricsProvider counts the requests sent through it.
+type metricsProvider struct {
+	provider
+	name    string
+	metrics *metrics
+}
+
+func (p metricsProvider) unwrap() provider { return p.provider }
+
+func (p metricsProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	l := metricLabels{provider: p.name, model: req.Model}
+	p.metrics.started(l)
+	start := time.Now()
+	stream, err := p.provider.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		p.metrics.finished(l, time.Since(start), err)
+		return nil, err
+	}
+	return &metricsStream{stream: stream, metrics: p.metrics, labels: l, start: start}, nil
+}
+
+type metricsStream struct {
+	stream  chatStream
+	metrics *metrics
+	labels  metricLabels
+	start   time.Time
+	chunks  int
+	finish  openai.FinishReason
+	done    bool
+}
+
+func (s *metricsStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	response, err := s.stream.Recv()
+	switch {
+	case errors.Is(err, io.EOF):
+		var end error
+		if s.finish == openai.FinishReasonLength {
+			end = errTruncated
+		}
+		s.end(end)
+	case err != nil:
+		s.end(err)
+	default:
+		s.chunks++
+		if s.chunks == 1 {
+			s.metrics.firstTokenAfter(s.labels, time.Since(s.start))
+		}
+		if len(response.Choices) > 0 && response.Choices[0].FinishReason != "" {
+			s.finish = response.Choices[0].FinishReason
+		}
+		if response.Usage != nil {
+			s.metrics.addTokens(s.labels, *response.Usage)
+		}
+	}
+	return response, err
+}
+
+func (s *metricsStream) Close() error {
+	// A reply closed before it ended was given up on.
+	s.end(context.Canceled)
+	return s.stream.Close()
+}
+
+func (s *metricsStream) end(err error) {
+	if s.done {
+		return
+	}
+	s.done = true
+	s.metrics.finished(s.labels, time.Since(s.start), err)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// readStdin returns whatever is piped into the program, or an empty string
+// when stdin is a terminal.
+func readStdin() (string, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeCharDevice != 0 {
+		return "", nil
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// withContext attaches piped input to a prompt, framed as a file like those
+// given with @path, as a fence could be closed early by one in the input.
+func withContext(prompt, context string) string {
+	context = strings.TrimRight(context, "\n")
+	switch {
+	case context == "":
+		return prompt
+	case prompt == "":
+		return context
+	}
+	return prompt + fmt.Sprintf("\n\n<file path=\"stdin\">\n%s\n</file>", context)
+}
+
+// outputOptions are how a one-shot reply is written.
+type outputOptions struct {
+	// json writes the reply and what is known of it as a single JSON
+	// object once it is complete, instead of streaming the text.
+	json bool
+	// quiet writes nothing but the text of the reply, leaving out the
+	// notices on stderr and the newline after it, for piping.
+	quiet bool
+	// file receives the reply as it arrives, as well, if not nil.
+	file *responseFile
+}
+
+// notef writes a notice to stderr, unless quiet.
+func (o outputOptions) notef(format string, args ...any) {
+	if !o.quiet {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// parseOutputFormat reads the -output flag.
+func parseOutputFormat(format string) (outputOptions, error) {
+	switch format {
+	case "", "text":
+		return outputOptions{}, nil
+	case "json":
+		return outputOptions{json: true}, nil
+	}
+	return outputOptions{}, fmt.Errorf("unknown output format %q; use text or json", format)
+}
+
+// oneShotOutput is what -output json prints.
+type oneShotOutput struct {
+	Message      string              `json:"message"`
+	Model        string              `json:"model"`
+	FinishReason openai.FinishReason `json:"finish_reason"`
+	Usage        oneShotUsage        `json:"usage"`
+	Timings      oneShotTimings      `json:"timings"`
+	// Fingerprint is the reply's system_fingerprint, if the provider
+	// reported one.
+	Fingerprint  string `json:"system_fingerprint,omitempty"`
+	Conversation string `json:"conversation"`
+	// Error is why a reply that was kept in part was cut short.
+	Error string `json:"error,omitempty"`
+}
+
+type oneShotUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// oneShotTimings are in milliseconds from when the request was sent.
+type oneShotTimings struct {
+	FirstToken int64 `json:"first_token_ms"`
+	Total      int64 `json:"total_ms"`
+}
+
+// runOneShot sends a single prompt and streams the reply to stdout instead of
+// starting the TUI. r adds code from an index to the request, if not nil, and
+// the model may call tools.
+func runOneShot(cfg config, p provider, b *budget, conv *conversation, prompt string, r *retriever, tools *toolRegistry, out outputOptions) error {
+	warning, err := b.check()
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		out.notef("%s\n", warning)
+	}
+
+	for {
+		err := oneShotReply(cfg, p, b, conv, prompt, r, tools, out)
+		if !errors.Is(err, errTruncated) {
+			return err
+		}
+		if out.json || out.quiet {
+			// The finish reason says as much, and there's no asking.
+			return nil
+		}
+		fmt.Fprintln(os.Stderr, "[truncated] The reply was cut off at the max_tokens limit.")
+		if !confirmOnTerminal("Continue it?") {
+			return nil
+		}
+		prompt = ""
+	}
+}
+
+// oneShotReply sends prompt and streams the reply, or the rest of the last
+// reply if prompt is empty. A reply cut off by max_tokens is kept, and
+// errTruncated returned.
+func oneShotReply(cfg config, p provider, b *budget, conv *conversation, prompt string, r *retriever, tools *toolRegistry, out outputOptions) error {
+	// The messages are kept in conv.Messages too, so that a continuation
+	// is sent along with what it continues.
+	keep := func(msg openai.ChatCompletionMessage) error {
+		conv.Messages = append(conv.Messages, msg)
+		return conv.append(msg)
+	}
+
+	var (
+		reply    strings.Builder
+		messages []openai.ChatCompletionMessage
+		saveErr  error
+	)
+	if prompt == "" {
+		// The request alone asks to continue; the rest is saved as part of
+		// the same reply.
+		last := len(conv.Messages) - 1
+		if err := conv.truncate(last); err != nil {
+			return err
+		}
+		reply.WriteString(conv.Messages[last].Content)
+		messages = append(conv.Messages[:last+1:last+1], openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: continuePrompt,
+		})
+		conv.Messages = conv.Messages[:last]
+	} else {
+		if err := keep(openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: prompt,
+		}); err != nil {
+			return err
+		}
+		n := len(conv.Messages)
+		messages = conv.Messages[:n:n]
+	}
+
+	req, err := newChatRequest(cfg, detectPromptData(), messages)
+	if err != nil {
+		return err
+	}
+	ctx := withRetryNotice(context.Background(), func(s string) {
+		out.notef("%s\n", s)
+	})
+	ctx = withQueueStatus(ctx, func(position int) {
+		if position > 0 {
+			out.notef("Waiting for the rate limit (#%d in the queue)…\n", position)
+		}
+	})
+	if err := r.augment(ctx, &req); err != nil {
+		return err
+	}
+	_, summary, err := fitContext(ctx, cfg, p, conv, &req)
+	if summary.TotalTokens > 0 {
+		if err := b.record(cfg, cfg.summaryModel(), summary); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var firstToken time.Duration
+	result, err := chatWithTools(ctx, p, tools, req, chatEvents{
+		delta: func(delta string) {
+			if firstToken == 0 {
+				firstToken = time.Since(start)
+			}
+			if !out.json {
+				fmt.Print(delta)
+			}
+			if err := out.file.write(delta); err != nil && saveErr == nil {
+				saveErr = err
+			}
+			reply.WriteString(delta)
+		},
+		calling: func(calls []openai.ToolCall) {
+			if reply.Len() > 0 && !out.json && !out.quiet {
+				fmt.Println()
+			}
+			out.notef("%s...\n", tools.status(calls))
+		},
+		toolIO: toolIO{
+			confirm: confirmOnTerminal,
+			output: func(s string) {
+				out.notef("%s", s)
+			},
+		},
+		called: func(message openai.ChatCompletionMessage, results []openai.ChatCompletionMessage) {
+			reply.Reset()
+			for _, msg := range append([]openai.ChatCompletionMessage{message}, results...) {
+				if err := keep(msg); err != nil && saveErr == nil {
+					saveErr = err
+				}
+			}
+		},
+	})
+	truncated := errors.Is(err, errTruncated)
+	// A reply cut short by a timeout is kept as far as it got.
+	var timeout timeoutError
+	partial := errors.As(err, &timeout) && reply.Len() > 0
+	if !truncated {
+		if err := out.file.end(); err != nil && saveErr == nil {
+			saveErr = err
+		}
+	}
+	switch {
+	case out.json, out.quiet:
+	case truncated:
+		// Leave stdout as it is for the rest of the reply to follow.
+		fmt.Fprintln(os.Stderr)
+	default:
+		fmt.Println()
+	}
+	if err == nil || truncated {
+		err = saveErr
+	}
+	if err != nil && !partial {
+		return err
+	}
+	if result.usage.TotalTokens > 0 {
+		if err := b.record(cfg, cfg.Model, result.usage); err != nil {
+			return err
+		}
+	}
+	sent := prompt
+	if sent == "" {
+		sent = continuePrompt
+	}
+	if err := cfg.audit(newAuditEntry(cfg, conv, sent, reply.String(), start, result, err)); err != nil {
+		return err
+	}
+	if out.json {
+		o := oneShotOutput{
+			Message:      reply.String(),
+			Model:        result.model,
+			FinishReason: result.finish,
+			Usage: oneShotUsage{
+				PromptTokens:     result.usage.PromptTokens,
+				CompletionTokens: result.usage.CompletionTokens,
+				TotalTokens:      result.usage.TotalTokens,
+			},
+			Timings: oneShotTimings{
+				FirstToken: firstToken.Milliseconds(),
+				Total:      time.Since(start).Milliseconds(),
+			},
+			Fingerprint:  result.fingerprint,
+			Conversation: conv.ID,
+		}
+		if o.Model == "" {
+			o.Model = req.Model
+		}
+		if err != nil {
+			o.Error = err.Error()
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(o); err != nil {
+			return err
+		}
+	} else if cfg.Seed != nil && result.fingerprint != "" {
+		out.notef("seed %d, system_fingerprint %s\n", *cfg.Seed, result.fingerprint)
+	}
+
+	if err := keep(openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleAssistant,
+		Content: reply.String(),
+	}); err != nil {
+		return err
+	}
+	if truncated {
+		return errTruncated
+	}
+	return err
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// paletteSize is the number of actions the palette shows at once.
+const paletteSize = 10
+
+// paletteAction is an entry in the command palette.
+type paletteAction struct {
+	title string
+	run   func(m *model) tea.Cmd
+}
+
+// palette is a fuzzy-searchable list of everything the chat can do.
+type palette struct {
+	input   textinput.Model
+	actions []paletteAction
+	matches []paletteAction
+	cursor  int
+}
+
+func newPalette(actions []paletteAction) *palette {
+	input := textinput.New()
+	input.Prompt = "> "
+	input.Placeholder = "Type to search"
+	input.Focus()
+
+	p := &palette{input: input, actions: actions}
+	p.filter()
+	return p
+}
+
+// filter ranks the actions against the query, best match first.
+func (p *palette) filter() {
+	type scored struct {
+		action paletteAction
+		score  int
+	}
+
+	var results []scored
+	for _, action := range p.actions {
+		if score, ok := fuzzyMatch(p.input.Value(), action.title); ok {
+			results = append(results, scored{action, score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+
+	p.matches = p.matches[:0]
+	for _, result := range results {
+		p.matches = append(p.matches, result.action)
+	}
+	p.cursor = 0
+}
+
+// fuzzyMatch reports whether the letters of query appear in order in s,
+// scoring matches that are consecutive, start words or come early higher.
+func fuzzyMatch(query, s string) (int, bool) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	target := []rune(strings.ToLower(s))
+
+	score, pos, prev, first := 0, 0, -2, -1
+	for _, r := range query {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		for pos < len(target) && target[pos] != r {
+			pos++
+		}
+		if pos == len(target) {
+			return 0, false
+		}
+
+		if first < 0 {
+			first = pos
+		}
+		score++
+		if pos == prev+1 {
+			score += 2
+		}
+		if pos == 0 || !unicode.IsLetter(target[pos-1]) {
+			score += 3
+		}
+		prev = pos
+		pos++
+	}
+	return score*100 - first, true
+}
+
+// paletteActions lists everything the palette offers. Commands that need
+// arguments are typed into the input for completing.
+func (m *model) paletteActions() []paletteAction {
+	var actions []paletteAction
+	for _, name := range knownModels {
+		name := name
+		actions = append(actions, paletteAction{"Switch model: " + name, func(m *model) tea.Cmd {
+			m.switchModel([]string{name})
+			return nil
+		}})
+	}
+	for _, name := range m.config.profileNames() {
+		name := name
+		actions = append(actions, paletteAction{"Switch profile: " + name, func(m *model) tea.Cmd {
+			return m.switchProfile([]string{name})
+		}})
+	}
+	for _, name := range m.config.personaNames() {
+		name := name
+		actions = append(actions, paletteAction{"Switch persona: " + name, func(m *model) tea.Cmd {
+			return m.switchPersona([]string{name})
+		}})
+	}
+	if names, err := m.sessions.names(); err == nil && m.room == nil {
+		for _, name := range names {
+			name := name
+			actions = append(actions, paletteAction{"Load session: " + name, func(m *model) tea.Cmd {
+				m.loadSession([]string{name})
+				return nil
+			}})
+		}
+	}
+	if names, err := listTemplates(); err == nil {
+		for _, name := range names {
+			name := name
+			actions = append(actions, paletteAction{"Use template: " + name, func(m *model) tea.Cmd {
+				return m.useTemplate([]string{name})
+			}})
+		}
+	}
+
+	actions = append(actions,
+		paletteAction{"Toggle Markdown", func(m *model) tea.Cmd {
+			m.config.Markdown = !m.config.Markdown
+			m.refreshViewport()
+			return nil
+		}},
+		paletteAction{"Toggle reply details", func(m *model) tea.Cmd {
+			m.config.ReplyDetails = !m.config.ReplyDetails
+			m.refreshViewport()
+			return nil
+		}},
+		paletteAction{"Copy last reply", func(m *model) tea.Cmd {
+			m.copyLastReply()
+			return nil
+		}},
+		paletteAction{"Search the conversation", func(m *model) tea.Cmd {
+			m.openSearch()
+			return nil
+		}},
+	)
+
+	reply, _ := m.lastReply()
+	for i, block := range parseCodeBlocks(reply) {
+		n := strconv.Itoa(i + 1)
+		actions = append(actions,
+			paletteAction{fmt.Sprintf("Copy code block %s (%s)", n, describeCodeBlock(block)), func(m *model) tea.Cmd {
+				m.codeBlock([]string{n})
+				return nil
+			}},
+			paletteAction{fmt.Sprintf("Save code block %s (%s)", n, describeCodeBlock(block)), func(m *model) tea.Cmd {
+				m.textarea.SetValue("/code " + n + " ")
+				return nil
+			}},
+		)
+	}
+
+	for _, name := range commandNames() {
+		command := slashCommands[name]
+		if m.room != nil && roomlessCommands[name] {
+			continue
+		}
+		title := fmt.Sprintf("%s — %s", strings.TrimSpace(name+" "+command.usage), command.help)
+		if strings.HasPrefix(command.usage, "<") {
+			actions = append(actions, paletteAction{title, func(m *model) tea.Cmd {
+				m.textarea.SetValue(command.name + " ")
+				return nil
+			}})
+			continue
+		}
+		actions = append(actions, paletteAction{title, func(m *model) tea.Cmd {
+			return command.run(m, nil)
+		}})
+	}
+	return actions
+}
+
+// updatePalette handles keys while the palette is open.
+func (m *model) updatePalette(msg tea.KeyMsg) tea.Cmd {
+	p := m.palette
+	switch msg.String() {
+	case "esc", "ctrl+c":
+		m.palette = nil
+		return nil
+	case "enter":
+		m.palette = nil
+		if len(p.matches) == 0 {
+			return nil
+		}
+		m.err = nil
+		m.notice = ""
+		return p.matches[p.cursor].run(m)
+	case "up", "ctrl+p":
+		if p.cursor > 0 {
+			p.cursor--
+		}
+		return nil
+	case "down", "ctrl+n":
+		if p.cursor < len(p.matches)-1 {
+			p.cursor++
+		}
+		return nil
+	}
+
+	query := p.input.Value()
+	var cmd tea.Cmd
+	p.input, cmd = p.input.Update(msg)
+	if p.input.Value() != query {
+		p.filter()
+	}
+	return cmd
+}
+
+func (m model) paletteView() string {
+	p := m.palette
+	lines := []string{p.input.View(), ""}
+
+	// Keep the cursor in the window of actions shown.
+	start := 0
+	if p.cursor >= paletteSize {
+		start = p.cursor - paletteSize + 1
+	}
+	for i := start; i < len(p.matches) && i < start+paletteSize; i++ {
+		line := "  " + p.matches[i].title
+		if i == p.cursor {
+			line = m.styles.user.Render("> " + p.matches[i].title)
+		}
+		lines = append(lines, line)
+	}
+	if len(p.matches) == 0 {
+		lines = append(lines, "  No matches")
+	}
+
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(m.styles.footer.GetForeground()).
+		Padding(0, 1).
+		Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pasteAttachLines is the number of lines from which a paste is attached to
+// the next message instead of going into the input.
+const pasteAttachLines = 20
+
+// paste handles text pasted into the terminal, which arrives as one bracketed
+// paste rather than as keys, so that its new lines don't send it line by
+// line. Long pastes such as stack traces, and any that wouldn't fit within
+// the input limit, are attached to the next message like piped input.
+func (m *model) paste(text string) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	lines := strings.Count(strings.TrimRight(text, "\n"), "\n") + 1
+	fits := m.textarea.CharLimit == 0 || m.textarea.Length()+len([]rune(text)) <= m.textarea.CharLimit
+	if lines < pasteAttachLines && fits {
+		m.textarea.InsertString(text)
+		return
+	}
+
+	if m.attachment != "" {
+		m.attachment = strings.TrimRight(m.attachment, "\n") + "\n\n"
+	}
+	m.attachment += text
+	m.err = nil
+	m.notice = fmt.Sprintf("Attached %d pasted lines to your next message", lines)
+}
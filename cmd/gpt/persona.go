@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// persona is a named set of chat settings, such as a SQL expert with a
+// system prompt of its own. Unset fields keep the current settings.
+type persona struct {
+	Provider     string   `yaml:"provider"`
+	Model        string   `yaml:"model"`
+	Temperature  float32  `yaml:"temperature"`
+	MaxTokens    int      `yaml:"max_tokens"`
+	Stop         []string `yaml:"stop"`
+	SystemPrompt string   `yaml:"system_prompt"`
+	// Headers are added to the configured headers, e.g. for a gateway
+	// that only this persona goes through.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// applyPersona returns cfg with the named persona's settings.
+func applyPersona(cfg config, name string) (config, error) {
+	p, ok := cfg.Personas[name]
+	if !ok {
+		return cfg, fmt.Errorf("unknown persona %q", name)
+	}
+
+	if p.Provider != "" && p.Provider != cfg.Provider {
+		cfg.Provider = p.Provider
+		// The configured model most likely belongs to the other provider.
+		cfg.Model = defaultModels[p.Provider]
+	}
+	if p.Model != "" {
+		cfg.Model = p.Model
+	}
+	if p.Temperature != 0 {
+		cfg.Temperature = p.Temperature
+	}
+	if p.MaxTokens != 0 {
+		cfg.MaxTokens = p.MaxTokens
+	}
+	if len(p.Stop) > 0 {
+		cfg.Stop = p.Stop
+	}
+	if p.SystemPrompt != "" {
+		cfg.SystemPrompt = p.SystemPrompt
+	}
+	if len(p.Headers) > 0 {
+		cfg.Headers = mergeHeaders(cfg.Headers, p.Headers)
+	}
+	return cfg, nil
+}
+
+func (c config) personaNames() []string {
+	names := make([]string, 0, len(c.Personas))
+	for name := range c.Personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// switchPersona handles /persona. Without arguments it lists the personas.
+func (m *model) switchPersona(args []string) tea.Cmd {
+	if len(args) == 0 {
+		names := m.config.personaNames()
+		if len(names) == 0 {
+			m.notice = "No personas configured"
+			return nil
+		}
+		current := m.persona
+		if current == "" {
+			current = "none"
+		}
+		m.notice = fmt.Sprintf("Current persona: %s. Available: %s", current, strings.Join(names, ", "))
+		return nil
+	}
+
+	cfg, err := applyPersona(m.config, args[0])
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	if cfg.Provider != m.config.Provider || len(m.config.Personas[args[0]].Headers) > 0 {
+		prov, err := newProvider(cfg)
+		if err != nil {
+			m.err = err
+			return nil
+		}
+		m.provider = prov
+	}
+
+	m.config = cfg
+	m.persona = args[0]
+	m.tokens.setModel(cfg.Model)
+	m.notice = fmt.Sprintf("Switched to %s (%s)", args[0], cfg.Model)
+	return nil
+}
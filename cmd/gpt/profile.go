@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// profile is a named way of reaching a provider, such as work, personal or
+// local, with the model and system prompt to use there. Unset fields keep
+// the settings outside the profile.
+type profile struct {
+	Provider     string            `yaml:"provider"`
+	BaseURL      string            `yaml:"base_url"`
+	APIKey       string            `yaml:"api_key"`
+	APIKeyCmd    string            `yaml:"api_key_cmd"`
+	Organization string            `yaml:"organization"`
+	Project      string            `yaml:"project"`
+	Headers      map[string]string `yaml:"headers"`
+	Model        string            `yaml:"model"`
+	SystemPrompt string            `yaml:"system_prompt"`
+}
+
+// applyProfile returns cfg with the named profile's settings.
+func applyProfile(cfg config, name string) (config, error) {
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return cfg, fmt.Errorf("unknown profile %q", name)
+	}
+	cfg.Profile = name
+
+	if p.Provider != "" && p.Provider != cfg.Provider {
+		cfg.Provider = p.Provider
+		// The model and server most likely belong to the other provider.
+		cfg.Model = defaultModels[p.Provider]
+		cfg.BaseURL = ""
+	}
+	if p.BaseURL != "" {
+		cfg.BaseURL = p.BaseURL
+	}
+	if p.APIKey != "" || p.APIKeyCmd != "" {
+		// Either replaces whatever key was set before.
+		if key := cfg.apiKey(cfg.Provider); key != nil {
+			*key = p.APIKey
+		}
+		cfg.APIKeyCmd = p.APIKeyCmd
+	}
+	if p.Organization != "" {
+		cfg.Organization = p.Organization
+	}
+	if p.Project != "" {
+		cfg.Project = p.Project
+	}
+	if len(p.Headers) > 0 {
+		cfg.Headers = mergeHeaders(cfg.Headers, p.Headers)
+	}
+	if p.Model != "" {
+		cfg.Model = p.Model
+	}
+	if p.SystemPrompt != "" {
+		cfg.SystemPrompt = p.SystemPrompt
+	}
+	return cfg, nil
+}
+
+func (c config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// switchProfile handles /profile. Without arguments it lists the profiles.
+// Switching starts again from the config file, so that nothing carries over
+// from the last profile, and then puts back the persona and what was given
+// on the command line, as they were before.
+func (m *model) switchProfile(args []string) tea.Cmd {
+	if len(args) == 0 {
+		names := m.config.profileNames()
+		if len(names) == 0 {
+			m.notice = "No profiles configured"
+			return nil
+		}
+		current := m.config.Profile
+		if current == "" {
+			current = "none"
+		}
+		m.notice = fmt.Sprintf("Current profile: %s. Available: %s", current, strings.Join(names, ", "))
+		return nil
+	}
+
+	cfg, err := loadConfigFor(args[0])
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	if m.persona != "" {
+		if cfg, err = applyPersona(cfg, m.persona); err != nil {
+			m.err = err
+			return nil
+		}
+	}
+	cfg = globals.apply(cfg)
+	if err := applySampling(&cfg, m.sampling); err != nil {
+		m.err = err
+		return nil
+	}
+	cfg.fillDefaults()
+	prov, err := newProvider(cfg)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+
+	m.config = cfg
+	m.provider = prov
+	m.tokens.setModel(cfg.Model)
+	m.notice = fmt.Sprintf("Switched to the %s profile (%s)", args[0], cfg.Model)
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+)
+
+const defaultSystemPrompt = `You are a helpful assistant in a command-line chat. The user is on {{.GOOS}} using the {{.Shell}} shell, so tailor commands and instructions to that environment.`
+
+// promptData is available to system prompt templates.
+type promptData struct {
+	GOOS  string
+	Shell string
+}
+
+func renderPrompt(text string, data promptData) (string, error) {
+	tmpl, err := template.New("prompt").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
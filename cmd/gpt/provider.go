@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// chatStream yields the chunks of a streamed reply, returning io.EOF once it
+// is complete.
+type chatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
+
+// provider is a chat backend. Requests and replies use the OpenAI types,
+// which other backends translate to and from.
+type provider interface {
+	CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error)
+}
+
+// providerWrapper is a provider that adds to another, such as by logging its
+// requests.
+type providerWrapper interface {
+	unwrap() provider
+}
+
+// embedder is a provider that can also turn text into embedding vectors, one
+// for each input.
+type embedder interface {
+	CreateEmbeddings(ctx context.Context, model string, input []string) ([][]float32, error)
+}
+
+// defaultEmbeddingModels is the embedding model used for each provider that
+// has one when none is configured.
+var defaultEmbeddingModels = map[string]string{
+	"openai": string(openai.SmallEmbedding3),
+	"ollama": "nomic-embed-text",
+	"azure":  string(openai.SmallEmbedding3),
+}
+
+// newEmbedder returns the provider if it can embed text, looking beneath
+// the providers wrapped around it, which only chat.
+func newEmbedder(cfg config, p provider) (embedder, error) {
+	for {
+		w, ok := p.(providerWrapper)
+		if !ok {
+			break
+		}
+		p = w.unwrap()
+	}
+	e, ok := p.(embedder)
+	if !ok {
+		return nil, fmt.Errorf("the %s provider can't create embeddings", cfg.Provider)
+	}
+	return e, nil
+}
+
+// defaultModels is the model used for each provider when none is configured.
+var defaultModels = map[string]string{
+	"openai":    openai.GPT3Dot5Turbo,
+	"ollama":    "llama3",
+	"azure":     openai.GPT3Dot5Turbo,
+	"anthropic": "claude-sonnet-4-5",
+	"gemini":    "gemini-2.5-flash",
+	"mock":      "mock",
+}
+
+// newProvider returns the configured provider, held to the timeouts and rate
+// limits, logging and tracing if any are set, and counted for the metrics if
+// they are enabled.
+func newProvider(cfg config) (provider, error) {
+	p, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Timeouts.Read > 0 || cfg.Timeouts.Request > 0 {
+		p = timeoutProvider{provider: p, timeouts: cfg.Timeouts}
+	}
+	l, err := requestLogFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Inside the rate limiter, so that time spent queueing isn't counted
+	// against the provider.
+	if l != nil {
+		p = loggingProvider{provider: p, name: cfg.Provider, log: l}
+	}
+	if t := tracerFor(cfg); t != nil {
+		p = tracingProvider{provider: p, name: cfg.Provider, tracer: t}
+	}
+	if providerMetrics != nil {
+		p = metricsProvider{provider: p, name: cfg.Provider, metrics: providerMetrics}
+	}
+	if l := limiterFor(cfg); l != nil {
+		return rateLimitedProvider{provider: p, limiter: l}, nil
+	}
+	return p, nil
+}
+
+func newBackend(cfg config) (provider, error) {
+	cfg, err := cfg.withStoredKey()
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Provider {
+	case "openai":
+		client, err := cfg.newClient()
+		if err != nil {
+			return nil, err
+		}
+		return openaiProvider{client: client}, nil
+	case "ollama":
+		return newOllamaProvider(cfg)
+	case "azure":
+		return newAzureProvider(cfg)
+	case "anthropic":
+		return newAnthropicProvider(cfg)
+	case "gemini":
+		return newGeminiProvider(cfg)
+	case "mock":
+		return newMockProvider(cfg)
+	}
+	return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+}
+
+type openaiProvider struct {
+	client *openai.Client
+}
+
+func (p openaiProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	// Ask for token usage, which arrives in a final chunk with no choices.
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+
+	stream, err := p.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (p openaiProvider) CreateEmbeddings(ctx context.Context, model string, input []string) ([][]float32, error) {
+	return createEmbeddings(ctx, p.client, model, input)
+}
+
+// createEmbeddings embeds input with an OpenAI-compatible client.
+func createEmbeddings(ctx context.Context, client *openai.Client, model string, input []string) ([][]float32, error) {
+	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: input,
+		Model: openai.EmbeddingModel(model),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) != len(input) {
+		return nil, fmt.Errorf("got %d embeddings for %d inputs", len(resp.Data), len(input))
+	}
+
+	vectors := make([][]float32, len(input))
+	for _, data := range resp.Data {
+		if data.Index < 0 || data.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		vectors[data.Index] = data.Embedding
+	}
+	return vectors, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	defaultAnthropicURL       = "https://api.anthropic.com"
+	defaultAnthropicMaxTokens = 4096
+	anthropicVersion          = "2023-06-01"
+)
+
+type anthropicConfig struct {
+	APIKey    string `yaml:"api_key"`
+	MaxTokens int    `yaml:"max_tokens"`
+
+	// ThinkingBudget enables extended thinking with this many tokens.
+	ThinkingBudget int `yaml:"thinking_budget"`
+}
+
+// anthropicProvider talks to Claude through the Messages API.
+type anthropicProvider struct {
+	baseURL string
+	config  anthropicConfig
+	client  *http.Client
+}
+
+func newAnthropicProvider(cfg config) (anthropicProvider, error) {
+	if cfg.Anthropic.APIKey == "" {
+		return anthropicProvider{}, errors.New("anthropic: no API key configured; set anthropic.api_key or ANTHROPIC_API_KEY")
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = defaultAnthropicURL
+	}
+	client, err := cfg.httpClient()
+	if err != nil {
+		return anthropicProvider{}, err
+	}
+	return anthropicProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		config:  cfg.Anthropic,
+		client:  client,
+	}, nil
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	MaxTokens     int                `json:"max_tokens"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	Stream        bool               `json:"stream"`
+	Temperature   float32            `json:"temperature,omitempty"`
+	TopP          float32            `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Thinking      *anthropicThinking `json:"thinking,omitempty"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+func (p anthropicProvider) newRequest(req openai.ChatCompletionRequest) anthropicRequest {
+	body := anthropicRequest{
+		Model:         req.Model,
+		MaxTokens:     req.MaxTokens,
+		Stream:        true,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+	}
+	if body.MaxTokens == 0 {
+		body.MaxTokens = p.config.MaxTokens
+	}
+	if body.MaxTokens == 0 {
+		body.MaxTokens = defaultAnthropicMaxTokens
+	}
+	if p.config.ThinkingBudget > 0 {
+		body.Thinking = &anthropicThinking{
+			Type:         "enabled",
+			BudgetTokens: p.config.ThinkingBudget,
+		}
+	}
+
+	// System prompts go in a field of their own, and the API expects user and
+	// assistant turns to alternate.
+	var system []string
+	for _, msg := range req.Messages {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			system = append(system, msg.Content)
+			continue
+		}
+		if n := len(body.Messages); n > 0 && body.Messages[n-1].Role == msg.Role {
+			body.Messages[n-1].Content += "\n\n" + msg.Content
+			continue
+		}
+		body.Messages = append(body.Messages, anthropicMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
+	body.System = strings.Join(system, "\n\n")
+	return body
+}
+
+func (p anthropicProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	data, err := json.Marshal(p.newRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/v1/messages", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("X-Api-Key", p.config.APIKey)
+	httpReq.Header.Set("Anthropic-Version", anthropicVersion)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		var errResp struct {
+			Error anthropicError `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&errResp) == nil && errResp.Error.Message != "" {
+			return nil, fmt.Errorf("anthropic: %s", errResp.Error.Message)
+		}
+		return nil, fmt.Errorf("anthropic: %s", resp.Status)
+	}
+
+	return &anthropicStream{
+		body:   resp.Body,
+		events: newSSEReader(resp.Body),
+		model:  req.Model,
+	}, nil
+}
+
+// anthropicStream translates Messages API events into chat completion
+// chunks. Thinking is not shown; only the text of the reply is passed on.
+type anthropicStream struct {
+	body   io.ReadCloser
+	events *sseReader
+	model  string
+	usage  openai.Usage
+	done   bool
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		ID    string         `json:"id"`
+		Model string         `json:"model"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error anthropicError `json:"error"`
+}
+
+var anthropicStopReasons = map[string]string{
+	"end_turn":      "stop",
+	"stop_sequence": "stop",
+	"max_tokens":    "length",
+	"tool_use":      "tool_calls",
+}
+
+func (s *anthropicStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	for !s.done {
+		event, err := s.events.next()
+		if errors.Is(err, io.EOF) {
+			return openai.ChatCompletionStreamResponse{}, errors.New("anthropic: stream ended unexpectedly")
+		}
+		if err != nil {
+			return openai.ChatCompletionStreamResponse{}, err
+		}
+
+		var payload anthropicEvent
+		if err := json.Unmarshal([]byte(event.Data), &payload); err != nil {
+			return openai.ChatCompletionStreamResponse{}, err
+		}
+
+		switch payload.Type {
+		case "message_start":
+			if payload.Message.Model != "" {
+				s.model = payload.Message.Model
+			}
+			s.usage.PromptTokens = payload.Message.Usage.InputTokens
+		case "content_block_delta":
+			if payload.Delta.Type == "text_delta" {
+				return s.chunk(payload.Delta.Text, ""), nil
+			}
+		case "message_delta":
+			// The output token count here is cumulative.
+			s.usage.CompletionTokens = payload.Usage.OutputTokens
+			s.usage.TotalTokens = s.usage.PromptTokens + s.usage.CompletionTokens
+			if payload.Delta.StopReason != "" {
+				reason, ok := anthropicStopReasons[payload.Delta.StopReason]
+				if !ok {
+					reason = payload.Delta.StopReason
+				}
+				chunk := s.chunk("", reason)
+				usage := s.usage
+				chunk.Usage = &usage
+				return chunk, nil
+			}
+		case "message_stop":
+			s.done = true
+		case "error":
+			return openai.ChatCompletionStreamResponse{}, fmt.Errorf("anthropic: %s", payload.Error.Message)
+		}
+	}
+	return openai.ChatCompletionStreamResponse{}, io.EOF
+}
+
+func (s *anthropicStream) chunk(content, finishReason string) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{
+		Model: s.model,
+		Choices: []openai.ChatCompletionStreamChoice{
+			{
+				Delta: openai.ChatCompletionStreamChoiceDelta{
+					Content: content,
+				},
+				FinishReason: openai.FinishReason(finishReason),
+			},
+		},
+	}
+}
+
+func (s *anthropicStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const defaultAzureAPIVersion = "2024-06-01"
+
+type azureConfig struct {
+	Endpoint   string `yaml:"endpoint"`
+	APIKey     string `yaml:"api_key"`
+	APIVersion string `yaml:"api_version"`
+
+	// Deployment is used for models that have no entry in Deployments.
+	// If it is empty too, the model name is taken as the deployment name.
+	Deployment  string            `yaml:"deployment"`
+	Deployments map[string]string `yaml:"deployments"`
+}
+
+// azureProvider talks to Azure OpenAI, where each model is served from a
+// deployment of its own.
+type azureProvider struct {
+	config azureConfig
+	client *http.Client
+}
+
+func newAzureProvider(cfg config) (azureProvider, error) {
+	azure := cfg.Azure
+	if azure.Endpoint == "" {
+		azure.Endpoint = cfg.BaseURL
+	}
+	if azure.APIKey == "" {
+		azure.APIKey = cfg.APIKey
+	}
+	if azure.APIVersion == "" {
+		azure.APIVersion = defaultAzureAPIVersion
+	}
+
+	if azure.Endpoint == "" {
+		return azureProvider{}, errors.New("azure: no endpoint configured; set azure.endpoint or AZURE_OPENAI_ENDPOINT")
+	}
+	client, err := cfg.httpClient()
+	if err != nil {
+		return azureProvider{}, err
+	}
+	return azureProvider{
+		config: azure,
+		client: client,
+	}, nil
+}
+
+func (p azureProvider) deployment(model string) string {
+	if deployment, ok := p.config.Deployments[model]; ok {
+		return deployment
+	}
+	if p.config.Deployment != "" {
+		return p.config.Deployment
+	}
+	return model
+}
+
+func (p azureProvider) newClient() *openai.Client {
+	clientConfig := openai.DefaultAzureConfig(p.config.APIKey, p.config.Endpoint)
+	clientConfig.APIVersion = p.config.APIVersion
+	clientConfig.AzureModelMapperFunc = p.deployment
+	clientConfig.HTTPClient = p.client
+	return openai.NewClientWithConfig(clientConfig)
+}
+
+func (p azureProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	stream, err := p.newClient().CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (p azureProvider) CreateEmbeddings(ctx context.Context, model string, input []string) ([][]float32, error) {
+	return createEmbeddings(ctx, p.newClient(), model, input)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const defaultGeminiURL = "https://generativelanguage.googleapis.com"
+
+type geminiConfig struct {
+	APIKey string `yaml:"api_key"`
+
+	// Models maps short names to Gemini model names, e.g. flash to
+	// gemini-2.5-flash.
+	Models map[string]string `yaml:"models"`
+}
+
+// geminiProvider talks to Google's Gemini models through the Generative
+// Language API.
+type geminiProvider struct {
+	baseURL string
+	config  geminiConfig
+	client  *http.Client
+}
+
+func newGeminiProvider(cfg config) (geminiProvider, error) {
+	if cfg.Gemini.APIKey == "" {
+		return geminiProvider{}, errors.New("gemini: no API key configured; set gemini.api_key or GEMINI_API_KEY")
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = defaultGeminiURL
+	}
+	client, err := cfg.httpClient()
+	if err != nil {
+		return geminiProvider{}, err
+	}
+	return geminiProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		config:  cfg.Gemini,
+		client:  client,
+	}, nil
+}
+
+func (p geminiProvider) model(name string) string {
+	if mapped, ok := p.config.Models[name]; ok {
+		name = mapped
+	}
+	return strings.TrimPrefix(name, "models/")
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      float32  `json:"temperature,omitempty"`
+	TopP             float32  `json:"topP,omitempty"`
+	MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	PresencePenalty  float32  `json:"presencePenalty,omitempty"`
+	FrequencyPenalty float32  `json:"frequencyPenalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent        `json:"contents"`
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func newGeminiRequest(req openai.ChatCompletionRequest) geminiRequest {
+	body := geminiRequest{
+		GenerationConfig: geminiGenerationConfig{
+			Temperature:      req.Temperature,
+			TopP:             req.TopP,
+			MaxOutputTokens:  req.MaxTokens,
+			StopSequences:    req.Stop,
+			PresencePenalty:  req.PresencePenalty,
+			FrequencyPenalty: req.FrequencyPenalty,
+			Seed:             req.Seed,
+		},
+	}
+
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case openai.ChatMessageRoleSystem:
+			if body.SystemInstruction == nil {
+				body.SystemInstruction = &geminiContent{}
+			}
+			body.SystemInstruction.Parts = append(body.SystemInstruction.Parts, geminiPart{Text: msg.Content})
+		case openai.ChatMessageRoleAssistant:
+			body.Contents = append(body.Contents, geminiContent{
+				Role:  "model",
+				Parts: []geminiPart{{Text: msg.Content}},
+			})
+		default:
+			body.Contents = append(body.Contents, geminiContent{
+				Role:  "user",
+				Parts: []geminiPart{{Text: msg.Content}},
+			})
+		}
+	}
+	return body
+}
+
+func (p geminiProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	data, err := json.Marshal(newGeminiRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	model := p.model(req.Model)
+	endpoint := fmt.Sprintf("%s/v1beta/models/%s:streamGenerateContent?alt=sse", p.baseURL, url.PathEscape(model))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Goog-Api-Key", p.config.APIKey)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		var errResp geminiError
+		if json.NewDecoder(resp.Body).Decode(&errResp) == nil && errResp.Error.Message != "" {
+			return nil, fmt.Errorf("gemini: %s", errResp.Error.Message)
+		}
+		return nil, fmt.Errorf("gemini: %s", resp.Status)
+	}
+
+	return &geminiStream{
+		body:   resp.Body,
+		events: newSSEReader(resp.Body),
+		model:  model,
+	}, nil
+}
+
+// geminiStream translates streamGenerateContent responses into chat
+// completion chunks. The stream simply ends after the last response.
+type geminiStream struct {
+	body   io.ReadCloser
+	events *sseReader
+	model  string
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+	} `json:"usageMetadata"`
+	ModelVersion string `json:"modelVersion"`
+}
+
+var geminiFinishReasons = map[string]string{
+	"STOP":       "stop",
+	"MAX_TOKENS": "length",
+	"SAFETY":     "content_filter",
+	"RECITATION": "content_filter",
+}
+
+func (s *geminiStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	event, err := s.events.next()
+	if err != nil {
+		return openai.ChatCompletionStreamResponse{}, err
+	}
+
+	var payload geminiResponse
+	if err := json.Unmarshal([]byte(event.Data), &payload); err != nil {
+		return openai.ChatCompletionStreamResponse{}, err
+	}
+
+	model := s.model
+	if payload.ModelVersion != "" {
+		model = payload.ModelVersion
+	}
+	response := openai.ChatCompletionStreamResponse{Model: model}
+	if usage := payload.UsageMetadata; usage != nil {
+		// Thinking is billed as output.
+		completion := usage.CandidatesTokenCount + usage.ThoughtsTokenCount
+		response.Usage = &openai.Usage{
+			PromptTokens:     usage.PromptTokenCount,
+			CompletionTokens: completion,
+			TotalTokens:      usage.PromptTokenCount + completion,
+		}
+	}
+	for i, candidate := range payload.Candidates {
+		var text strings.Builder
+		for _, part := range candidate.Content.Parts {
+			text.WriteString(part.Text)
+		}
+
+		reason := candidate.FinishReason
+		if mapped, ok := geminiFinishReasons[reason]; ok {
+			reason = mapped
+		}
+		response.Choices = append(response.Choices, openai.ChatCompletionStreamChoice{
+			Index: i,
+			Delta: openai.ChatCompletionStreamChoiceDelta{
+				Content: text.String(),
+			},
+			FinishReason: openai.FinishReason(strings.ToLower(reason)),
+		})
+	}
+	return response, nil
+}
+
+func (s *geminiStream) Close() error {
+	return s.body.Close()
+}
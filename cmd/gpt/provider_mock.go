@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultMockDelay is the time between the words of a mock reply.
+const defaultMockDelay = 20 * time.Millisecond
+
+type mockConfig struct {
+	// Replies answer the messages they match, the first that does.
+	Replies []mockReply `yaml:"replies"`
+	// Fixtures is a YAML file of more replies, tried after Replies.
+	Fixtures string `yaml:"fixtures"`
+	// Delay is the time between the words of a reply, 20ms unless set.
+	Delay *time.Duration `yaml:"delay"`
+}
+
+// mockReply is a canned reply of the mock provider.
+type mockReply struct {
+	// Match is looked for in the last message; a reply without one
+	// matches any.
+	Match string `yaml:"match"`
+	Reply string `yaml:"reply"`
+}
+
+// mockProvider answers without any network, with canned replies or else
+// lorem ipsum, streamed a word at a time. The same request always gets the
+// same reply, so that it can stand in for a real provider in tests and
+// while working on templates, themes and keys offline.
+type mockProvider struct {
+	replies []mockReply
+	delay   time.Duration
+}
+
+func newMockProvider(cfg config) (mockProvider, error) {
+	p := mockProvider{replies: cfg.Mock.Replies, delay: defaultMockDelay}
+	if cfg.Mock.Delay != nil {
+		p.delay = *cfg.Mock.Delay
+	}
+	if cfg.Mock.Fixtures != "" {
+		data, err := os.ReadFile(cfg.Mock.Fixtures)
+		if err != nil {
+			return mockProvider{}, fmt.Errorf("mock: %w", err)
+		}
+		var fixtures []mockReply
+		if err := yaml.Unmarshal(data, &fixtures); err != nil {
+			return mockProvider{}, fmt.Errorf("mock: %s: %w", cfg.Mock.Fixtures, err)
+		}
+		p.replies = append(p.replies, fixtures...)
+	}
+	return p, nil
+}
+
+func (p mockProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	var last string
+	if n := len(req.Messages); n > 0 {
+		last = req.Messages[n-1].Content
+	}
+	prompt := 0
+	for _, msg := range req.Messages {
+		prompt += tokensPerMessage + estimateTokens(msg.Content)
+	}
+
+	return &mockStream{
+		ctx:       ctx,
+		model:     req.Model,
+		words:     strings.SplitAfter(p.reply(req, last), " "),
+		delay:     p.delay,
+		maxTokens: req.MaxTokens,
+		usage:     openai.Usage{PromptTokens: prompt + tokensPerReply},
+	}, nil
+}
+
+// reply is the first canned reply matching the last message, or else lorem
+// ipsum seeded by it.
+func (p mockProvider) reply(req openai.ChatCompletionRequest, last string) string {
+	for _, r := range p.replies {
+		if strings.Contains(last, r.Match) {
+			return r.Reply
+		}
+	}
+	if f := req.ResponseFormat; f != nil && f.Type != openai.ChatCompletionResponseFormatTypeText {
+		return "{}"
+	}
+	return lorem(last)
+}
+
+// loremWords are what mock replies are made of when nothing else is given.
+var loremWords = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing
+elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua enim ad
+minim veniam quis nostrud exercitation ullamco laboris nisi aliquip ex ea
+commodo consequat duis aute irure in reprehenderit voluptate velit esse cillum
+fugiat nulla pariatur excepteur sint occaecat cupidatat non proident sunt culpa
+qui officia deserunt mollit anim id est laborum`)
+
+// lorem returns a few sentences of lorem ipsum, the same for the same seed.
+func lorem(seed string) string {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	state := h.Sum64() | 1
+	next := func(n int) int {
+		// xorshift, which is enough to vary the words.
+		state ^= state << 13
+		state ^= state >> 7
+		state ^= state << 17
+		return int(state % uint64(n))
+	}
+
+	var sentences []string
+	for i := 2 + next(4); i > 0; i-- {
+		words := make([]string, 6+next(10))
+		for j := range words {
+			words[j] = loremWords[next(len(loremWords))]
+		}
+		sentence := strings.Join(words, " ")
+		sentences = append(sentences, strings.ToUpper(sentence[:1])+sentence[1:]+".")
+	}
+	return strings.Join(sentences, " ")
+}
+
+// mockStream streams a mock reply a word at a time, ending with its usage.
+type mockStream struct {
+	ctx       context.Context
+	model     string
+	words     []string
+	delay     time.Duration
+	maxTokens int
+	usage     openai.Usage
+	done      bool
+}
+
+func (s *mockStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if s.done {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	if err := s.wait(); err != nil {
+		return openai.ChatCompletionStreamResponse{}, err
+	}
+
+	response := openai.ChatCompletionStreamResponse{Model: s.model}
+	choice := openai.ChatCompletionStreamChoice{}
+	switch {
+	case len(s.words) == 0:
+		choice.FinishReason = openai.FinishReasonStop
+	case s.maxTokens > 0 && s.usage.CompletionTokens+estimateTokens(s.words[0]) > s.maxTokens:
+		choice.FinishReason = openai.FinishReasonLength
+	default:
+		choice.Delta.Content = s.words[0]
+		s.words = s.words[1:]
+		s.usage.CompletionTokens += estimateTokens(choice.Delta.Content)
+		response.Choices = []openai.ChatCompletionStreamChoice{choice}
+		return response, nil
+	}
+	s.done = true
+	s.usage.TotalTokens = s.usage.PromptTokens + s.usage.CompletionTokens
+	usage := s.usage
+	response.Choices = []openai.ChatCompletionStreamChoice{choice}
+	response.Usage = &usage
+	return response, nil
+}
+
+// wait holds the next word back for the delay, unless the request is given
+// up on first.
+func (s *mockStream) wait() error {
+	if s.delay <= 0 {
+		return s.ctx.Err()
+	}
+	t := time.NewTimer(s.delay)
+	defer t.Stop()
+	select {
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (s *mockStream) Close() error {
+	s.done = true
+	return nil
+}
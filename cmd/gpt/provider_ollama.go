@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const defaultOllamaURL = "http://localhost:11434"
+
+// ollamaProvider talks to a local Ollama server using its native chat API.
+type ollamaProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newOllamaProvider(cfg config) (ollamaProvider, error) {
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = defaultOllamaURL
+		if host := os.Getenv("OLLAMA_HOST"); host != "" {
+			baseURL = host
+			if !strings.Contains(baseURL, "://") {
+				baseURL = "http://" + baseURL
+			}
+		}
+	}
+
+	client, err := cfg.httpClient()
+	if err != nil {
+		return ollamaProvider{}, err
+	}
+	return ollamaProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+	}, nil
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  map[string]any  `json:"options,omitempty"`
+	// Format is "json" or a JSON Schema the reply must follow.
+	Format any `json:"format,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Model      string        `json:"model"`
+	Message    ollamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason"`
+	Error      string        `json:"error"`
+
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+func (p ollamaProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	body := ollamaChatRequest{
+		Model:   req.Model,
+		Stream:  true,
+		Options: map[string]any{},
+	}
+	for _, msg := range req.Messages {
+		body.Messages = append(body.Messages, ollamaMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
+	if req.Temperature != 0 {
+		body.Options["temperature"] = req.Temperature
+	}
+	if req.TopP != 0 {
+		body.Options["top_p"] = req.TopP
+	}
+	if req.MaxTokens != 0 {
+		body.Options["num_predict"] = req.MaxTokens
+	}
+	if req.PresencePenalty != 0 {
+		body.Options["presence_penalty"] = req.PresencePenalty
+	}
+	if req.FrequencyPenalty != 0 {
+		body.Options["frequency_penalty"] = req.FrequencyPenalty
+	}
+	if len(req.Stop) > 0 {
+		body.Options["stop"] = req.Stop
+	}
+	if req.Seed != nil {
+		body.Options["seed"] = *req.Seed
+	}
+	if f := req.ResponseFormat; f != nil {
+		switch {
+		case f.JSONSchema != nil:
+			body.Format = f.JSONSchema.Schema
+		case f.Type == openai.ChatCompletionResponseFormatTypeJSONObject:
+			body.Format = "json"
+		}
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/api/chat", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		var errResp ollamaChatResponse
+		if json.NewDecoder(resp.Body).Decode(&errResp) == nil && errResp.Error != "" {
+			return nil, fmt.Errorf("ollama: %s", errResp.Error)
+		}
+		return nil, fmt.Errorf("ollama: %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1024*1024)
+	return &ollamaStream{body: resp.Body, scanner: scanner}, nil
+}
+
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+	Error      string      `json:"error"`
+}
+
+func (p ollamaProvider) CreateEmbeddings(ctx context.Context, model string, input []string) ([][]float32, error) {
+	data, err := json.Marshal(ollamaEmbedRequest{Model: model, Input: input})
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/api/embed", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body ollamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+	switch {
+	case body.Error != "":
+		return nil, fmt.Errorf("ollama: %s", body.Error)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("ollama: %s", resp.Status)
+	case len(body.Embeddings) != len(input):
+		return nil, fmt.Errorf("ollama: got %d embeddings for %d inputs", len(body.Embeddings), len(input))
+	}
+	return body.Embeddings, nil
+}
+
+// ollamaStream reads Ollama's newline-delimited JSON responses.
+type ollamaStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	done    bool
+}
+
+func (s *ollamaStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if s.done {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+
+	for s.scanner.Scan() {
+		line := bytes.TrimSpace(s.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return openai.ChatCompletionStreamResponse{}, err
+		}
+		if chunk.Error != "" {
+			return openai.ChatCompletionStreamResponse{}, fmt.Errorf("ollama: %s", chunk.Error)
+		}
+
+		choice := openai.ChatCompletionStreamChoice{
+			Delta: openai.ChatCompletionStreamChoiceDelta{
+				Content: chunk.Message.Content,
+			},
+		}
+		response := openai.ChatCompletionStreamResponse{
+			Model: chunk.Model,
+		}
+		if chunk.Done {
+			s.done = true
+			choice.FinishReason = openai.FinishReason(chunk.DoneReason)
+			if choice.FinishReason == "" {
+				choice.FinishReason = openai.FinishReasonStop
+			}
+			response.Usage = &openai.Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+			}
+		}
+		response.Choices = []openai.ChatCompletionStreamChoice{choice}
+		return response, nil
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return openai.ChatCompletionStreamResponse{}, err
+	}
+	if !s.done {
+		return openai.ChatCompletionStreamResponse{}, errors.New("ollama: stream ended unexpectedly")
+	}
+	return openai.ChatCompletionStreamResponse{}, io.EOF
+}
+
+func (s *ollamaStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// replyProvider answers every request with the same reply, in one piece.
+type replyProvider string
+
+func (p replyProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	return &scriptedStream{responses: []openai.ChatCompletionStreamResponse{{
+		Choices: []openai.ChatCompletionStreamChoice{{
+			Delta:        openai.ChatCompletionStreamChoiceDelta{Content: string(p)},
+			FinishReason: openai.FinishReasonStop,
+		}},
+		Usage: &openai.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}}}, nil
+}
+
+// scriptedStream sends the responses it is given.
+type scriptedStream struct {
+	responses []openai.ChatCompletionStreamResponse
+}
+
+func (s *scriptedStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if len(s.responses) == 0 {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	response := s.responses[0]
+	s.responses = s.responses[1:]
+	return response, nil
+}
+
+func (s *scriptedStream) Close() error { return nil }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// rateLimitConfig caps what is sent to the provider, for API keys with low
+// limits. Zero leaves a limit off.
+type rateLimitConfig struct {
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	TokensPerMinute   int `yaml:"tokens_per_minute"`
+}
+
+// rateLimiter holds requests back, first come first served, until they fit
+// in the limits for the last minute. Tokens are estimated before sending and
+// count the most the reply may use, as the providers count them.
+type rateLimiter struct {
+	limits rateLimitConfig
+
+	mu      sync.Mutex
+	sent    []sentRequest
+	waiting []*int
+	// changed is closed and replaced whenever a request leaves the queue.
+	changed chan struct{}
+}
+
+type sentRequest struct {
+	at     time.Time
+	tokens int
+}
+
+var (
+	limitersMu sync.Mutex
+	// limiters are shared by every use of a provider, so that switching
+	// models or personas doesn't start the count again.
+	limiters = make(map[string]*rateLimiter)
+)
+
+// limiterFor returns the limiter for the configured provider, or nil if no
+// limits are set.
+func limiterFor(cfg config) *rateLimiter {
+	if cfg.RateLimit.RequestsPerMinute <= 0 && cfg.RateLimit.TokensPerMinute <= 0 {
+		return nil
+	}
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	l, ok := limiters[cfg.Provider]
+	if !ok {
+		l = &rateLimiter{changed: make(chan struct{})}
+		limiters[cfg.Provider] = l
+	}
+	l.mu.Lock()
+	l.limits = cfg.RateLimit
+	l.mu.Unlock()
+	return l
+}
+
+type queueStatusKey struct{}
+
+// withQueueStatus returns ctx with status, which is told a request's place
+// in the queue whenever it changes while it is held back, and 0 once it is
+// sent.
+func withQueueStatus(ctx context.Context, status func(position int)) context.Context {
+	return context.WithValue(ctx, queueStatusKey{}, status)
+}
+
+// wait blocks until a request of tokens may be sent.
+func (l *rateLimiter) wait(ctx context.Context, tokens int) error {
+	status, _ := ctx.Value(queueStatusKey{}).(func(int))
+	reported := 0
+	report := func(position int) {
+		if status != nil && position != reported {
+			status(position)
+			reported = position
+		}
+	}
+	defer report(0)
+
+	ticket := new(int)
+	l.mu.Lock()
+	l.waiting = append(l.waiting, ticket)
+	for {
+		now := time.Now()
+		position := l.position(ticket)
+		var delay time.Duration
+		if position == 0 {
+			if delay = l.delay(now, tokens); delay == 0 {
+				l.sent = append(l.sent, sentRequest{at: now, tokens: tokens})
+				l.leave(ticket)
+				l.mu.Unlock()
+				return nil
+			}
+		}
+		changed := l.changed
+		l.mu.Unlock()
+		report(position + 1)
+
+		// Those behind the first wait for it to go; the first waits for
+		// the limits to allow it.
+		var timeout <-chan time.Time
+		if delay > 0 {
+			timeout = time.After(delay)
+		}
+		select {
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.leave(ticket)
+			l.mu.Unlock()
+			return ctx.Err()
+		case <-changed:
+		case <-timeout:
+		}
+		l.mu.Lock()
+	}
+}
+
+func (l *rateLimiter) position(ticket *int) int {
+	for i, t := range l.waiting {
+		if t == ticket {
+			return i
+		}
+	}
+	return -1
+}
+
+// leave takes ticket out of the queue, letting the rest move up.
+func (l *rateLimiter) leave(ticket *int) {
+	if i := l.position(ticket); i >= 0 {
+		l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// delay returns how long until a request of tokens fits in the limits, once
+// the requests older than a minute are forgotten.
+func (l *rateLimiter) delay(now time.Time, tokens int) time.Duration {
+	for len(l.sent) > 0 && now.Sub(l.sent[0].at) >= time.Minute {
+		l.sent = l.sent[1:]
+	}
+
+	var delay time.Duration
+	until := func(r sentRequest) {
+		if d := r.at.Add(time.Minute).Sub(now); d > delay {
+			delay = d
+		}
+	}
+	if rpm := l.limits.RequestsPerMinute; rpm > 0 && len(l.sent) >= rpm {
+		until(l.sent[len(l.sent)-rpm])
+	}
+	if tpm := l.limits.TokensPerMinute; tpm > 0 {
+		used := 0
+		for _, r := range l.sent {
+			used += r.tokens
+		}
+		// A request bigger than the limit goes once nothing else counts.
+		for i := 0; used+tokens > tpm && i < len(l.sent); i++ {
+			used -= l.sent[i].tokens
+			until(l.sent[i])
+		}
+	}
+	return delay
+}
+
+// rateLimitedProvider holds requests back to keep within the limits.
+type rateLimitedProvider struct {
+	provider
+	limiter *rateLimiter
+}
+
+func (p rateLimitedProvider) unwrap() provider { return p.provider }
+
+func (p rateLimitedProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	tokens := tokensPerReply + req.MaxTokens
+	for _, msg := range req.Messages {
+		tokens += tokensPerMessage + estimateTokens(msg.Content)
+	}
+	if err := p.limiter.wait(ctx, tokens); err != nil {
+		return nil, err
+	}
+	return p.provider.CreateChatCompletionStream(ctx, req)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterDelay(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(seconds int) time.Time {
+		return now.Add(-time.Duration(seconds) * time.Second)
+	}
+	tests := []struct {
+		name   string
+		limits rateLimitConfig
+		sent   []sentRequest
+		tokens int
+		want   time.Duration
+	}{
+		{
+			name:   "nothing sent",
+			limits: rateLimitConfig{RequestsPerMinute: 1, TokensPerMinute: 100},
+			tokens: 100,
+		},
+		{
+			name:   "under the request limit",
+			limits: rateLimitConfig{RequestsPerMinute: 3},
+			sent:   []sentRequest{{at: ago(10)}, {at: ago(5)}},
+		},
+		{
+			name:   "at the request limit",
+			limits: rateLimitConfig{RequestsPerMinute: 2},
+			sent:   []sentRequest{{at: ago(50)}, {at: ago(20)}, {at: ago(5)}},
+			want:   40 * time.Second,
+		},
+		{
+			name:   "older than a minute is forgotten",
+			limits: rateLimitConfig{RequestsPerMinute: 1},
+			sent:   []sentRequest{{at: ago(60)}, {at: ago(90)}},
+		},
+		{
+			name:   "under the token limit",
+			limits: rateLimitConfig{TokensPerMinute: 100},
+			sent:   []sentRequest{{at: ago(30), tokens: 40}},
+			tokens: 60,
+		},
+		{
+			name:   "over the token limit",
+			limits: rateLimitConfig{TokensPerMinute: 100},
+			sent:   []sentRequest{{at: ago(45), tokens: 30}, {at: ago(30), tokens: 40}, {at: ago(10), tokens: 20}},
+			tokens: 50,
+			want:   30 * time.Second,
+		},
+		{
+			name:   "bigger than the token limit",
+			limits: rateLimitConfig{TokensPerMinute: 100},
+			sent:   []sentRequest{{at: ago(50), tokens: 10}, {at: ago(20), tokens: 10}},
+			tokens: 500,
+			want:   40 * time.Second,
+		},
+		{
+			name:   "the longer of the two",
+			limits: rateLimitConfig{RequestsPerMinute: 2, TokensPerMinute: 100},
+			sent:   []sentRequest{{at: ago(50), tokens: 5}, {at: ago(15), tokens: 90}},
+			tokens: 20,
+			want:   45 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &rateLimiter{limits: tt.limits, sent: tt.sent}
+			if got := l.delay(now, tt.tokens); got != tt.want {
+				t.Errorf("delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := &rateLimiter{limits: rateLimitConfig{RequestsPerMinute: 1}, changed: make(chan struct{})}
+	if err := l.wait(context.Background(), 10); err != nil {
+		t.Fatalf("first wait() = %v", err)
+	}
+
+	// The second request has to wait the rest of the minute, so it is
+	// still queued when given up on.
+	var positions []int
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ctx = withQueueStatus(ctx, func(position int) {
+		positions = append(positions, position)
+	})
+	if err := l.wait(ctx, 10); err != context.DeadlineExceeded {
+		t.Fatalf("second wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(positions) != 2 || positions[0] != 1 || positions[1] != 0 {
+		t.Errorf("queue positions = %v, want [1 0]", positions)
+	}
+	if len(l.waiting) != 0 || len(l.sent) != 1 {
+		t.Errorf("after giving up, %d waiting and %d sent, want 0 and 1", len(l.waiting), len(l.sent))
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+)
+
+// messageRenderer turns assistant messages into styled text for the viewport.
+// Rendered Markdown of finished messages is cached so that only the message
+// being streamed is re-rendered on every delta.
+type messageRenderer struct {
+	dark      bool
+	codeTheme string
+	width     int
+	term      *glamour.TermRenderer
+	cache     map[string]string
+}
+
+func newMessageRenderer(width int, t theme) *messageRenderer {
+	r := &messageRenderer{
+		dark:      t.Markdown != "light",
+		codeTheme: t.Code,
+	}
+	r.setWidth(width)
+	return r
+}
+
+func (r *messageRenderer) setWidth(width int) {
+	if width == r.width && r.term != nil {
+		return
+	}
+
+	styles := glamour.DarkStyleConfig
+	if !r.dark {
+		styles = glamour.LightStyleConfig
+	}
+	if r.codeTheme != "" {
+		styles.CodeBlock.Theme = r.codeTheme
+		styles.CodeBlock.Chroma = nil
+	}
+
+	term, err := glamour.NewTermRenderer(
+		glamour.WithStyles(styles),
+		glamour.WithWordWrap(width),
+	)
+	if err != nil {
+		return
+	}
+	r.width = width
+	r.term = term
+	r.cache = make(map[string]string)
+}
+
+// renderMarkdown returns the Markdown rendering of content, falling back to
+// the raw text if it can't be rendered. Only final content should be cached.
+func (r *messageRenderer) renderMarkdown(content string, cache bool) string {
+	if out, ok := r.cache[content]; ok {
+		return out
+	}
+	if r.term == nil {
+		return content
+	}
+
+	out, err := r.term.Render(tagCodeBlocks(content))
+	if err != nil {
+		return content
+	}
+	out = strings.Trim(out, "\n")
+
+	if cache {
+		r.cache[content] = out
+	}
+	return out
+}
+
+// renderPlain returns content as is, apart from highlighting code blocks.
+func (r *messageRenderer) renderPlain(content string) string {
+	theme := r.codeTheme
+	if theme == "" {
+		theme = "monokai"
+		if !r.dark {
+			theme = "friendly"
+		}
+	}
+	return highlightCodeBlocks(content, theme)
+}
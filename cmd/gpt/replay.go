@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	// replayPause is the wait before a message when it isn't known how long
+	// it took, and replayMaxPause the longest wait before one that is.
+	replayPause    = time.Second
+	replayMaxPause = 3 * time.Second
+	// replayPace is the time between the words of a reply when it isn't
+	// known how long it took to stream, and replayMaxReply the longest a
+	// reply that is known takes.
+	replayPace     = 30 * time.Millisecond
+	replayMaxReply = 30 * time.Second
+)
+
+// runReplay implements the replay subcommand, which plays a saved session or
+// stored conversation back in the chat, the replies streaming in as they did.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "play back this many times as fast")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: gpt replay [-speed n] <session or conversation ID>\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *speed <= 0 {
+		return errors.New("-speed must be more than 0")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	r, err := loadReplay(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if r.modelName != "" {
+		cfg.Model = r.modelName
+	}
+	cfg.fillDefaults()
+
+	m, err := newChatModel(cfg, nil, &conversation{ID: r.title}, "")
+	if err != nil {
+		return err
+	}
+	// The tools aren't run again, but their results are summed up as they
+	// were.
+	m.tools = &toolRegistry{tools: make(map[string]tool)}
+	if err := m.tools.addAgentTools(); err != nil {
+		return err
+	}
+	m.textarea.Blur()
+	m.textarea.Placeholder = "space pauses · → skips ahead · + and - change the speed · q quits"
+	r.model = m
+	r.speed = *speed
+	r.describe()
+
+	_, err = tea.NewProgram(r).Run()
+	return err
+}
+
+// replayModel is the chat, playing back a transcript rather than taking
+// input.
+type replayModel struct {
+	model
+
+	title     string
+	modelName string
+	steps     []replayStep
+	// next is the step being played, and pieces the rest of the reply
+	// streaming in it.
+	next   int
+	pieces []string
+
+	speed  float64
+	paused bool
+	// stalled is whether a step came due while paused, to be played once
+	// the replay goes on.
+	stalled bool
+}
+
+// replayStep is a message of the transcript and its timing.
+type replayStep struct {
+	message openai.ChatCompletionMessage
+	// wait is how long before the message starts, and pace how long
+	// between the pieces of a reply.
+	wait time.Duration
+	pace time.Duration
+}
+
+type replayTickMsg struct{}
+
+// loadReplay looks name up as a saved session first and then as a
+// conversation ID, as gpt export does. Only conversations know when each
+// message was written.
+func loadReplay(name string) (replayModel, error) {
+	sessions, err := newSessionStore()
+	if err != nil {
+		return replayModel{}, err
+	}
+	sess, err := sessions.load(name)
+	if err == nil {
+		return replayModel{
+			title:     sess.Name,
+			modelName: sess.Model,
+			steps:     replaySteps(sess.Messages, nil),
+		}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return replayModel{}, err
+	}
+
+	store, err := newHistoryStore()
+	if err != nil {
+		return replayModel{}, err
+	}
+	info, err := store.info(name)
+	if err != nil {
+		return replayModel{}, err
+	}
+	_, entries, err := store.read(info.ID)
+	if err != nil {
+		return replayModel{}, err
+	}
+	messages := make([]openai.ChatCompletionMessage, len(entries))
+	times := make([]time.Time, len(entries))
+	for i, entry := range entries {
+		messages[i] = entry.message()
+		times[i] = entry.Time
+	}
+	title := info.Title
+	if title == "" {
+		title = info.ID
+	}
+	return replayModel{title: title, steps: replaySteps(messages, times)}, nil
+}
+
+// replaySteps times the messages by when they were written, if that is
+// known. Messages written together, as when a session is continued, are
+// paced as if it weren't.
+func replaySteps(messages []openai.ChatCompletionMessage, times []time.Time) []replayStep {
+	steps := make([]replayStep, 0, len(messages))
+	for i, message := range messages {
+		step := replayStep{message: message, wait: replayPause, pace: replayPace}
+		gap := time.Duration(-1)
+		if times != nil && i > 0 {
+			gap = times[i].Sub(times[i-1])
+		}
+
+		switch message.Role {
+		case openai.ChatMessageRoleSystem:
+			step.wait = 0
+		case openai.ChatMessageRoleUser:
+			if gap >= 0 {
+				step.wait = min(gap, replayMaxPause)
+			}
+		case openai.ChatMessageRoleTool:
+			// The tools ran before the call was written, so the results
+			// follow it at once.
+			step.wait = replayPace
+		case openai.ChatMessageRoleAssistant:
+			step.wait = 10 * replayPace
+			if gap > 100*time.Millisecond && message.Content != "" {
+				// The first piece takes as long as the rest.
+				pieces := len(replayPieces(message.Content)) + 1
+				step.pace = min(max(min(gap, replayMaxReply)/time.Duration(pieces), 5*time.Millisecond), 250*time.Millisecond)
+				step.wait = step.pace
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// replayPieces splits a reply into the words it streams in by.
+func replayPieces(content string) []string {
+	return strings.SplitAfter(content, " ")
+}
+
+func (r replayModel) Init() tea.Cmd {
+	if len(r.steps) == 0 {
+		return nil
+	}
+	return r.tick(r.steps[0].wait)
+}
+
+// tick plays the next step, or piece of a reply, after d at the replay's
+// speed.
+func (r replayModel) tick(d time.Duration) tea.Cmd {
+	return tea.Tick(time.Duration(float64(d)/r.speed), func(time.Time) tea.Msg {
+		return replayTickMsg{}
+	})
+}
+
+func (r replayModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, r.keys.Quit), msg.String() == "q", msg.String() == "esc":
+			return r, tea.Quit
+		case msg.String() == " ":
+			r.paused = !r.paused
+			r.describe()
+			if !r.paused && r.stalled {
+				r.stalled = false
+				return r, r.tick(0)
+			}
+			return r, nil
+		case msg.String() == "right":
+			r.skip()
+			return r, nil
+		case msg.String() == "+" || msg.String() == "=":
+			r.speed = min(r.speed*2, 16)
+			r.describe()
+			return r, nil
+		case msg.String() == "-":
+			r.speed = max(r.speed/2, 0.25)
+			r.describe()
+			return r, nil
+		case key.Matches(msg, r.keys.ScrollUp, r.keys.ScrollDown, r.keys.HalfUp, r.keys.HalfDown, r.keys.Top, r.keys.Bottom):
+			// Scrolled by the chat.
+		default:
+			return r, nil
+		}
+	case replayTickMsg:
+		if r.paused {
+			r.stalled = true
+			return r, nil
+		}
+		cmd := r.step()
+		r.refreshViewport()
+		return r, cmd
+	}
+
+	m, cmd := r.model.Update(msg)
+	r.model = m.(model)
+	return r, cmd
+}
+
+// step plays the next piece of the reply streaming, or else the next
+// message, returning when to play the one after.
+func (r *replayModel) step() tea.Cmd {
+	if r.next == len(r.steps) {
+		return nil
+	}
+	current := r.steps[r.next]
+
+	if r.streaming {
+		if len(r.pieces) > 0 {
+			r.messages[len(r.messages)-1].Content += r.pieces[0]
+			r.pieces = r.pieces[1:]
+			return r.tick(current.pace)
+		}
+		r.messages[len(r.messages)-1] = current.message
+		r.streaming = false
+		return r.advance()
+	}
+
+	if current.message.Role == openai.ChatMessageRoleAssistant && current.message.Content != "" {
+		r.messages = append(r.messages, openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleAssistant,
+		})
+		r.pieces = replayPieces(current.message.Content)
+		r.streaming = true
+		return r.tick(current.pace)
+	}
+	r.messages = append(r.messages, current.message)
+	return r.advance()
+}
+
+// advance moves on to the next message, once the one before has been
+// played.
+func (r *replayModel) advance() tea.Cmd {
+	r.next++
+	if r.next == len(r.steps) {
+		r.describe()
+		return nil
+	}
+	return r.tick(r.steps[r.next].wait)
+}
+
+// skip finishes the reply streaming at once, or else plays the next
+// message without waiting.
+func (r *replayModel) skip() {
+	if r.next == len(r.steps) {
+		return
+	}
+	if !r.streaming {
+		// The tick already waiting plays the one after.
+		r.messages = append(r.messages, r.steps[r.next].message)
+		r.next++
+		if r.next == len(r.steps) {
+			r.describe()
+		}
+	} else {
+		r.messages[len(r.messages)-1].Content += strings.Join(r.pieces, "")
+		r.pieces = nil
+	}
+	r.refreshViewport()
+	r.viewport.GotoBottom()
+}
+
+// describe tells how the replay is going.
+func (r *replayModel) describe() {
+	switch {
+	case r.next == len(r.steps):
+		r.notice = fmt.Sprintf("End of %s · q quits", r.title)
+	case r.paused:
+		r.notice = fmt.Sprintf("Replaying %s · paused", r.title)
+	default:
+		r.notice = fmt.Sprintf("Replaying %s · %g×", r.title, r.speed)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// replyDetails is what is known of how a reply was written, shown under it
+// when reply_details is set.
+type replyDetails struct {
+	model   string
+	usage   openai.Usage
+	elapsed time.Duration
+	finish  openai.FinishReason
+}
+
+func (d replyDetails) String() string {
+	parts := []string{d.model}
+	if d.usage.TotalTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%s prompt + %s completion tokens",
+			formatCount(d.usage.PromptTokens), formatCount(d.usage.CompletionTokens)))
+	}
+	parts = append(parts, d.elapsed.Round(100*time.Millisecond).String())
+	if d.finish != "" {
+		parts = append(parts, string(d.finish))
+	}
+	return strings.Join(parts, " · ")
+}
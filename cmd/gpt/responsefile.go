@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// responseFile receives replies as they stream in, so that a long one can be
+// read, or followed with tail -f, outside the chat.
+type responseFile struct {
+	path string
+	f    *os.File
+	// wrote is whether anything has been written, so that replies after
+	// the first are kept apart.
+	wrote bool
+	// inReply is whether a reply is being written.
+	inReply bool
+}
+
+// openResponseFile opens path for replies, adding to what is there already
+// if appending, else replacing it.
+func openResponseFile(path string, appending bool) (*responseFile, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appending {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r := &responseFile{path: path, f: f}
+	if appending {
+		if info, err := f.Stat(); err == nil && info.Size() > 0 {
+			r.wrote = true
+		}
+	}
+	return r, nil
+}
+
+// write adds a piece of a reply, keeping a new reply apart from the one
+// before. It is written straight through, so that the file keeps up with the
+// reply.
+func (r *responseFile) write(s string) error {
+	if r == nil || s == "" {
+		return nil
+	}
+	if !r.inReply && r.wrote {
+		if _, err := r.f.WriteString("\n"); err != nil {
+			return err
+		}
+	}
+	r.inReply, r.wrote = true, true
+	_, err := r.f.WriteString(s)
+	return err
+}
+
+// end finishes the reply being written, if any, with a newline.
+func (r *responseFile) end() error {
+	if r == nil || !r.inReply {
+		return nil
+	}
+	r.inReply = false
+	_, err := r.f.WriteString("\n")
+	return err
+}
+
+func (r *responseFile) close() error {
+	if r == nil {
+		return nil
+	}
+	return r.f.Close()
+}
+
+// saveResponse handles /save-response. The last reply is written to the file,
+// or, while one is streaming, what has arrived of it and then the rest as it
+// comes. -a appends to the file instead of replacing it.
+func (m *model) saveResponse(args []string) tea.Cmd {
+	appending := len(args) > 0 && args[0] == "-a"
+	if appending {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		m.err = errors.New("usage: /save-response [-a] <file>")
+		return nil
+	}
+	reply, ok := m.lastReply()
+	if !ok && !m.streaming {
+		m.notice = "Nothing to save yet"
+		return nil
+	}
+
+	if m.saveTo != nil {
+		m.saveTo.close()
+		m.saveTo = nil
+	}
+	r, err := openResponseFile(args[0], appending)
+	if err == nil {
+		err = r.write(reply)
+	}
+	if err != nil {
+		m.err = err
+		r.close()
+		return nil
+	}
+
+	if m.streaming {
+		m.saveTo = r
+		m.notice = fmt.Sprintf("Saving the reply to %s as it arrives", args[0])
+		return nil
+	}
+	if err := r.end(); err != nil {
+		m.err = err
+	}
+	if err := r.close(); err != nil {
+		m.err = err
+	}
+	m.notice = "Saved the last reply to " + args[0]
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultRetries is how many times a failed request is tried again,
+	// and maxRetries the most it may be.
+	defaultRetries = 3
+	maxRetries     = 10
+	// retryBaseDelay is the wait before the first retry, doubled for each
+	// one after.
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+	// retryAfterLimit is the longest a server may ask to be left alone
+	// for; asking for longer fails the request at once.
+	retryAfterLimit = time.Minute
+)
+
+// retryTransport tries requests again when they fail for reasons that tend
+// to pass: rate limits, overloaded or failing servers and dropped
+// connections. It waits as long as the server asks, or else backs off
+// exponentially with jitter.
+type retryTransport struct {
+	retries int
+	base    http.RoundTripper
+}
+
+type retryNoticeKey struct{}
+
+// withRetryNotice returns ctx with notice, which is told of each retry
+// before waiting for it.
+func withRetryNotice(ctx context.Context, notice func(string)) context.Context {
+	return context.WithValue(ctx, retryNoticeKey{}, notice)
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt == t.retries {
+			return resp, err
+		}
+		wait, reason, ok := retryDelay(resp, err, attempt)
+		if !ok {
+			return resp, err
+		}
+		// The body has been sent, so it must be made again.
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		spanFrom(ctx).event("retry", map[string]any{"reason": reason, "wait_ms": int(wait.Milliseconds())})
+		if notice, ok := ctx.Value(retryNoticeKey{}).(func(string)); ok {
+			notice(fmt.Sprintf("%s; retrying in %s…", reason, wait.Round(time.Second)))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay returns how long to wait before trying a request again and why,
+// or false if it shouldn't be.
+func retryDelay(resp *http.Response, err error, attempt int) (time.Duration, string, bool) {
+	backoff := retryBaseDelay << attempt
+	// Shifted far enough, the delay overflows.
+	if attempt > 20 || backoff <= 0 || backoff > retryMaxDelay {
+		backoff = retryMaxDelay
+	}
+	// Spread the retries of requests that failed together.
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return 0, "", false
+		}
+		return backoff, "the connection failed", true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+		529: // Anthropic's overloaded
+	default:
+		return 0, "", false
+	}
+	reason := "the server replied " + resp.Status
+	if wait, ok := retryAfter(resp.Header); ok {
+		if wait > retryAfterLimit {
+			return 0, "", false
+		}
+		return wait, reason, true
+	}
+	return backoff, reason, true
+}
+
+// retryAfter reads how long the server asks to wait, from OpenAI's
+// retry-after-ms or the standard Retry-After in seconds or as a date.
+func retryAfter(h http.Header) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(h.Get("Retry-After-Ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	value := h.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
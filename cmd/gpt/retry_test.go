@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		header  http.Header
+		err     error
+		attempt int
+		// The wait is in [min, max], the range the jitter may take it to.
+		min, max time.Duration
+		reason   string
+		ok       bool
+	}{
+		{
+			name:   "connection failed",
+			err:    errors.New("connection reset by peer"),
+			min:    retryBaseDelay / 2,
+			max:    retryBaseDelay,
+			reason: "the connection failed",
+			ok:     true,
+		},
+		{
+			name: "cancelled",
+			err:  fmt.Errorf("post: %w", context.Canceled),
+		},
+		{
+			name: "deadline exceeded",
+			err:  context.DeadlineExceeded,
+		},
+		{
+			name:   "rate limited",
+			status: http.StatusTooManyRequests,
+			min:    retryBaseDelay / 2,
+			max:    retryBaseDelay,
+			reason: "the server replied 429 Too Many Requests",
+			ok:     true,
+		},
+		{
+			name:   "overloaded",
+			status: 529,
+			min:    retryBaseDelay / 2,
+			max:    retryBaseDelay,
+			reason: "the server replied 529 ",
+			ok:     true,
+		},
+		{
+			name:   "bad request",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "retry-after in seconds",
+			status: http.StatusServiceUnavailable,
+			header: http.Header{"Retry-After": {"7"}},
+			min:    7 * time.Second,
+			max:    7 * time.Second,
+			reason: "the server replied 503 Service Unavailable",
+			ok:     true,
+		},
+		{
+			name:   "retry-after-ms",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Retry-After-Ms": {"1500"}, "Retry-After": {"2"}},
+			min:    1500 * time.Millisecond,
+			max:    1500 * time.Millisecond,
+			reason: "the server replied 429 Too Many Requests",
+			ok:     true,
+		},
+		{
+			name:   "retry-after too long",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Retry-After": {"3600"}},
+		},
+		{
+			name:    "backs off",
+			status:  http.StatusBadGateway,
+			attempt: 3,
+			min:     4 * retryBaseDelay,
+			max:     8 * retryBaseDelay,
+			reason:  "the server replied 502 Bad Gateway",
+			ok:      true,
+		},
+		{
+			name:    "backs off no further than the most",
+			err:     errors.New("EOF"),
+			attempt: 8,
+			min:     retryMaxDelay / 2,
+			max:     retryMaxDelay,
+			reason:  "the connection failed",
+			ok:      true,
+		},
+		{
+			name:    "shifted far enough to overflow",
+			err:     errors.New("EOF"),
+			attempt: 70,
+			min:     retryMaxDelay / 2,
+			max:     retryMaxDelay,
+			reason:  "the connection failed",
+			ok:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{
+					StatusCode: tt.status,
+					Status:     fmt.Sprintf("%d %s", tt.status, http.StatusText(tt.status)),
+					Header:     tt.header,
+				}
+			}
+			// The jitter is random, so try a few times.
+			for i := 0; i < 20; i++ {
+				wait, reason, ok := retryDelay(resp, tt.err, tt.attempt)
+				if ok != tt.ok || reason != tt.reason {
+					t.Fatalf("retryDelay() = %v, %q, %v, want %q, %v", wait, reason, ok, tt.reason, tt.ok)
+				}
+				if wait < tt.min || wait > tt.max {
+					t.Fatalf("retryDelay() waits %v, want %v to %v", wait, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		{name: "none", header: http.Header{}},
+		{name: "seconds", header: http.Header{"Retry-After": {"3"}}, want: 3 * time.Second, ok: true},
+		{name: "milliseconds", header: http.Header{"Retry-After-Ms": {"250.5"}}, want: 250500 * time.Microsecond, ok: true},
+		{name: "negative", header: http.Header{"Retry-After": {"-1"}}},
+		{name: "past date", header: http.Header{"Retry-After": {"Wed, 21 Oct 2015 07:28:00 GMT"}}, ok: true},
+		{name: "garbage", header: http.Header{"Retry-After": {"soon"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.header)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
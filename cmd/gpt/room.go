@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// room is a conversation shared by everyone connected to gpt serve -room.
+// They take turns: the reply to one member's message streams before anyone
+// else can send one. The member whose turn it is asks for the reply as in
+// any chat and shares the transcript as it grows; the others are shown it.
+type room struct {
+	conv *conversation
+
+	mu       sync.Mutex
+	messages []openai.ChatCompletionMessage
+	members  []*roomMember
+	// turn is the member whose message is being answered, if any.
+	turn *roomMember
+	// saved is how many of the messages are in the conversation on disk;
+	// the rest are a reply still streaming.
+	saved int
+}
+
+// roomMember is someone in a room, with a chat of their own.
+type roomMember struct {
+	name    string
+	program *tea.Program
+
+	mu sync.Mutex
+	// pending is the state of the room not yet shown to the member. Only
+	// the latest matters, so a member slow to take it misses nothing.
+	pending *roomSyncMsg
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// roomSyncMsg is the state of the room, sent to its members when it changes.
+type roomSyncMsg struct {
+	messages []openai.ChatCompletionMessage
+	// turn is the name of the member whose message is being answered, if
+	// any.
+	turn    string
+	members []string
+}
+
+func newRoom(conv *conversation) *room {
+	return &room{conv: conv, messages: conv.Messages, saved: len(conv.Messages)}
+}
+
+// invalidNameChars are those OpenAI doesn't take in the name of whoever sent
+// a message.
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// join adds a member by the name they logged in with, made unique in the
+// room. They hear from the room once start is called.
+func (r *room) join(name string) *roomMember {
+	name = invalidNameChars.ReplaceAllString(name, "_")
+	if name == "" {
+		name = "guest"
+	}
+	if len(name) > 60 {
+		name = name[:60]
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unique := name
+	for n := 2; r.named(unique); n++ {
+		unique = fmt.Sprintf("%s-%d", name, n)
+	}
+	m := &roomMember{
+		name: unique,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	r.members = append(r.members, m)
+	return m
+}
+
+func (r *room) named(name string) bool {
+	for _, m := range r.members {
+		if m.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// start sends what happens in the room to the member's p from now on, and
+// tells everyone they are in.
+func (r *room) start(m *roomMember, p *tea.Program) {
+	m.program = p
+	go m.deliver()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.broadcast(nil)
+}
+
+// leave lets the member go. If it was their turn, the reply they were
+// asked for was given up with their connection, and the room finishes the
+// turn for them before handing it on.
+func (r *room) leave(m *roomMember) {
+	r.mu.Lock()
+	for i, member := range r.members {
+		if member == m {
+			r.members = append(r.members[:i], r.members[i+1:]...)
+			close(m.done)
+			break
+		}
+	}
+	ending := r.turn == m
+	var save func() error
+	if ending {
+		save = r.endTurn()
+		// The turn is held by a stand-in while the conversation is saved,
+		// so that no one else's message gets in ahead of what is left of
+		// it, nor anything more of it from the member's own chat.
+		r.turn = &roomMember{name: m.name}
+	}
+	r.mu.Unlock()
+
+	if save != nil {
+		if err := save(); err != nil {
+			// There is no one left to tell.
+			log.Error("chat room", "err", err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ending {
+		r.turn = nil
+	}
+	r.broadcast(nil)
+}
+
+// endTurn keeps what arrived of a reply cut short, as the chat itself does,
+// or else takes back the message that went unanswered. It is called with
+// r.mu held, and returns the change to the conversation file, if any, to
+// be made once r.mu is released.
+func (r *room) endTurn() func() error {
+	last := len(r.messages) - 1
+	if last >= r.saved && r.messages[last].Content != "" {
+		r.saved = len(r.messages)
+		reply := r.messages[last]
+		return func() error { return r.conv.append(reply) }
+	}
+	r.messages = r.messages[:r.saved]
+	if last := r.saved - 1; last >= 0 && r.messages[last].Role == openai.ChatMessageRoleUser {
+		r.messages = r.messages[:last]
+		r.saved = last
+		return func() error { return r.conv.truncate(last) }
+	}
+	return nil
+}
+
+// snapshot returns the transcript so far, for a member joining.
+func (r *room) snapshot() []openai.ChatCompletionMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return cloneMessages(r.messages)
+}
+
+// take gives the member the turn, unless someone else has it, whose name is
+// returned.
+func (r *room) take(m *roomMember) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.turn != nil && r.turn != m {
+		return r.turn.name, false
+	}
+	r.turn = m
+	return "", true
+}
+
+// holder returns the name of whoever has the turn, other than m.
+func (r *room) holder(m *roomMember) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.turn == nil || r.turn == m {
+		return ""
+	}
+	return r.turn.name
+}
+
+// share shows the others the transcript of the member whose turn it is,
+// after saving what is new of it with save, if given, and gives up the turn
+// if done. Once the member has left, the turn is no longer theirs to share
+// or save.
+func (r *room) share(m *roomMember, messages []openai.ChatCompletionMessage, save func() error, done bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.turn != m {
+		return nil
+	}
+	var err error
+	if save != nil {
+		err = save()
+		r.saved = len(messages)
+	}
+	r.messages = cloneMessages(messages)
+	if done {
+		r.turn = nil
+	}
+	r.broadcast(m)
+	return err
+}
+
+// broadcast sends the state of the room to every member but except. It is
+// called with r.mu held.
+func (r *room) broadcast(except *roomMember) {
+	msg := &roomSyncMsg{messages: cloneMessages(r.messages)}
+	if r.turn != nil {
+		msg.turn = r.turn.name
+	}
+	for _, m := range r.members {
+		msg.members = append(msg.members, m.name)
+	}
+	for _, m := range r.members {
+		if m != except {
+			m.post(msg)
+		}
+	}
+}
+
+func (m *roomMember) post(msg *roomSyncMsg) {
+	m.mu.Lock()
+	m.pending = msg
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends the member's program the latest state of the room whenever
+// it changes, until they leave.
+func (m *roomMember) deliver() {
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.wake:
+		}
+		m.mu.Lock()
+		msg := m.pending
+		m.pending = nil
+		m.mu.Unlock()
+		if msg != nil {
+			m.program.Send(*msg)
+		}
+	}
+}
+
+func cloneMessages(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	return append([]openai.ChatCompletionMessage(nil), messages...)
+}
+
+// nameColors are the colors members' names are shown in.
+var nameColors = []string{"205", "39", "214", "42", "141", "203", "45", "220", "171", "118"}
+
+// nameStyle is the style of a member's name, the same in every chat and
+// every time they join.
+func nameStyle(name string) lipgloss.Style {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(nameColors[h.Sum32()%uint32(len(nameColors))]))
+}
+
+// roomWelcome greets a member of a room whose conversation hasn't started.
+func (m *model) roomWelcome() string {
+	var others []string
+	for _, name := range m.roomMembers {
+		if name != m.member.name {
+			others = append(others, nameStyle(name).Render(name))
+		}
+	}
+	who := "You're the first one here."
+	if len(others) > 0 {
+		who = "Here with you: " + strings.Join(others, ", ") + "."
+	}
+	return fmt.Sprintf(`Welcome to the chat room, %s!
+%s
+Type a message and press Enter to send. One message is answered at a time,
+and everyone sees the reply as it arrives.`, nameStyle(m.member.name).Render(m.member.name), who)
+}
+
+// syncRoom takes in the state of the room from another member.
+func (m *model) syncRoom(msg roomSyncMsg) {
+	joined, left := diffNames(m.roomMembers, msg.members)
+	switch {
+	case m.roomMembers == nil:
+		// The first news, of this member joining.
+	case len(joined) > 0:
+		m.notice = strings.Join(joined, ", ") + " joined the room"
+	case len(left) > 0:
+		m.notice = strings.Join(left, ", ") + " left the room"
+	}
+	m.roomMembers = msg.members
+	m.roomTurn = msg.turn
+	// While it is this member's turn their own transcript is ahead.
+	if !m.streaming {
+		m.messages = msg.messages
+		m.truncated = false
+	}
+	m.refreshViewport()
+}
+
+// shareRoom shows the rest of the room this member's transcript while it is
+// their turn.
+func (m *model) shareRoom(done bool) {
+	if m.room == nil {
+		return
+	}
+	m.room.share(m.member, m.messages, nil, done)
+	if done {
+		m.roomTurn = ""
+	}
+}
+
+// saveMessages adds messages, the last of the transcript, to the
+// conversation. In a room they are shared with the others as they are
+// saved, so that the room knows what of the turn is kept should the member
+// leave in the middle of it.
+func (m *model) saveMessages(messages ...openai.ChatCompletionMessage) error {
+	save := func() error {
+		for _, message := range messages {
+			if err := m.conversation.append(message); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if m.room == nil {
+		return save()
+	}
+	return m.room.share(m.member, m.messages, save, false)
+}
+
+func diffNames(before, after []string) (joined, left []string) {
+	in := make(map[string]bool)
+	for _, name := range before {
+		in[name] = true
+	}
+	for _, name := range after {
+		if !in[name] {
+			joined = append(joined, name)
+		}
+		delete(in, name)
+	}
+	for _, name := range before {
+		if in[name] {
+			left = append(left, name)
+		}
+	}
+	return joined, left
+}
+
+// roomless reports whether command can't be used because the chat is in a
+// room, saying so.
+func (m *model) roomless(command string) bool {
+	if m.room == nil || !roomlessCommands[command] {
+		return false
+	}
+	m.err = fmt.Errorf("%s isn't available in a chat room", command)
+	return true
+}
+
+// roomlessCommands rewrite the shared transcript, and so can't be used in a
+// room.
+var roomlessCommands = map[string]bool{
+	"/clear":        true,
+	"/retry":        true,
+	"/continue":     true,
+	"/candidates":   true,
+	"/load":         true,
+	"/capture-pane": true,
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// samplingParam is a setting of how replies are sampled, settable in the
+// config file, by a flag of the same name with dashes and with /set.
+type samplingParam struct {
+	name  string
+	usage string
+	set   func(cfg *config, value string) error
+	get   func(cfg config) string
+}
+
+var samplingParams = []samplingParam{
+	floatParam("temperature", "randomness of replies, from 0 to 2", 0, 2,
+		func(cfg *config) *float32 { return &cfg.Temperature }),
+	floatParam("top_p", "sample from the most likely tokens up to this probability, from 0 to 1", 0, 1,
+		func(cfg *config) *float32 { return &cfg.TopP }),
+	floatParam("presence_penalty", "penalty for tokens that have appeared at all, from -2 to 2", -2, 2,
+		func(cfg *config) *float32 { return &cfg.PresencePenalty }),
+	floatParam("frequency_penalty", "penalty for tokens by how often they have appeared, from -2 to 2", -2, 2,
+		func(cfg *config) *float32 { return &cfg.FrequencyPenalty }),
+	{
+		name:  "max_tokens",
+		usage: "most tokens in a reply; 0 leaves it to the provider",
+		set: func(cfg *config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("max_tokens must be a whole number of at least 0, not %q", value)
+			}
+			cfg.MaxTokens = n
+			return nil
+		},
+		get: func(cfg config) string {
+			return strconv.Itoa(cfg.MaxTokens)
+		},
+	},
+	{
+		name:  "seed",
+		usage: "seed for repeatable replies, where the provider supports one; none to unset",
+		set: func(cfg *config, value string) error {
+			if value == "none" {
+				cfg.Seed = nil
+				return nil
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("seed must be a whole number or none, not %q", value)
+			}
+			cfg.Seed = &n
+			return nil
+		},
+		get: func(cfg config) string {
+			if cfg.Seed == nil {
+				return "none"
+			}
+			return strconv.Itoa(*cfg.Seed)
+		},
+	},
+}
+
+// floatParam is a samplingParam for a number between min and max.
+func floatParam(name, usage string, min, max float64, field func(cfg *config) *float32) samplingParam {
+	return samplingParam{
+		name:  name,
+		usage: usage,
+		set: func(cfg *config, value string) error {
+			f, err := strconv.ParseFloat(value, 32)
+			if err != nil || f < min || f > max {
+				return fmt.Errorf("%s must be a number from %v to %v, not %q", name, min, max, value)
+			}
+			*field(cfg) = float32(f)
+			return nil
+		},
+		get: func(cfg config) string {
+			return strconv.FormatFloat(float64(*field(&cfg)), 'g', -1, 32)
+		},
+	}
+}
+
+func findSamplingParam(name string) (samplingParam, bool) {
+	name = strings.ReplaceAll(name, "-", "_")
+	for _, p := range samplingParams {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return samplingParam{}, false
+}
+
+// samplingFlags registers a flag for each sampling parameter on fs and
+// returns the values given, to be applied once the config is loaded.
+func samplingFlags(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	for _, p := range samplingParams {
+		p := p
+		fs.Func(strings.ReplaceAll(p.name, "_", "-"), p.usage, func(value string) error {
+			if err := p.set(&config{}, value); err != nil {
+				return err
+			}
+			values[p.name] = value
+			return nil
+		})
+	}
+	return values
+}
+
+// applySampling sets the parameters in values, as returned by samplingFlags.
+func applySampling(cfg *config, values map[string]string) error {
+	for name, value := range values {
+		p, _ := findSamplingParam(name)
+		if err := p.set(cfg, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set handles /set, which changes a sampling parameter for the rest of the
+// chat, or shows them all.
+func (m *model) set(args []string) tea.Cmd {
+	m.err = nil
+	m.notice = ""
+
+	if len(args) == 0 {
+		settings := make([]string, len(samplingParams))
+		for i, p := range samplingParams {
+			settings[i] = p.name + " " + p.get(m.config)
+		}
+		m.notice = strings.Join(settings, " · ")
+		return nil
+	}
+
+	name, value, ok := strings.Cut(args[0], "=")
+	if !ok && len(args) == 2 {
+		value, ok = args[1], true
+	}
+	p, known := findSamplingParam(name)
+	switch {
+	case !known:
+		m.err = fmt.Errorf("unknown setting %q", name)
+	case !ok:
+		m.notice = fmt.Sprintf("%s is %s: %s", p.name, p.get(m.config), p.usage)
+	default:
+		if err := p.set(&m.config, value); err != nil {
+			m.err = err
+			return nil
+		}
+		m.notice = fmt.Sprintf("%s set to %s", p.name, p.get(m.config))
+	}
+	return nil
+}
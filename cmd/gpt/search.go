@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ansiEscape matches the escape sequences that style rendered text.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// search finds text in the conversation as it is shown, so that what matches
+// can be highlighted and jumped to.
+type search struct {
+	input textinput.Model
+	// typing is whether the query is still being typed. Once it is entered,
+	// n and N move between the matches.
+	typing bool
+	// matches are the lines of the viewport content that the query is on.
+	matches []int
+	current int
+}
+
+func newSearch() *search {
+	input := textinput.New()
+	input.Prompt = "/"
+	input.Placeholder = "Search the conversation"
+	input.Focus()
+	return &search{input: input, typing: true}
+}
+
+// highlight joins the rendered messages into the viewport content, finding
+// the lines the query is on and marking it there. Marked lines lose their
+// other styling, as matches may span several styles.
+func (s *search) highlight(blocks []string, styles styles) string {
+	s.matches = s.matches[:0]
+	var query *regexp.Regexp
+	if s.input.Value() != "" {
+		query = regexp.MustCompile("(?i)" + regexp.QuoteMeta(s.input.Value()))
+	}
+
+	var lines []string
+	for _, block := range blocks {
+		for _, line := range strings.Split(block, "\n") {
+			if plain := ansiEscape.ReplaceAllString(line, ""); query != nil && query.MatchString(plain) {
+				style := styles.match
+				if len(s.matches) == s.current {
+					style = styles.currentMatch
+				}
+				s.matches = append(s.matches, len(lines))
+				line = query.ReplaceAllStringFunc(plain, func(match string) string {
+					return style.Render(match)
+				})
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if s.current >= len(s.matches) {
+		s.current = 0
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *search) view() string {
+	parts := []string{s.input.View()}
+	switch {
+	case len(s.matches) > 0:
+		parts = append(parts, fmt.Sprintf("%d/%d", s.current+1, len(s.matches)))
+	case s.input.Value() != "":
+		parts = append(parts, "No matches")
+	}
+	if !s.typing {
+		parts = append(parts, "n/N to move, esc to close")
+	}
+	return strings.Join(parts, "  ")
+}
+
+// openSearch starts a new search, or goes back to editing the query of the
+// current one.
+func (m *model) openSearch() {
+	if m.search == nil {
+		m.search = newSearch()
+		return
+	}
+	m.search.typing = true
+	m.search.input.Focus()
+}
+
+// closeSearch ends the search and removes its highlighting.
+func (m *model) closeSearch() {
+	m.search = nil
+	m.refreshViewport()
+}
+
+// jumpToMatch moves by delta matches, wrapping around, and scrolls the match
+// into the middle of the viewport.
+func (m *model) jumpToMatch(delta int) {
+	s := m.search
+	if len(s.matches) == 0 {
+		return
+	}
+	s.current = (s.current + delta + len(s.matches)) % len(s.matches)
+	m.refreshViewport()
+	m.viewport.SetYOffset(s.matches[s.current] - m.viewport.Height/2)
+}
+
+// updateSearch handles keys while searching, reporting whether it used the
+// key. Once the query is entered, keys other than n, N and Esc end the search
+// and are handled as usual.
+func (m *model) updateSearch(msg tea.KeyMsg) (bool, tea.Cmd) {
+	s := m.search
+	if !s.typing {
+		switch {
+		case msg.String() == "n":
+			m.jumpToMatch(1)
+		case msg.String() == "N":
+			m.jumpToMatch(-1)
+		case msg.String() == "/" || key.Matches(msg, m.keys.Search):
+			m.openSearch()
+		case msg.String() == "esc":
+			m.closeSearch()
+		default:
+			m.closeSearch()
+			return false, nil
+		}
+		return true, nil
+	}
+
+	switch msg.String() {
+	case "esc", "ctrl+c":
+		m.closeSearch()
+		return true, nil
+	case "enter":
+		s.typing = false
+		s.input.Blur()
+		m.jumpToMatch(0)
+		return true, nil
+	}
+
+	query := s.input.Value()
+	var cmd tea.Cmd
+	s.input, cmd = s.input.Update(msg)
+	if s.input.Value() != query {
+		// Search as the query is typed, starting again from the first match.
+		s.current = 0
+		m.refreshViewport()
+		m.jumpToMatch(0)
+	}
+	return true, cmd
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// session is a named snapshot of a conversation together with the settings
+// it was using.
+type session struct {
+	Name         string                         `json:"name"`
+	Model        string                         `json:"model"`
+	Temperature  float32                        `json:"temperature,omitempty"`
+	SystemPrompt string                         `json:"system_prompt"`
+	Messages     []openai.ChatCompletionMessage `json:"messages"`
+	SavedAt      time.Time                      `json:"saved_at"`
+}
+
+// apply returns cfg with the session's settings.
+func (s session) apply(cfg config) config {
+	cfg.Model = s.Model
+	cfg.Temperature = s.Temperature
+	cfg.SystemPrompt = s.SystemPrompt
+	return cfg
+}
+
+type sessionStore struct {
+	dir string
+}
+
+func newSessionStore() (*sessionStore, error) {
+	dataDir, err := defaultDataDir()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(dataDir, "sessions")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &sessionStore{dir: dir}, nil
+}
+
+func (s *sessionStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid session name %q", name)
+	}
+	return filepath.Join(s.dir, name+".json"), nil
+}
+
+func (s *sessionStore) save(sess session) error {
+	path, err := s.path(sess.Name)
+	if err != nil {
+		return err
+	}
+
+	sess.SavedAt = time.Now()
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load reads a saved session. The error wraps os.ErrNotExist if there is no
+// session with that name.
+func (s *sessionStore) load(name string) (session, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return session{}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return session{}, fmt.Errorf("session %q not found: %w", name, err)
+	}
+	if err != nil {
+		return session{}, err
+	}
+
+	var sess session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return session{}, fmt.Errorf("session %q: %w", name, err)
+	}
+	return sess, nil
+}
+
+// names returns the names of the saved sessions.
+func (s *sessionStore) names() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), ".json"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
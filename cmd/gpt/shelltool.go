@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// maxShellOutput is the most of a command's output given to the model.
+	maxShellOutput = 16 * 1024
+	// shellSummaryLines is how much of the output the transcript shows.
+	shellSummaryLines = 20
+	// shellWaitDelay is how long a command that was given up on, or has
+	// exited, may keep its output open, as a child left running can.
+	shellWaitDelay = 5 * time.Second
+)
+
+// errDeclined is the result of a command the user didn't allow.
+var errDeclined = errors.New("the user declined to run the command")
+
+func newShellTool() tool {
+	return tool{
+		name:        "run_shell",
+		description: "Run a command in the user's shell and get its output. The user is asked before each command runs.",
+		parameters: jsonSchema{
+			Type: "object",
+			Properties: map[string]jsonSchema{
+				"command": {Type: "string", Description: "The command line to run"},
+			},
+			Required: []string{"command"},
+		},
+		status: func(args string) string {
+			return "Running " + displayCommand(shellCommandLine(args))
+		},
+		run: func(ctx context.Context, args string, tio toolIO) (string, error) {
+			command := shellCommandLine(args)
+			if command == "" {
+				return "", errors.New("no command given")
+			}
+			if !tio.ask(fmt.Sprintf("Run this command in %s?\n  $ %s", shellName(), displayCommand(command))) {
+				return "", errDeclined
+			}
+			return runShell(ctx, command, tio)
+		},
+		summary: func(result string) string {
+			lines := strings.Split(result, "\n")
+			if len(lines) > shellSummaryLines {
+				lines = append(lines[:shellSummaryLines], fmt.Sprintf("... (%d more lines)", len(lines)-shellSummaryLines))
+			}
+			return strings.Join(lines, "\n")
+		},
+	}
+}
+
+// shellCommandLine returns the command in the arguments of a run_shell call.
+func shellCommandLine(args string) string {
+	var params struct {
+		Command string `json:"command"`
+	}
+	_ = json.Unmarshal([]byte(args), &params)
+	return strings.TrimSpace(params.Command)
+}
+
+// displayCommand returns command as it is shown before asking to run it. A
+// command with more than one line, or with characters that aren't printable
+// such as escape sequences, is quoted so that nothing in it can hide what
+// runs.
+func displayCommand(command string) string {
+	for _, r := range command {
+		if !unicode.IsPrint(r) {
+			return strconv.Quote(command)
+		}
+	}
+	return command
+}
+
+// shellName is the shell commands run in.
+func shellName() string {
+	if runtime.GOOS == "windows" {
+		if shell := os.Getenv("COMSPEC"); shell != "" {
+			return shell
+		}
+		return "cmd.exe"
+	}
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+// runShell runs command, showing its output as it arrives, and returns the
+// output along with how it exited.
+func runShell(ctx context.Context, command string, tio toolIO) (string, error) {
+	cmd := shellCommand(ctx, command)
+	out := &shellOutput{tio: tio}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = shellWaitDelay
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	status := "Exit status: 0"
+	switch {
+	case errors.As(err, &exitErr):
+		status = fmt.Sprintf("Exit status: %d", exitErr.ExitCode())
+	case err != nil:
+		return "", err
+	}
+	return status + "\n\n" + out.String(), nil
+}
+
+// shellCommand returns a command that runs command in the user's shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, shellName(), "/C", command)
+	}
+	return exec.CommandContext(ctx, shellName(), "-c", command)
+}
+
+// shellOutput collects the combined output of a command, up to
+// maxShellOutput, passing it on as it arrives.
+type shellOutput struct {
+	tio toolIO
+
+	mu        sync.Mutex
+	b         strings.Builder
+	truncated bool
+}
+
+func (o *shellOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	n := len(p)
+	o.tio.print(string(p))
+	if room := maxShellOutput - o.b.Len(); len(p) > room {
+		p = p[:room]
+		o.truncated = true
+	}
+	o.b.Write(p)
+	return n, nil
+}
+
+func (o *shellOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	s := strings.ToValidUTF8(o.b.String(), "")
+	if o.truncated {
+		s += "\n[output truncated]"
+	}
+	return s
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestDisplayCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{command: "ls -la", want: "ls -la"},
+		{command: `grep "a b" *.go`, want: `grep "a b" *.go`},
+		{command: "echo hi\rrm -rf ~", want: `"echo hi\rrm -rf ~"`},
+		{command: "rm -rf ~ \x1b[2K\x1b[1Gls", want: `"rm -rf ~ \x1b[2K\x1b[1Gls"`},
+		{command: "ls\n\n\n\nrm -rf ~", want: `"ls\n\n\n\nrm -rf ~"`},
+		{command: "ls\u202e", want: `"ls\u202e"`},
+		{command: "echo héllo", want: "echo héllo"},
+	}
+	for _, tt := range tests {
+		if got := displayCommand(tt.command); got != tt.want {
+			t.Errorf("displayCommand(%q) = %s, want %s", tt.command, got, tt.want)
+		}
+	}
+}
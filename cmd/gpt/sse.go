@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// sseEvent is a single server-sent event.
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// sseReader parses a text/event-stream body.
+type sseReader struct {
+	scanner *bufio.Scanner
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 4*1024*1024)
+	return &sseReader{scanner: scanner}
+}
+
+// next returns the next event, or io.EOF at the end of the stream.
+func (r *sseReader) next() (sseEvent, error) {
+	var (
+		event sseEvent
+		data  []string
+	)
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if line == "" {
+			if len(data) == 0 && event.Event == "" {
+				continue
+			}
+			event.Data = strings.Join(data, "\n")
+			return event, nil
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return sseEvent{}, err
+	}
+	if len(data) > 0 {
+		event.Data = strings.Join(data, "\n")
+		return event, nil
+	}
+	return sseEvent{}, io.EOF
+}
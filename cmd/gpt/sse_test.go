@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSSEReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []sseEvent
+	}{
+		{
+			name:   "empty",
+			stream: "",
+		},
+		{
+			name:   "data",
+			stream: "data: {\"a\":1}\n\ndata: [DONE]\n\n",
+			want:   []sseEvent{{Data: `{"a":1}`}, {Data: "[DONE]"}},
+		},
+		{
+			name:   "named events",
+			stream: "event: message_start\ndata: {}\n\nevent: ping\n\n",
+			want:   []sseEvent{{Event: "message_start", Data: "{}"}, {Event: "ping"}},
+		},
+		{
+			name:   "data over several lines",
+			stream: "data: one\ndata: two\ndata:three\n\n",
+			want:   []sseEvent{{Data: "one\ntwo\nthree"}},
+		},
+		{
+			name:   "comments and other fields",
+			stream: ": keep-alive\n\nid: 7\nretry: 1000\ndata: x\n\n",
+			want:   []sseEvent{{Data: "x"}},
+		},
+		{
+			name:   "blank lines between events",
+			stream: "\n\ndata: a\n\n\n\ndata: b\n\n",
+			want:   []sseEvent{{Data: "a"}, {Data: "b"}},
+		},
+		{
+			name:   "CRLF",
+			stream: "data: a\r\n\r\n",
+			want:   []sseEvent{{Data: "a"}},
+		},
+		{
+			name:   "no blank line at the end",
+			stream: "data: a\n\ndata: b",
+			want:   []sseEvent{{Data: "a"}, {Data: "b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newSSEReader(strings.NewReader(tt.stream))
+			var got []sseEvent
+			for {
+				event, err := r.next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, event)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
+	"github.com/charmbracelet/wish/activeterm"
+	bm "github.com/charmbracelet/wish/bubbletea"
+	"github.com/charmbracelet/wish/logging"
+	"github.com/muesli/termenv"
+)
+
+// newSSHServer returns a server of the chat over SSH at addr, to the holders
+// of the keys in authorizedKeys, or else ~/.ssh/authorized_keys. Each
+// connection gets a chat of its own, with the config, history and sessions of
+// this machine, unless the server has a room for them to share.
+func (s *apiServer) newSSHServer(addr, authorizedKeys string) (*ssh.Server, error) {
+	dataDir, err := defaultDataDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, err
+	}
+	if authorizedKeys == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		authorizedKeys = filepath.Join(home, ".ssh", "authorized_keys")
+	}
+	if _, err := os.Stat(authorizedKeys); err != nil {
+		return nil, fmt.Errorf("ssh: no keys to let in: %w", err)
+	}
+	// The styles are shared by every connection, whatever this process's
+	// own terminal is, so they keep to colors that about every terminal
+	// has.
+	lipgloss.SetColorProfile(termenv.ANSI256)
+
+	return wish.NewServer(
+		wish.WithAddress(addr),
+		// Made on first use.
+		wish.WithHostKeyPath(filepath.Join(dataDir, "ssh_host_ed25519")),
+		wish.WithAuthorizedKeys(authorizedKeys),
+		wish.WithMiddleware(
+			bm.MiddlewareWithProgramHandler(s.sshChat, termenv.ANSI256),
+			activeterm.Middleware(),
+			logging.Middleware(),
+		),
+	)
+}
+
+// sshChat starts the chat for a connection. The command given to ssh may
+// resume a conversation or a saved session, as with gpt itself:
+//
+//	ssh -t -p 2222 host -resume last
+//
+// In a room, the connection joins it under the name it logged in with.
+func (s *apiServer) sshChat(sess ssh.Session) *tea.Program {
+	m, err := s.newSSHChat(sess)
+	if err != nil {
+		wish.Fatalln(sess, err)
+		return nil
+	}
+	p := tea.NewProgram(m, bm.MakeOptions(sess)...)
+	if m.room != nil {
+		m.room.start(m.member, p)
+		go func() {
+			<-sess.Context().Done()
+			m.room.leave(m.member)
+		}()
+	}
+	return p
+}
+
+func (s *apiServer) newSSHChat(sess ssh.Session) (model, error) {
+	fs := flag.NewFlagSet("gpt", flag.ContinueOnError)
+	fs.SetOutput(sess.Stderr())
+	resume := fs.String("resume", "", "resume the conversation with this `id`, or the last one")
+	sessionName := fs.String("session", "", "load the `name`d session, and save to it")
+	if err := fs.Parse(sess.Command()); err != nil {
+		return model{}, err
+	}
+	if *resume != "" && *sessionName != "" {
+		return model{}, errors.New("-resume and -session can't be used together")
+	}
+	if s.room != nil && (*resume != "" || *sessionName != "") {
+		return model{}, errors.New("-resume and -session can't be used in a chat room")
+	}
+
+	cfg := s.config
+	var conv *conversation
+	if s.room != nil {
+		conv = s.room.conv
+	}
+	if *resume != "" {
+		var err error
+		if conv, err = s.store.open(*resume); err != nil {
+			return model{}, err
+		}
+	}
+	if *sessionName != "" {
+		saved, err := s.sessions.load(*sessionName)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return model{}, err
+		default:
+			cfg = saved.apply(cfg)
+			cfg.fillDefaults()
+			if conv, err = s.store.createWith(saved.Messages); err != nil {
+				return model{}, err
+			}
+		}
+	}
+	if conv == nil {
+		var err error
+		if conv, err = s.store.create(); err != nil {
+			return model{}, err
+		}
+		// A conversation in which nothing was said isn't kept.
+		go func() {
+			<-sess.Context().Done()
+			conv.discardIfEmpty()
+		}()
+	}
+
+	prov, err := newProvider(cfg)
+	if err != nil {
+		return model{}, err
+	}
+	tools, err := newToolRegistry(cfg)
+	if err != nil {
+		return model{}, err
+	}
+	go func() {
+		<-sess.Context().Done()
+		tools.close()
+	}()
+	inputs, err := loadInputHistory()
+	if err != nil {
+		return model{}, err
+	}
+
+	m, err := newChatModel(cfg, prov, conv, "")
+	if err != nil {
+		return model{}, err
+	}
+	m.tools = tools
+	m.budget = s.budget
+	m.store = s.store
+	m.sessions = s.sessions
+	m.inputs = inputs
+	m.session = *sessionName
+	m.terminal = sess
+	// The reply streaming is given up if the connection drops.
+	m.ctx = sess.Context()
+	if s.room != nil {
+		m.room = s.room
+		m.member = s.room.join(sess.User())
+		// The conversation on disk is behind while a reply streams.
+		m.messages = s.room.snapshot()
+	}
+	return m, nil
+}
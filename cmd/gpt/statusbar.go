@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// statusBar describes the state of the chat in a single line: what is going
+// on and with which model on the left, and what it is costing on the right.
+func (m model) statusBar() string {
+	var left []string
+	if m.vim.enabled {
+		left = append(left, m.vim.status())
+	}
+	if m.queued > 0 {
+		left = append(left, fmt.Sprintf("queued #%d for the rate limit", m.queued))
+	} else if m.streaming {
+		left = append(left, "streaming")
+	} else if m.roomTurn != "" {
+		left = append(left, "streaming for "+m.roomTurn)
+	} else {
+		left = append(left, "idle")
+	}
+	left = append(left, m.config.Model)
+	if m.config.Profile != "" {
+		left = append(left, "profile: "+m.config.Profile)
+	}
+	if m.persona != "" {
+		left = append(left, "persona: "+m.persona)
+	}
+	if m.session != "" {
+		left = append(left, "session: "+m.session)
+	}
+	if m.room != nil {
+		left = append(left, "room: "+strings.Join(m.roomMembers, ", "))
+	}
+
+	var right []string
+	if !m.viewport.AtBottom() {
+		right = append(right, fmt.Sprintf("↓ %.0f%%", m.viewport.ScrollPercent()*100))
+	}
+	if m.textarea.Value() != "" {
+		right = append(right, m.inputStatus())
+	}
+	right = append(right,
+		m.tokenStatus(),
+		formatCost(m.costs.total(m.config)),
+	)
+
+	bar := strings.Join(left, " · ")
+	status := strings.Join(right, " · ")
+	if gap := m.width - lipgloss.Width(bar) - lipgloss.Width(status); gap > 0 {
+		bar += strings.Repeat(" ", gap) + status
+	} else {
+		bar += " · " + status
+	}
+	return m.styles.footer.Render(bar)
+}
+
+// inputStatus counts what is being typed, against the input limit if there
+// is one, so that it is clear when a paste has been cut short.
+func (m model) inputStatus() string {
+	chars := formatCount(m.textarea.Length()) + " chars"
+	if limit := m.textarea.CharLimit; limit > 0 {
+		chars = fmt.Sprintf("%s/%s chars", formatCount(m.textarea.Length()), formatCount(limit))
+		if m.textarea.Length() >= limit {
+			chars += " (limit reached)"
+		}
+	}
+	return fmt.Sprintf("input: %s, %s tokens", chars, formatCount(m.tokens.count(m.textarea.Value())))
+}
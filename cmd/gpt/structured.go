@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// maxSchemaRetries is how many times the model is asked to correct a reply
+// that doesn't match the schema.
+const maxSchemaRetries = 2
+
+// loadSchema reads a JSON Schema from path.
+func loadSchema(path string) (json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON object: %w", path, err)
+	}
+	return data, nil
+}
+
+// runStructured sends a single prompt asking for JSON, matching schema if it
+// isn't nil, and prints only the JSON. A reply that isn't valid JSON or
+// doesn't match the schema is sent back to be corrected, up to
+// maxSchemaRetries times.
+func runStructured(cfg config, p provider, b *budget, conv *conversation, prompt string, schema json.RawMessage, out outputOptions) error {
+	warning, err := b.check()
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		out.notef("%s\n", warning)
+	}
+
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt}
+	if err := conv.append(message); err != nil {
+		return err
+	}
+
+	instruction, format := jsonFormat(schema)
+	var decoded any
+	if schema != nil {
+		if err := json.Unmarshal(schema, &decoded); err != nil {
+			return err
+		}
+	}
+
+	messages := append(conv.Messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: prompt + instruction,
+	})
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		req, err := newChatRequest(cfg, detectPromptData(), messages)
+		if err != nil {
+			return err
+		}
+		req.ResponseFormat = format
+
+		var reply strings.Builder
+		result, err := streamChat(context.Background(), p, req, func(delta string) {
+			reply.WriteString(delta)
+		})
+		if err != nil {
+			return err
+		}
+		if result.usage.TotalTokens > 0 {
+			if err := b.record(cfg, cfg.Model, result.usage); err != nil {
+				return err
+			}
+		}
+
+		sent := messages[len(messages)-1].Content
+		if err := cfg.audit(newAuditEntry(cfg, conv, sent, reply.String(), start, result, nil)); err != nil {
+			return err
+		}
+
+		output := unfence(reply.String())
+		problems := checkJSON(output, decoded, schema != nil)
+		if len(problems) == 0 {
+			if out.quiet {
+				fmt.Print(output)
+			} else {
+				fmt.Println(output)
+			}
+			return conv.append(openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: output,
+			})
+		}
+		if attempt == maxSchemaRetries {
+			return fmt.Errorf("the reply doesn't match the schema: %s", strings.Join(problems, "; "))
+		}
+
+		out.notef("The reply doesn't match the schema (%s); asking again...\n", strings.Join(problems, "; "))
+		messages = append(messages,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply.String()},
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: "That reply has these problems:\n\n- " + strings.Join(problems, "\n- ") + "\n\nReply again with corrected JSON only.",
+			},
+		)
+	}
+}
+
+// jsonFormat returns what to add to the prompt and the response format to ask
+// for JSON, matching schema if it isn't nil. Providers without structured
+// outputs are told in the prompt instead.
+func jsonFormat(schema json.RawMessage) (string, *openai.ChatCompletionResponseFormat) {
+	if schema == nil {
+		return "\n\nReply with JSON only, not in a code fence.",
+			&openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	}
+	return "\n\nReply with JSON only, not in a code fence, matching this JSON Schema:\n\n" + string(schema),
+		&openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   "output",
+				Schema: schema,
+			},
+		}
+}
+
+// checkJSON returns what is wrong with output: that it isn't JSON, or, if
+// validate is set, how it doesn't match schema.
+func checkJSON(output string, schema any, validate bool) []string {
+	var value any
+	if err := json.Unmarshal([]byte(output), &value); err != nil {
+		return []string{"not valid JSON: " + err.Error()}
+	}
+	if !validate {
+		return nil
+	}
+	return validateJSON(schema, value)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// templateVariable matches {{name}} in a prompt template. The input variable
+// is filled with piped input and files.
+var templateVariable = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// promptTemplate is a reusable prompt stored as prompts/NAME.md in the config
+// directory. The file may start with YAML front matter between --- lines
+// setting stop sequences for the reply.
+type promptTemplate struct {
+	Name string
+	Text string
+	Stop []string
+}
+
+func templateDir() (string, error) {
+	dir, err := defaultConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "prompts"), nil
+}
+
+func loadTemplate(name string) (promptTemplate, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return promptTemplate{}, fmt.Errorf("invalid template name %q", name)
+	}
+	dir, err := templateDir()
+	if err != nil {
+		return promptTemplate{}, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, name+".md"))
+	if errors.Is(err, os.ErrNotExist) {
+		return promptTemplate{}, fmt.Errorf("template %q not found in %s", name, dir)
+	}
+	if err != nil {
+		return promptTemplate{}, err
+	}
+
+	t := promptTemplate{Name: name, Text: string(data)}
+	if rest, ok := strings.CutPrefix(t.Text, "---\n"); ok {
+		header, text, ok := strings.Cut(rest, "\n---\n")
+		if !ok {
+			return promptTemplate{}, fmt.Errorf("template %q: front matter has no closing ---", name)
+		}
+		var front struct {
+			Stop []string `yaml:"stop"`
+		}
+		if err := yaml.Unmarshal([]byte(header), &front); err != nil {
+			return promptTemplate{}, fmt.Errorf("template %q: %w", name, err)
+		}
+		t.Text, t.Stop = text, front.Stop
+	}
+	return t, nil
+}
+
+// listTemplates returns the names of the stored templates.
+func listTemplates() ([]string, error) {
+	dir, err := templateDir()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), ".md"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// variables returns the names of the template's variables in order of first
+// use.
+func (t promptTemplate) variables() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range templateVariable.FindAllStringSubmatch(t.Text, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// missing returns the variables that have no value.
+func (t promptTemplate) missing(values map[string]string) []string {
+	var names []string
+	for _, name := range t.variables() {
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// render fills in the variables. Any input the template has no place for is
+// attached to the end.
+func (t promptTemplate) render(values map[string]string) string {
+	input := values["input"]
+	used := false
+	text := templateVariable.ReplaceAllStringFunc(t.Text, func(match string) string {
+		name := templateVariable.FindStringSubmatch(match)[1]
+		if name == "input" {
+			used = true
+			return input
+		}
+		return values[name]
+	})
+
+	text = strings.TrimSpace(text)
+	if used {
+		return text
+	}
+	return withContext(text, input)
+}
+
+// parseTemplateArgs splits arguments into NAME=VALUE variables and the rest.
+func parseTemplateArgs(args []string) (map[string]string, []string) {
+	values := make(map[string]string)
+	var rest []string
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if ok && templateVariable.MatchString("{{"+name+"}}") {
+			values[name] = value
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return values, rest
+}
+
+// templatePrompt builds a one-shot prompt from a template, returning it with
+// the template's stop sequences. Arguments are NAME=VALUE variables or files
+// to use as input along with stdin, and any other variables are asked for on
+// the terminal.
+func templatePrompt(name string, args []string, stdin string) (string, []string, error) {
+	t, err := loadTemplate(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	values, files := parseTemplateArgs(args)
+	input := []string{stdin}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", nil, err
+		}
+		input = append(input, string(data))
+	}
+	if input := strings.TrimSpace(strings.Join(input, "\n")); input != "" {
+		values["input"] = input
+	}
+
+	if missing := t.missing(values); len(missing) > 0 {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return "", nil, fmt.Errorf("template %q needs %s", name, strings.Join(missing, ", "))
+		}
+		defer tty.Close()
+
+		scanner := bufio.NewScanner(tty)
+		for _, variable := range missing {
+			fmt.Fprintf(os.Stderr, "%s: ", variable)
+			if !scanner.Scan() {
+				return "", nil, errors.New("no value for " + variable)
+			}
+			values[variable] = scanner.Text()
+		}
+	}
+	return t.render(values), t.Stop, nil
+}
+
+// templateFill is a template whose variables are being asked for in the TUI.
+type templateFill struct {
+	template promptTemplate
+	values   map[string]string
+	missing  []string
+}
+
+func (f *templateFill) question() string {
+	return fmt.Sprintf("%s: enter %s (Esc to cancel)", f.template.Name, f.missing[0])
+}
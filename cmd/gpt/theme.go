@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// theme holds the colors of the chat. Colors are ANSI color numbers such as
+// "5" or truecolor hex codes such as "#ff79c6". Code is a chroma style, or
+// empty for the Markdown style's own.
+type theme struct {
+	User      string `yaml:"user"`
+	Assistant string `yaml:"assistant"`
+	Error     string `yaml:"error"`
+	Notice    string `yaml:"notice"`
+	Footer    string `yaml:"footer"`
+	Code      string `yaml:"code"`
+	// Markdown picks the "dark" or "light" Markdown style.
+	Markdown string `yaml:"markdown"`
+}
+
+var builtinThemes = map[string]theme{
+	"dark": {
+		User:      "5",
+		Assistant: "5",
+		Error:     "9",
+		Footer:    "8",
+		Markdown:  "dark",
+	},
+	"light": {
+		User:      "5",
+		Assistant: "5",
+		Error:     "1",
+		Footer:    "8",
+		Markdown:  "light",
+	},
+	"solarized": {
+		User:      "#268bd2",
+		Assistant: "#d33682",
+		Error:     "#dc322f",
+		Notice:    "#2aa198",
+		Footer:    "#586e75",
+		Code:      "solarized-dark",
+		Markdown:  "dark",
+	},
+}
+
+// resolveTheme returns the configured theme. Without one, the dark or light
+// theme is picked to suit the terminal, and themes defined in the config file
+// start from that too.
+func resolveTheme(cfg config) (theme, error) {
+	base := builtinThemes["dark"]
+	if !lipgloss.HasDarkBackground() {
+		base = builtinThemes["light"]
+	}
+
+	t := base
+	if cfg.Theme != "" {
+		if custom, ok := cfg.Themes[cfg.Theme]; ok {
+			t = custom.over(base)
+		} else if builtin, ok := builtinThemes[cfg.Theme]; ok {
+			t = builtin
+		} else {
+			return t, fmt.Errorf("unknown theme %q", cfg.Theme)
+		}
+	}
+
+	if cfg.CodeTheme != "" {
+		t.Code = cfg.CodeTheme
+	}
+	return t, nil
+}
+
+// over fills in whatever t leaves unset from base.
+func (t theme) over(base theme) theme {
+	for _, field := range []struct{ value, fallback *string }{
+		{&t.User, &base.User},
+		{&t.Assistant, &base.Assistant},
+		{&t.Error, &base.Error},
+		{&t.Notice, &base.Notice},
+		{&t.Footer, &base.Footer},
+		{&t.Code, &base.Code},
+		{&t.Markdown, &base.Markdown},
+	} {
+		if *field.value == "" {
+			*field.value = *field.fallback
+		}
+	}
+	return t
+}
+
+// styles are the lipgloss styles made from a theme.
+type styles struct {
+	user      lipgloss.Style
+	assistant lipgloss.Style
+	err       lipgloss.Style
+	notice    lipgloss.Style
+	footer    lipgloss.Style
+	// match and currentMatch highlight search matches.
+	match        lipgloss.Style
+	currentMatch lipgloss.Style
+}
+
+func newStyles(t theme) styles {
+	color := func(c string) lipgloss.Style {
+		if c == "" {
+			return lipgloss.NewStyle()
+		}
+		return lipgloss.NewStyle().Foreground(lipgloss.Color(c))
+	}
+	return styles{
+		user:      color(t.User),
+		assistant: color(t.Assistant),
+		err:       color(t.Error),
+		notice:    color(t.Notice),
+		footer:    color(t.Footer),
+
+		match:        lipgloss.NewStyle().Reverse(true),
+		currentMatch: color(t.Notice).Reverse(true).Bold(true),
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// timeoutConfig bounds how long talking to the provider may take. Zero leaves
+// a bound off.
+type timeoutConfig struct {
+	// Connect bounds connecting to the provider.
+	Connect time.Duration `yaml:"connect"`
+	// Read bounds the wait for each part of a reply, the first included.
+	Read time.Duration `yaml:"read"`
+	// Request bounds a whole request, reply and all.
+	Request time.Duration `yaml:"request"`
+}
+
+// timeoutError is returned when a request runs past one of the timeouts.
+// What arrived of the reply before is kept.
+type timeoutError struct {
+	setting string
+	limit   time.Duration
+}
+
+func (e timeoutError) Error() string {
+	if e.setting == "read" {
+		return fmt.Sprintf("the provider sent nothing for %s (timeouts.read)", e.limit)
+	}
+	return fmt.Sprintf("the reply took longer than %s (timeouts.request)", e.limit)
+}
+
+// timeoutProvider ends requests that run past the read or request timeout.
+type timeoutProvider struct {
+	provider
+	timeouts timeoutConfig
+}
+
+func (p timeoutProvider) unwrap() provider { return p.provider }
+
+func (p timeoutProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	s := &timeoutStream{ctx: ctx, cancel: cancel, read: p.timeouts.Read}
+	if limit := p.timeouts.Request; limit > 0 {
+		s.deadline = time.AfterFunc(limit, func() {
+			cancel(timeoutError{setting: "request", limit: limit})
+		})
+	}
+	if limit := p.timeouts.Read; limit > 0 {
+		s.idle = time.AfterFunc(limit, func() {
+			cancel(timeoutError{setting: "read", limit: limit})
+		})
+	}
+
+	stream, err := p.provider.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		err = s.explain(err)
+		s.Close()
+		return nil, err
+	}
+	s.stream = stream
+	return s, nil
+}
+
+type timeoutStream struct {
+	stream   chatStream
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	deadline *time.Timer
+	idle     *time.Timer
+	read     time.Duration
+}
+
+func (s *timeoutStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	response, err := s.stream.Recv()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return response, s.explain(err)
+	}
+	if s.idle != nil {
+		s.idle.Reset(s.read)
+	}
+	return response, err
+}
+
+func (s *timeoutStream) Close() error {
+	if s.deadline != nil {
+		s.deadline.Stop()
+	}
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	var err error
+	if s.stream != nil {
+		err = s.stream.Close()
+	}
+	s.cancel(nil)
+	return err
+}
+
+// explain replaces the error of a request cut short by a timeout with one
+// that says which.
+func (s *timeoutStream) explain(err error) error {
+	var timeout timeoutError
+	if errors.As(context.Cause(s.ctx), &timeout) {
+		return timeout
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// defaultCaptureLines is how far back a pane is captured unless told
+// otherwise.
+const defaultCaptureLines = 200
+
+// capturePane returns the last lines of a tmux pane's scrollback. An empty
+// target is the pane gpt runs in; above limits it to the history above what
+// is on screen, which is the chat itself once it has started.
+func capturePane(target string, lines int, above bool) (string, error) {
+	if os.Getenv("TMUX") == "" {
+		return "", errors.New("capturing a pane needs gpt to run inside tmux")
+	}
+
+	args := []string{"capture-pane", "-p", "-J", "-S", "-" + strconv.Itoa(lines)}
+	if above {
+		args = append(args, "-E", "-1")
+	}
+	if target == "" {
+		target = os.Getenv("TMUX_PANE")
+	}
+	if target != "" {
+		args = append(args, "-t", target)
+	}
+	out, err := runTmux(args...)
+	if err != nil {
+		return "", err
+	}
+	text := strings.TrimRight(out, "\n ")
+	if text == "" {
+		return "", errors.New("the pane is empty")
+	}
+	return text, nil
+}
+
+// paneAttachment formats what was captured of a pane for a message.
+func paneAttachment(target, text string) string {
+	if target == "" {
+		target = "current"
+	}
+	return fmt.Sprintf("\n\n<pane id=\"%s\">\n%s\n</pane>", target, text)
+}
+
+// runTmux runs tmux with args and returns what it printed.
+func runTmux(args ...string) (string, error) {
+	out, err := exec.Command("tmux", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("tmux: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("tmux: %w", err)
+	}
+	return string(out), nil
+}
+
+// capture handles /capture-pane, attaching the scrollback of a pane to the
+// next message: by default the history above the chat.
+func (m *model) capture(args []string) tea.Cmd {
+	lines, target := defaultCaptureLines, ""
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+			lines = n
+		} else {
+			target = arg
+		}
+	}
+
+	text, err := capturePane(target, lines, target == "")
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	m.pages += paneAttachment(target, text)
+	m.notice = fmt.Sprintf("Attached %d lines of the pane to your next message", strings.Count(text, "\n")+1)
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func init() {
+	// Use the encodings bundled into the binary rather than downloading them.
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// estimateTokens approximates the number of tokens in s using the common rule
+// of thumb of four characters per token.
+func estimateTokens(s string) int {
+	return (utf8.RuneCountInString(s) + 3) / 4
+}
+
+// lazyEncoding loads a tiktoken encoding the first time it is needed. Loading
+// takes a moment, so the TUI starts it in the background and estimates until
+// it is ready.
+type lazyEncoding struct {
+	name string
+	once sync.Once
+	enc  atomic.Pointer[tiktoken.Tiktoken]
+}
+
+func (l *lazyEncoding) load() *tiktoken.Tiktoken {
+	l.once.Do(func() {
+		if enc, err := tiktoken.GetEncoding(l.name); err == nil {
+			l.enc.Store(enc)
+		}
+	})
+	return l.enc.Load()
+}
+
+func (l *lazyEncoding) loaded() *tiktoken.Tiktoken {
+	return l.enc.Load()
+}
+
+var encodings = map[string]*lazyEncoding{
+	"o200k_base":  {name: "o200k_base"},
+	"cl100k_base": {name: "cl100k_base"},
+}
+
+// encodingFor returns the encoding used by a model. Models from other
+// providers are counted with an OpenAI encoding, which is close enough.
+func encodingFor(model string) *lazyEncoding {
+	for _, prefix := range []string{"gpt-4o", "gpt-4.1", "gpt-5", "o1", "o3", "o4"} {
+		if strings.HasPrefix(model, prefix) {
+			return encodings["o200k_base"]
+		}
+	}
+	return encodings["cl100k_base"]
+}
+
+// countTokens counts the tokens in s for model, loading the encoding if
+// necessary.
+func countTokens(model, s string) int {
+	enc := encodingFor(model).load()
+	if enc == nil {
+		return estimateTokens(s)
+	}
+	return len(enc.EncodeOrdinary(s))
+}
+
+// Every message carries a few tokens of framing, and every reply is primed
+// with a few more.
+const (
+	tokensPerMessage = 4
+	tokensPerReply   = 3
+)
+
+func countMessageTokens(model string, messages []openai.ChatCompletionMessage) int {
+	total := tokensPerReply
+	for _, msg := range messages {
+		total += tokensPerMessage + countTokens(model, msg.Content)
+	}
+	return total
+}
+
+// tokenCounter counts tokens for the TUI without blocking it. Counts are
+// estimated until the model's encoding has loaded, and exact counts are cached
+// by content.
+type tokenCounter struct {
+	encoding *lazyEncoding
+	cache    map[string]int
+}
+
+func newTokenCounter(model string) *tokenCounter {
+	c := &tokenCounter{}
+	c.setModel(model)
+	return c
+}
+
+func (c *tokenCounter) setModel(model string) {
+	enc := encodingFor(model)
+	if enc == c.encoding {
+		return
+	}
+
+	c.encoding = enc
+	c.cache = make(map[string]int)
+	go enc.load()
+}
+
+func (c *tokenCounter) count(s string) int {
+	if n, ok := c.cache[s]; ok {
+		return n
+	}
+
+	enc := c.encoding.loaded()
+	if enc == nil {
+		return estimateTokens(s)
+	}
+	n := len(enc.EncodeOrdinary(s))
+	c.cache[s] = n
+	return n
+}
+
+func (c *tokenCounter) countMessages(messages []openai.ChatCompletionMessage) int {
+	total := tokensPerReply
+	for _, msg := range messages {
+		total += tokensPerMessage + c.count(msg.Content)
+	}
+	return total
+}
+
+// contextWindows lists context window sizes by model name prefix. More
+// specific prefixes come first.
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4o", 128_000},
+	{"gpt-4-turbo", 128_000},
+	{"gpt-4.1", 1_047_576},
+	{"gpt-4", 8_192},
+	{"gpt-5", 400_000},
+	{"gpt-3.5-turbo", 16_385},
+	{"o1", 200_000},
+	{"o3", 200_000},
+	{"o4", 200_000},
+	{"claude", 200_000},
+	{"gemini", 1_048_576},
+	{"llama3", 8_192},
+}
+
+// contextWindow returns the context window of the configured model, or 0 if
+// it is unknown.
+func (c config) contextWindow() int {
+	if c.ContextWindow > 0 {
+		return c.ContextWindow
+	}
+	for _, window := range contextWindows {
+		if strings.HasPrefix(c.Model, window.prefix) {
+			return window.tokens
+		}
+	}
+	return 0
+}
+
+// outputLimits lists the most tokens a model can write in one reply, by
+// model name prefix. More specific prefixes come first.
+var outputLimits = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4o", 16_384},
+	{"gpt-4-turbo", 4_096},
+	{"gpt-4.1", 32_768},
+	{"gpt-4", 8_192},
+	{"gpt-5", 128_000},
+	{"gpt-3.5-turbo", 4_096},
+	{"o1", 100_000},
+	{"o3", 100_000},
+	{"o4", 100_000},
+	{"claude-3-5", 8_192},
+	{"claude-3-7", 64_000},
+	{"claude-3", 4_096},
+	{"claude-opus-4", 32_000},
+	{"claude-sonnet-4", 64_000},
+	{"claude-haiku-4", 64_000},
+	{"gemini-2.5", 65_536},
+	{"gemini", 8_192},
+}
+
+// outputLimit returns the most tokens model can write in a reply, or 0 if it
+// is unknown.
+func outputLimit(model string) int {
+	for _, limit := range outputLimits {
+		if strings.HasPrefix(model, limit.prefix) {
+			return limit.tokens
+		}
+	}
+	return 0
+}
+
+// maxTokens returns the most tokens to ask for in a reply: max_tokens, or
+// else the longest matching prefix in model_max_tokens, kept within what the
+// model can write. 0 leaves it to the provider.
+func (c config) maxTokens() int {
+	n := c.MaxTokens
+	if n == 0 {
+		longest := -1
+		for prefix, tokens := range c.ModelMaxTokens {
+			if strings.HasPrefix(c.Model, prefix) && len(prefix) > longest {
+				n, longest = tokens, len(prefix)
+			}
+		}
+	}
+	if limit := outputLimit(c.Model); limit > 0 && n > limit {
+		n = limit
+	}
+	return n
+}
+
+// formatCount formats n with thousands separators, e.g. 1,234.
+func formatCount(n int) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0 && s[i-1] != '-'; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// formatWindow formats a context window size compactly, e.g. 128k or 1M.
+func formatWindow(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.3gM", float64(n)/1_000_000)
+	case n >= 1_000:
+		return fmt.Sprintf("%dk", n/1_000)
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// maxToolRounds is how many times a reply may call tools before it has to
+// answer with what it has.
+const maxToolRounds = 8
+
+// tool is a function the model may call while replying.
+type tool struct {
+	name        string
+	description string
+	// parameters describes the arguments, which arrive as a JSON object,
+	// as a jsonSchema or JSON Schema from elsewhere.
+	parameters any
+	// status describes a call while it runs.
+	status func(args string) string
+	// run carries out a call, returning the result for the model.
+	run func(ctx context.Context, args string, tio toolIO) (string, error)
+	// summary is what the transcript shows of a result, if anything.
+	summary func(result string) string
+
+	// required are the arguments that must be given, taken from the
+	// parameters by register.
+	required []string
+}
+
+// toolIO is how a running tool reaches the user.
+type toolIO struct {
+	// confirm asks the user to allow something, reporting whether they
+	// did. Without it nothing is allowed.
+	confirm func(prompt string) bool
+	// output shows what a tool prints as it runs.
+	output func(string)
+	// running is told of each call as it starts.
+	running func(call openai.ToolCall)
+}
+
+func (tio toolIO) ask(prompt string) bool {
+	return tio.confirm != nil && tio.confirm(prompt)
+}
+
+func (tio toolIO) print(s string) {
+	if tio.output != nil {
+		tio.output(s)
+	}
+}
+
+// jsonSchema is the part of JSON Schema needed to describe tool arguments.
+type jsonSchema struct {
+	Type        string                `json:"type"`
+	Description string                `json:"description,omitempty"`
+	Properties  map[string]jsonSchema `json:"properties,omitempty"`
+	Required    []string              `json:"required,omitempty"`
+	Items       *jsonSchema           `json:"items,omitempty"`
+	Enum        []string              `json:"enum,omitempty"`
+}
+
+// toolRegistry holds the tools offered to the model. A nil registry offers
+// none.
+type toolRegistry struct {
+	tools map[string]tool
+	// servers are the MCP servers some of the tools come from.
+	servers []*mcpClient
+	// rounds caps how many times a reply may call tools, in place of
+	// maxToolRounds.
+	rounds int
+}
+
+// newToolRegistry registers the built-in tools the config turns on.
+func newToolRegistry(cfg config) (*toolRegistry, error) {
+	r := &toolRegistry{tools: make(map[string]tool)}
+	if cfg.WebSearch.Backend != "" {
+		t, err := newWebSearchTool(cfg.WebSearch)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.register(t); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ShellTool {
+		if err := r.register(newShellTool()); err != nil {
+			return nil, err
+		}
+	}
+	transport, err := cfg.transport()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.addMCPServers(cfg.MCPServers, &http.Client{Transport: transport}); err != nil {
+		r.close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// addAgentTools registers the tools an agent works with, where they aren't
+// already: the shell, reading and writing files, and fetching pages.
+func (r *toolRegistry) addAgentTools() error {
+	for _, t := range []tool{newShellTool(), newReadFileTool(), newWriteFileTool(), newFetchTool()} {
+		if _, ok := r.tools[t.name]; ok {
+			continue
+		}
+		if err := r.register(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close disconnects from the MCP servers.
+func (r *toolRegistry) close() {
+	if r == nil {
+		return
+	}
+	for _, server := range r.servers {
+		server.close()
+	}
+}
+
+// register adds a tool, which must have a name of its own and take an
+// object of arguments.
+func (r *toolRegistry) register(t tool) error {
+	if t.name == "" || t.run == nil {
+		return fmt.Errorf("tool %q needs a name and a function to run", t.name)
+	}
+	if _, ok := r.tools[t.name]; ok {
+		return fmt.Errorf("tool %q is registered twice", t.name)
+	}
+	var schema struct {
+		Type     string   `json:"type"`
+		Required []string `json:"required"`
+	}
+	data, err := json.Marshal(t.parameters)
+	if err == nil {
+		err = json.Unmarshal(data, &schema)
+	}
+	if err != nil || schema.Type != "object" {
+		return fmt.Errorf("tool %q must take an object of arguments", t.name)
+	}
+	t.required = schema.Required
+	r.tools[t.name] = t
+	return nil
+}
+
+// names returns the names of the tools in order.
+func (r *toolRegistry) names() []string {
+	if r == nil {
+		return nil
+	}
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// definitions describes the tools for a request.
+func (r *toolRegistry) definitions() []openai.Tool {
+	var defs []openai.Tool
+	for _, name := range r.names() {
+		t := r.tools[name]
+		defs = append(defs, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        t.name,
+				Description: t.description,
+				Parameters:  t.parameters,
+			},
+		})
+	}
+	return defs
+}
+
+func (r *toolRegistry) find(name string) (tool, bool) {
+	if r == nil {
+		return tool{}, false
+	}
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// status describes calls while they run.
+func (r *toolRegistry) status(calls []openai.ToolCall) string {
+	call := calls[0].Function
+	status := "Calling " + call.Name
+	if t, ok := r.find(call.Name); ok && t.status != nil {
+		status = t.status(call.Arguments)
+	}
+	if len(calls) > 1 {
+		status += fmt.Sprintf(" (and %d more)", len(calls)-1)
+	}
+	return status
+}
+
+// run carries out calls, returning a tool message with the result of each.
+// Failures are reported to the model rather than ending the reply, so that it
+// can try something else.
+func (r *toolRegistry) run(ctx context.Context, calls []openai.ToolCall, tio toolIO) []openai.ChatCompletionMessage {
+	results := make([]openai.ChatCompletionMessage, len(calls))
+	for i, call := range calls {
+		if tio.running != nil {
+			tio.running(call)
+		}
+		content, err := r.call(ctx, call, tio)
+		if err != nil {
+			content = "Error: " + err.Error()
+		}
+		results[i] = openai.ChatCompletionMessage{
+			Role:       openai.ChatMessageRoleTool,
+			Content:    content,
+			ToolCallID: call.ID,
+		}
+	}
+	return results
+}
+
+func (r *toolRegistry) call(ctx context.Context, call openai.ToolCall, tio toolIO) (string, error) {
+	t, ok := r.find(call.Function.Name)
+	if !ok {
+		return "", fmt.Errorf("there is no tool called %q", call.Function.Name)
+	}
+
+	args := call.Function.Arguments
+	if strings.TrimSpace(args) == "" {
+		args = "{}"
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("the arguments must be a JSON object: %w", err)
+	}
+	for _, name := range t.required {
+		if _, ok := params[name]; !ok {
+			return "", fmt.Errorf("missing argument %q", name)
+		}
+	}
+	return t.run(ctx, args, tio)
+}
+
+// summary returns what the transcript shows of the result of a call to the
+// named tool.
+func (r *toolRegistry) summary(name, result string) string {
+	if t, ok := r.find(name); ok && t.summary != nil {
+		return t.summary(result)
+	}
+	return ""
+}
+
+// chatEvents follows a reply as it streams and calls tools.
+type chatEvents struct {
+	// delta receives each piece of text.
+	delta func(string)
+	// calling is told of the tools a reply calls before they run.
+	calling func(calls []openai.ToolCall)
+	// called receives the reply so far, with its calls, and their
+	// results, after which the reply goes on.
+	called func(reply openai.ChatCompletionMessage, results []openai.ChatCompletionMessage)
+
+	toolIO
+}
+
+// chatWithTools streams a reply to req, carrying out the tools it calls and
+// sending back their results until it answers. The usage is summed over the
+// requests this takes.
+func chatWithTools(ctx context.Context, p provider, tools *toolRegistry, req openai.ChatCompletionRequest, events chatEvents) (chatResult, error) {
+	var result chatResult
+	req.Tools = tools.definitions()
+	limit := maxToolRounds
+	if tools != nil && tools.rounds > 0 {
+		limit = tools.rounds
+	}
+	for round := 0; ; round++ {
+		if round == limit {
+			// Make it answer with what it has.
+			req.Tools = nil
+		}
+
+		var reply strings.Builder
+		r, err := streamChat(ctx, p, req, func(delta string) {
+			reply.WriteString(delta)
+			events.delta(delta)
+		})
+		result.usage.PromptTokens += r.usage.PromptTokens
+		result.usage.CompletionTokens += r.usage.CompletionTokens
+		result.usage.TotalTokens += r.usage.TotalTokens
+		if r.fingerprint != "" {
+			result.fingerprint = r.fingerprint
+		}
+		if r.model != "" {
+			result.model = r.model
+		}
+		result.finish = r.finish
+		calls := r.calls
+		if err != nil || len(calls) == 0 {
+			return result, err
+		}
+
+		events.calling(calls)
+		message := openai.ChatCompletionMessage{
+			Role:      openai.ChatMessageRoleAssistant,
+			Content:   reply.String(),
+			ToolCalls: calls,
+		}
+		results := tools.run(ctx, calls, events.toolIO)
+		events.called(message, results)
+		req.Messages = append(req.Messages, message)
+		req.Messages = append(req.Messages, results...)
+	}
+}
+
+// formatToolCall shows a call in the transcript.
+func formatToolCall(call openai.ToolCall) string {
+	args := strings.Join(strings.Fields(call.Function.Arguments), " ")
+	if len(args) > 80 {
+		args = strings.ToValidUTF8(args[:77], "") + "..."
+	}
+	return fmt.Sprintf("%s(%s)", call.Function.Name, args)
+}
+
+// listTools handles /tools.
+func (m *model) listTools() {
+	names := m.tools.names()
+	if len(names) == 0 {
+		m.notice = "No tools are set up"
+		return
+	}
+	m.notice = "Tools: " + strings.Join(names, ", ")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// toolCallingProvider calls the add tool the first calls times it is offered
+// tools, and otherwise leaves the reply to the provider it wraps.
+type toolCallingProvider struct {
+	provider
+	calls    int
+	requests []openai.ChatCompletionRequest
+}
+
+func (p *toolCallingProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	p.requests = append(p.requests, req)
+	if len(req.Tools) == 0 || p.calls == 0 {
+		return p.provider.CreateChatCompletionStream(ctx, req)
+	}
+	p.calls--
+	id := "call_" + strconv.Itoa(len(p.requests))
+	index := 0
+	return &scriptedStream{responses: []openai.ChatCompletionStreamResponse{
+		toolCallResponse(openai.ToolCall{Index: &index, ID: id, Function: openai.FunctionCall{Name: "add"}}),
+		toolCallResponse(openai.ToolCall{Index: &index, Function: openai.FunctionCall{Arguments: `{"a": 3, `}}),
+		toolCallResponse(openai.ToolCall{Index: &index, Function: openai.FunctionCall{Arguments: `"b": 4}`}}),
+		{
+			Choices: []openai.ChatCompletionStreamChoice{{FinishReason: openai.FinishReasonToolCalls}},
+			Usage:   &openai.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		},
+	}}, nil
+}
+
+func toolCallResponse(call openai.ToolCall) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{{
+		Delta: openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{call}},
+	}}}
+}
+
+func TestChatWithTools(t *testing.T) {
+	tests := []struct {
+		name string
+		// calls is how many times the model calls the tool, and rounds
+		// the most it may.
+		calls, rounds int
+		wantCalls     int
+	}{
+		{name: "one call", calls: 1, wantCalls: 1},
+		{name: "several calls", calls: 3, wantCalls: 3},
+		{name: "out of rounds", calls: 5, rounds: 2, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tools := &toolRegistry{tools: make(map[string]tool), rounds: tt.rounds}
+			err := tools.register(tool{
+				name: "add",
+				parameters: jsonSchema{
+					Type: "object",
+					Properties: map[string]jsonSchema{
+						"a": {Type: "integer"},
+						"b": {Type: "integer"},
+					},
+					Required: []string{"a", "b"},
+				},
+				run: func(ctx context.Context, args string, tio toolIO) (string, error) {
+					return "7", nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &toolCallingProvider{
+				provider: replyProvider("The sum is 7."),
+				calls:    tt.calls,
+			}
+			req := openai.ChatCompletionRequest{
+				Model:    "test-model",
+				Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "What is 3 + 4?"}},
+			}
+
+			var (
+				reply   strings.Builder
+				calling int
+				called  []openai.ChatCompletionMessage
+			)
+			result, err := chatWithTools(context.Background(), p, tools, req, chatEvents{
+				delta: func(delta string) { reply.WriteString(delta) },
+				calling: func(calls []openai.ToolCall) {
+					calling++
+					if len(calls) != 1 || calls[0].Function.Name != "add" || calls[0].Function.Arguments != `{"a": 3, "b": 4}` {
+						t.Errorf("calling %+v, want add with a and b", calls)
+					}
+				},
+				called: func(reply openai.ChatCompletionMessage, results []openai.ChatCompletionMessage) {
+					called = append(called, results...)
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reply.String() != "The sum is 7." {
+				t.Errorf("reply = %q, want %q", reply.String(), "The sum is 7.")
+			}
+			if calling != tt.wantCalls || len(called) != tt.wantCalls {
+				t.Fatalf("%d calls and %d results, want %d", calling, len(called), tt.wantCalls)
+			}
+			for i, msg := range called {
+				want := openai.ChatCompletionMessage{
+					Role:       openai.ChatMessageRoleTool,
+					Content:    "7",
+					ToolCallID: "call_" + strconv.Itoa(i+1),
+				}
+				if !reflect.DeepEqual(msg, want) {
+					t.Errorf("result %d = %+v, want %+v", i, msg, want)
+				}
+			}
+
+			// Each request after the first carries the calls and results
+			// so far.
+			if len(p.requests) != tt.wantCalls+1 {
+				t.Fatalf("%d requests, want %d", len(p.requests), tt.wantCalls+1)
+			}
+			last := p.requests[len(p.requests)-1]
+			if len(last.Messages) != 1+2*tt.wantCalls {
+				t.Errorf("last request has %d messages, want %d", len(last.Messages), 1+2*tt.wantCalls)
+			}
+			if tt.rounds > 0 && len(last.Tools) != 0 {
+				t.Errorf("last request offers %d tools, want none once out of rounds", len(last.Tools))
+			}
+			if result.usage.PromptTokens < 10*tt.wantCalls || result.usage.TotalTokens != result.usage.PromptTokens+result.usage.CompletionTokens {
+				t.Errorf("usage = %+v, want it summed over the requests", result.usage)
+			}
+		})
+	}
+}
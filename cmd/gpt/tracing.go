@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// tracingConfig exports a span for each request to the provider, and one for
+// each HTTP attempt beneath it, to an OpenTelemetry collector over OTLP/HTTP.
+// The spans follow the OpenTelemetry conventions for generative AI, so that
+// tools that know them can chart latency, retries and tokens by model.
+type tracingConfig struct {
+	// Endpoint is the collector's OTLP/HTTP address, such as
+	// http://localhost:4318. Tracing is off without one.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are sent with each export, e.g. for authentication.
+	Headers     map[string]string `yaml:"headers"`
+	ServiceName string            `yaml:"service_name"`
+}
+
+const (
+	// traceBatch is how many spans are held before they are exported.
+	traceBatch = 64
+	// traceInterval is how often spans are exported regardless, so that a
+	// long chat shows up as it goes.
+	traceInterval = 5 * time.Second
+	// traceExportTimeout bounds a single export.
+	traceExportTimeout = 5 * time.Second
+)
+
+// withEnv fills in what the standard OTEL_ variables set and the
+// config file doesn't.
+func (t tracingConfig) withEnv() tracingConfig {
+	if t.Endpoint == "" {
+		if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); v != "" {
+			// This one is the full URL, /v1/traces included.
+			t.Endpoint = strings.TrimSuffix(v, "/v1/traces")
+		} else {
+			t.Endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		}
+	}
+	if len(t.Headers) == 0 {
+		if v := os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"); v != "" {
+			t.Headers = make(map[string]string)
+			for _, pair := range strings.Split(v, ",") {
+				if key, value, ok := strings.Cut(pair, "="); ok {
+					t.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+				}
+			}
+		}
+	}
+	if t.ServiceName == "" {
+		t.ServiceName = os.Getenv("OTEL_SERVICE_NAME")
+	}
+	if t.ServiceName == "" {
+		t.ServiceName = "gpt"
+	}
+	return t
+}
+
+// span is a finished or running operation. Its methods do nothing on a nil
+// span, so that untraced code needn't check.
+type span struct {
+	tracer   *tracer
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+
+	mu       sync.Mutex
+	attrs    map[string]any
+	events   []spanEvent
+	err      error
+	attempts int
+	ended    bool
+}
+
+type spanEvent struct {
+	time  time.Time
+	name  string
+	attrs map[string]any
+}
+
+// spanKindClient is OTLP's kind for a span of a request to a remote service.
+const spanKindClient = 3
+
+type spanKey struct{}
+
+func withSpan(ctx context.Context, s *span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+func (s *span) event(name string, attrs map[string]any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.events = append(s.events, spanEvent{time: time.Now(), name: name, attrs: attrs})
+	s.mu.Unlock()
+}
+
+// fail marks the span as failed, unless the error is only the end of a
+// stream.
+func (s *span) fail(err error) {
+	if s == nil || err == nil || errors.Is(err, io.EOF) {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// nextAttempt numbers the HTTP attempts made for the span, from 0.
+func (s *span) nextAttempt() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.attempts
+	s.attempts++
+	return n
+}
+
+func (s *span) end() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.mu.Unlock()
+	s.tracer.finish(s, time.Now())
+}
+
+// tracer keeps finished spans until they are exported.
+type tracer struct {
+	config tracingConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []json.RawMessage
+	stop    chan struct{}
+}
+
+var (
+	tracersMu sync.Mutex
+	// tracers are shared by every provider exporting to the same collector.
+	tracers = make(map[string]*tracer)
+)
+
+// tracerFor returns the tracer the config asks for, or nil if tracing is off.
+func tracerFor(cfg config) *tracer {
+	tc := cfg.Tracing.withEnv()
+	if tc.Endpoint == "" {
+		return nil
+	}
+	tracersMu.Lock()
+	defer tracersMu.Unlock()
+	if t, ok := tracers[tc.Endpoint]; ok {
+		return t
+	}
+	t := &tracer{
+		config: tc,
+		// Exports aren't traced, retried or sent with the provider's
+		// headers.
+		client: &http.Client{Timeout: traceExportTimeout},
+		stop:   make(chan struct{}),
+	}
+	tracers[tc.Endpoint] = t
+	go t.run()
+	return t
+}
+
+// flushTraces exports whatever spans are left, before gpt exits.
+func flushTraces() {
+	tracersMu.Lock()
+	defer tracersMu.Unlock()
+	for _, t := range tracers {
+		close(t.stop)
+		t.export()
+	}
+	tracers = make(map[string]*tracer)
+}
+
+func (t *tracer) run() {
+	ticker := time.NewTicker(traceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.export()
+		}
+	}
+}
+
+// start begins a span, a child of the one in ctx if there is one.
+func (t *tracer) start(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{
+		tracer: t,
+		spanID: randomHex(8),
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  make(map[string]any),
+	}
+	if parent := spanFrom(ctx); parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		s.traceID = randomHex(16)
+	}
+	return withSpan(ctx, s), s
+}
+
+func (t *tracer) finish(s *span, end time.Time) {
+	data, err := json.Marshal(s.otlp(end))
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	t.pending = append(t.pending, data)
+	full := len(t.pending) >= traceBatch
+	t.mu.Unlock()
+	if full {
+		go t.export()
+	}
+}
+
+// export sends the pending spans. Spans that can't be sent are dropped, as
+// tracing mustn't get in the way of chatting.
+func (t *tracer) export() {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(map[string]any{
+				"service.name":    t.config.ServiceName,
+				"service.version": currentBuild().Version,
+			})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github.com/jianyuan/gpt-cli"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(t.config.Endpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.config.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// otlp returns the span in OTLP's JSON encoding.
+func (s *span) otlp(end time.Time) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := map[string]any{
+		"traceId":           s.traceID,
+		"spanId":            s.spanID,
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attrs),
+	}
+	if s.parentID != "" {
+		o["parentSpanId"] = s.parentID
+	}
+	if len(s.events) > 0 {
+		events := make([]any, len(s.events))
+		for i, e := range s.events {
+			events[i] = map[string]any{
+				"timeUnixNano": strconv.FormatInt(e.time.UnixNano(), 10),
+				"name":         e.name,
+				"attributes":   otlpAttributes(e.attrs),
+			}
+		}
+		o["events"] = events
+	}
+	if s.err != nil {
+		o["status"] = map[string]any{"code": 2, "message": s.err.Error()}
+	}
+	return o
+}
+
+func otlpAttributes(attrs map[string]any) []any {
+	list := make([]any, 0, len(attrs))
+	for _, key := range sortedKeys(attrs) {
+		var value map[string]any
+		switch v := attrs[key].(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int:
+			// 64-bit integers are strings in OTLP's JSON.
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case []string:
+			values := make([]any, len(v))
+			for i, s := range v {
+				values[i] = map[string]any{"stringValue": s}
+			}
+			value = map[string]any{"arrayValue": map[string]any{"values": values}}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		list = append(list, map[string]any{"key": key, "value": value})
+	}
+	return list
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// tracingProvider traces each request, from sending it until its reply has
+// been read, with its model, tokens and finish reason.
+type tracingProvider struct {
+	provider
+	name   string
+	tracer *tracer
+}
+
+func (p tracingProvider) unwrap() provider { return p.provider }
+
+func (p tracingProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (chatStream, error) {
+	ctx, s := p.tracer.start(ctx, "chat "+req.Model, spanKindClient)
+	s.set("gen_ai.operation.name", "chat")
+	s.set("gen_ai.system", p.name)
+	s.set("gen_ai.request.model", req.Model)
+	if req.MaxTokens > 0 {
+		s.set("gen_ai.request.max_tokens", req.MaxTokens)
+	}
+	if req.Temperature != 0 {
+		s.set("gen_ai.request.temperature", float64(req.Temperature))
+	}
+	s.set("gpt.request.messages", len(req.Messages))
+
+	stream, err := p.provider.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		s.fail(err)
+		s.end()
+		return nil, err
+	}
+	return &tracingStream{stream: stream, span: s}, nil
+}
+
+type tracingStream struct {
+	stream chatStream
+	span   *span
+	chunks int
+}
+
+func (t *tracingStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	response, err := t.stream.Recv()
+	if err != nil {
+		t.span.set("gpt.response.chunks", t.chunks)
+		t.span.fail(err)
+		t.span.end()
+		return response, err
+	}
+	t.chunks++
+	if t.chunks == 1 {
+		t.span.event("gen_ai.first_token", nil)
+	}
+	if response.Model != "" {
+		t.span.set("gen_ai.response.model", response.Model)
+	}
+	if len(response.Choices) > 0 && response.Choices[0].FinishReason != "" {
+		t.span.set("gen_ai.response.finish_reasons", []string{string(response.Choices[0].FinishReason)})
+	}
+	if u := response.Usage; u != nil {
+		t.span.set("gen_ai.usage.input_tokens", u.PromptTokens)
+		t.span.set("gen_ai.usage.output_tokens", u.CompletionTokens)
+	}
+	return response, err
+}
+
+func (t *tracingStream) Close() error {
+	t.span.set("gpt.response.chunks", t.chunks)
+	t.span.end()
+	return t.stream.Close()
+}
+
+// tracingTransport traces each HTTP attempt beneath a request, so that
+// retries show up as spans of their own.
+type tracingTransport struct {
+	tracer *tracer
+	base   http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	parent := spanFrom(req.Context())
+	_, s := t.tracer.start(req.Context(), req.Method, spanKindClient)
+	s.set("http.request.method", req.Method)
+	s.set("server.address", req.URL.Hostname())
+	s.set("url.path", req.URL.Path)
+	if attempt := parent.nextAttempt(); attempt > 0 {
+		s.set("http.request.resend_count", attempt)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		s.fail(err)
+	} else {
+		s.set("http.response.status_code", resp.StatusCode)
+		if resp.StatusCode >= 400 {
+			s.fail(errors.New(resp.Status))
+		}
+	}
+	// The span ends with the headers; reading the body is the parent's.
+	s.end()
+	return resp, err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// vectorStore keeps the embedded chunks of an index. Chunks are identified by
+// their hash, so re-indexing only adds what changed and deletes what is gone.
+type vectorStore interface {
+	// model returns the embedding model the chunks were embedded with.
+	model() (string, error)
+	setModel(model string) error
+	// hashes returns the hashes of the stored chunks.
+	hashes() (map[string]bool, error)
+	add(chunks []indexChunk) error
+	delete(hashes []string) error
+	// query returns the k chunks closest to vector, closest first.
+	query(vector []float32, k int) ([]indexChunk, error)
+	close() error
+}
+
+// openVectorStore opens the store of the given kind for the directory root.
+// Unless create is set, the error wraps os.ErrNotExist if there is none yet.
+func openVectorStore(kind, root string, create bool) (vectorStore, error) {
+	switch kind {
+	case "", "sqlite":
+		return openSQLiteStore(filepath.Join(root, sqliteIndexFile), create)
+	case "json":
+		path, err := jsonIndexPath(root)
+		if err != nil {
+			return nil, err
+		}
+		return openJSONStore(path, root, create)
+	}
+	return nil, fmt.Errorf("unknown vector_store %q; use sqlite or json", kind)
+}
+
+// jsonStore keeps an index in a single JSON file in the data directory, read
+// into memory whole. It suits small projects and places where the index
+// shouldn't be written next to the code.
+type jsonStore struct {
+	path string
+	data struct {
+		Root   string       `json:"root"`
+		Model  string       `json:"model"`
+		Chunks []indexChunk `json:"chunks"`
+	}
+}
+
+// jsonIndexPath returns where the JSON index of the directory root is kept.
+func jsonIndexPath(root string) (string, error) {
+	dataDir, err := defaultDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "indexes", hashString(root)[:16]+".json"), nil
+}
+
+func openJSONStore(path, root string, create bool) (*jsonStore, error) {
+	s := &jsonStore{path: path}
+	s.data.Root = root
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && create:
+		return s, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *jsonStore) model() (string, error) {
+	return s.data.Model, nil
+}
+
+func (s *jsonStore) setModel(model string) error {
+	s.data.Model = model
+	return s.save()
+}
+
+func (s *jsonStore) hashes() (map[string]bool, error) {
+	hashes := make(map[string]bool, len(s.data.Chunks))
+	for _, chunk := range s.data.Chunks {
+		hashes[chunk.Hash] = true
+	}
+	return hashes, nil
+}
+
+func (s *jsonStore) add(chunks []indexChunk) error {
+	s.data.Chunks = append(s.data.Chunks, chunks...)
+	return s.save()
+}
+
+func (s *jsonStore) delete(hashes []string) error {
+	gone := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		gone[hash] = true
+	}
+
+	kept := s.data.Chunks[:0]
+	for _, chunk := range s.data.Chunks {
+		if !gone[chunk.Hash] {
+			kept = append(kept, chunk)
+		}
+	}
+	s.data.Chunks = kept
+	return s.save()
+}
+
+func (s *jsonStore) query(vector []float32, k int) ([]indexChunk, error) {
+	return closestChunks(s.data.Chunks, vector, k), nil
+}
+
+func (s *jsonStore) close() error {
+	return nil
+}
+
+func (s *jsonStore) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// closestChunks returns the k chunks whose embeddings are closest to vector.
+func closestChunks(chunks []indexChunk, vector []float32, k int) []indexChunk {
+	type scored struct {
+		chunk indexChunk
+		score float64
+	}
+
+	results := make([]scored, 0, len(chunks))
+	for _, chunk := range chunks {
+		results = append(results, scored{chunk, cosineSimilarity(vector, chunk.Vector)})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+
+	if len(results) > k {
+		results = results[:k]
+	}
+	closest := make([]indexChunk, len(results))
+	for i, result := range results {
+		closest[i] = result.chunk
+	}
+	return closest
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteIndexFile is the name of the SQLite index, kept at the root of the
+// indexed directory.
+const sqliteIndexFile = ".gpt-index.db"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS chunks (
+	hash     TEXT PRIMARY KEY,
+	path     TEXT NOT NULL,
+	start    INTEGER NOT NULL,
+	end_line INTEGER NOT NULL,
+	text     TEXT NOT NULL,
+	vector   BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS chunks_path ON chunks (path);
+`
+
+// sqliteStore keeps an index in a SQLite database next to the indexed code,
+// so that each project has its own. The driver is pure Go, which can't load
+// extensions such as sqlite-vec, so similarity is computed as the chunks are
+// read.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLiteStore(path string, create bool) (*sqliteStore, error) {
+	if !create {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) model() (string, error) {
+	var model string
+	err := s.db.QueryRow(`SELECT value FROM meta WHERE key = 'model'`).Scan(&model)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return model, err
+}
+
+func (s *sqliteStore) setModel(model string) error {
+	_, err := s.db.Exec(`INSERT INTO meta (key, value) VALUES ('model', ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, model)
+	return err
+}
+
+func (s *sqliteStore) hashes() (map[string]bool, error) {
+	rows, err := s.db.Query(`SELECT hash FROM chunks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes[hash] = true
+	}
+	return hashes, rows.Err()
+}
+
+func (s *sqliteStore) add(chunks []indexChunk) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO chunks (hash, path, start, end_line, text, vector)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, chunk := range chunks {
+		vector, err := encodeVector(chunk.Vector)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(chunk.Hash, chunk.Path, chunk.Start, chunk.End, chunk.Text, vector); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) delete(hashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, hash := range hashes {
+		if _, err := tx.Exec(`DELETE FROM chunks WHERE hash = ?`, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) query(vector []float32, k int) ([]indexChunk, error) {
+	rows, err := s.db.Query(`SELECT hash, path, start, end_line, text, vector FROM chunks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []indexChunk
+	for rows.Next() {
+		var (
+			chunk indexChunk
+			blob  []byte
+		)
+		if err := rows.Scan(&chunk.Hash, &chunk.Path, &chunk.Start, &chunk.End, &chunk.Text, &blob); err != nil {
+			return nil, err
+		}
+		if chunk.Vector, err = decodeVector(blob); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return closestChunks(chunks, vector, k), nil
+}
+
+func (s *sqliteStore) close() error {
+	return s.db.Close()
+}
+
+// encodeVector stores a vector as little-endian float32s, the layout
+// sqlite-vec uses too.
+func encodeVector(vector []float32) ([]byte, error) {
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, vector); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decodeVector(blob []byte) ([]float32, error) {
+	if len(blob)%4 != 0 {
+		return nil, fmt.Errorf("vector of %d bytes isn't float32s", len(blob))
+	}
+	vector := make([]float32, len(blob)/4)
+	return vector, binary.Read(bytes.NewReader(blob), binary.LittleEndian, vector)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type vimMode int
+
+const (
+	vimInsert vimMode = iota
+	vimNormal
+)
+
+// vimState is the state of the opt-in modal editing. The chat starts in
+// insert mode so that typing works straight away; Esc switches to normal
+// mode.
+type vimState struct {
+	enabled bool
+	mode    vimMode
+	// pending is the first key of a two-key command such as dd or gg.
+	pending string
+}
+
+func (v vimState) status() string {
+	if v.mode == vimNormal {
+		return "-- NORMAL --"
+	}
+	return "-- INSERT --"
+}
+
+// vimNormalKey handles a key in normal mode, reporting whether it was used.
+// Keys that aren't vim commands, such as Enter, are left to the usual
+// bindings.
+func (m *model) vimNormalKey(msg tea.KeyMsg) (bool, tea.Cmd) {
+	if msg.Type != tea.KeyRunes {
+		m.vim.pending = ""
+		return false, nil
+	}
+
+	keys := m.vim.pending + msg.String()
+	m.vim.pending = ""
+	var cmd tea.Cmd
+	switch keys {
+	case "i":
+		m.vim.mode = vimInsert
+	case "a":
+		m.textarea, cmd = m.textarea.Update(tea.KeyMsg{Type: tea.KeyRight})
+		m.vim.mode = vimInsert
+	case "A":
+		m.textarea.CursorEnd()
+		m.vim.mode = vimInsert
+	case "I":
+		m.textarea.CursorStart()
+		m.vim.mode = vimInsert
+	case "h":
+		m.textarea, cmd = m.textarea.Update(tea.KeyMsg{Type: tea.KeyLeft})
+	case "l":
+		m.textarea, cmd = m.textarea.Update(tea.KeyMsg{Type: tea.KeyRight})
+	case "0":
+		m.textarea.CursorStart()
+	case "$":
+		m.textarea.CursorEnd()
+	case "x":
+		m.textarea, cmd = m.textarea.Update(tea.KeyMsg{Type: tea.KeyDelete})
+	case "j":
+		m.viewport.LineDown(1)
+	case "k":
+		m.viewport.LineUp(1)
+	case "G":
+		m.viewport.GotoBottom()
+	case "gg":
+		m.viewport.GotoTop()
+	case "dd":
+		m.textarea.Reset()
+	case ":":
+		// Commands are the slash commands.
+		m.textarea.SetValue("/")
+		m.vim.mode = vimInsert
+	case "/":
+		m.openSearch()
+	case "?":
+		m.showHelp = true
+	case "d", "g":
+		m.vim.pending = keys
+	}
+	return true, cmd
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSearchResults is the number of results given to the model unless
+// configured otherwise.
+const defaultSearchResults = 5
+
+var searchEndpoints = map[string]string{
+	"brave": "https://api.search.brave.com/res/v1/web/search",
+	"bing":  "https://api.bing.microsoft.com/v7.0/search",
+}
+
+// webSearchConfig sets up the web_search tool.
+type webSearchConfig struct {
+	// Backend is "brave", "searxng" or "bing".
+	Backend string `yaml:"backend"`
+	APIKey  string `yaml:"api_key"`
+	// URL is the SearxNG instance to use, or overrides the endpoint of
+	// the others.
+	URL string `yaml:"url"`
+	// Results is the number of results given to the model.
+	Results int `yaml:"results"`
+}
+
+// searchResult is a page found by a web search.
+type searchResult struct {
+	Title   string
+	URL     string
+	Snippet string
+}
+
+// searchSource matches the title and URL of a result formatted by
+// formatSearchResults.
+var searchSource = regexp.MustCompile(`(?m)^\d+\. (.*)\n   (\S+)$`)
+
+func newWebSearchTool(cfg webSearchConfig) (tool, error) {
+	switch cfg.Backend {
+	case "brave", "bing":
+		if cfg.APIKey == "" {
+			return tool{}, fmt.Errorf("web_search: the %s backend needs an api_key", cfg.Backend)
+		}
+		if cfg.URL == "" {
+			cfg.URL = searchEndpoints[cfg.Backend]
+		}
+	case "searxng":
+		if cfg.URL == "" {
+			return tool{}, errors.New("web_search: the searxng backend needs the url of an instance")
+		}
+	default:
+		return tool{}, fmt.Errorf("web_search: unknown backend %q", cfg.Backend)
+	}
+	if cfg.Results <= 0 {
+		cfg.Results = defaultSearchResults
+	}
+
+	return tool{
+		name:        "web_search",
+		description: "Search the web. Use it for recent events and anything else you may not know; cite the URLs of the results you use.",
+		parameters: jsonSchema{
+			Type: "object",
+			Properties: map[string]jsonSchema{
+				"query": {Type: "string", Description: "What to search for"},
+			},
+			Required: []string{"query"},
+		},
+		status: func(args string) string {
+			return fmt.Sprintf("Searching the web for %q", searchQuery(args))
+		},
+		run: func(ctx context.Context, args string, tio toolIO) (string, error) {
+			query := searchQuery(args)
+			if query == "" {
+				return "", errors.New("no query given")
+			}
+			results, err := webSearch(ctx, cfg, query)
+			if err != nil {
+				return "", err
+			}
+			return formatSearchResults(results), nil
+		},
+		summary: func(result string) string {
+			sources := searchSources(result)
+			if len(sources) == 0 {
+				return ""
+			}
+			return "Sources:\n" + strings.Join(sources, "\n")
+		},
+	}, nil
+}
+
+// searchQuery returns the query in the arguments of a web_search call.
+func searchQuery(args string) string {
+	var params struct {
+		Query string `json:"query"`
+	}
+	_ = json.Unmarshal([]byte(args), &params)
+	return params.Query
+}
+
+// webSearch asks the configured backend for the top results for query.
+func webSearch(ctx context.Context, cfg webSearchConfig, query string) ([]searchResult, error) {
+	var results []searchResult
+	switch cfg.Backend {
+	case "brave":
+		var resp struct {
+			Web struct {
+				Results []struct {
+					Title       string `json:"title"`
+					URL         string `json:"url"`
+					Description string `json:"description"`
+				} `json:"results"`
+			} `json:"web"`
+		}
+		params := url.Values{"q": {query}, "count": {strconv.Itoa(cfg.Results)}}
+		if err := getJSON(ctx, cfg.URL+"?"+params.Encode(), map[string]string{"X-Subscription-Token": cfg.APIKey}, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Web.Results {
+			results = append(results, searchResult{Title: r.Title, URL: r.URL, Snippet: r.Description})
+		}
+	case "bing":
+		var resp struct {
+			WebPages struct {
+				Value []struct {
+					Name    string `json:"name"`
+					URL     string `json:"url"`
+					Snippet string `json:"snippet"`
+				} `json:"value"`
+			} `json:"webPages"`
+		}
+		params := url.Values{"q": {query}, "count": {strconv.Itoa(cfg.Results)}}
+		if err := getJSON(ctx, cfg.URL+"?"+params.Encode(), map[string]string{"Ocp-Apim-Subscription-Key": cfg.APIKey}, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.WebPages.Value {
+			results = append(results, searchResult{Title: r.Name, URL: r.URL, Snippet: r.Snippet})
+		}
+	case "searxng":
+		var resp struct {
+			Results []struct {
+				Title   string `json:"title"`
+				URL     string `json:"url"`
+				Content string `json:"content"`
+			} `json:"results"`
+		}
+		params := url.Values{"q": {query}, "format": {"json"}}
+		endpoint := strings.TrimSuffix(cfg.URL, "/") + "/search?" + params.Encode()
+		var headers map[string]string
+		if cfg.APIKey != "" {
+			headers = map[string]string{"Authorization": "Bearer " + cfg.APIKey}
+		}
+		if err := getJSON(ctx, endpoint, headers, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Results {
+			results = append(results, searchResult{Title: r.Title, URL: r.URL, Snippet: r.Content})
+		}
+	}
+
+	if len(results) > cfg.Results {
+		results = results[:cfg.Results]
+	}
+	return results, nil
+}
+
+// getJSON fetches url and decodes the JSON it returns into v.
+func getJSON(ctx context.Context, url string, headers map[string]string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("search failed: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// formatSearchResults lists results for the model.
+func formatSearchResults(results []searchResult) string {
+	if len(results) == 0 {
+		return "No results."
+	}
+	var b strings.Builder
+	for i, r := range results {
+		fmt.Fprintf(&b, "%d. %s\n   %s\n", i+1, strings.TrimSpace(r.Title), r.URL)
+		if snippet := strings.Join(strings.Fields(r.Snippet), " "); snippet != "" {
+			fmt.Fprintf(&b, "   %s\n", snippet)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// searchSources lists the title and URL of each result of a search, for the
+// transcript.
+func searchSources(content string) []string {
+	var sources []string
+	for _, match := range searchSource.FindAllStringSubmatch(content, -1) {
+		sources = append(sources, match[1]+" — "+match[2])
+	}
+	return sources
+}
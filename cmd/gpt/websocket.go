@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsMessage is a message on the API's WebSocket, either way. Type says which
+// of the rest are used:
+//
+//	send     client: Content, Conversation (a new one if empty), Model, SystemPrompt
+//	cancel   client: Conversation, whose reply is given up on
+//	started  server: Conversation, which the reply is in
+//	delta    server: Conversation, Content, the next piece of the reply
+//	done     server: Conversation, Reply
+//	error    server: Conversation, if there is one, Error
+type wsMessage struct {
+	Type         string    `json:"type"`
+	Conversation string    `json:"conversation,omitempty"`
+	Content      string    `json:"content,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	SystemPrompt *string   `json:"system_prompt,omitempty"`
+	Reply        *apiReply `json:"reply,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// wsHandler serves /v1/ws. Any number of replies can stream at once, each in
+// its own conversation.
+func (s *apiServer) wsHandler() http.Handler {
+	return websocket.Server{
+		// Hosts and origins are checked by guard, which also lets in
+		// clients that aren't browsers and send no origin.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			newWSSession(s, ws).run()
+		},
+	}
+}
+
+// wsSession is a connection to the WebSocket.
+type wsSession struct {
+	server *apiServer
+	ws     *websocket.Conn
+	ctx    context.Context
+
+	mu sync.Mutex
+	// cancels stops the replies streaming, by conversation.
+	cancels map[string]context.CancelFunc
+	wg      sync.WaitGroup
+
+	writeMu sync.Mutex
+}
+
+func newWSSession(s *apiServer, ws *websocket.Conn) *wsSession {
+	return &wsSession{server: s, ws: ws, cancels: make(map[string]context.CancelFunc)}
+}
+
+// run reads messages until the connection is closed, which gives up on the
+// replies still streaming.
+func (c *wsSession) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer c.wg.Wait()
+	defer cancel()
+	c.ctx = ctx
+
+	for {
+		var msg wsMessage
+		if err := websocket.JSON.Receive(c.ws, &msg); err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.write(wsMessage{Type: "error", Error: err.Error()})
+			}
+			return
+		}
+		switch msg.Type {
+		case "send":
+			c.send(msg)
+		case "cancel":
+			c.mu.Lock()
+			stop, ok := c.cancels[msg.Conversation]
+			c.mu.Unlock()
+			if !ok {
+				c.write(wsMessage{Type: "error", Conversation: msg.Conversation, Error: "no reply to cancel"})
+				continue
+			}
+			stop()
+		default:
+			c.write(wsMessage{Type: "error", Error: fmt.Sprintf("unknown message type %q", msg.Type)})
+		}
+	}
+}
+
+// send starts a reply to msg, streamed in the background.
+func (c *wsSession) send(msg wsMessage) {
+	s := c.server
+	fail := func(err error) {
+		c.write(wsMessage{Type: "error", Conversation: msg.Conversation, Error: err.Error()})
+	}
+	if msg.Content == "" {
+		fail(errors.New("no content given"))
+		return
+	}
+
+	var (
+		conv *conversation
+		err  error
+	)
+	if msg.Conversation != "" {
+		conv, err = s.store.open(msg.Conversation)
+	} else {
+		// Written at once, so that another started at the same time gets
+		// another ID.
+		conv, err = s.store.createWith(nil)
+	}
+	if err != nil {
+		fail(err)
+		return
+	}
+	if !s.busy.claim(conv.ID) {
+		fail(fmt.Errorf("conversation %q is waiting for a reply", conv.ID))
+		return
+	}
+	cfg := s.config
+	if msg.Model != "" {
+		cfg.Model = msg.Model
+	}
+	if msg.SystemPrompt != nil {
+		cfg.SystemPrompt = *msg.SystemPrompt
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.mu.Lock()
+	c.cancels[conv.ID] = cancel
+	c.mu.Unlock()
+	c.write(wsMessage{Type: "started", Conversation: conv.ID})
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer s.busy.release(conv.ID)
+		defer func() {
+			c.mu.Lock()
+			delete(c.cancels, conv.ID)
+			c.mu.Unlock()
+			cancel()
+		}()
+
+		reply, result, err := converse(ctx, cfg, s.provider, s.budget, conv, msg.Content, func(delta string) {
+			c.write(wsMessage{Type: "delta", Conversation: conv.ID, Content: delta})
+		})
+		if err != nil && reply == "" {
+			// A new conversation the message never reached isn't kept.
+			conv.discardIfEmpty()
+			c.write(wsMessage{Type: "error", Conversation: conv.ID, Error: err.Error()})
+			return
+		}
+		r := newAPIReply(cfg, conv, reply, result, err)
+		c.write(wsMessage{Type: "done", Conversation: conv.ID, Reply: &r})
+	}()
+}
+
+// write sends msg, one at a time. A connection that has gone is noticed by
+// run.
+func (c *wsSession) write(msg wsMessage) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	websocket.JSON.Send(c.ws, msg)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles are the web UI that gpt serve -web serves: a single page on the
+// API, with the conversations down the side and the chat streaming over the
+// WebSocket.
+//
+//go:embed web
+var webFiles embed.FS
+
+func webUI() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Strategies for a conversation that has outgrown the context window.
+const (
+	// contextSliding drops the oldest turns until the rest fits.
+	contextSliding = "sliding"
+	// contextSummarize replaces the oldest turns with a rolling summary.
+	contextSummarize = "summarize"
+	// contextError refuses to send the request.
+	contextError = "error"
+)
+
+// maxReplyReserve caps the room left for the reply when no max_tokens is set.
+const maxReplyReserve = 4096
+
+const summaryPrompt = `Summarize the conversation below so that it can be continued without it.
+Keep names, facts, decisions, code identifiers and open questions; leave out
+pleasantries. If there is an earlier summary, fold it in. Reply with the
+summary only.`
+
+// fitContext makes req fit the model's context window, leaving room for the
+// reply. System messages and the latest message are always kept. It returns
+// the number of messages dropped or newly summarized, and the usage of the
+// request for the summary, if one was made, for the caller to record.
+func fitContext(ctx context.Context, cfg config, p provider, conv *conversation, req *openai.ChatCompletionRequest) (int, openai.Usage, error) {
+	window := cfg.contextWindow()
+	if window == 0 {
+		return 0, openai.Usage{}, nil
+	}
+
+	reserve := req.MaxTokens
+	if reserve == 0 {
+		reserve = window / 8
+		if reserve > maxReplyReserve {
+			reserve = maxReplyReserve
+		}
+	}
+	limit := window - reserve
+
+	var system, rest []openai.ChatCompletionMessage
+	for _, msg := range req.Messages {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			system = append(system, msg)
+		} else {
+			rest = append(rest, msg)
+		}
+	}
+
+	// The transcript after the system prompt is the conversation, so an
+	// earlier summary stands in for the messages it covers.
+	summary := conv.summary
+	if cfg.ContextStrategy != contextSummarize || summary.Covers >= len(rest) {
+		summary = conversationSummary{}
+	}
+	rest = rest[summary.Covers:]
+
+	build := func() []openai.ChatCompletionMessage {
+		messages := append([]openai.ChatCompletionMessage{}, system...)
+		if summary.Text != "" {
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "Summary of the earlier conversation:\n\n" + summary.Text,
+			})
+		}
+		return append(messages, rest...)
+	}
+
+	used := countMessageTokens(req.Model, build())
+	if used <= limit {
+		req.Messages = build()
+		return 0, openai.Usage{}, nil
+	}
+
+	target := limit
+	switch cfg.ContextStrategy {
+	case "", contextSliding:
+	case contextSummarize:
+		// Summarize more than is strictly needed so that it isn't done
+		// again on the very next turn.
+		target = limit / 2
+	case contextError:
+		return 0, openai.Usage{}, fmt.Errorf("conversation is %s tokens, more than the %s the model allows; start a new one or set context_strategy: %s",
+			formatCount(used), formatWindow(limit), contextSliding)
+	default:
+		return 0, openai.Usage{}, fmt.Errorf("unknown context_strategy %q", cfg.ContextStrategy)
+	}
+
+	var (
+		dropped      []openai.ChatCompletionMessage
+		summaryUsage openai.Usage
+	)
+	drop := func() {
+		used -= tokensPerMessage + countTokens(req.Model, rest[0].Content)
+		dropped = append(dropped, rest[0])
+		rest = rest[1:]
+	}
+	for len(rest) > 1 && used > target {
+		drop()
+	}
+	// Don't start the conversation halfway through an exchange.
+	for len(rest) > 1 && rest[0].Role != openai.ChatMessageRoleUser {
+		drop()
+	}
+
+	if cfg.ContextStrategy == contextSummarize {
+		text, usage, err := summarize(ctx, cfg, p, summary.Text, dropped)
+		summaryUsage = usage
+		if err != nil {
+			return 0, summaryUsage, fmt.Errorf("summarize: %w", err)
+		}
+		summary = conversationSummary{
+			Covers: summary.Covers + len(dropped),
+			Text:   text,
+		}
+		if err := conv.setSummary(summary); err != nil {
+			return 0, summaryUsage, err
+		}
+		used = countMessageTokens(req.Model, build())
+	}
+
+	if used > limit {
+		return 0, summaryUsage, fmt.Errorf("message is %s tokens, more than the %s the model allows",
+			formatCount(used), formatWindow(limit))
+	}
+	req.Messages = build()
+	return len(dropped), summaryUsage, nil
+}
+
+// summarize asks the summary model to fold messages into an earlier summary.
+func summarize(ctx context.Context, cfg config, p provider, earlier string, messages []openai.ChatCompletionMessage) (string, openai.Usage, error) {
+	var transcript strings.Builder
+	if earlier != "" {
+		fmt.Fprintf(&transcript, "Earlier summary:\n\n%s\n\n", earlier)
+	}
+	transcript.WriteString("Conversation:\n\n")
+	for _, msg := range messages {
+		role := "User"
+		if msg.Role == openai.ChatMessageRoleAssistant {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", role, msg.Content)
+	}
+
+	req := openai.ChatCompletionRequest{
+		Model: cfg.summaryModel(),
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: summaryPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: transcript.String()},
+		},
+	}
+
+	var summary strings.Builder
+	result, err := streamChat(ctx, p, req, func(delta string) {
+		summary.WriteString(delta)
+	})
+	return strings.TrimSpace(summary.String()), result.usage, err
+}
+
+// summaryModel is the model that writes summaries.
+func (c config) summaryModel() string {
+	if c.SummaryModel != "" {
+		return c.SummaryModel
+	}
+	return c.Model
+}
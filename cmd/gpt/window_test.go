@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// testTranscript returns a system prompt and then n messages of about 100
+// tokens each, taking turns from the user.
+func testTranscript(n int) []openai.ChatCompletionMessage {
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: "Be brief."}}
+	for i := 0; i < n; i++ {
+		role := openai.ChatMessageRoleUser
+		if i%2 == 1 {
+			role = openai.ChatMessageRoleAssistant
+		}
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    role,
+			Content: fmt.Sprintf("%d%s", i, strings.Repeat(" word", 99)),
+		})
+	}
+	return messages
+}
+
+func TestFitContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		// window is the context window; the reply is given 300 tokens of
+		// it, leaving room for about six of the messages.
+		window   int
+		messages []openai.ChatCompletionMessage
+		summary  conversationSummary
+		// dropped is how many messages were dropped or summarized, and
+		// kept how many of the transcript's are sent after the system
+		// prompt and any summary.
+		dropped, kept int
+		summarized    bool
+		err           string
+	}{
+		{
+			name:     "no window known",
+			messages: testTranscript(20),
+			kept:     20,
+		},
+		{
+			name:     "fits",
+			window:   1000,
+			messages: testTranscript(4),
+			kept:     4,
+		},
+		{
+			name:     "sliding",
+			window:   1000,
+			messages: testTranscript(10),
+			dropped:  4,
+			kept:     6,
+		},
+		{
+			name:     "sliding starts with the user",
+			window:   1000,
+			messages: testTranscript(9),
+			dropped:  4,
+			kept:     5,
+		},
+		{
+			name:     "sliding ignores the summary",
+			strategy: contextSliding,
+			window:   1000,
+			messages: testTranscript(10),
+			summary:  conversationSummary{Covers: 6, Text: "Earlier."},
+			dropped:  4,
+			kept:     6,
+		},
+		{
+			name:       "summarize",
+			strategy:   contextSummarize,
+			window:     1000,
+			messages:   testTranscript(10),
+			dropped:    8,
+			kept:       2,
+			summarized: true,
+		},
+		{
+			name:       "earlier summary",
+			strategy:   contextSummarize,
+			window:     1000,
+			messages:   testTranscript(10),
+			summary:    conversationSummary{Covers: 6, Text: "Earlier."},
+			kept:       4,
+			summarized: true,
+		},
+		{
+			name:     "error",
+			strategy: contextError,
+			window:   1000,
+			messages: testTranscript(10),
+			err:      "start a new one",
+		},
+		{
+			name:     "unknown strategy",
+			strategy: "forget",
+			window:   1000,
+			messages: testTranscript(10),
+			err:      `unknown context_strategy "forget"`,
+		},
+		{
+			name:   "message too big",
+			window: 1000,
+			messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: strings.Repeat("word ", 1000)},
+			},
+			err: "message is",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config{Model: "test-model", ContextWindow: tt.window, ContextStrategy: tt.strategy}
+			p := replyProvider("What was said.")
+			conv := &conversation{ID: "test", path: filepath.Join(t.TempDir(), "test.jsonl")}
+			conv.summary = tt.summary
+			req := openai.ChatCompletionRequest{Model: cfg.Model, MaxTokens: 300, Messages: tt.messages}
+
+			dropped, usage, err := fitContext(context.Background(), cfg, p, conv, &req)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("fitContext() error = %v, want one about %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dropped != tt.dropped {
+				t.Errorf("dropped %d messages, want %d", dropped, tt.dropped)
+			}
+
+			want := []openai.ChatCompletionMessage{tt.messages[0]}
+			if tt.summarized {
+				text := "What was said."
+				if tt.dropped == 0 {
+					text = tt.summary.Text
+				}
+				want = append(want, openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleSystem,
+					Content: "Summary of the earlier conversation:\n\n" + text,
+				})
+			}
+			want = append(want, tt.messages[len(tt.messages)-tt.kept:]...)
+			if fmt.Sprint(req.Messages) != fmt.Sprint(want) {
+				t.Errorf("sent %d messages, want %d: %v", len(req.Messages), len(want), req.Messages)
+			}
+
+			if tt.summarized && tt.dropped > 0 {
+				if usage.TotalTokens == 0 {
+					t.Error("no usage reported for the summary")
+				}
+				if want := tt.summary.Covers + tt.dropped; conv.summary.Covers != want {
+					t.Errorf("summary covers %d messages, want %d", conv.summary.Covers, want)
+				}
+			} else if usage.TotalTokens != 0 {
+				t.Errorf("usage = %+v without a summary made", usage)
+			}
+		})
+	}
+}